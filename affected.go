@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/daemon"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/urfave/cli/v2"
+)
+
+// OutputFormat defines the format for the affected command output
+type OutputFormat string
+
+const (
+	FormatList         OutputFormat = "list"
+	FormatGoArgs       OutputFormat = "go-args"
+	FormatGitHubMatrix OutputFormat = "github-matrix"
+	FormatDirs         OutputFormat = "dirs"
+	FormatRelDirs      OutputFormat = "rel-dirs"
+	FormatList0        OutputFormat = "list0"
+	FormatDirs0        OutputFormat = "dirs0"
+	FormatRelDirs0     OutputFormat = "rel-dirs0"
+	FormatTemplate     OutputFormat = "template"
+	FormatGitLabCI     OutputFormat = "gitlab-ci"
+	FormatCircleCI     OutputFormat = "circleci"
+	FormatAzureMatrix  OutputFormat = "azure-matrix"
+	FormatJSON         OutputFormat = "json"
+)
+
+// affectedFormats lists every format of the affected command
+var affectedFormats = []OutputFormat{
+	FormatList, FormatGoArgs, FormatGitHubMatrix, FormatDirs, FormatRelDirs, FormatList0,
+	FormatDirs0, FormatRelDirs0, FormatTemplate, FormatGitLabCI, FormatCircleCI, FormatAzureMatrix, FormatJSON,
+}
+
+// exitCodeNothingAffected is returned by 'affected --exit-code' when no module is affected
+const exitCodeNothingAffected = 3
+
+// affectedOptions controls how the affected set is expanded and printed
+type affectedOptions struct {
+	Format      OutputFormat
+	Template    string
+	JobCommand  string
+	IncludeDeps bool
+	// IncludeDependents adds the modules depending on the affected ones
+	IncludeDependents bool
+	ExitCode          bool
+	// GitHubOutput also writes the affected modules to GITHUB_OUTPUT
+	GitHubOutput bool
+	Exclude      []string
+	Owners       []string
+	// Build is the configuration the imports are analyzed for
+	Build analyzer.BuildContext
+}
+
+// createAffectedCommand creates the 'affected' command
+func createAffectedCommand() *cli.Command {
+	var (
+		path         string
+		base         string
+		useMergeBase bool
+		changes      changeFlags
+		format       string
+		tmplText     string
+		jobCommand   string
+		includeDeps  bool
+		dependents   bool
+		exitCode     bool
+		githubOutput bool
+		schema       bool
+		exclude      cli.StringSlice
+		owners       cli.StringSlice
+		build        buildFlags
+	)
+
+	return &cli.Command{
+		Name:  "affected",
+		Usage: "List modules affected by changes since a git reference",
+		Description: `Detect which modules have changed compared to a git reference.
+
+Examples:
+  knit affected                        # Compare against 'main' branch
+  knit affected --base origin/main     # Compare against origin/main
+  knit affected --merge-base           # Use merge-base (recommended for CI)
+  knit affected --base-sha abc123      # Compare against a specific commit
+  knit affected --since-sha-file .knit/last-green  # Compare against the last green commit
+  git diff --name-only HEAD~1 | knit affected --files-from -  # Use a pre-computed file list
+  knit affected -f go-args             # Output: -p module1 -p module2
+  knit affected -f github-matrix       # Output: {"module":[{"path":...,"dir":...,"name":...}]} for GitHub Actions
+  knit affected -f rel-dirs            # Output: module directories relative to the workspace
+  knit affected -f list0 | xargs -0 -n1 echo  # NUL-separated output (also dirs0, rel-dirs0)
+  knit affected -f template --template '{{range .Modules}}{{.Path}},{{.Dir}}\n{{end}}'
+  knit affected -f gitlab-ci > child.yml  # Output: GitLab CI child pipeline, one job per module
+  knit affected -f circleci            # Output: {"run-api":true} CircleCI continuation parameters
+  knit affected -f azure-matrix        # Output: JSON matrix for Azure Pipelines strategy.matrix
+  knit affected -f json                # Output: {"schemaVersion":1,"modules":[{"path":...,"relDir":...}]}
+  knit affected -f json --schema       # Print the JSON schema of -f json (or of -f github-matrix)
+  knit affected --include-deps         # Include dependencies of affected modules
+  knit affected --include-dependents   # Include the modules to retest, tests' dependencies included
+  knit affected --include-dependents --no-test-deps  # Only the modules whose build is impacted
+  knit affected -d --tags integration --goos linux  # Follow the imports CI builds
+  knit affected --exit-code            # Exit with code 3 when nothing is affected
+  knit affected -m --github-output     # Also set the modules, any_affected and matrix step outputs
+  knit affected --exclude 'example.com/legacy/...'  # Never report some modules
+  knit affected --owner @org/payments  # Only modules owned by a team in CODEOWNERS`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Git reference to compare against (branch, tag, or commit)",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.BoolFlag{
+				Name:        "merge-base",
+				Usage:       "Compare against merge-base (common ancestor) - recommended for CI/PRs",
+				Aliases:     []string{"m"},
+				Destination: &useMergeBase,
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "Output format: list (default), go-args, github-matrix, dirs, rel-dirs, list0, dirs0, rel-dirs0, template, gitlab-ci, circleci, azure-matrix, json",
+				Aliases:     []string{"f"},
+				Value:       "list",
+				Destination: &format,
+			},
+			&cli.StringFlag{
+				Name:        "template",
+				Usage:       "Go template used with -f template (fields: .WorkspaceRoot, .Modules with .Path .Dir .RelDir .Name .GoVersion .Main)",
+				Destination: &tmplText,
+			},
+			&cli.StringFlag{
+				Name:        "job-command",
+				Usage:       "Go template of the command run by each generated CI job",
+				Value:       defaultJobCommand,
+				Destination: &jobCommand,
+			},
+			&cli.BoolFlag{
+				Name:        "include-deps",
+				Usage:       "Include dependencies of affected modules",
+				Aliases:     []string{"d"},
+				Destination: &includeDeps,
+			},
+			&cli.BoolFlag{
+				Name:        "include-dependents",
+				Usage:       "Include the modules depending on affected modules, directly or transitively",
+				Destination: &dependents,
+			},
+			&cli.BoolFlag{
+				Name:        "exit-code",
+				Usage:       fmt.Sprintf("Exit with code %d when no module is affected", exitCodeNothingAffected),
+				Destination: &exitCode,
+			},
+			&cli.BoolFlag{
+				Name:        "github-output",
+				Usage:       "Also write the modules, any_affected and matrix outputs of the step to GITHUB_OUTPUT, on GitHub Actions",
+				Destination: &githubOutput,
+			},
+			schemaFlag("-f json or -f github-matrix", &schema),
+			excludeFlag(&exclude),
+			ownerFlag(&owners),
+		}, append(changes.flags(), build.flags()...)...),
+		Action: func(c *cli.Context) error {
+			if schema {
+				switch OutputFormat(format) {
+				case FormatJSON:
+					return printSchema(schemaAffected)
+				case FormatGitHubMatrix:
+					return printSchema(schemaGitHubMatrix)
+				}
+				return fmt.Errorf("--schema requires -f json or -f github-matrix, the formats with a JSON schema")
+			}
+			src, err := changes.source(base, useMergeBase)
+			if err != nil {
+				return err
+			}
+			return runAffected(path, src, affectedOptions{
+				Format:            OutputFormat(format),
+				Template:          tmplText,
+				JobCommand:        jobCommand,
+				IncludeDeps:       includeDeps,
+				IncludeDependents: dependents,
+				ExitCode:          exitCode,
+				GitHubOutput:      githubOutput,
+				Exclude:           exclude.Value(),
+				Owners:            owners.Value(),
+				Build:             build.context(),
+			})
+		},
+	}
+}
+
+func runAffected(path string, src changeSource, opts affectedOptions) error {
+	absPath, modules, imports, err := loadModuleImports(path, opts.IncludeDeps || opts.IncludeDependents, opts.Build)
+	if err != nil {
+		return err
+	}
+	if len(modules) == 0 {
+		return fmt.Errorf("no modules found in workspace")
+	}
+
+	affected, err := affectedModules(modules, absPath, src)
+	if err != nil {
+		return err
+	}
+
+	// Include dependencies and dependents if requested
+	if (opts.IncludeDeps || opts.IncludeDependents) && len(affected) > 0 {
+		allAffected := make(map[string]bool)
+		for _, m := range affected {
+			allAffected[m.Path] = true
+		}
+
+		// For each affected module, find its dependencies
+		if opts.IncludeDeps {
+			graph := analyzer.GraphFromImports(modules, imports)
+			for _, m := range affected {
+				deps, err := analyzer.GetDependencyPaths(graph, m.Path)
+				if err != nil {
+					// Module might not have dependencies, continue
+					continue
+				}
+				for _, dep := range deps {
+					allAffected[dep] = true
+				}
+			}
+		}
+		// And the modules depending on it
+		if opts.IncludeDependents {
+			for _, m := range affected {
+				for dep := range resolver.Dependents(imports, m.Path, 0) {
+					allAffected[dep] = true
+				}
+			}
+		}
+
+		// Convert back to slice, keeping the workspace order
+		affected = make([]analyzer.Module, 0, len(allAffected))
+		for _, m := range modules {
+			if allAffected[m.Path] {
+				affected = append(affected, m)
+			}
+		}
+	}
+
+	// Exclusions win over modules pulled in by --include-deps and --include-dependents
+	affected = excludeModules(affected, opts.Exclude, absPath)
+	if len(opts.Owners) > 0 {
+		byModule, err := moduleOwners(absPath, modules)
+		if err != nil {
+			return err
+		}
+		affected = filterByOwner(affected, byModule, opts.Owners)
+	}
+
+	affectedCount := len(affected)
+	reportToDaemon(absPath, daemon.Report{Affected: &affectedCount})
+
+	// Output in the requested format
+	if err := outputAffected(affected, opts, absPath); err != nil {
+		return err
+	}
+	if opts.GitHubOutput {
+		if err := writeGitHubOutput(newTemplateData[struct{}](affected, absPath, nil)); err != nil {
+			return err
+		}
+	}
+
+	if opts.ExitCode && len(affected) == 0 {
+		return cli.Exit("", exitCodeNothingAffected)
+	}
+	return nil
+}
+
+func outputAffected(modules []analyzer.Module, opts affectedOptions, workspaceRoot string) error {
+	paths := make([]string, len(modules))
+	for i, m := range modules {
+		paths[i] = m.Path
+	}
+
+	switch format := opts.Format; format {
+	case FormatList:
+		printLines(paths, "\n")
+
+	case FormatList0:
+		printLines(paths, "\x00")
+
+	case FormatGoArgs:
+		// Output: -p module1 -p module2 ...
+		var args []string
+		for _, p := range paths {
+			args = append(args, "-p", p)
+		}
+		fmt.Println(strings.Join(args, " "))
+
+	case FormatGitHubMatrix:
+		return outputGitHubMatrix(newTemplateData[struct{}](modules, workspaceRoot, nil))
+
+	case FormatDirs, FormatDirs0:
+		dirs := make([]string, len(modules))
+		for i, m := range modules {
+			dirs[i] = m.Dir
+		}
+		printLines(dirs, lineTerminator(format))
+
+	case FormatRelDirs, FormatRelDirs0:
+		dirs := make([]string, len(modules))
+		for i, m := range modules {
+			rel, err := filepath.Rel(workspaceRoot, m.Dir)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path: %w", err)
+			}
+			dirs[i] = rel
+		}
+		printLines(dirs, lineTerminator(format))
+
+	case FormatTemplate:
+		return renderTemplate(opts.Template, newTemplateData[struct{}](modules, workspaceRoot, nil))
+
+	case FormatGitLabCI:
+		return outputGitLabCI(newTemplateData[struct{}](modules, workspaceRoot, nil), opts.JobCommand)
+
+	case FormatCircleCI:
+		return outputCircleCI(newTemplateData[struct{}](modules, workspaceRoot, nil))
+
+	case FormatAzureMatrix:
+		return outputAzureMatrix(newTemplateData[struct{}](modules, workspaceRoot, nil))
+
+	case FormatJSON:
+		return outputAffectedJSON(newTemplateData[struct{}](modules, workspaceRoot, nil))
+
+	default:
+		return fmt.Errorf("unknown format: %s (use list, go-args, github-matrix, dirs, rel-dirs, list0, dirs0, rel-dirs0, template, gitlab-ci, circleci, azure-matrix, or json)", format)
+	}
+
+	return nil
+}
+
+// lineTerminator returns the separator of a line-based format: NUL for the
+// "0" variants meant for xargs -0, newline otherwise
+func lineTerminator(format OutputFormat) string {
+	if strings.HasSuffix(string(format), "0") {
+		return "\x00"
+	}
+	return "\n"
+}
+
+// printLines prints each line followed by terminator
+func printLines(lines []string, terminator string) {
+	for _, l := range lines {
+		fmt.Print(l + terminator)
+	}
+}
+
+// outputAffectedJSON prints the affected modules as a JSON object versioned
+// by schemaVersion, whose schema --schema prints
+func outputAffectedJSON(data templateData) error {
+	type moduleEntry struct {
+		Path      string `json:"path"`
+		Dir       string `json:"dir"`
+		RelDir    string `json:"relDir"`
+		Name      string `json:"name"`
+		GoVersion string `json:"goVersion,omitempty"`
+		Main      bool   `json:"main"`
+	}
+	type affectedOutput struct {
+		SchemaVersion int           `json:"schemaVersion"`
+		Modules       []moduleEntry `json:"modules"`
+	}
+
+	// Ensure empty array, not null
+	output := affectedOutput{SchemaVersion: outputSchemaVersion, Modules: make([]moduleEntry, 0, len(data.Modules))}
+	for _, m := range data.Modules {
+		output.Modules = append(output.Modules, moduleEntry{
+			Path:      m.Path,
+			Dir:       m.Dir,
+			RelDir:    filepath.ToSlash(m.RelDir),
+			Name:      m.Name,
+			GoVersion: m.GoVersion,
+			Main:      m.Main,
+		})
+	}
+
+	out, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
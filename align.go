@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/mod/semver"
+)
+
+// alignment is the version a dependency is aligned to, with the modules
+// requiring it at another version
+type alignment struct {
+	Dependency string
+	Version    string
+	// Outdated maps the path of each workspace module to update to the
+	// version it currently requires
+	Outdated map[string]string
+}
+
+// createAlignCommand creates the 'align' command aligning the versions of
+// third-party dependencies across workspace modules
+func createAlignCommand() *cli.Command {
+	var (
+		path    string
+		version string
+		dryRun  bool
+		noTidy  bool
+	)
+
+	return &cli.Command{
+		Name:      "align",
+		Usage:     "Require third-party dependencies at the same version in every module",
+		ArgsUsage: "[dependency]",
+		Description: `Find the third-party modules directly required at different versions by
+workspace modules, and rewrite the go.mod files requiring a lower version to
+the highest one, then run 'go mod tidy' in each rewritten module. With a
+dependency argument only that dependency is aligned, and --version sets the
+version every module requiring it is moved to.
+
+Examples:
+  knit align --dry-run                       # Show the misaligned dependencies
+  knit align                                 # Align every dependency
+  knit align --version v2.27.2 github.com/urfave/cli/v2`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "version",
+				Usage:       "Version to align the dependency to, instead of the highest required one",
+				Destination: &version,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "Print the changes without rewriting go.mod files",
+				Aliases:     []string{"n"},
+				Destination: &dryRun,
+			},
+			&cli.BoolFlag{
+				Name:        "no-tidy",
+				Usage:       "Do not run 'go mod tidy' in the rewritten modules",
+				Destination: &noTidy,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() > 1 {
+				return fmt.Errorf("expected at most one dependency, got %d", c.NArg())
+			}
+			dependency := c.Args().First()
+			if version != "" {
+				if dependency == "" {
+					return fmt.Errorf("--version requires a dependency argument")
+				}
+				if !semver.IsValid(version) {
+					return fmt.Errorf("invalid version %q", version)
+				}
+			}
+
+			_, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			reqs, err := analyzer.ListRequirements(modules)
+			if err != nil {
+				return err
+			}
+
+			alignments := planAlignments(reqs, dependency, version)
+			if dependency != "" && !requiresDependency(reqs, dependency) {
+				return fmt.Errorf("no workspace module requires %s", dependency)
+			}
+			if len(alignments) == 0 {
+				fmt.Println("Dependencies are aligned")
+				return nil
+			}
+
+			printAlignments(alignments)
+			if dryRun {
+				return nil
+			}
+			return applyAlignments(modules, alignments, noTidy)
+		},
+	}
+}
+
+// planAlignments returns the dependencies required at more than one version,
+// or at another version than the given one, sorted by dependency. A non-empty
+// dependency restricts the plan to it.
+func planAlignments(reqs map[string][]analyzer.Requirement, dependency, version string) []alignment {
+	// dependency -> module -> required version
+	versions := make(map[string]map[string]string)
+	for module, rs := range reqs {
+		for _, r := range rs {
+			if dependency != "" && r.Path != dependency {
+				continue
+			}
+			if versions[r.Path] == nil {
+				versions[r.Path] = make(map[string]string)
+			}
+			versions[r.Path][module] = r.Version
+		}
+	}
+
+	var alignments []alignment
+	for dep, byModule := range versions {
+		target := version
+		if target == "" {
+			for _, v := range byModule {
+				if semver.Compare(v, target) > 0 {
+					target = v
+				}
+			}
+		}
+
+		a := alignment{Dependency: dep, Version: target, Outdated: make(map[string]string)}
+		for module, v := range byModule {
+			if v != target {
+				a.Outdated[module] = v
+			}
+		}
+		if len(a.Outdated) > 0 {
+			alignments = append(alignments, a)
+		}
+	}
+	sort.Slice(alignments, func(i, j int) bool { return alignments[i].Dependency < alignments[j].Dependency })
+	return alignments
+}
+
+// requiresDependency reports whether any module requires dependency
+func requiresDependency(reqs map[string][]analyzer.Requirement, dependency string) bool {
+	for _, rs := range reqs {
+		for _, r := range rs {
+			if r.Path == dependency {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func printAlignments(alignments []alignment) {
+	for _, a := range alignments {
+		fmt.Printf("%s -> %s\n", a.Dependency, a.Version)
+		for _, module := range sortedKeys(a.Outdated) {
+			fmt.Printf("    %s (%s)\n", module, a.Outdated[module])
+		}
+	}
+}
+
+// applyAlignments rewrites the go.mod files of outdated modules, then tidies
+// each rewritten module once
+func applyAlignments(modules []analyzer.Module, alignments []alignment, noTidy bool) error {
+	byPath := make(map[string]analyzer.Module, len(modules))
+	for _, m := range modules {
+		byPath[m.Path] = m
+	}
+
+	rewritten := make(map[string]bool)
+	for _, a := range alignments {
+		for module := range a.Outdated {
+			if err := analyzer.SetRequirement(byPath[module].GoMod, a.Dependency, a.Version); err != nil {
+				return err
+			}
+			rewritten[module] = true
+		}
+	}
+
+	if noTidy {
+		return nil
+	}
+	for _, module := range sortedKeys(rewritten) {
+		cmd := exec.Command("go", "mod", "tidy")
+		cmd.Dir = byPath[module].Dir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go mod tidy failed in %s: %w", module, err)
+		}
+	}
+	return nil
+}
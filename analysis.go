@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/cache"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/urfave/cli/v2"
+)
+
+// analysisFile holds the last package analysis of a workspace, so
+// that the commands run again on an unchanged tree skip go list
+const analysisFile = "analysis.json"
+
+type analysis struct {
+	Key string `json:"key"`
+	analyzer.Analysis
+}
+
+// buildFlags holds the flags selecting the build configuration the imports
+// are analyzed for, shared by the commands walking the dependency graph
+type buildFlags struct {
+	tags       string
+	goos       string
+	goarch     string
+	noTestDeps bool
+}
+
+func (f *buildFlags) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "tags",
+			Usage:       "Comma-separated build tags the imports are analyzed with, as go build -tags",
+			Destination: &f.tags,
+		},
+		&cli.StringFlag{
+			Name:        "goos",
+			Usage:       "Target operating system the imports are analyzed for (default: GOOS or the host)",
+			Destination: &f.goos,
+		},
+		&cli.StringFlag{
+			Name:        "goarch",
+			Usage:       "Target architecture the imports are analyzed for (default: GOARCH or the host)",
+			Destination: &f.goarch,
+		},
+		&cli.BoolFlag{
+			Name:        "no-test-deps",
+			Usage:       "Ignore the dependencies only _test.go files create, to analyze the impact on production code",
+			Destination: &f.noTestDeps,
+		},
+	}
+}
+
+// context returns the build configuration selected by the flags
+func (f *buildFlags) context() analyzer.BuildContext {
+	return analyzer.BuildContext{
+		Tags:    strings.FieldsFunc(f.tags, func(r rune) bool { return r == ',' || r == ' ' }),
+		GOOS:    f.goos,
+		GOARCH:  f.goarch,
+		NoTests: f.noTestDeps,
+	}
+}
+
+// listModuleImports returns the imports of analyzeModules, without the
+// imports of _test.go files when build.NoTests is set
+func listModuleImports(workspaceRoot string, modules []analyzer.Module, build analyzer.BuildContext) (map[string]map[string][]analyzer.Import, error) {
+	noTests := build.NoTests
+	// The cache holds the test imports too, they are filtered out after
+	build.NoTests = false
+	a, err := analyzeModules(workspaceRoot, modules, build)
+	if err != nil {
+		return nil, err
+	}
+	if noTests {
+		return analyzer.WithoutTests(a.Imports), nil
+	}
+	return a.Imports, nil
+}
+
+// analyzeModules is analyzer.AnalyzeModules, answered from
+// .knit/analysis.json when the modules, their go.mod, go.sum and .go files,
+// the Go build environment and the build configuration did not change since
+// the last call. Set KNIT_ANALYSIS_CACHE=off to always run go list.
+func analyzeModules(workspaceRoot string, modules []analyzer.Module, build analyzer.BuildContext) (*analyzer.Analysis, error) {
+	if os.Getenv("KNIT_ANALYSIS_CACHE") == "off" {
+		return analyzer.AnalyzeModules(context.Background(), modules, build)
+	}
+	key, err := analysisKey(modules, build)
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join(workspaceRoot, history.Dir, analysisFile)
+	if data, err := os.ReadFile(file); err == nil {
+		var a analysis
+		if json.Unmarshal(data, &a) == nil && a.Key == key && a.Imports != nil {
+			return &a.Analysis, nil
+		}
+	}
+
+	a, err := analyzer.AnalyzeModules(context.Background(), modules, build)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveAnalysis(file, analysis{Key: key, Analysis: *a}); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to cache the package analysis: %v\n", err)
+	}
+	return a, nil
+}
+
+// analysisKey hashes what go list reads to list the imports of the modules
+func analysisKey(modules []analyzer.Module, build analyzer.BuildContext) (string, error) {
+	key := cache.NewKey("analysis", "go list -json, with test imports, embeds and non-Go files")
+	for _, env := range append([]string{"GOWORK"}, goBuildEnv...) {
+		if value, ok := os.LookupEnv(env); ok {
+			key.Add("env "+env, value)
+		}
+	}
+	if !build.IsZero() {
+		key.Add("tags", strings.Join(build.Tags, ","))
+		key.Add("goos", build.GOOS)
+		key.Add("goarch", build.GOARCH)
+	}
+	for _, m := range modules {
+		key.Add("dir", m.Dir)
+		if err := key.AddGoSources(m.Path, m.Dir, nil); err != nil {
+			return "", err
+		}
+		// A cgo or assembly file added or removed changes what go list reports
+		if err := key.AddNonGoSources(m.Path, m.Dir); err != nil {
+			return "", err
+		}
+	}
+	return key.Sum(), nil
+}
+
+// saveAnalysis writes the file atomically, as concurrent commands may read it
+func saveAnalysis(file string, a analysis) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), analysisFile+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/artifacts"
+)
+
+// collectArtifacts copies the files matching the outputs of a task in every
+// module into dir/<task>/<module dir relative to the workspace root>
+func collectArtifacts(workspaceRoot, name string, outputs []string, modules []analyzer.Module, dir string) error {
+	if len(outputs) == 0 {
+		fmt.Printf("No outputs declared for %s in knit.yaml, no artifact collected\n", name)
+		return nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	count := 0
+	for _, m := range modules {
+		files, err := artifacts.Match(m.Dir, outputs)
+		if err != nil {
+			return fmt.Errorf("failed to collect the outputs of %s: %w", m.Path, err)
+		}
+		relDir, err := filepath.Rel(workspaceRoot, m.Dir)
+		if err != nil {
+			relDir = m.Path
+		}
+		if err := artifacts.Copy(m.Dir, filepath.Join(dir, name, relDir), files); err != nil {
+			return fmt.Errorf("failed to collect the outputs of %s: %w", m.Path, err)
+		}
+		count += len(files)
+	}
+	fmt.Printf("Collected %d artifacts from %d modules into %s\n", count, len(modules), filepath.Join(dir, name))
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/bench"
+	"github.com/nicolasgere/knit/lib/git"
+	"github.com/urfave/cli/v2"
+)
+
+// createBenchCommand creates the 'bench' command comparing the benchmarks of
+// the affected modules with their base reference
+func createBenchCommand() *cli.Command {
+	var (
+		path         string
+		base         string
+		useMergeBase bool
+		changes      changeFlags
+		all          bool
+		targets      cli.StringSlice
+		gate         string
+		pattern      string
+		count        int
+		benchtime    string
+	)
+
+	return &cli.Command{
+		Name:  "bench",
+		Usage: "Compare the benchmarks of affected modules with the base reference, failing on regressions",
+		Description: `Run the benchmarks of each affected module, then the ones of the module at
+the base reference, exported next to the working tree, and print how the
+median ns/op of every benchmark changed. With --gate, fail when a benchmark
+slows down by more than the threshold. Benchmarks new in the change are
+listed without comparison, modules missing at the base reference are
+skipped. The benchmarks run one module at a time, so that they do not
+compete for the CPU.
+
+Examples:
+  knit bench --gate 5% --base origin/main --merge-base
+  knit bench --all --bench 'BenchmarkParse' --count 10`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Git reference to compare against (branch, tag, or commit)",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.BoolFlag{
+				Name:        "merge-base",
+				Usage:       "Compare against merge-base (common ancestor) - recommended for CI/PRs",
+				Aliases:     []string{"m"},
+				Destination: &useMergeBase,
+			},
+			&cli.BoolFlag{
+				Name:        "all",
+				Usage:       "Benchmark every module, not only the affected ones",
+				Destination: &all,
+			},
+			&cli.StringSliceFlag{
+				Name:        "target",
+				Usage:       "Targeted module path or directory, globs allowed, '/...' matches a whole prefix (repeatable)",
+				Aliases:     []string{"t"},
+				Destination: &targets,
+			},
+			&cli.StringFlag{
+				Name:        "gate",
+				Usage:       "Fail when a benchmark slows down by more than this percentage, e.g. 5%",
+				Destination: &gate,
+			},
+			&cli.StringFlag{
+				Name:        "bench",
+				Usage:       "Regular expression of the benchmarks to run, as go test -bench",
+				Value:       ".",
+				Destination: &pattern,
+			},
+			&cli.IntFlag{
+				Name:        "count",
+				Usage:       "Number of runs of every benchmark, the median being compared",
+				Value:       5,
+				Destination: &count,
+			},
+			&cli.StringFlag{
+				Name:        "benchtime",
+				Usage:       "Run time or iterations of every benchmark, as go test -benchtime",
+				Destination: &benchtime,
+			},
+		}, changes.flags()...),
+		Action: func(c *cli.Context) error {
+			threshold, err := parseGate(gate)
+			if err != nil {
+				return err
+			}
+			if count < 1 {
+				return fmt.Errorf("--count must be at least 1")
+			}
+			src, err := changes.source(base, useMergeBase)
+			if err != nil {
+				return err
+			}
+			absPath, workspace, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			modules := workspace
+			if !all {
+				if modules, err = affectedModules(modules, absPath, src); err != nil {
+					return err
+				}
+			}
+			if patterns := targets.Value(); len(patterns) > 0 {
+				targeted, err := resolveTargets(workspace, patterns, absPath)
+				if err != nil {
+					return err
+				}
+				modules = intersectModules(modules, targeted)
+			}
+			if len(modules) == 0 {
+				fmt.Println("No affected modules found")
+				return nil
+			}
+
+			// Materialize the base reference next to the working tree
+			repoRoot, err := git.GetRepoRoot(absPath)
+			if err != nil {
+				return err
+			}
+			baseDir, err := os.MkdirTemp("", "knit-bench-")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			defer os.RemoveAll(baseDir)
+			if err := git.ExportTree(src.Base, src.UseMergeBase, absPath, baseDir); err != nil {
+				return err
+			}
+
+			args := benchArgs(pattern, count, benchtime)
+			regressions := 0
+			var rows []string
+			for _, m := range modules {
+				oldDir, err := baseModuleDir(repoRoot, baseDir, m)
+				if err != nil {
+					return err
+				}
+				if oldDir == "" {
+					fmt.Printf("- %s: not found at %s, skipped\n", m.Path, src.Base)
+					continue
+				}
+				fmt.Printf("Running the benchmarks of %s\n", m.Path)
+				head, err := runBenchmarks(m.Dir, args)
+				if err != nil {
+					return fmt.Errorf("%s: %w", m.Path, err)
+				}
+				if len(head) == 0 {
+					fmt.Printf("- %s: no benchmarks\n", m.Path)
+					continue
+				}
+				fmt.Printf("Running the benchmarks of %s at %s\n", m.Path, src.Base)
+				old, err := runBenchmarks(oldDir, args)
+				if err != nil {
+					return fmt.Errorf("%s at %s: %w", m.Path, src.Base, err)
+				}
+				for _, cmp := range bench.Compare(old, head, threshold) {
+					if cmp.Regressed {
+						regressions++
+					}
+					rows = append(rows, benchRow(m, cmp))
+				}
+			}
+
+			if len(rows) > 0 {
+				fmt.Println()
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "MODULE\tBENCHMARK\tBASE\tHEAD\tDELTA")
+				for _, row := range rows {
+					fmt.Fprintln(w, row)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+			if regressions > 0 {
+				return cli.Exit(fmt.Sprintf("%d benchmark(s) regressed by more than %s%%", regressions, strconv.FormatFloat(threshold, 'f', -1, 64)), 1)
+			}
+			return nil
+		},
+	}
+}
+
+// parseGate parses a percentage such as 5% or 2.5, empty meaning no gate
+func parseGate(gate string) (float64, error) {
+	if gate == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(gate), "%"), 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid --gate %q, expected a positive percentage such as 5%%", gate)
+	}
+	return v, nil
+}
+
+// benchArgs returns the go test arguments running the benchmarks only
+func benchArgs(pattern string, count int, benchtime string) []string {
+	args := []string{"test", "-run", "^$", "-bench", pattern, "-count", strconv.Itoa(count)}
+	if benchtime != "" {
+		args = append(args, "-benchtime", benchtime)
+	}
+	return append(args, "./...")
+}
+
+// runBenchmarks runs go test with args in dir and parses the benchmarks it
+// prints
+func runBenchmarks(dir string, args []string) (bench.Results, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("go %s failed: %w\n%s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return bench.Parse(strings.NewReader(string(output)))
+}
+
+// benchRow renders the comparison of a benchmark of m as a row of the table
+func benchRow(m analyzer.Module, c bench.Comparison) string {
+	name := c.Name
+	if rest, ok := strings.CutPrefix(name, m.Path+"."); ok {
+		name = rest
+	} else if rest, ok := strings.CutPrefix(name, m.Path+"/"); ok {
+		name = rest
+	}
+	if c.New() {
+		return fmt.Sprintf("%s\t%s\t-\t%s\tnew", m.Path, name, formatNsPerOp(c.Head))
+	}
+	delta := fmt.Sprintf("%+.1f%%", c.Delta)
+	if c.Regressed {
+		delta += " ✗"
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s", m.Path, name, formatNsPerOp(c.Base), formatNsPerOp(c.Head), delta)
+}
+
+// formatNsPerOp renders a duration per operation in nanoseconds with a unit
+// fitting its magnitude
+func formatNsPerOp(ns float64) string {
+	switch {
+	case ns >= 1e9:
+		return fmt.Sprintf("%.2fs/op", ns/1e9)
+	case ns >= 1e6:
+		return fmt.Sprintf("%.2fms/op", ns/1e6)
+	case ns >= 1e3:
+		return fmt.Sprintf("%.2fµs/op", ns/1e3)
+	}
+	return fmt.Sprintf("%.1fns/op", ns)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nicolasgere/knit/lib/runner"
+	"github.com/nicolasgere/knit/lib/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/mod/module"
+)
+
+// platform is a GOOS and GOARCH pair to build for
+type platform struct {
+	goos, goarch string
+}
+
+func (p platform) String() string {
+	return p.goos + "/" + p.goarch
+}
+
+// parsePlatforms parses a comma-separated list of goos/goarch pairs
+func parsePlatforms(s string) ([]platform, error) {
+	var platforms []platform
+	seen := make(map[platform]bool)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		goos, goarch, ok := strings.Cut(field, "/")
+		if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+			return nil, fmt.Errorf("invalid platform %q, expected goos/goarch, e.g. linux/amd64", field)
+		}
+		p := platform{goos, goarch}
+		if !seen[p] {
+			seen[p] = true
+			platforms = append(platforms, p)
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no platform to build for")
+	}
+	return platforms, nil
+}
+
+// createBuildCommand creates the 'build' command cross-compiling the main
+// packages of every module
+func createBuildCommand(r *runner.Runner) *cli.Command {
+	var (
+		path        string
+		platforms   string
+		outDir      string
+		targets     cli.StringSlice
+		exclude     cli.StringSlice
+		useColor    bool
+		timestamps  string
+		prefixWidth int
+	)
+
+	return &cli.Command{
+		Name:  "build",
+		Usage: "Build the main packages of every module for a list of platforms",
+		Description: `Build the modules with a main package for every platform of --platforms in
+parallel, the host one by default. The binary of a module is written to
+DIR/<module>_<goos>_<goarch>, <module> being the last element of its path
+without its major version suffix, and .exe added for windows. Modules whose
+paths end alike get -2, -3... after <module>, in the order of 'knit list'. A module with several main packages gets a directory
+of that name holding one binary per package. A failed build does not stop the
+others, the failures are reported per platform at the end.
+
+Examples:
+  knit build --platforms linux/amd64,linux/arm64,darwin/arm64
+  knit build -t example.com/app -o bin   # Only app, into bin/`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "platforms",
+				Usage:       "Comma-separated goos/goarch pairs to build for (default: the host)",
+				Destination: &platforms,
+			},
+			&cli.StringFlag{
+				Name:        "out",
+				Usage:       "Directory the binaries are written to",
+				Aliases:     []string{"o"},
+				Value:       "dist",
+				Destination: &outDir,
+			},
+			&cli.StringSliceFlag{
+				Name:        "target",
+				Usage:       "Targeted module path or directory, globs allowed, '/...' matches a whole prefix (repeatable)",
+				Aliases:     []string{"t"},
+				Destination: &targets,
+			},
+			excludeFlag(&exclude),
+			&cli.BoolFlag{
+				Name:        "color",
+				Usage:       "Enable colored output for better readability",
+				Aliases:     []string{"c"},
+				Destination: &useColor,
+			},
+			timestampsFlag(&timestamps),
+			prefixWidthFlag(&prefixWidth),
+		},
+		Action: func(c *cli.Context) error {
+			utils.SetColorEnabled(useColor)
+			if err := utils.SetTimestamps(timestamps); err != nil {
+				return err
+			}
+			if err := utils.SetPrefixWidth(prefixWidth); err != nil {
+				return err
+			}
+			if platforms == "" {
+				platforms = runtime.GOOS + "/" + runtime.GOARCH
+			}
+			selected, err := parsePlatforms(platforms)
+			if err != nil {
+				return err
+			}
+			dir, err := filepath.Abs(outDir)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			if patterns := targets.Value(); len(patterns) > 0 {
+				if modules, err = resolveTargets(modules, patterns, absPath); err != nil {
+					return err
+				}
+			}
+			modules = excludeModules(modules, exclude.Value(), absPath)
+			described, err := describeModules(absPath, modules)
+			if err != nil {
+				return err
+			}
+			var binaries []listedModule
+			for _, m := range described {
+				if m.HasMain {
+					binaries = append(binaries, m)
+				}
+			}
+			if len(binaries) == 0 {
+				fmt.Println("No module with a main package to build")
+				return nil
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+			toolchains, err := moduleToolchains(absPath, modules)
+			if err != nil {
+				return err
+			}
+			return runBuilds(r, buildTasks(binaries, selected, dir, toolchains), len(binaries), selected)
+		},
+	}
+}
+
+// buildTask is the build of a module for a platform
+type buildTask struct {
+	module   string
+	platform platform
+	task     runner.Task
+}
+
+// buildTasks returns the build of every module for every platform, writing
+// the binaries to dir with the toolchain settings of moduleToolchains
+func buildTasks(modules []listedModule, platforms []platform, dir string, toolchains map[string]string) []buildTask {
+	names := make([]string, len(modules))
+	for i, m := range modules {
+		names[i] = binaryName(m.Path)
+	}
+	names = uniqueNames(names, '-')
+
+	var tasks []buildTask
+	for i, m := range modules {
+		for _, p := range platforms {
+			name := fmt.Sprintf("%s_%s_%s", names[i], p.goos, p.goarch)
+			out := filepath.Join(dir, name)
+			if len(m.Mains) > 1 {
+				// go build -o writes one binary per package into a directory
+				out += string(filepath.Separator)
+			} else if p.goos == "windows" {
+				out += ".exe"
+			}
+			args := []string{"go", "build", "-o", shellQuote(out)}
+			for _, main := range m.Mains {
+				args = append(args, shellQuote(main))
+			}
+			env := []string{"GOOS=" + p.goos, "GOARCH=" + p.goarch}
+			if setting := toolchains[m.Path]; setting != "" {
+				env = append(env, setting)
+			}
+			tasks = append(tasks, buildTask{
+				module:   m.Path,
+				platform: p,
+				task: runner.Task{
+					Id:   m.Path + " " + p.String(),
+					Name: "build",
+					Cmd:  strings.Join(args, " "),
+					Root: m.Dir,
+					Env:  env,
+				},
+			})
+		}
+	}
+	return tasks
+}
+
+// binaryName returns the name of the binary of a module, the last element of
+// its path without the major version suffix, e.g. service for
+// example.com/service/v2
+func binaryName(modulePath string) string {
+	if prefix, _, ok := module.SplitPathVersion(modulePath); ok {
+		modulePath = prefix
+	}
+	return shortName(modulePath)
+}
+
+// runBuilds runs the builds in parallel and reports the failed ones grouped
+// by platform
+func runBuilds(r *runner.Runner, builds []buildTask, modules int, platforms []platform) error {
+	tasks := make([]runner.Task, len(builds))
+	ids := make([]string, len(builds))
+	for i, b := range builds {
+		tasks[i], ids[i] = b.task, b.task.Id
+	}
+	utils.AlignPrefixes(ids)
+	results := make([]runner.TaskResult, len(tasks))
+	tfs := r.RunTasks(tasks)
+	var wg sync.WaitGroup
+	wg.Add(len(tfs))
+	for i, tf := range tfs {
+		go handleTaskFuture(tf, &results[i], nil, &wg)
+	}
+	wg.Wait()
+
+	failed := make(map[string][]string)
+	failures := 0
+	for i, result := range results {
+		if result.Status != 0 {
+			p := builds[i].platform.String()
+			failed[p] = append(failed[p], builds[i].module)
+			failures++
+		}
+	}
+	if failures == 0 {
+		names := make([]string, len(platforms))
+		for i, p := range platforms {
+			names[i] = p.String()
+		}
+		fmt.Printf("Built %d modules for %s\n", modules, strings.Join(names, ", "))
+		return nil
+	}
+	fmt.Println("Failed builds:")
+	for _, p := range sortedKeys(failed) {
+		sort.Strings(failed[p])
+		fmt.Printf("  %s: %s\n", p, strings.Join(failed[p], ", "))
+	}
+	return cli.Exit(fmt.Sprintf("%d of %d builds failed", failures, len(builds)), 1)
+}
+
+// shellQuote quotes s for sh, the shell the tasks run in
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/artifacts"
+	"github.com/nicolasgere/knit/lib/cache"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/nicolasgere/knit/lib/runner"
+)
+
+// taskCache holds the cache keys of the modules a task with cache enabled
+// runs on
+type taskCache struct {
+	cache   *cache.Cache
+	name    string
+	outputs []string
+	modules []analyzer.Module
+	keys    []string
+}
+
+// goBuildEnv are the environment variables changing what the go command
+// builds, part of every cache key
+var goBuildEnv = []string{
+	"GOOS", "GOARCH", "GOFLAGS", "GOEXPERIMENT", "CGO_ENABLED", "CGO_CFLAGS", "CGO_CPPFLAGS",
+	"CGO_CXXFLAGS", "CGO_LDFLAGS", "CC", "CXX", "GO386", "GOAMD64", "GOARM", "GOARM64",
+	"GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM", "GOTOOLCHAIN",
+}
+
+// newTaskCache derives the cache key of every task. The key of a module
+// covers the task command and environment, the Go toolchain, go.work, the
+// Go sources, go.mod, go.sum, embedded, cgo and assembly files of the module
+// and of the workspace modules it depends on, and the declared inputs. The
+// declared outputs are left out.
+func newTaskCache(workspaceRoot, name string, task config.TaskConfig, tasks []runner.Task, modules []analyzer.Module) (*taskCache, error) {
+	for _, pattern := range task.Inputs {
+		if err := artifacts.Validate(pattern); err != nil {
+			return nil, fmt.Errorf("invalid inputs of task %s: %w", name, err)
+		}
+	}
+	_, all, a, err := loadAnalysis(workspaceRoot, true, analyzer.BuildContext{})
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]string, len(all))
+	for _, m := range all {
+		dirs[m.Path] = m.Dir
+	}
+	toolchain, err := goToolchain(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	var workFiles []string
+	for _, f := range []string{"go.work", "go.work.sum"} {
+		if _, err := os.Stat(filepath.Join(workspaceRoot, f)); err == nil {
+			workFiles = append(workFiles, f)
+		}
+	}
+
+	tc := &taskCache{cache: cache.Open(workspaceRoot), name: name, outputs: task.Outputs, modules: modules, keys: make([]string, len(tasks))}
+	for i, m := range modules {
+		produced, err := artifacts.Match(m.Dir, task.Outputs)
+		if err != nil {
+			return nil, err
+		}
+		skip := make(map[string]bool, len(produced))
+		for _, f := range produced {
+			skip[f] = true
+		}
+
+		key := cache.NewKey(name, tasks[i].Cmd)
+		key.Add("toolchain", toolchain)
+		for _, o := range task.Outputs {
+			key.Add("output", o)
+		}
+		lookup := envLookup(tasks[i].Environ)
+		for _, env := range append(append([]string{}, goBuildEnv...), task.InputEnv...) {
+			if value, ok := lookup(env); ok {
+				key.Add("env "+env, value)
+			}
+		}
+		// The task environment comes after, as it overrides the one of knit
+		for _, kv := range tasks[i].Env {
+			key.Add("task env", kv)
+		}
+		if err := key.AddFiles("workspace", workspaceRoot, workFiles); err != nil {
+			return nil, err
+		}
+		if err := key.AddGoSources(m.Path, m.Dir, skip); err != nil {
+			return nil, err
+		}
+		if err := key.AddEmbedded(m.Path, m.Dir, a.Embeds[m.Path]); err != nil {
+			return nil, err
+		}
+		if err := key.AddFiles(m.Path, m.Dir, a.NonGoFiles[m.Path]); err != nil {
+			return nil, err
+		}
+		inputs, err := artifacts.Match(m.Dir, task.Inputs)
+		if err != nil {
+			return nil, err
+		}
+		if err := key.AddFiles(m.Path+" input", m.Dir, inputs); err != nil {
+			return nil, err
+		}
+		for _, dep := range resolver.Dependencies(a.Imports, m.Path) {
+			if err := key.AddGoSources(dep, dirs[dep], nil); err != nil {
+				return nil, err
+			}
+			if err := key.AddEmbedded(dep, dirs[dep], a.Embeds[dep]); err != nil {
+				return nil, err
+			}
+			if err := key.AddFiles(dep, dirs[dep], a.NonGoFiles[dep]); err != nil {
+				return nil, err
+			}
+		}
+		tc.keys[i] = key.Sum()
+	}
+	return tc, nil
+}
+
+// goToolchain returns the version of the Go toolchain the go command selects
+// in the workspace, following the toolchain directives
+func goToolchain(workspaceRoot string) (string, error) {
+	cmd := exec.Command("go", "env", "GOVERSION")
+	cmd.Dir = workspaceRoot
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the Go toolchain version: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// restore restores the outputs of the modules with a cached result and
+// returns their entries, nil for the modules without one
+func (tc *taskCache) restore() ([]*cache.Entry, error) {
+	entries := make([]*cache.Entry, len(tc.keys))
+	for i, key := range tc.keys {
+		e, err := tc.cache.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			continue
+		}
+		if err := tc.cache.Restore(key, e, tc.modules[i].Dir); err != nil {
+			return nil, err
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+// store caches the successful results of the modules that ran. Failing to
+// write the cache only warns, the run itself being done.
+func (tc *taskCache) store(results []runner.TaskResult, ran []bool) {
+	for i, result := range results {
+		if !ran[i] || result.Status != 0 {
+			continue
+		}
+		m := tc.modules[i]
+		produced, err := artifacts.Match(m.Dir, tc.outputs)
+		if err == nil {
+			e := &cache.Entry{Task: tc.name, Module: m.Path, Created: time.Now(), Elapsed: result.Duration, Outputs: produced}
+			err = tc.cache.Put(tc.keys[i], e, m.Dir)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to cache the result of %s: %v\n", m.Path, err)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/artifacts"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/git"
+	"github.com/nicolasgere/knit/lib/vcs"
+	"github.com/urfave/cli/v2"
+)
+
+// changeFlags holds the flags shared by every command that detects changed files
+type changeFlags struct {
+	baseSHA    string
+	shaFile    string
+	filesFrom  string
+	vcs        string
+	gitBackend string
+}
+
+func (f *changeFlags) flags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "base-sha",
+			Usage:       "Commit SHA to compare against (overrides --base)",
+			Destination: &f.baseSHA,
+		},
+		&cli.StringFlag{
+			Name:        "since-sha-file",
+			Usage:       "File containing the commit SHA to compare against, e.g. the last successful CI run (overrides --base)",
+			Destination: &f.shaFile,
+		},
+		&cli.StringFlag{
+			Name:        "files-from",
+			Usage:       "Read changed files from a file ('-' for stdin) instead of asking the VCS",
+			Destination: &f.filesFrom,
+		},
+	}, f.vcsFlags()...)
+}
+
+// vcsFlags returns only the flags selecting the VCS, for commands taking the
+// reference to compare against from elsewhere
+func (f *changeFlags) vcsFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "vcs",
+			Usage:       "Version control system: auto (default), git, jj, hg",
+			Value:       vcs.Auto,
+			Destination: &f.vcs,
+		},
+		&cli.StringFlag{
+			Name:        "git-backend",
+			Usage:       "Git implementation: auto (default), exec (git binary), go-git (built-in)",
+			Value:       git.BackendAuto,
+			Destination: &f.gitBackend,
+		},
+	}
+}
+
+// vcsSource builds the change source comparing against ref with the selected
+// VCS, ignoring the flags overriding the reference
+func (f *changeFlags) vcsSource(ref string, useMergeBase bool) changeSource {
+	return changeSource{
+		Base:         ref,
+		UseMergeBase: useMergeBase,
+		VCS:          f.vcs,
+		GitBackend:   f.gitBackend,
+	}
+}
+
+// source builds the change source for the given base reference
+func (f *changeFlags) source(base string, useMergeBase bool) (changeSource, error) {
+	ref, err := resolveBaseRef(base, f.baseSHA, f.shaFile)
+	if err != nil {
+		return changeSource{}, err
+	}
+	return changeSource{
+		Base:         ref,
+		UseMergeBase: useMergeBase,
+		FilesFrom:    f.filesFrom,
+		VCS:          f.vcs,
+		GitBackend:   f.gitBackend,
+	}, nil
+}
+
+// resolveBaseRef picks the reference to compare against. An explicit SHA
+// wins over the SHA file, which wins over the base reference. A missing SHA file
+// falls back to base, so the first run of a pipeline still works.
+func resolveBaseRef(base, baseSHA, shaFile string) (string, error) {
+	if baseSHA != "" {
+		return baseSHA, nil
+	}
+	if shaFile == "" {
+		return base, nil
+	}
+
+	data, err := os.ReadFile(shaFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "warning: %s not found, comparing against %s\n", shaFile, base)
+			return base, nil
+		}
+		return "", fmt.Errorf("failed to read sha file: %w", err)
+	}
+
+	sha := strings.TrimSpace(string(data))
+	if sha == "" {
+		fmt.Fprintf(os.Stderr, "warning: %s is empty, comparing against %s\n", shaFile, base)
+		return base, nil
+	}
+	return sha, nil
+}
+
+// changeSource describes where the list of changed files comes from: a VCS
+// diff against Base, or a pre-computed list read from FilesFrom when set
+type changeSource struct {
+	Base         string
+	UseMergeBase bool
+	FilesFrom    string
+	VCS          string
+	GitBackend   string
+}
+
+// changedFiles returns the changed files, relative to the workspace root or absolute
+func (s changeSource) changedFiles(absPath string) ([]string, error) {
+	if s.FilesFrom == "" {
+		repo, err := vcs.New(s.VCS, s.GitBackend, absPath)
+		if err != nil {
+			return nil, err
+		}
+		files, err := repo.ChangedFiles(s.Base, s.UseMergeBase, absPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get changed files: %w", err)
+		}
+
+		// The VCS prints paths relative to the repository root, which is not
+		// necessarily the workspace root
+		repoRoot, err := repo.Root(absPath)
+		if err != nil {
+			return nil, err
+		}
+		return vcs.RelativeToDir(files, repoRoot, absPath)
+	}
+
+	var input io.Reader = os.Stdin
+	if s.FilesFrom != "-" {
+		f, err := os.Open(s.FilesFrom)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file list: %w", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	files := make([]string, 0)
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			files = append(files, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+	return files, nil
+}
+
+// affectedModules returns the modules containing changed files, or consuming
+// the code generated from them as mapped by knit.yaml, in the same order as
+// modules
+func affectedModules(modules []analyzer.Module, absPath string, src changeSource) ([]analyzer.Module, error) {
+	changes, err := detectChanges(modules, absPath, src)
+	if err != nil {
+		return nil, err
+	}
+	return changes.affected, nil
+}
+
+// moduleChanges are the modules a change affects
+type moduleChanges struct {
+	// affected are the modules affected, in the order of the workspace
+	affected []analyzer.Module
+	// generate holds the modules consuming the code generated from changed
+	// files to run a generate task of knit.yaml on, keyed by task
+	generate map[string][]analyzer.Module
+}
+
+// detectChanges reads the changed files of src once and returns the
+// modules they affect
+func detectChanges(modules []analyzer.Module, absPath string, src changeSource) (*moduleChanges, error) {
+	changedFiles, err := src.changedFiles(absPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(absPath)
+	if err != nil {
+		return nil, err
+	}
+	consumers := generatedConsumers(cfg, changedFiles, absPath, modules)
+
+	moduleDirs := make([]string, len(modules))
+	for i, m := range modules {
+		moduleDirs[i] = m.Dir
+	}
+
+	changedFiles, requiring, err := attributeVendored(changedFiles, absPath, modules)
+	if err != nil {
+		return nil, err
+	}
+	affectedDirs := make(map[string]bool)
+	for _, dir := range git.FindAffectedModuleDirs(changedFiles, moduleDirs, absPath) {
+		affectedDirs[dir] = true
+	}
+
+	changes := &moduleChanges{affected: make([]analyzer.Module, 0, len(affectedDirs)), generate: make(map[string][]analyzer.Module)}
+	for _, m := range modules {
+		if _, generated := consumers[m.Path]; affectedDirs[m.Dir] || requiring[m.Path] || generated {
+			changes.affected = append(changes.affected, m)
+		}
+		for _, task := range consumers[m.Path] {
+			changes.generate[task] = append(changes.generate[task], m)
+		}
+	}
+	return changes, nil
+}
+
+// generatedConsumers returns the modules consuming the code generated from
+// the changed files according to the generated section of cfg, keyed by
+// module path, with the generate tasks to run on each
+func generatedConsumers(cfg *config.Config, changedFiles []string, absPath string, modules []analyzer.Module) map[string][]string {
+	consumers := make(map[string][]string)
+	for _, g := range cfg.Generated {
+		if !changedInput(g.Inputs, changedFiles, absPath) {
+			continue
+		}
+		for _, m := range filterModules(modules, g.Modules, absPath) {
+			tasks := consumers[m.Path]
+			if g.Generate != "" && !slices.Contains(tasks, g.Generate) {
+				tasks = append(tasks, g.Generate)
+			}
+			consumers[m.Path] = tasks
+		}
+	}
+	return consumers
+}
+
+// changedInput reports whether one of the changed files, relative to absPath
+// unless absolute, matches one of the input globs
+func changedInput(inputs, changedFiles []string, absPath string) bool {
+	for _, file := range changedFiles {
+		rel := file
+		if filepath.IsAbs(file) {
+			var err error
+			if rel, err = filepath.Rel(absPath, file); err != nil {
+				continue
+			}
+		}
+		rel = filepath.ToSlash(rel)
+		for _, input := range inputs {
+			if artifacts.MatchPath(input, rel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attributeVendored splits off the changed files of the vendor directory of
+// the workspace at absPath, written by go work vendor, returning the other
+// files and the paths of the modules requiring the vendored modules changed.
+// A change to vendor/modules.txt alone affects no module: the go.mod files
+// changing with it do. A vendor directory of a module belongs to the module,
+// like its other files.
+func attributeVendored(changedFiles []string, absPath string, modules []analyzer.Module) ([]string, map[string]bool, error) {
+	if _, err := os.Stat(filepath.Join(absPath, "go.work")); err != nil {
+		return changedFiles, nil, nil
+	}
+	v, err := analyzer.ReadVendor(absPath)
+	if err != nil || v == nil {
+		return changedFiles, nil, err
+	}
+
+	var rest []string
+	vendored := make(map[string]bool)
+	for _, file := range changedFiles {
+		absFile := file
+		if !filepath.IsAbs(file) {
+			absFile = filepath.Join(absPath, file)
+		}
+		rel, err := filepath.Rel(v.Dir, absFile)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			rest = append(rest, file)
+			continue
+		}
+		if m := v.ModuleOf(filepath.ToSlash(rel)); m != "" {
+			vendored[m] = true
+		}
+	}
+
+	requiring := make(map[string]bool)
+	for m := range vendored {
+		paths, err := analyzer.Requiring(modules, m)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range paths {
+			requiring[p] = true
+		}
+	}
+	return rest, requiring, nil
+}
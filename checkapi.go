@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/apicheck"
+	"github.com/nicolasgere/knit/lib/git"
+	"github.com/urfave/cli/v2"
+)
+
+// createCheckAPICommand creates the 'check-api' command reporting breaking
+// changes of module APIs compared to a git reference
+func createCheckAPICommand() *cli.Command {
+	var (
+		path         string
+		base         string
+		useMergeBase bool
+		changes      changeFlags
+		all          bool
+		verbose      bool
+	)
+
+	return &cli.Command{
+		Name:  "check-api",
+		Usage: "Report incompatible changes to the exported API of affected modules",
+		Description: `Compare the exported API of each affected module with its version at the
+base reference, apidiff style, and fail on incompatible changes. Main and
+internal packages are not part of the API. Modules missing at the base
+reference are skipped.
+
+Examples:
+  knit check-api --base origin/main --merge-base
+  knit check-api --all -v              # Every module, listing compatible changes too`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Git reference to compare against (branch, tag, or commit)",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.BoolFlag{
+				Name:        "merge-base",
+				Usage:       "Compare against merge-base (common ancestor) - recommended for CI/PRs",
+				Aliases:     []string{"m"},
+				Destination: &useMergeBase,
+			},
+			&cli.BoolFlag{
+				Name:        "all",
+				Usage:       "Check every module, not only the affected ones",
+				Destination: &all,
+			},
+			&cli.BoolFlag{
+				Name:        "verbose",
+				Usage:       "Also list compatible changes",
+				Aliases:     []string{"v"},
+				Destination: &verbose,
+			},
+		}, changes.flags()...),
+		Action: func(c *cli.Context) error {
+			src, err := changes.source(base, useMergeBase)
+			if err != nil {
+				return err
+			}
+			return runCheckAPI(path, src, all, verbose)
+		},
+	}
+}
+
+func runCheckAPI(path string, src changeSource, all, verbose bool) error {
+	absPath, modules, err := loadModules(path)
+	if err != nil {
+		return err
+	}
+	if !all {
+		if modules, err = affectedModules(modules, absPath, src); err != nil {
+			return err
+		}
+	}
+	if len(modules) == 0 {
+		fmt.Println("No affected modules found")
+		return nil
+	}
+
+	// Materialize the base reference next to the working tree
+	repoRoot, err := git.GetRepoRoot(absPath)
+	if err != nil {
+		return err
+	}
+	baseDir, err := os.MkdirTemp("", "knit-check-api-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(baseDir)
+	if err := git.ExportTree(src.Base, src.UseMergeBase, absPath, baseDir); err != nil {
+		return err
+	}
+
+	incompatible := 0
+	for _, m := range modules {
+		oldDir, err := baseModuleDir(repoRoot, baseDir, m)
+		if err != nil {
+			return err
+		}
+		if oldDir == "" {
+			fmt.Printf("- %s: not found at %s, skipped\n", m.Path, src.Base)
+			continue
+		}
+
+		changes, err := apicheck.Compare(m.Path, oldDir, m.Dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.Path, err)
+		}
+
+		breaking := 0
+		for _, c := range changes {
+			if !c.Compatible {
+				breaking++
+			}
+		}
+		incompatible += breaking
+
+		if breaking > 0 {
+			fmt.Printf("✗ %s: %d incompatible change(s)\n", m.Path, breaking)
+		} else {
+			fmt.Printf("✓ %s: compatible (%d change(s))\n", m.Path, len(changes))
+		}
+		for _, c := range changes {
+			if !c.Compatible {
+				fmt.Printf("    %s\n", c.Message)
+			} else if verbose {
+				fmt.Printf("    compatible: %s\n", c.Message)
+			}
+		}
+	}
+
+	if incompatible > 0 {
+		return cli.Exit(fmt.Sprintf("%d incompatible API change(s)", incompatible), 1)
+	}
+	return nil
+}
+
+// baseModuleDir returns the directory of module m in the exported base tree,
+// or an empty string when the module does not exist there
+func baseModuleDir(repoRoot, baseDir string, m analyzer.Module) (string, error) {
+	// Resolve symlinks so paths from git and from go list compare equal
+	if resolved, err := filepath.EvalSymlinks(repoRoot); err == nil {
+		repoRoot = resolved
+	}
+	dir := m.Dir
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	rel, err := filepath.Rel(repoRoot, dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate %s in the repository: %w", m.Path, err)
+	}
+	oldDir := filepath.Join(baseDir, rel)
+	if _, err := os.Stat(filepath.Join(oldDir, "go.mod")); err != nil {
+		return "", nil
+	}
+	return oldDir, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/query"
+	"github.com/urfave/cli/v2"
+)
+
+// archViolation is a dependency edge breaking an architecture rule
+type archViolation struct {
+	Rule   string
+	From   string
+	To     string
+	Import analyzer.Import
+}
+
+// bannedImportViolation is a package importing a banned package
+type bannedImportViolation struct {
+	Rule     config.BannedImport
+	Package  string
+	Imported string
+	// Position is the file and line of the import, relative to the workspace root
+	Position string
+}
+
+// createCheckArchCommand creates the 'check-arch' command validating the
+// dependency graph against the architecture rules of knit.yaml
+func createCheckArchCommand() *cli.Command {
+	var path string
+
+	return &cli.Command{
+		Name:  "check-arch",
+		Usage: "Check module dependencies against the architecture rules of knit.yaml",
+		Description: `Validate every workspace dependency against the 'architecture' rules of
+knit.yaml and list the violating edges. Rules select modules with queries
+(see 'knit query --help'):
+
+  architecture:
+    - name: domain does not depend on api
+      from: tag(domain)
+      deny: tag(api)
+    - name: nothing uses internal tools
+      deny: example.com/internal-tools/...
+    - name: libraries only use libraries
+      from: tag(lib)
+      allow: tag(lib)
+
+'from' defaults to every module. Package imports are checked against the
+'bannedImports' rules, where "/..." matches a whole prefix:
+
+  bannedImports:
+    - import: database/sql
+      allow: [example.com/platform/db/...]
+      message: use example.com/platform/db instead
+
+Exits with code 1 on violations.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Load(absPath)
+			if err != nil {
+				return err
+			}
+			if len(cfg.Architecture) == 0 && len(cfg.BannedImports) == 0 {
+				fmt.Printf("No architecture rules in %s\n", config.FileName)
+				return nil
+			}
+
+			violations, err := checkArchitecture(modules, cfg, absPath)
+			if err != nil {
+				return err
+			}
+			banned, err := checkBannedImports(modules, cfg, absPath)
+			if err != nil {
+				return err
+			}
+
+			rule := ""
+			for _, v := range violations {
+				if v.Rule != rule {
+					rule = v.Rule
+					fmt.Printf("✗ %s\n", rule)
+				}
+				fmt.Printf("    %s -> %s (%s imports %s)\n", v.From, v.To, v.Import.Package, v.Import.Imported)
+			}
+			imported := ""
+			for _, v := range banned {
+				if v.Rule.Import != imported {
+					imported = v.Rule.Import
+					header := "banned import " + imported
+					if v.Rule.Message != "" {
+						header += ": " + v.Rule.Message
+					}
+					fmt.Printf("✗ %s\n", header)
+				}
+				fmt.Printf("    %s: %s imports %s\n", v.Position, v.Package, v.Imported)
+			}
+
+			if total := len(violations) + len(banned); total > 0 {
+				return cli.Exit(fmt.Sprintf("%d architecture violation(s)", total), 1)
+			}
+			fmt.Printf("✓ %d architecture rule(s) passed\n", len(cfg.Architecture)+len(cfg.BannedImports))
+			return nil
+		},
+	}
+}
+
+// checkArchitecture returns the dependency edges violating the rules, rule
+// by rule, in workspace order
+func checkArchitecture(modules []analyzer.Module, cfg *config.Config, absPath string) ([]archViolation, error) {
+	// The rules constrain the build, the dependencies of tests are free
+	imports, err := listModuleImports(absPath, modules, analyzer.BuildContext{NoTests: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze imports: %w", err)
+	}
+	universe := newUniverse(absPath, modules, cfg, imports, nil)
+
+	var violations []archViolation
+	for i, rule := range cfg.Architecture {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule #%d", i+1)
+		}
+		if rule.Deny == "" && rule.Allow == "" {
+			return nil, fmt.Errorf("%s: 'deny' or 'allow' is required", name)
+		}
+
+		from := rule.From
+		if from == "" {
+			from = "all()"
+		}
+		sources, err := evalRuleQuery(universe, name, "from", from)
+		if err != nil {
+			return nil, err
+		}
+		denied, err := evalRuleQuery(universe, name, "deny", rule.Deny)
+		if err != nil {
+			return nil, err
+		}
+		allowed, err := evalRuleQuery(universe, name, "allow", rule.Allow)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range modules {
+			if !sources[m.Path] {
+				continue
+			}
+			for _, dep := range sortedDeps(imports, m.Path) {
+				if denied[dep] || (rule.Allow != "" && !allowed[dep]) {
+					violations = append(violations, archViolation{
+						Rule:   name,
+						From:   m.Path,
+						To:     dep,
+						Import: imports[m.Path][dep][0],
+					})
+				}
+			}
+		}
+	}
+	return violations, nil
+}
+
+// evalRuleQuery evaluates one query of a rule as a set. An empty query
+// selects nothing.
+func evalRuleQuery(u *query.Universe, rule, field, q string) (map[string]bool, error) {
+	selected := make(map[string]bool)
+	if q == "" {
+		return selected, nil
+	}
+	paths, err := query.Eval(q, u)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid '%s': %w", rule, field, err)
+	}
+	for _, p := range paths {
+		selected[p] = true
+	}
+	return selected, nil
+}
+
+// checkBannedImports returns the imports of workspace packages matching a
+// banned import rule, rule by rule, with the file and line of each import
+func checkBannedImports(modules []analyzer.Module, cfg *config.Config, absPath string) ([]bannedImportViolation, error) {
+	if len(cfg.BannedImports) == 0 {
+		return nil, nil
+	}
+	packages, err := analyzer.ListPackages(absPath, modules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packages: %w", err)
+	}
+
+	var violations []bannedImportViolation
+	for _, rule := range cfg.BannedImports {
+		if rule.Import == "" {
+			return nil, fmt.Errorf("banned import rule without 'import'")
+		}
+		for _, p := range packages {
+			if matchesAny(rule.Allow, p.ImportPath) {
+				continue
+			}
+			for _, imp := range p.Imports {
+				if !query.MatchPattern(rule.Import, imp) {
+					continue
+				}
+				positions, err := importPositions(p, imp, absPath)
+				if err != nil {
+					return nil, err
+				}
+				for _, pos := range positions {
+					violations = append(violations, bannedImportViolation{Rule: rule, Package: p.ImportPath, Imported: imp, Position: pos})
+				}
+			}
+		}
+	}
+	return violations, nil
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if query.MatchPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// importPositions returns "file:line" for each import of imported in the
+// non-test files of a package. go list only reports imports per package.
+func importPositions(p analyzer.Package, imported, absPath string) ([]string, error) {
+	fset := token.NewFileSet()
+	var positions []string
+	for _, name := range p.GoFiles {
+		file := filepath.Join(p.Dir, name)
+		f, err := parser.ParseFile(fset, file, nil, parser.ImportsOnly)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		for _, spec := range f.Imports {
+			if path, err := strconv.Unquote(spec.Path.Value); err != nil || path != imported {
+				continue
+			}
+			rel, err := filepath.Rel(absPath, file)
+			if err != nil {
+				rel = file
+			}
+			positions = append(positions, fmt.Sprintf("%s:%d", rel, fset.Position(spec.Pos()).Line))
+		}
+	}
+	// Imports resolved through vendor or cgo may not be found verbatim
+	if len(positions) == 0 {
+		rel, err := filepath.Rel(absPath, p.Dir)
+		if err != nil {
+			rel = p.Dir
+		}
+		positions = append(positions, rel)
+	}
+	return positions, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/mod/module"
+)
+
+// majorDrift is a dependency required at more than one major version
+type majorDrift struct {
+	// Prefix is the path of the dependency without its major version suffix
+	Prefix string
+	// Majors maps the path of every major version, such as
+	// github.com/foo/bar/v2, to the requirements of workspace modules on it
+	Majors map[string][]majorRequirement
+}
+
+// majorRequirement is a workspace module requiring a major version
+type majorRequirement struct {
+	Module  string
+	Version string
+}
+
+// createCheckMajorsCommand creates the 'check-majors' command reporting the
+// dependencies workspace modules require at different major versions
+func createCheckMajorsCommand() *cli.Command {
+	var path string
+
+	return &cli.Command{
+		Name:  "check-majors",
+		Usage: "Report the dependencies required at different major versions across modules",
+		Description: `Find the third-party modules directly required at more than one major
+version, such as github.com/foo/bar/v2 and github.com/foo/bar/v3, by the
+workspace modules, and list which modules require which. The major versions
+of a module are distinct modules to the go command, so a binary may build
+in both and behave differently depending on which one a package uses.
+'knit align' cannot fix them, moving to another major version changes the
+import paths. Exits with code 1 when a dependency is found.
+
+Examples:
+  knit check-majors`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			_, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			reqs, err := analyzer.ListRequirements(modules)
+			if err != nil {
+				return err
+			}
+
+			drifts := findMajorDrifts(reqs)
+			if len(drifts) == 0 {
+				fmt.Println("✓ Every dependency is required at a single major version")
+				return nil
+			}
+			for _, d := range drifts {
+				fmt.Printf("✗ %s is required at %d major versions\n", d.Prefix, len(d.Majors))
+				for _, major := range sortedKeys(d.Majors) {
+					described := make([]string, len(d.Majors[major]))
+					for i, r := range d.Majors[major] {
+						described[i] = r.Module + " (" + r.Version + ")"
+					}
+					fmt.Printf("    %s: %s\n", major, strings.Join(described, ", "))
+				}
+			}
+			return cli.Exit(fmt.Sprintf("%d dependency(ies) required at different major versions", len(drifts)), 1)
+		},
+	}
+}
+
+// findMajorDrifts groups the requirements by dependency without its major
+// version suffix, gopkg.in/yaml.v2 and gopkg.in/yaml.v3 sharing
+// gopkg.in/yaml, and returns the dependencies required at more than one
+// major version, sorted by prefix
+func findMajorDrifts(reqs map[string][]analyzer.Requirement) []majorDrift {
+	byPrefix := make(map[string]map[string][]majorRequirement)
+	for _, m := range sortedKeys(reqs) {
+		for _, r := range reqs[m] {
+			prefix, _, ok := module.SplitPathVersion(r.Path)
+			if !ok {
+				continue
+			}
+			if byPrefix[prefix] == nil {
+				byPrefix[prefix] = make(map[string][]majorRequirement)
+			}
+			byPrefix[prefix][r.Path] = append(byPrefix[prefix][r.Path], majorRequirement{Module: m, Version: r.Version})
+		}
+	}
+
+	var drifts []majorDrift
+	for prefix, majors := range byPrefix {
+		if len(majors) > 1 {
+			drifts = append(drifts, majorDrift{Prefix: prefix, Majors: majors})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Prefix < drifts[j].Prefix })
+	return drifts
+}
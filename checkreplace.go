@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/urfave/cli/v2"
+)
+
+// replaceProblem is a replace directive flagged by 'knit check-replace'
+type replaceProblem struct {
+	Module  analyzer.Module
+	Replace analyzer.Replace
+	Reason  string
+}
+
+// createCheckReplaceCommand creates the 'check-replace' command validating
+// the replace directives of workspace modules
+func createCheckReplaceCommand() *cli.Command {
+	var (
+		path string
+		fix  bool
+	)
+
+	return &cli.Command{
+		Name:  "check-replace",
+		Usage: "Flag replace directives that are redundant or point outside the workspace",
+		Description: `Check the replace directives of every module go.mod and flag the ones that
+replace a workspace module (go.work already uses it), point at a directory
+that does not exist or holds no go.mod, or point at a directory outside the
+workspace. With --fix the flagged directives are removed.
+
+Examples:
+  knit check-replace
+  knit check-replace --fix`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "fix",
+				Usage:       "Remove the flagged replace directives",
+				Destination: &fix,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			problems, err := checkReplaces(absPath, modules)
+			if err != nil {
+				return err
+			}
+			if len(problems) == 0 {
+				fmt.Println("✓ No problematic replace directive")
+				return nil
+			}
+
+			for _, p := range problems {
+				rel, err := filepath.Rel(absPath, p.Module.GoMod)
+				if err != nil {
+					rel = p.Module.GoMod
+				}
+				fmt.Printf("✗ %s: replace %s: %s\n", rel, p.Replace, p.Reason)
+			}
+
+			if !fix {
+				return cli.Exit(fmt.Sprintf("%d problematic replace directive(s), remove them with --fix", len(problems)), 1)
+			}
+			if err := dropReplaces(problems); err != nil {
+				return err
+			}
+			fmt.Printf("Removed %d replace directive(s)\n", len(problems))
+			return nil
+		},
+	}
+}
+
+// checkReplaces returns the problematic replace directives of every module
+func checkReplaces(absPath string, modules []analyzer.Module) ([]replaceProblem, error) {
+	replaces, err := analyzer.ListReplaces(modules)
+	if err != nil {
+		return nil, err
+	}
+	workspaceModules := make(map[string]bool, len(modules))
+	for _, m := range modules {
+		workspaceModules[m.Path] = true
+	}
+
+	var problems []replaceProblem
+	for _, m := range modules {
+		for _, r := range replaces[m.Path] {
+			if reason := replaceReason(absPath, m, r, workspaceModules); reason != "" {
+				problems = append(problems, replaceProblem{Module: m, Replace: r, Reason: reason})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// replaceReason explains what is wrong with a replace directive of module m,
+// or returns an empty string when nothing is
+func replaceReason(absPath string, m analyzer.Module, r analyzer.Replace, workspaceModules map[string]bool) string {
+	if workspaceModules[r.OldPath] {
+		return fmt.Sprintf("%s is a workspace module, go.work already uses it", r.OldPath)
+	}
+	if !r.IsLocal() {
+		return ""
+	}
+
+	target := r.NewPath
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(m.Dir, target)
+	}
+	if _, err := os.Stat(filepath.Join(target, "go.mod")); err != nil {
+		return fmt.Sprintf("%s does not exist or holds no go.mod", r.NewPath)
+	}
+	rel, err := filepath.Rel(absPath, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Sprintf("%s is outside the workspace", r.NewPath)
+	}
+	return ""
+}
+
+// dropReplaces removes the problematic directives, one rewrite per go.mod
+func dropReplaces(problems []replaceProblem) error {
+	byFile := make(map[string][]analyzer.Replace)
+	for _, p := range problems {
+		byFile[p.Module.GoMod] = append(byFile[p.Module.GoMod], p.Replace)
+	}
+	for _, file := range sortedKeys(byFile) {
+		if err := analyzer.DropReplaces(file, byFile[file]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
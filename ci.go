@@ -0,0 +1,532 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/nicolasgere/knit/lib/runner"
+	"github.com/nicolasgere/knit/lib/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// defaultJobCommand is the command run by each generated CI job
+const defaultJobCommand = "knit test -t {{.Path}}"
+
+// renderJobCommand executes the job command template for a module
+func renderJobCommand(tmpl *template.Template, m templateModule) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, m); err != nil {
+		return "", fmt.Errorf("failed to execute job command: %w", err)
+	}
+	return b.String(), nil
+}
+
+func parseJobCommand(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultJobCommand
+	}
+	tmpl, err := template.New("job").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse job command: %w", err)
+	}
+	return tmpl, nil
+}
+
+// outputGitHubMatrix prints a matrix for GitHub Actions. Each entry of the
+// "module" dimension is an object, so jobs can use matrix.module.path,
+// matrix.module.dir (for working-directory) and matrix.module.name.
+func outputGitHubMatrix(data templateData) error {
+	return writeGitHubMatrix(os.Stdout, data)
+}
+
+// writeGitHubMatrix writes the matrix of outputGitHubMatrix to w, on a line
+func writeGitHubMatrix(w io.Writer, data templateData) error {
+	type matrixEntry struct {
+		Path string `json:"path"`
+		Dir  string `json:"dir"`
+		Name string `json:"name"`
+	}
+	type matrixOutput struct {
+		Module []matrixEntry `json:"module"`
+	}
+
+	// Ensure empty array, not null
+	matrix := matrixOutput{Module: make([]matrixEntry, 0, len(data.Modules))}
+	for _, m := range data.Modules {
+		matrix.Module = append(matrix.Module, matrixEntry{Path: m.Path, Dir: m.RelDir, Name: m.Name})
+	}
+
+	out, err := json.Marshal(matrix)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Fprintln(w, string(out))
+	return nil
+}
+
+// writeGitHubOutput appends the outputs of a GitHub Actions step to the file
+// named by GITHUB_OUTPUT: modules, a JSON array of the module paths,
+// any_affected, true or false, and matrix, the matrix of -f github-matrix
+func writeGitHubOutput(data templateData) error {
+	file := os.Getenv("GITHUB_OUTPUT")
+	if file == "" {
+		return fmt.Errorf("--github-output requires GITHUB_OUTPUT, set in the steps of GitHub Actions")
+	}
+
+	paths := make([]string, 0, len(data.Modules))
+	for _, m := range data.Modules {
+		paths = append(paths, m.Path)
+	}
+	modules, err := json.Marshal(paths)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	var matrix strings.Builder
+	if err := writeGitHubMatrix(&matrix, data); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write GITHUB_OUTPUT: %w", err)
+	}
+	defer f.Close()
+	// Every value is single-line JSON, written as key=value
+	if _, err := fmt.Fprintf(f, "modules=%s\nany_affected=%t\nmatrix=%s", modules, len(paths) > 0, matrix.String()); err != nil {
+		return fmt.Errorf("failed to write GITHUB_OUTPUT: %w", err)
+	}
+	return nil
+}
+
+// outputGitLabCI prints a GitLab CI child pipeline with one job per module.
+// GitLab rejects pipelines without jobs, so an empty set yields a no-op job.
+func outputGitLabCI(data templateData, jobCommand string) error {
+	tmpl, err := parseJobCommand(jobCommand)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by knit affected -f gitlab-ci\n")
+	b.WriteString("stages:\n  - test\n")
+
+	if len(data.Modules) == 0 {
+		b.WriteString("\nno-affected-modules:\n")
+		b.WriteString("  stage: test\n")
+		b.WriteString("  script:\n")
+		b.WriteString("    - echo \"No affected modules\"\n")
+	}
+
+	for _, m := range data.Modules {
+		script, err := renderJobCommand(tmpl, m)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "\n%s:\n", strconv.Quote("test:"+m.Path))
+		b.WriteString("  stage: test\n")
+		b.WriteString("  variables:\n")
+		fmt.Fprintf(&b, "    KNIT_MODULE: %s\n", strconv.Quote(m.Path))
+		fmt.Fprintf(&b, "    KNIT_MODULE_DIR: %s\n", strconv.Quote(m.RelDir))
+		b.WriteString("  script:\n")
+		fmt.Fprintf(&b, "    - %s\n", strconv.Quote(script))
+	}
+
+	fmt.Print(b.String())
+	return nil
+}
+
+// jobName returns a CI-safe identifier for a module, derived from its
+// directory relative to the workspace. Distinct directories may share it,
+// see uniqueNames.
+// Characters other than ASCII letters and digits are replaced by sep.
+func jobName(m templateModule, sep rune) string {
+	name := m.RelDir
+	if name == "." || name == "" {
+		name = m.Name
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(sep)
+		}
+	}
+	return b.String()
+}
+
+// uniqueNames returns names, in order, suffixing a name already taken by a
+// previous one with sep and a number, e.g. for the job names of directories
+// such as a-b and a.b, which are the same
+func uniqueNames(names []string, sep rune) []string {
+	unique := make([]string, len(names))
+	taken := make(map[string]bool, len(names))
+	for i, name := range names {
+		n := name
+		for k := 2; taken[n]; k++ {
+			n = fmt.Sprintf("%s%c%d", name, sep, k)
+		}
+		taken[n] = true
+		unique[i] = n
+	}
+	return unique
+}
+
+// outputCircleCI prints the pipeline parameters for CircleCI's continuation
+// orb: a "run-<module>" boolean set to true for each module. Parameters must be
+// declared in the continuation config; undeclared ones are rejected by CircleCI.
+func outputCircleCI(data templateData) error {
+	names := make([]string, len(data.Modules))
+	for i, m := range data.Modules {
+		names[i] = jobName(m, '-')
+	}
+	params := make(map[string]bool, len(data.Modules))
+	for _, name := range uniqueNames(names, '-') {
+		params["run-"+name] = true
+	}
+
+	out, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// outputAzureMatrix prints a matrix for Azure Pipelines' strategy.matrix,
+// keyed by job names that Azure accepts (letters, digits and underscores,
+// starting with a letter). Each entry becomes variables of the job.
+func outputAzureMatrix(data templateData) error {
+	type matrixEntry struct {
+		Module    string `json:"module"`
+		ModuleDir string `json:"moduleDir"`
+	}
+
+	names := make([]string, len(data.Modules))
+	for i, m := range data.Modules {
+		name := jobName(m, '_')
+		if name == "" || (name[0] >= '0' && name[0] <= '9') || name[0] == '_' {
+			name = "m" + name
+		}
+		names[i] = name
+	}
+	names = uniqueNames(names, '_')
+	matrix := make(map[string]matrixEntry, len(data.Modules))
+	for i, m := range data.Modules {
+		matrix[names[i]] = matrixEntry{Module: m.Path, ModuleDir: m.RelDir}
+	}
+
+	out, err := json.Marshal(matrix)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// ciDefaultTasks are the tasks 'knit ci' runs when ci.tasks of knit.yaml is
+// not set
+var ciDefaultTasks = []string{"vet", "test", "build"}
+
+// ciBuiltinTasks are the commands of the tasks 'knit ci' runs without a run
+// command in knit.yaml, but fmt, which checks the formatting
+var ciBuiltinTasks = map[string]string{
+	"vet":   "go vet ./...",
+	"test":  "go test ./...",
+	"build": "go build ./...",
+}
+
+// ciTaskResult is the outcome of a task of 'knit ci'
+type ciTaskResult struct {
+	Name string
+	// Failed are the modules the task failed in
+	Failed []string
+	// Err is set when the task failed as a whole, e.g. in a before hook
+	Err error
+	// Skipped is set when the task did not run after a failure with --fail-fast
+	Skipped bool
+}
+
+// createCICommand creates the 'ci' command running the CI tasks on the
+// modules affected by a change and their dependents
+func createCICommand(r *runner.Runner) *cli.Command {
+	var (
+		path        string
+		base        string
+		changes     changeFlags
+		tasks       cli.StringSlice
+		targets     cli.StringSlice
+		all         bool
+		failFast    bool
+		timestamps  string
+		prefixWidth int
+	)
+
+	return &cli.Command{
+		Name:  "ci",
+		Usage: "Run the CI tasks on the affected modules and their dependents, with a single report",
+		Description: `Chain the usual CI flow in one command: detect the modules affected by the
+changes since the merge-base with --base, add the modules depending on them,
+then run every task of ci.tasks of knit.yaml on them, in order. The modules
+of a task are started in topological order, dependencies first. Every task
+runs even when a previous one failed, unless --fail-fast is set, and a
+report of the tasks ends the run, which fails when one of them did.
+
+The tasks are fmt (checking the formatting, as 'knit fmt --check'), vet,
+test and build, or tasks of knit.yaml with a run command, e.g. lint, whose
+hooks, onlyIf and cache apply. A run command in knit.yaml wins over the
+built-in one. Without ci.tasks, vet, test and build are run.
+
+Examples:
+  knit ci --base origin/main
+  knit ci --task fmt --task test   # Instead of ci.tasks of knit.yaml
+  knit ci --all                    # Every module, e.g. on the main branch
+  knit ci generate --provider github -o .github/workflows/knit.yml`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Git reference whose merge-base the changes are detected from",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.StringSliceFlag{
+				Name:        "task",
+				Usage:       "Run this task instead of the ones of ci.tasks of knit.yaml (repeatable, in order)",
+				Destination: &tasks,
+			},
+			&cli.StringSliceFlag{
+				Name:        "target",
+				Usage:       "Only run the tasks on these modules, globs allowed, '/...' matches a whole prefix (repeatable)",
+				Aliases:     []string{"t"},
+				Destination: &targets,
+			},
+			&cli.BoolFlag{
+				Name:        "all",
+				Usage:       "Run the tasks on every module instead of the affected ones",
+				Destination: &all,
+			},
+			&cli.BoolFlag{
+				Name:        "fail-fast",
+				Usage:       "Skip the remaining tasks once one failed",
+				Destination: &failFast,
+			},
+			timestampsFlag(&timestamps),
+			prefixWidthFlag(&prefixWidth),
+		}, changes.flags()...),
+		Subcommands: []*cli.Command{
+			createCIGenerateCommand(),
+		},
+		Action: func(c *cli.Context) error {
+			if err := utils.SetTimestamps(timestamps); err != nil {
+				return err
+			}
+			if err := utils.SetPrefixWidth(prefixWidth); err != nil {
+				return err
+			}
+			absPath, modules, imports, err := loadModuleImports(path, true, analyzer.BuildContext{})
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Load(absPath)
+			if err != nil {
+				return err
+			}
+			// Every task is resolved first, so that a typo fails before any run
+			names, commands, err := ciTasks(cfg, tasks.Value())
+			if err != nil {
+				return err
+			}
+
+			selected := modules
+			if !all {
+				src, err := changes.source(base, true)
+				if err != nil {
+					return err
+				}
+				affected, err := affectedModules(modules, absPath, src)
+				if err != nil {
+					return err
+				}
+				if selected = withDependents(modules, affected, imports); len(selected) == 0 {
+					fmt.Println("No affected modules found")
+					writeStepSummary("ci", nil, nil, nil, nil, nil, true)
+					return nil
+				}
+				fmt.Printf("%d affected module(s), %d with their dependents\n", len(affected), len(selected))
+			}
+			if patterns := targets.Value(); len(patterns) > 0 {
+				targeted, err := resolveTargets(modules, patterns, absPath)
+				if err != nil {
+					return err
+				}
+				if selected = intersectModules(selected, targeted); len(selected) == 0 {
+					fmt.Println("No targeted module is affected")
+					return nil
+				}
+			}
+			selected = topologicalOrder(selected, analyzer.WithoutTests(imports))
+
+			results := make([]ciTaskResult, len(names))
+			failed := false
+			for i, name := range names {
+				results[i].Name = name
+				if failed && failFast {
+					results[i].Skipped = true
+					continue
+				}
+				fmt.Printf("\nRunning %s on %d module(s)\n", name, len(selected))
+				if results[i], err = runCITask(absPath, name, commands[i], r, selected, !all); err != nil {
+					return err
+				}
+				failed = failed || results[i].Err != nil
+			}
+			return printCIReport(results, len(selected))
+		},
+	}
+}
+
+// ciTasks returns the tasks of 'knit ci', override or ci.tasks of knit.yaml
+// or ciDefaultTasks, with their commands
+func ciTasks(cfg *config.Config, override []string) ([]string, []string, error) {
+	names := ciDefaultTasks
+	if len(override) > 0 {
+		names = override
+	} else if len(cfg.CI.Tasks) > 0 {
+		names = cfg.CI.Tasks
+	}
+	commands := make([]string, len(names))
+	for i, name := range names {
+		cmd, err := ciTaskCommand(cfg, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		commands[i] = cmd
+	}
+	return names, commands, nil
+}
+
+// ciTaskCommand returns the command of a task of 'knit ci', its run command
+// in knit.yaml or the built-in one
+func ciTaskCommand(cfg *config.Config, name string) (string, error) {
+	if run := cfg.Tasks[name].Run; run != "" {
+		return run, nil
+	}
+	if name == "fmt" {
+		return fmtCheckCommand(cfg.Format), nil
+	}
+	if cmd, ok := ciBuiltinTasks[name]; ok {
+		return cmd, nil
+	}
+	return "", fmt.Errorf("unknown task %q: expected fmt, vet, test, build or a task with a run command in %s", name, config.FileName)
+}
+
+// runCITask runs a task of 'knit ci' on the modules and returns the modules
+// it failed in, an error only when the task could not run
+func runCITask(absPath, name, cmd string, r *runner.Runner, modules []analyzer.Module, affected bool) (ciTaskResult, error) {
+	result := ciTaskResult{Name: name}
+	err := runOnModules(absPath, name, cmd, r, modules, runOptions{affected: affected, ordered: true})
+	if err == nil {
+		return result, nil
+	}
+	if _, ok := err.(cli.ExitCoder); !ok {
+		return result, err
+	}
+	result.Err = err
+	h, err := history.Load(absPath)
+	if err != nil {
+		return result, err
+	}
+	for _, m := range modules {
+		if slices.Contains(h.Failed[name], m.Path) {
+			result.Failed = append(result.Failed, m.Path)
+		}
+	}
+	return result, nil
+}
+
+// printCIReport prints the outcome of every task of 'knit ci' and returns
+// the exit error of the run when a task failed
+func printCIReport(results []ciTaskResult, modules int) error {
+	fmt.Printf("\nCI report, %d module(s):\n", modules)
+	failures := 0
+	for _, res := range results {
+		switch {
+		case res.Skipped:
+			fmt.Printf("  - %s: skipped\n", res.Name)
+		case len(res.Failed) > 0:
+			failures++
+			fmt.Printf("  ✗ %s: %d module(s) failed: %s\n", res.Name, len(res.Failed), strings.Join(res.Failed, ", "))
+		case res.Err != nil:
+			failures++
+			fmt.Printf("  ✗ %s: %v\n", res.Name, res.Err)
+		default:
+			fmt.Printf("  ✓ %s\n", res.Name)
+		}
+	}
+	if failures > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d task(s) failed", failures, len(results)), 1)
+	}
+	return nil
+}
+
+// withDependents returns the affected modules and the modules depending on
+// them, directly or transitively, in the order of modules
+func withDependents(modules, affected []analyzer.Module, imports map[string]map[string][]analyzer.Import) []analyzer.Module {
+	set := make(map[string]bool)
+	for _, m := range affected {
+		set[m.Path] = true
+		for dep := range resolver.Dependents(imports, m.Path, 0) {
+			set[dep] = true
+		}
+	}
+	var selected []analyzer.Module
+	for _, m := range modules {
+		if set[m.Path] {
+			selected = append(selected, m)
+		}
+	}
+	return selected
+}
+
+// topologicalOrder orders the modules dependencies first, keeping their
+// order otherwise. The modules on an import cycle come last.
+func topologicalOrder(modules []analyzer.Module, imports map[string]map[string][]analyzer.Import) []analyzer.Module {
+	paths := make([]string, len(modules))
+	byPath := make(map[string]analyzer.Module, len(modules))
+	for i, m := range modules {
+		paths[i] = m.Path
+		byPath[m.Path] = m
+	}
+	ordered := make([]analyzer.Module, 0, len(modules))
+	for _, level := range resolver.Levels(imports, paths) {
+		for _, p := range level {
+			ordered = append(ordered, byPath[p])
+			delete(byPath, p)
+		}
+	}
+	for _, p := range paths {
+		if m, ok := byPath[p]; ok {
+			ordered = append(ordered, m)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/urfave/cli/v2"
+)
+
+// CI providers of 'knit ci generate'
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+// ciWorkflow is what a generated CI file needs to know about the workspace
+type ciWorkflow struct {
+	// Base is the default branch pushes are compared against
+	Base string
+	// Tasks are the tasks of 'knit ci' every module job runs
+	Tasks []string
+	// GoVersion is the highest go version of the modules
+	GoVersion string
+	// GoWork is set when the workspace has a go.work file
+	GoWork bool
+}
+
+// createCIGenerateCommand creates the 'ci generate' command writing the CI
+// file of a provider running the tasks of 'knit ci' on the affected modules
+func createCIGenerateCommand() *cli.Command {
+	var (
+		path     string
+		provider string
+		base     string
+		output   string
+		check    bool
+	)
+
+	return &cli.Command{
+		Name:  "generate",
+		Usage: "Generate the CI file of a provider running the tasks of knit ci on the affected modules",
+		Description: `Print, or write to --output, a ready-to-use CI file: a first job lists the
+modules affected by the pull request or push and their dependents with
+'knit affected', then one job per module runs the tasks of ci.tasks of
+knit.yaml with 'knit ci'. On GitHub Actions the modules are a matrix and
+every task is a step; on GitLab CI they are the jobs of a child pipeline.
+The first push of a branch, which has no previous commit, runs on every
+module.
+Generate the file again when ci.tasks changes, --check fails when it is out
+of date.
+
+Examples:
+  knit ci generate --provider github -o .github/workflows/knit.yml
+  knit ci generate --provider gitlab -o .gitlab-ci.yml
+  knit ci generate --provider github -o .github/workflows/knit.yml --check`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "provider",
+				Usage:       "CI provider: github or gitlab",
+				Required:    true,
+				Destination: &provider,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Default branch the pushes are compared against",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.StringFlag{
+				Name:        "output",
+				Usage:       "Write the file to `FILE`, relative to the workspace root, instead of printing it",
+				Aliases:     []string{"o"},
+				Destination: &output,
+			},
+			&cli.BoolFlag{
+				Name:        "check",
+				Usage:       "Only check that --output is up to date, exiting with code 1 when it is not",
+				Destination: &check,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if check && output == "" {
+				return fmt.Errorf("--check requires --output")
+			}
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Load(absPath)
+			if err != nil {
+				return err
+			}
+			tasks, _, err := ciTasks(cfg, nil)
+			if err != nil {
+				return err
+			}
+			dirs := make([]string, len(modules))
+			for i, m := range modules {
+				if dirs[i], err = filepath.Rel(absPath, m.Dir); err != nil {
+					return err
+				}
+			}
+			goVersion, err := highestGoVersion(absPath, dirs)
+			if err != nil {
+				return err
+			}
+			w := ciWorkflow{Base: base, Tasks: tasks, GoVersion: goVersion}
+			if _, err := os.Stat(filepath.Join(absPath, "go.work")); err == nil {
+				w.GoWork = true
+			}
+
+			var content string
+			switch provider {
+			case ProviderGitHub:
+				content = githubWorkflow(w)
+			case ProviderGitLab:
+				content = gitlabPipeline(w)
+			default:
+				return fmt.Errorf("unknown provider %q: expected %s or %s", provider, ProviderGitHub, ProviderGitLab)
+			}
+
+			if output == "" {
+				fmt.Print(content)
+				return nil
+			}
+			file := filepath.Join(absPath, output)
+			if check {
+				if current, err := os.ReadFile(file); err != nil || !bytes.Equal(current, []byte(content)) {
+					return cli.Exit(fmt.Sprintf("%s is out of date, run 'knit ci generate --provider %s -o %s'", output, provider, output), 1)
+				}
+				fmt.Printf("✓ %s is up to date\n", output)
+				return nil
+			}
+			return writeStarterFile(absPath, output, content, true)
+		},
+	}
+}
+
+// ciTaskArgs returns the --task flags of 'knit ci' running the tasks
+func ciTaskArgs(tasks []string) string {
+	args := make([]string, len(tasks))
+	for i, task := range tasks {
+		if strings.Trim(task, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") != "" {
+			task = shellQuote(task)
+		}
+		args[i] = "--task " + task
+	}
+	return strings.Join(args, " ")
+}
+
+// githubWorkflow returns a GitHub Actions workflow listing the affected
+// modules and their dependents, then running every task of w in a matrix
+// job per module, one step per task. The steps after the first run even
+// when a previous one failed, as the tasks of 'knit ci' do.
+func githubWorkflow(w ciWorkflow) string {
+	setupGo := "      - uses: actions/setup-go@v5\n        with:\n"
+	if w.GoWork {
+		setupGo += "          go-version-file: go.work\n"
+	} else {
+		setupGo += fmt.Sprintf("          go-version: %s\n", strconv.Quote(w.GoVersion))
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by knit ci generate --provider github, run it again when\n# ci.tasks of knit.yaml changes\n")
+	b.WriteString(`name: knit
+
+on:
+  pull_request:
+  push:
+    branches: [` + w.Base + `]
+
+jobs:
+  affected:
+    runs-on: ubuntu-latest
+    outputs:
+      matrix: ${{ steps.affected.outputs.matrix }}
+      any: ${{ steps.affected.outputs.any_affected }}
+    steps:
+      - uses: actions/checkout@v4
+        with:
+          fetch-depth: 0
+` + setupGo + `      - run: go install github.com/nicolasgere/knit@latest
+      - id: affected
+        env:
+          BASE: ${{ github.event_name == 'pull_request' && format('origin/{0}', github.base_ref) || github.event.before }}
+        run: |
+          ` + firstPushGuard("          ") + `
+          knit affected $MERGE_BASE --base "$BASE" --include-dependents --github-output
+
+  ci:
+    needs: affected
+    if: needs.affected.outputs.any == 'true'
+    runs-on: ubuntu-latest
+    strategy:
+      fail-fast: false
+      matrix: ${{ fromJson(needs.affected.outputs.matrix) }}
+    name: ci ${{ matrix.module.name }}
+    steps:
+      - uses: actions/checkout@v4
+` + setupGo + `      - run: go install github.com/nicolasgere/knit@latest
+`)
+	for i, task := range w.Tasks {
+		fmt.Fprintf(&b, "      - name: %s\n", strconv.Quote(task))
+		if i > 0 {
+			b.WriteString("        if: ${{ !cancelled() }}\n")
+		}
+		fmt.Fprintf(&b, "        run: knit ci --all %s -t ${{ matrix.module.path }}\n", ciTaskArgs([]string{task}))
+	}
+	return b.String()
+}
+
+// gitlabPipeline returns a GitLab CI pipeline whose first job writes the
+// child pipeline of the affected modules and their dependents with
+// 'knit affected -f gitlab-ci', one job per module running the tasks of w,
+// which a trigger job then runs
+func gitlabPipeline(w ciWorkflow) string {
+	image := "golang:" + w.GoVersion
+	script := []string{
+		"go install github.com/nicolasgere/knit@latest",
+		`BASE="${CI_MERGE_REQUEST_DIFF_BASE_SHA:-$CI_COMMIT_BEFORE_SHA}"`,
+		firstPushGuard(""),
+		// The jobs of the child pipeline install knit in the same image
+		fmt.Sprintf(`printf 'default:\n  image: %s\n  before_script:\n    - go install github.com/nicolasgere/knit@latest\n\n' > child.yml`, image),
+		fmt.Sprintf(`knit affected $MERGE_BASE --base "$BASE" --include-dependents -f gitlab-ci --job-command %s >> child.yml`,
+			shellQuote("knit ci --all "+ciTaskArgs(w.Tasks)+" -t {{.Path}}")),
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by knit ci generate --provider gitlab, run it again when\n# ci.tasks of knit.yaml changes\n")
+	b.WriteString("stages:\n  - plan\n  - ci\n")
+	b.WriteString("\naffected:\n")
+	b.WriteString("  stage: plan\n")
+	fmt.Fprintf(&b, "  image: %s\n", image)
+	b.WriteString("  variables:\n    GIT_DEPTH: 0\n")
+	b.WriteString("  script:\n")
+	for _, line := range script {
+		fmt.Fprintf(&b, "    - %s\n", strconv.Quote(line))
+	}
+	b.WriteString("  artifacts:\n    paths:\n      - child.yml\n")
+	b.WriteString("\nci:\n")
+	b.WriteString("  stage: ci\n")
+	b.WriteString("  trigger:\n    include:\n      - artifact: child.yml\n        job: affected\n    strategy: depend\n")
+	return b.String()
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/urfave/cli/v2"
+)
+
+// cleanItem is something 'knit clean' removes
+type cleanItem struct {
+	// Name is what is removed, for display
+	Name string
+	// Size is the disk space it takes, in bytes
+	Size int64
+	// unknownSize is set when Size is only known once removed
+	unknownSize bool
+	// remove deletes it
+	remove func() error
+}
+
+// createCleanCommand creates the 'clean' command removing the caches and
+// artifacts of knit and of the go command
+func createCleanCommand() *cli.Command {
+	var (
+		path      string
+		targets   cli.StringSlice
+		dirs      cli.StringSlice
+		testCache bool
+		goCache   bool
+		dryRun    bool
+	)
+
+	return &cli.Command{
+		Name:  "clean",
+		Usage: "Remove the caches and artifacts of knit and the go command, reporting the reclaimed space",
+		Description: `Remove the task cache of knit (.knit/cache), its package analysis cache
+(.knit/analysis.json), the logs of 'knit logs' (.knit/logs), the coverage
+profiles of 'knit test --cover' and the files 'go clean' removes in every
+module, such as test binaries left by go test -c, then print the space
+reclaimed. The history of .knit/history.json, durations and test outcomes,
+is kept. -t only cleans the coverage profiles and 'go clean' files of these
+modules, keeping the caches and logs of .knit shared by every module. --dir
+removes other directories, such as the output of 'knit build' or of
+--artifacts-dir, and refuses the workspace root, a directory holding a module
+or anything outside of the workspace.
+
+The build and test caches of the go command are shared by every module on
+the machine, and cleaned whole: --test-cache runs 'go clean -testcache',
+forgetting the results of every test, and --go-cache runs 'go clean -cache'.
+
+Examples:
+  knit clean --dry-run
+  knit clean --dir dist --test-cache
+  knit clean -t example.com/service/...`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringSliceFlag{
+				Name:        "target",
+				Usage:       "Only clean these modules, keeping the caches and logs of .knit, globs allowed, '/...' matches a whole prefix (repeatable)",
+				Aliases:     []string{"t"},
+				Destination: &targets,
+			},
+			&cli.StringSliceFlag{
+				Name:        "dir",
+				Usage:       "Also remove this directory of the workspace, not the root or a module directory (repeatable)",
+				Destination: &dirs,
+			},
+			&cli.BoolFlag{
+				Name:        "test-cache",
+				Usage:       "Run 'go clean -testcache', for every module of the machine",
+				Destination: &testCache,
+			},
+			&cli.BoolFlag{
+				Name:        "go-cache",
+				Usage:       "Run 'go clean -cache', the whole build cache of the machine",
+				Destination: &goCache,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "Print what would be removed without removing it",
+				Aliases:     []string{"n"},
+				Destination: &dryRun,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			var extraDirs []string
+			for _, dir := range dirs.Value() {
+				rel, err := cleanDir(absPath, dir, modules)
+				if err != nil {
+					return err
+				}
+				extraDirs = append(extraDirs, rel)
+			}
+			if len(targets.Value()) > 0 {
+				if modules, err = resolveTargets(modules, targets.Value(), absPath); err != nil {
+					return err
+				}
+			}
+
+			var items []*cleanItem
+			var paths []string
+			if len(targets.Value()) == 0 {
+				// the caches and logs of .knit are shared by every module
+				paths = []string{filepath.Join(history.Dir, "cache"), filepath.Join(history.Dir, analysisFile), filepath.Join(history.Dir, logsDir)}
+			}
+			for _, m := range modules {
+				rel, err := filepath.Rel(absPath, filepath.Join(m.Dir, coverProfile))
+				if err != nil {
+					return err
+				}
+				paths = append(paths, rel)
+			}
+			paths = append(paths, extraDirs...)
+			for _, p := range paths {
+				item, err := pathItem(absPath, p)
+				if err != nil {
+					return err
+				}
+				if item != nil {
+					items = append(items, item)
+				}
+			}
+			for _, m := range modules {
+				item, err := goCleanItem(absPath, m)
+				if err != nil {
+					return err
+				}
+				if item != nil {
+					items = append(items, item)
+				}
+			}
+			if testCache || goCache {
+				item, err := goCacheItem(goCache)
+				if err != nil {
+					return err
+				}
+				items = append(items, item)
+			}
+
+			if len(items) == 0 {
+				fmt.Println("Nothing to clean")
+				return nil
+			}
+			var total int64
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, item := range items {
+				if !dryRun {
+					if err := item.remove(); err != nil {
+						w.Flush()
+						return err
+					}
+				}
+				size := formatBytes(item.Size)
+				if item.unknownSize && dryRun {
+					size = "unknown"
+				}
+				fmt.Fprintf(w, "%s\t%s\n", item.Name, size)
+				total += item.Size
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if dryRun {
+				fmt.Printf("Would reclaim %s\n", formatBytes(total))
+			} else {
+				fmt.Printf("Reclaimed %s\n", formatBytes(total))
+			}
+			return nil
+		},
+	}
+}
+
+// cleanDir returns dir, a --dir value, relative to the workspace root, or an
+// error when removing it would remove the workspace, a module or anything
+// outside of the workspace
+func cleanDir(absPath, dir string, modules []analyzer.Module) (string, error) {
+	rel := filepath.Clean(dir)
+	if filepath.IsAbs(rel) {
+		var err error
+		if rel, err = filepath.Rel(absPath, rel); err != nil {
+			return "", fmt.Errorf("--dir %s: %w", dir, err)
+		}
+	}
+	if rel == "." {
+		return "", fmt.Errorf("--dir %s is the workspace root, refusing to remove it", dir)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("--dir %s is outside of the workspace %s, refusing to remove it", dir, absPath)
+	}
+	for _, m := range modules {
+		moduleRel, err := filepath.Rel(absPath, m.Dir)
+		if err != nil {
+			return "", err
+		}
+		if moduleRel == rel || strings.HasPrefix(moduleRel, rel+string(filepath.Separator)) {
+			return "", fmt.Errorf("--dir %s holds the module %s, refusing to remove it", dir, m.Path)
+		}
+	}
+	return rel, nil
+}
+
+// pathItem returns the file or directory at rel, relative to the workspace
+// root, or nil when it does not exist
+func pathItem(absPath, rel string) (*cleanItem, error) {
+	file := filepath.Join(absPath, rel)
+	if _, err := os.Lstat(file); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	size, err := diskUsage(file)
+	if err != nil {
+		return nil, err
+	}
+	return &cleanItem{Name: filepath.ToSlash(rel), Size: size, remove: func() error { return os.RemoveAll(file) }}, nil
+}
+
+// goCleanItem returns the files 'go clean ./...' removes in module m, as told
+// by go clean -n, or nil when there is none
+func goCleanItem(absPath string, m analyzer.Module) (*cleanItem, error) {
+	cmd := exec.Command("go", "clean", "-n", "./...")
+	cmd.Dir = m.Dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go clean -n failed in %s: %w", m.Path, commandError(err))
+	}
+
+	// go clean -n prints "cd <package dir>" followed by "rm -f <files>"
+	var size int64
+	found := false
+	dir := m.Dir
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if d, ok := strings.CutPrefix(line, "cd "); ok {
+			dir = d
+			continue
+		}
+		files, ok := strings.CutPrefix(line, "rm -f ")
+		if !ok {
+			continue
+		}
+		for _, f := range strings.Fields(files) {
+			if !filepath.IsAbs(f) {
+				f = filepath.Join(dir, f)
+			}
+			if info, err := os.Lstat(f); err == nil && !info.IsDir() {
+				size += info.Size()
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	rel, err := filepath.Rel(absPath, m.Dir)
+	if err != nil {
+		rel = m.Dir
+	}
+	return &cleanItem{
+		Name: fmt.Sprintf("go clean in %s", filepath.ToSlash(rel)),
+		Size: size,
+		remove: func() error {
+			cmd := exec.Command("go", "clean", "./...")
+			cmd.Dir = m.Dir
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("go clean failed in %s: %w\n%s", m.Path, err, strings.TrimSpace(string(output)))
+			}
+			return nil
+		},
+	}, nil
+}
+
+// goCacheItem returns the build cache of the go command, whole with all,
+// or its test results. The size of the test results is unknown until they
+// are removed, it is then the space the build cache shrank by.
+func goCacheItem(all bool) (*cleanItem, error) {
+	output, err := exec.Command("go", "env", "GOCACHE").Output()
+	if err != nil {
+		return nil, fmt.Errorf("go env GOCACHE failed: %w", commandError(err))
+	}
+	cacheDir := strings.TrimSpace(string(output))
+	size, err := diskUsage(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &cleanItem{Name: "go build cache (" + cacheDir + ")", Size: size}
+	flag := "-cache"
+	if !all {
+		item.Name, item.Size, item.unknownSize, flag = "go test cache", 0, true, "-testcache"
+	}
+	item.remove = func() error {
+		if output, err := exec.Command("go", "clean", flag).CombinedOutput(); err != nil {
+			return fmt.Errorf("go clean %s failed: %w\n%s", flag, err, strings.TrimSpace(string(output)))
+		}
+		if !all {
+			after, err := diskUsage(cacheDir)
+			if err != nil {
+				return err
+			}
+			item.Size = max(size-after, 0)
+		}
+		return nil
+	}
+	return item, nil
+}
+
+// diskUsage returns the size of the regular files under path, 0 when it does
+// not exist
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && file == path {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", path, err)
+	}
+	return size, nil
+}
+
+// formatBytes renders a size in bytes with a binary unit fitting its
+// magnitude
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
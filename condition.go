@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/condition"
+	"github.com/nicolasgere/knit/lib/config"
+)
+
+// filterByCondition keeps the modules satisfying the onlyIf condition of a
+// task, reporting how many were skipped
+func filterByCondition(absPath string, cfg *config.Config, modules []analyzer.Module, onlyIf string) ([]analyzer.Module, error) {
+	c, err := condition.Parse(onlyIf)
+	if err != nil {
+		return nil, err
+	}
+	described, err := describeModules(absPath, modules)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := cfg.Tags()
+	kept := make([]analyzer.Module, 0, len(modules))
+	for i, m := range modules {
+		if c.Eval(condition.Module{HasMainPackage: described[i].HasMain, HasTests: described[i].HasTests, Tags: tags[m.Path]}) {
+			kept = append(kept, m)
+		}
+	}
+	if skipped := len(modules) - len(kept); skipped > 0 {
+		fmt.Printf("Skipping %d of %d modules not matching %s\n", skipped, len(modules), c)
+	}
+	return kept, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/coverage"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/urfave/cli/v2"
+)
+
+// coverProfile is the coverage profile 'knit test --cover' writes in the
+// directory of every module
+const coverProfile = "coverage.out"
+
+//go:embed assets/coverage.html
+var coverageHTML string
+
+var coverageHTMLTemplate = template.Must(template.New("coverage").Parse(coverageHTML))
+
+// createCoverageCommand creates the 'coverage' command grouping the reports
+// of the coverage profiles of the modules
+func createCoverageCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "coverage",
+		Usage: "Report the coverage profiles written by 'knit test --cover'",
+		Subcommands: []*cli.Command{
+			createCoverageReportCommand(),
+		},
+	}
+}
+
+// moduleCoverage is the coverage of a module in a report
+type moduleCoverage struct {
+	Path    string
+	Percent float64
+	// Page is the HTML page of the module relative to the report directory,
+	// empty when the module has no profile
+	Page string
+	// Stale is set when the profile is older than the last run of
+	// 'knit test --cover'
+	Stale bool
+}
+
+// staleProfile reports whether the profile file was written before the last
+// run of 'knit test --cover', started at coverRun, if known
+func staleProfile(file string, coverRun *time.Time) (bool, error) {
+	if coverRun == nil {
+		return false, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	// File systems may keep modification times to the second only
+	return info.ModTime().Before(coverRun.Truncate(time.Second)), nil
+}
+
+// createCoverageReportCommand creates the 'coverage report' command merging
+// the coverage profiles of the modules
+func createCoverageReportCommand() *cli.Command {
+	var (
+		path    string
+		htmlDir string
+		profile string
+	)
+
+	return &cli.Command{
+		Name:  "report",
+		Usage: "Print the coverage of every module and of the workspace, or render it as HTML",
+		Description: `Read the coverage profile of every module, written by 'knit test --cover', and
+print the percentage of statements covered in each module and in the whole
+workspace, the profiles merged. Modules without a profile are listed as such,
+and the profiles older than the last 'knit test --cover' run, left by an older
+run as the last one did not test the module, e.g. with --affected or a cached
+result, are marked stale.
+
+With --html DIR, also write to DIR the merged profile, coverage.out, the
+browsable source of every module and of the workspace as rendered by 'go tool
+cover -html', and index.html linking them.
+
+Examples:
+  knit test --cover; knit coverage report
+  knit coverage report --html out/`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "html",
+				Usage:       "Write the HTML report into `DIR`",
+				Destination: &htmlDir,
+			},
+			&cli.StringFlag{
+				Name:        "profile",
+				Usage:       "Name of the coverage profile in the module directories",
+				Value:       coverProfile,
+				Destination: &profile,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+
+			h, err := history.Load(absPath)
+			if err != nil {
+				return err
+			}
+
+			var covered []analyzer.Module
+			var profiles []*coverage.Profile
+			reports := make([]moduleCoverage, len(modules))
+			stale := false
+			for i, m := range modules {
+				reports[i].Path = m.Path
+				file := filepath.Join(m.Dir, profile)
+				p, err := coverage.ParseFile(file)
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if reports[i].Stale, err = staleProfile(file, h.CoverRun); err != nil {
+					return err
+				}
+				stale = stale || reports[i].Stale
+				reports[i].Percent, _ = p.Percent()
+				covered = append(covered, m)
+				profiles = append(profiles, p)
+			}
+			if len(profiles) == 0 {
+				return fmt.Errorf("no %s found in the modules, run 'knit test --cover' first", profile)
+			}
+			merged, err := coverage.Merge(profiles...)
+			if err != nil {
+				return err
+			}
+			total, _ := merged.Percent()
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "MODULE\tCOVERAGE")
+			for _, r := range reports {
+				if !hasModule(covered, r.Path) {
+					fmt.Fprintf(w, "%s\tno profile\n", r.Path)
+					continue
+				}
+				if r.Stale {
+					fmt.Fprintf(w, "%s\t%.1f%% (stale)\n", r.Path, r.Percent)
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%.1f%%\n", r.Path, r.Percent)
+			}
+			fmt.Fprintf(w, "all modules\t%.1f%%\n", total)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if stale {
+				fmt.Fprintln(os.Stderr, "warning: the stale profiles are older than the last 'knit test --cover' run, which did not test their module")
+			}
+
+			if htmlDir == "" {
+				return nil
+			}
+			return writeCoverageHTML(absPath, htmlDir, profile, modules, covered, reports, merged, total)
+		},
+	}
+}
+
+// writeCoverageHTML writes the HTML coverage report of the modules with a
+// profile into dir
+func writeCoverageHTML(absPath, dir, profile string, modules, covered []analyzer.Module, reports []moduleCoverage, merged *coverage.Profile, total float64) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	for i, m := range modules {
+		if !hasModule(covered, m.Path) {
+			continue
+		}
+		page := coveragePage(absPath, m)
+		if err := renderCoverage(m.Dir, filepath.Join(m.Dir, profile), filepath.Join(dir, page)); err != nil {
+			return fmt.Errorf("failed to render the coverage of %s: %w", m.Path, err)
+		}
+		reports[i].Page = page
+	}
+
+	mergedFile := filepath.Join(dir, coverProfile)
+	f, err := os.Create(mergedFile)
+	if err != nil {
+		return err
+	}
+	if err := merged.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// The whole workspace resolves the files of every module
+	if err := renderCoverage(absPath, mergedFile, filepath.Join(dir, "workspace.html")); err != nil {
+		return fmt.Errorf("failed to render the workspace coverage: %w", err)
+	}
+
+	index, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	err = coverageHTMLTemplate.Execute(index, map[string]any{
+		"Title":     "Coverage of " + filepath.Base(absPath),
+		"Modules":   reports,
+		"Workspace": moduleCoverage{Path: "all modules", Percent: total, Page: "workspace.html"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render HTML: %w", err)
+	}
+	fmt.Printf("Wrote the coverage report to %s\n", filepath.Join(dir, "index.html"))
+	return nil
+}
+
+// coveragePage returns the name of the HTML page of a module
+func coveragePage(absPath string, m analyzer.Module) string {
+	return moduleFileName(absPath, m) + ".html"
+}
+
+// moduleFileName returns a file name for a module, from its directory
+// relative to the workspace root
+func moduleFileName(absPath string, m analyzer.Module) string {
+	rel, err := filepath.Rel(absPath, m.Dir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		rel = shortName(m.Path)
+	}
+	return strings.ReplaceAll(filepath.ToSlash(rel), "/", "_")
+}
+
+// renderCoverage runs go tool cover -html in dir, where the go command
+// resolves the packages of the profile
+func renderCoverage(dir, profile, out string) error {
+	cmd := exec.Command("go", "tool", "cover", "-html="+profile, "-o", out)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/daemon"
+	"github.com/urfave/cli/v2"
+)
+
+// createDaemonCommand creates the 'daemon' command keeping the workspace
+// state warm for the affected and graph commands
+func createDaemonCommand() *cli.Command {
+	var (
+		path    string
+		status  bool
+		stop    bool
+		asJSON  bool
+		metrics string
+	)
+
+	return &cli.Command{
+		Name:  "daemon",
+		Usage: "Keep the module list and package graph warm for instant affected and graph queries",
+		Description: `Load the modules and the package imports of the workspace once, then watch
+its go.mod, go.work and .go files and reload them when their content
+changes. While the daemon runs, 'knit affected' and 'knit graph' ask it over
+a unix socket instead of running go list, answering in milliseconds. The
+socket lives in $XDG_RUNTIME_DIR/knit, or knit in the user cache directory,
+accessible to its user only. Queries
+received while files are changing wait for the reload, so answers are never
+stale. Set KNIT_DAEMON=off to bypass a running daemon.
+
+With --metrics, the daemon serves Prometheus metrics on /metrics: the
+commands run in the workspace report their task durations and failures,
+cache hits and misses, and affected set sizes to it.
+
+The daemon runs in the foreground until interrupted or stopped with --stop.
+
+Examples:
+  knit daemon &
+  knit daemon --metrics 127.0.0.1:9464 &
+  knit daemon --status
+  knit daemon --stop`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "status",
+				Usage:       "Print the status of the daemon serving the workspace",
+				Destination: &status,
+			},
+			&cli.BoolFlag{
+				Name:        "stop",
+				Usage:       "Stop the daemon serving the workspace",
+				Destination: &stop,
+			},
+			&cli.BoolFlag{
+				Name:        "json",
+				Usage:       "Output the status as JSON (with --status)",
+				Destination: &asJSON,
+			},
+			&cli.StringFlag{
+				Name:        "metrics",
+				Usage:       "Address to serve Prometheus metrics on, e.g. 127.0.0.1:9464",
+				Destination: &metrics,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+			socket := daemon.SocketPath(absPath)
+
+			switch {
+			case status:
+				resp, err := daemon.Query(socket, daemon.MethodStatus)
+				if err != nil {
+					return cli.Exit(fmt.Sprintf("%s: %v", absPath, err), 1)
+				}
+				return printDaemonStatus(resp.Status, socket, asJSON)
+			case stop:
+				if _, err := daemon.Query(socket, daemon.MethodStop); err != nil {
+					return cli.Exit(fmt.Sprintf("%s: %v", absPath, err), 1)
+				}
+				fmt.Printf("Stopped the daemon serving %s\n", absPath)
+				return nil
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+			server, err := daemon.NewServer(ctx, absPath)
+			if err != nil {
+				return err
+			}
+			if metrics != "" {
+				listener, err := net.Listen("tcp", metrics)
+				if err != nil {
+					return fmt.Errorf("failed to listen on %s: %w", metrics, err)
+				}
+				metricsServer := &http.Server{Handler: server.MetricsHandler()}
+				go metricsServer.Serve(listener)
+				defer metricsServer.Close()
+				fmt.Printf("Serving metrics on http://%s/metrics\n", listener.Addr())
+			}
+			fmt.Printf("Serving %s on %s\n", absPath, socket)
+			return server.Serve(ctx)
+		},
+	}
+}
+
+func printDaemonStatus(s *daemon.Status, socket string, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(s)
+	}
+	fmt.Printf("✓ serving %s on %s (pid %d)\n", s.Root, socket, s.PID)
+	fmt.Printf("    started:   %s\n", s.Started.Format("2006-01-02 15:04:05"))
+	fmt.Printf("    refreshed: %s (%d refresh(es), %d file(s))\n", s.Refreshed.Format("2006-01-02 15:04:05"), s.Refreshes, s.Files)
+	fmt.Printf("    queries:   %d\n", s.Queries)
+	if s.Error != "" {
+		fmt.Printf("    error:     %s\n", s.Error)
+	}
+	return nil
+}
+
+// loadModuleImports is loadAnalysis returning the imports only, without the
+// imports of _test.go files when build.NoTests is set
+func loadModuleImports(path string, withImports bool, build analyzer.BuildContext) (string, []analyzer.Module, map[string]map[string][]analyzer.Import, error) {
+	noTests := build.NoTests
+	// The daemon holds the test imports too, they are filtered out after
+	build.NoTests = false
+	absPath, modules, a, err := loadAnalysis(path, withImports, build)
+	if err != nil || a == nil {
+		return absPath, modules, nil, err
+	}
+	if noTests {
+		return absPath, modules, analyzer.WithoutTests(a.Imports), nil
+	}
+	return absPath, modules, a.Imports, nil
+}
+
+// loadAnalysis is loadModules followed, when withAnalysis is set, by
+// analyzeModules for build. Both are answered by the daemon serving the
+// workspace when one runs, unless KNIT_DAEMON is off or build is not the host
+// configuration the daemon analyzes the packages for.
+func loadAnalysis(path string, withAnalysis bool, build analyzer.BuildContext) (string, []analyzer.Module, *analyzer.Analysis, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if os.Getenv("KNIT_DAEMON") != "off" && build.IsZero() {
+		resp, err := daemon.Query(daemon.SocketPath(absPath), daemon.MethodState)
+		if err == nil {
+			modules, err := configuredModules(absPath, resp.State.Modules)
+			if err != nil {
+				return "", nil, nil, err
+			}
+			a := &analyzer.Analysis{Imports: resp.State.Imports, Embeds: resp.State.Embeds, NonGoFiles: resp.State.NonGoFiles}
+			if len(modules) < len(resp.State.Modules) {
+				a.Imports = importsBetween(a.Imports, modules)
+			}
+			return absPath, modules, a, nil
+		}
+		if !errors.Is(err, daemon.ErrNotRunning) {
+			fmt.Fprintf(os.Stderr, "warning: knit daemon: %v, loading the workspace\n", err)
+		}
+	}
+
+	absPath, modules, err := loadModules(absPath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if !withAnalysis {
+		return absPath, modules, nil, nil
+	}
+	a, err := analyzeModules(absPath, modules, build)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to build dependency graph: %w", err)
+	}
+	return absPath, modules, a, nil
+}
+
+// importsBetween keeps the imports of the modules from and to which are both
+// in modules, as the daemon analyzes the modules knit.yaml excludes too
+func importsBetween(imports map[string]map[string][]analyzer.Import, modules []analyzer.Module) map[string]map[string][]analyzer.Import {
+	kept := make(map[string]bool, len(modules))
+	for _, m := range modules {
+		kept[m.Path] = true
+	}
+	between := make(map[string]map[string][]analyzer.Import, len(modules))
+	for from, deps := range imports {
+		if !kept[from] {
+			continue
+		}
+		between[from] = make(map[string][]analyzer.Import, len(deps))
+		for to, imps := range deps {
+			if kept[to] {
+				between[from][to] = imps
+			}
+		}
+	}
+	return between
+}
+
+// reportToDaemon sends report to the daemon serving the workspace, if any,
+// for its metrics. A failure only warns, as the metrics are best effort.
+func reportToDaemon(workspaceRoot string, report daemon.Report) {
+	if os.Getenv("KNIT_DAEMON") == "off" {
+		return
+	}
+	_, err := daemon.Send(daemon.SocketPath(workspaceRoot), daemon.Request{Method: daemon.MethodReport, Report: &report})
+	if err != nil && !errors.Is(err, daemon.ErrNotRunning) {
+		fmt.Fprintf(os.Stderr, "warning: knit daemon: %v, the run is not reported\n", err)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	goversion "go/version"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/git"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/urfave/cli/v2"
+)
+
+// diagnosis is the outcome of one 'knit doctor' check
+type diagnosis struct {
+	Name   string
+	OK     bool
+	Detail string
+	// Fix suggests how to solve a failed check
+	Fix string
+	// Warning is set on a failed check that does not fail the command
+	Warning bool
+}
+
+// createDoctorCommand creates the 'doctor' command diagnosing the
+// environment and the workspace
+func createDoctorCommand() *cli.Command {
+	var (
+		path string
+		base string
+	)
+
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "Diagnose the environment and the workspace, suggesting fixes",
+		Description: `Check that git is available, that the local Go toolchain satisfies the go
+directive of every module, that go.work uses every module on disk, that the
+module dependency graph has no cycles, and that the base branch used by
+--affected exists. Exits with code 1 when a check fails.
+
+It also warns about orphan modules: library modules, without a main package,
+no other workspace module depends on, candidates for deletion or extraction.
+Modules published for use outside the workspace are orphans by design.
+
+Examples:
+  knit doctor
+  knit doctor --base origin/main`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Base branch that must exist",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+
+			diagnoses := []diagnosis{
+				checkGit(),
+				checkGoToolchain(absPath),
+				checkGoWork(absPath),
+				checkCycles(absPath),
+				checkOrphans(absPath),
+				checkBaseBranch(absPath, base),
+			}
+
+			failures := 0
+			for _, d := range diagnoses {
+				if d.OK {
+					fmt.Printf("✓ %s: %s\n", d.Name, d.Detail)
+					continue
+				}
+				if d.Warning {
+					fmt.Printf("! %s: %s\n", d.Name, d.Detail)
+				} else {
+					failures++
+					fmt.Printf("✗ %s: %s\n", d.Name, d.Detail)
+				}
+				if d.Fix != "" {
+					fmt.Printf("    fix: %s\n", d.Fix)
+				}
+			}
+			if failures > 0 {
+				return cli.Exit(fmt.Sprintf("%d of %d check(s) failed", failures, len(diagnoses)), 1)
+			}
+			return nil
+		},
+	}
+}
+
+func checkGit() diagnosis {
+	d := diagnosis{Name: "git"}
+	v, err := git.Version()
+	if err != nil {
+		d.Detail = "git is not available"
+		d.Fix = "install git and make sure it is in PATH"
+		return d
+	}
+	d.OK, d.Detail = true, v
+	return d
+}
+
+// checkGoToolchain compares the local toolchain, ignoring GOTOOLCHAIN
+// switching, with the go directive of every module on disk
+func checkGoToolchain(absPath string) diagnosis {
+	d := diagnosis{Name: "go"}
+	cmd := exec.Command("go", "env", "GOVERSION")
+	cmd.Dir = absPath
+	cmd.Env = append(os.Environ(), "GOTOOLCHAIN=local")
+	output, err := cmd.Output()
+	if err != nil {
+		d.Detail = "the go command is not available"
+		d.Fix = "install Go from https://go.dev/dl and make sure it is in PATH"
+		return d
+	}
+	local := strings.TrimSpace(string(output))
+
+	dirs, err := analyzer.FindModuleDirs(absPath)
+	if err != nil {
+		d.Detail = err.Error()
+		return d
+	}
+	var newer []string
+	required := ""
+	for _, dir := range dirs {
+		directives, err := analyzer.ReadGoDirectives(filepath.Join(absPath, dir, "go.mod"))
+		if err != nil {
+			d.Detail = err.Error()
+			return d
+		}
+		if directives.Go == "" {
+			continue
+		}
+		if required == "" || goversion.Compare("go"+directives.Go, "go"+required) > 0 {
+			required = directives.Go
+		}
+		if goversion.Compare(local, "go"+directives.Go) < 0 {
+			newer = append(newer, fmt.Sprintf("%s (go %s)", dir, directives.Go))
+		}
+	}
+
+	if len(newer) > 0 {
+		d.Detail = fmt.Sprintf("%s is older than the go directive of %s", local, strings.Join(newer, ", "))
+		d.Fix = fmt.Sprintf("install go%s or later, or set GOTOOLCHAIN=auto to let the go command download it", required)
+		return d
+	}
+	d.OK = true
+	d.Detail = local + " satisfies every module"
+	if required != "" {
+		d.Detail += fmt.Sprintf(" (highest go directive %s)", required)
+	}
+	return d
+}
+
+func checkGoWork(absPath string) diagnosis {
+	d := diagnosis{Name: "go.work"}
+	drift, err := findWorkDrift(absPath)
+	if err != nil {
+		d.Detail = err.Error()
+		return d
+	}
+	switch {
+	case !drift.Exists:
+		d.Detail = "go.work does not exist"
+		d.Fix = "run 'knit work sync' or 'knit init'"
+	case !drift.UpToDate():
+		d.Detail = fmt.Sprintf("%d module(s) missing (%s), %d stale use directive(s)",
+			len(drift.Missing), strings.Join(drift.Missing, ", "), len(drift.Stale))
+		d.Fix = "run 'knit work sync'"
+	default:
+		d.OK = true
+		d.Detail = fmt.Sprintf("uses all %d module(s)", len(drift.OnDisk))
+	}
+	return d
+}
+
+func checkCycles(absPath string) diagnosis {
+	d := diagnosis{Name: "dependency graph"}
+	_, modules, err := loadModules(absPath)
+	if err != nil {
+		d.Detail = err.Error()
+		d.Fix = "fix go.work and the go.mod files so that 'go list -m' succeeds"
+		return d
+	}
+	adjMap, err := analyzer.ListModuleImportsFor(context.Background(), modules, analyzer.BuildContext{NoTests: true})
+	if err != nil {
+		d.Detail = err.Error()
+		d.Fix = "fix the packages so that 'go list ./...' succeeds in every module"
+		return d
+	}
+
+	cycles := resolver.Cycles(adjMap)
+	if len(cycles) > 0 {
+		described := make([]string, len(cycles))
+		for i, c := range cycles {
+			described[i] = strings.Join(c, " <-> ")
+		}
+		d.Detail = fmt.Sprintf("%d cycle(s): %s", len(cycles), strings.Join(described, "; "))
+		d.Fix = "inspect the imports with 'knit why <from> <to>' and move shared code to a common module"
+		return d
+	}
+	d.OK = true
+	d.Detail = fmt.Sprintf("no cycles between %d module(s)", len(modules))
+	return d
+}
+
+// checkOrphans warns about the library modules no other module depends on,
+// in their tests too
+func checkOrphans(absPath string) diagnosis {
+	d := diagnosis{Name: "orphan modules", Warning: true}
+	absPath, modules, err := loadModules(absPath)
+	if err != nil {
+		d.Detail = err.Error()
+		return d
+	}
+	orphans, err := findOrphans(absPath, modules)
+	if err != nil {
+		d.Detail = err.Error()
+		return d
+	}
+	if len(orphans) > 0 {
+		d.Detail = fmt.Sprintf("%d library module(s) no module depends on: %s", len(orphans), strings.Join(orphans, ", "))
+		d.Fix = "delete them, or extract them to their own repository if they are used outside the workspace"
+		return d
+	}
+	d.OK = true
+	d.Detail = "every library module is a dependency of another module"
+	return d
+}
+
+// findOrphans returns the paths of the modules without a main package that
+// no other module imports, sorted
+func findOrphans(absPath string, modules []analyzer.Module) ([]string, error) {
+	imports, err := listModuleImports(absPath, modules, analyzer.BuildContext{})
+	if err != nil {
+		return nil, err
+	}
+	described, err := describeModules(absPath, modules)
+	if err != nil {
+		return nil, err
+	}
+	dependedOn := make(map[string]bool)
+	for from, deps := range imports {
+		for to := range deps {
+			if to != from {
+				dependedOn[to] = true
+			}
+		}
+	}
+	var orphans []string
+	for _, m := range described {
+		if !m.HasMain && !dependedOn[m.Path] {
+			orphans = append(orphans, m.Path)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+func checkBaseBranch(absPath, base string) diagnosis {
+	d := diagnosis{Name: "base branch"}
+	if !git.RefExists(base, absPath) {
+		d.Detail = base + " does not exist"
+		remote, branch, ok := strings.Cut(base, "/")
+		if !ok {
+			remote, branch = "origin", base
+		}
+		d.Fix = fmt.Sprintf("fetch it with 'git fetch %s %s', or pass another branch with --base", remote, branch)
+		return d
+	}
+	d.OK = true
+	d.Detail = base + " exists"
+	return d
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/runner"
+	"github.com/urfave/cli/v2"
+)
+
+// downloadBatch is the number of dependencies a single go mod download
+// downloads, so that the batches run concurrently
+const downloadBatch = 16
+
+// buildListFormat prints the dependencies of the build list of a module as
+// path@version, the replacement of a replaced one, skipping the workspace
+// modules and the directory replacements, which have no version
+const buildListFormat = `{{if not .Main}}{{with .Replace}}{{if .Version}}{{.Path}}@{{.Version}}{{end}}{{else}}{{.Path}}@{{.Version}}{{end}}{{end}}`
+
+// createDownloadCommand creates the 'download' command warming the module
+// cache with the dependencies of every module
+func createDownloadCommand(r *runner.Runner) *cli.Command {
+	var (
+		path    string
+		targets cli.StringSlice
+		dryRun  bool
+	)
+
+	return &cli.Command{
+		Name:  "download",
+		Usage: "Download the dependencies of every module to the module cache, once each",
+		Description: `Warm the module cache, so that CI jobs started afterwards in parallel find
+their dependencies there. The build lists of the modules are listed
+concurrently with 'go list -m all', the dependencies shared by several
+modules are kept once, then downloaded in concurrent batches of go mod
+download, every module of the build lists, as 'go mod download all' would.
+With go.work the modules share the build list of the workspace.
+
+Examples:
+  knit download
+  knit download -t example.com/service/...
+  knit download --dry-run   # List the dependencies without downloading`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringSliceFlag{
+				Name:        "target",
+				Usage:       "Only download the dependencies of these modules, globs allowed, '/...' matches a whole prefix (repeatable)",
+				Aliases:     []string{"t"},
+				Destination: &targets,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "Print the dependencies without downloading them",
+				Aliases:     []string{"n"},
+				Destination: &dryRun,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			if len(targets.Value()) > 0 {
+				if modules, err = resolveTargets(modules, targets.Value(), absPath); err != nil {
+					return err
+				}
+			}
+
+			deps, err := listBuildLists(r, modules)
+			if err != nil {
+				return err
+			}
+			if len(deps) == 0 {
+				fmt.Printf("No dependencies to download for %d module(s)\n", len(modules))
+				return nil
+			}
+			if dryRun {
+				for _, dep := range deps {
+					fmt.Println(dep)
+				}
+				fmt.Printf("%d dependency(ies) of %d module(s) to download\n", len(deps), len(modules))
+				return nil
+			}
+
+			var tasks []runner.Task
+			for start := 0; start < len(deps); start += downloadBatch {
+				batch := deps[start:min(start+downloadBatch, len(deps))]
+				args := []string{"go", "mod", "download"}
+				for _, dep := range batch {
+					args = append(args, shellQuote(dep))
+				}
+				tasks = append(tasks, runner.Task{
+					Id:    fmt.Sprintf("download %d/%d", len(tasks)+1, (len(deps)+downloadBatch-1)/downloadBatch),
+					Name:  "download",
+					Cmd:   strings.Join(args, " "),
+					Root:  absPath,
+					Label: fmt.Sprintf("go mod download (%d dependencies)", len(batch)),
+				})
+			}
+			results := make([]runner.TaskResult, len(tasks))
+			var wg sync.WaitGroup
+			wg.Add(len(tasks))
+			for i, tf := range r.RunTasks(tasks) {
+				go handleTaskFuture(tf, &results[i], nil, &wg)
+			}
+			wg.Wait()
+
+			failures := 0
+			for _, result := range results {
+				if result.Status != 0 {
+					failures++
+				}
+			}
+			if failures > 0 {
+				return cli.Exit(fmt.Sprintf("%d of %d download batch(es) failed", failures, len(tasks)), 1)
+			}
+			fmt.Printf("✓ Downloaded %d dependency(ies) of %d module(s)\n", len(deps), len(modules))
+			return nil
+		},
+	}
+}
+
+// listBuildLists lists the build lists of the modules concurrently and
+// returns their dependencies as path@version, sorted and deduplicated
+func listBuildLists(r *runner.Runner, modules []analyzer.Module) ([]string, error) {
+	tasks := make([]runner.Task, len(modules))
+	for i, m := range modules {
+		tasks[i] = runner.Task{
+			Id:    m.Path,
+			Name:  "download",
+			Cmd:   "go list -m -f " + shellQuote(buildListFormat) + " all",
+			Root:  m.Dir,
+			Label: "go list -m all",
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		seen   = make(map[string]bool)
+		failed []string
+		wg     sync.WaitGroup
+	)
+	q := r.Quiet()
+	for i, tf := range q.RunTasks(tasks) {
+		wg.Add(1)
+		go func(m analyzer.Module, tf *runner.TaskFuture) {
+			defer wg.Done()
+			var stderr []string
+			stdout, errs := tf.Stdout, tf.Stderr
+			for {
+				select {
+				case line, ok := <-stdout:
+					if !ok {
+						stdout = nil
+					} else if dep := strings.TrimSpace(string(line)); dep != "" {
+						mu.Lock()
+						seen[dep] = true
+						mu.Unlock()
+					}
+				case line, ok := <-errs:
+					if !ok {
+						errs = nil
+					} else {
+						stderr = append(stderr, string(line))
+					}
+				case res := <-tf.Done:
+					if res.Status != 0 {
+						mu.Lock()
+						failed = append(failed, fmt.Sprintf("go list -m all failed in %s:\n%s", m.Path, strings.Join(stderr, "\n")))
+						mu.Unlock()
+					}
+					return
+				}
+			}
+		}(modules[i], tf)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return nil, fmt.Errorf("%s", strings.Join(failed, "\n"))
+	}
+	return sortedKeys(seen), nil
+}
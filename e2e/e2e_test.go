@@ -1,11 +1,31 @@
 package e2e
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -37,6 +57,10 @@ func TestMain(m *testing.M) {
 		panic("failed to build binary: " + err.Error() + "\noutput: " + string(output))
 	}
 
+	// The knit runs of the tests must not write the summary of the CI job
+	// running them
+	os.Unsetenv("GITHUB_STEP_SUMMARY")
+
 	// Run tests
 	code := m.Run()
 
@@ -53,6 +77,18 @@ func runKnit(t *testing.T, args ...string) (string, error) {
 	return string(output), err
 }
 
+// runShell runs a script of a generated CI file with sh in dir, with the knit
+// binary first in PATH
+func runShell(t *testing.T, dir, script string, env ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command("sh", "-ec", script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, "PATH="+filepath.Dir(binaryPath)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
 func TestE2E_TestAllModules(t *testing.T) {
 	output, err := runKnit(t, "test", "-p", workspaceDir)
 	if err != nil {
@@ -128,6 +164,67 @@ func TestE2E_FmtAllModules(t *testing.T) {
 	}
 }
 
+func TestE2E_FmtCheck(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	output, err := runKnit(t, "fmt", "-p", dir, "--check")
+	if err != nil {
+		t.Fatalf("expected a formatted workspace to pass the check: %v\n%s", err, output)
+	}
+
+	unformatted := "package core\nfunc  Helper( ) {}\n"
+	writeFile(t, filepath.Join(dir, "core", "helper.go"), unformatted)
+	writeFile(t, filepath.Join(dir, "core", "internal", "sub", "sub.go"), "package sub\nvar  X=1\n")
+	output, err = runKnit(t, "fmt", "-p", dir, "--check")
+	if err == nil {
+		t.Fatalf("expected unformatted files to fail the check, got:\n%s", output)
+	}
+	for _, want := range []string{"[example.com/core]  helper.go", "[example.com/core]  internal/sub/sub.go", "1 of 4 modules failed"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "core", "helper.go")); err != nil || string(data) != unformatted {
+		t.Errorf("expected --check not to modify the file, got: %v\n%s", err, data)
+	}
+
+	if output, err := runKnit(t, "fmt", "-p", dir); err != nil {
+		t.Fatalf("fmt failed: %v\n%s", err, output)
+	}
+	if output, err := runKnit(t, "fmt", "-p", dir, "--check"); err != nil {
+		t.Errorf("expected the formatted files to pass the check: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_FmtFormatter(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "format:\n  run: gofmt -s -w .\n  check: gofmt -s -l .\n")
+	// Formatted for go fmt, but not simplified as gofmt -s does
+	simplifiable := "package core\n\nvar Points = []struct{ X int }{struct{ X int }{1}}\n"
+	writeFile(t, filepath.Join(dir, "core", "points.go"), simplifiable)
+
+	if output, err := runKnit(t, "fmt", "-p", dir, "-t", "example.com/core"); err != nil {
+		t.Fatalf("fmt failed: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "core", "points.go"))
+	if err != nil || string(data) != "package core\n\nvar Points = []struct{ X int }{{1}}\n" {
+		t.Errorf("expected format.run to simplify the file, got: %v\n%s", err, data)
+	}
+
+	writeFile(t, filepath.Join(dir, "core", "points.go"), simplifiable)
+	output, err := runKnit(t, "fmt", "-p", dir, "--check")
+	if err == nil || !strings.Contains(output, "[example.com/core]  points.go") {
+		t.Errorf("expected format.check to fail listing the file, got: %v\n%s", err, output)
+	}
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "format:\n  run: gofmt -s -w .\n")
+	if output, err := runKnit(t, "fmt", "-p", dir); err == nil || !strings.Contains(output, "format.check is required") {
+		t.Errorf("expected format.run without format.check to be rejected, got:\n%s", output)
+	}
+}
+
 func TestE2E_InstallAllModules(t *testing.T) {
 	t.Skip("Install command removed - not useful for Go modules")
 }
@@ -278,6 +375,52 @@ func TestE2E_AffectedWithDeps(t *testing.T) {
 	}
 }
 
+// assertAffected checks the modules knit affected reports for the
+// uncommitted changes of the workspace in dir, with extra arguments
+func assertAffected(t *testing.T, dir string, want string, args ...string) {
+	t.Helper()
+	output, err := runKnit(t, append([]string{"affected", "-p", dir, "--base", "HEAD"}, args...)...)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != want {
+		t.Errorf("knit affected %s: expected:\n%s\ngot:\n%s", strings.Join(args, " "), want, output)
+	}
+}
+
+func TestE2E_AffectedEmbeddedFile(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "core", "assets", "greeting.txt"), "hello\n")
+	writeFile(t, filepath.Join(dir, "core", "embed.go"), "package core\n\nimport _ \"embed\"\n\n//go:embed assets/greeting.txt\nvar greeting string\n")
+	cleanup := setupGitRepo(t, dir, []string{filepath.Join("core", "assets", "greeting.txt")})
+	defer cleanup()
+
+	// Only the embedded asset changed, no .go file
+	assertAffected(t, dir, "example.com/core\n")
+	assertAffected(t, dir, "example.com/core\nexample.com/utils\nexample.com/api\nexample.com/app\n", "--include-dependents")
+}
+
+func TestE2E_AffectedCgoAndAssemblyFiles(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	// The C file is only built with cgo, the tag keeping it out of the others
+	writeFile(t, filepath.Join(dir, "utils", "shim.c"), "//go:build knit_cgo\n\nint shim(void) { return 1; }\n")
+	writeFile(t, filepath.Join(dir, "utils", "shim.go"), "//go:build knit_cgo\n\npackage utils\n\n// int shim(void);\nimport \"C\"\n")
+	writeFile(t, filepath.Join(dir, "core", "stub.s"), "// Assembly of core\n")
+	cleanup := setupGitRepo(t, dir, []string{filepath.Join("utils", "shim.c"), filepath.Join("core", "stub.s")})
+	defer cleanup()
+
+	// Only the C file of utils and the assembly file of core changed
+	assertAffected(t, dir, "example.com/core\nexample.com/utils\n")
+	assertAffected(t, dir, "example.com/core\nexample.com/utils\nexample.com/api\nexample.com/app\n", "--include-dependents")
+
+	// A change to the C file of utils affects the modules depending on it
+	runGit(t, dir, "checkout", "--", filepath.Join("core", "stub.s"))
+	assertAffected(t, dir, "example.com/utils\n")
+	assertAffected(t, dir, "example.com/utils\nexample.com/api\nexample.com/app\n", "--include-dependents")
+}
+
 func TestE2E_AffectedNoChanges(t *testing.T) {
 	// Setup git repo with NO changes after commit
 	cleanup := setupGitRepo(t, workspaceDir, []string{})
@@ -293,3 +436,4002 @@ func TestE2E_AffectedNoChanges(t *testing.T) {
 		t.Errorf("expected empty module array in output, got:\n%s", output)
 	}
 }
+
+// gitOutput runs a git command in dir and returns its trimmed output
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %v failed: %v", args, err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestE2E_AffectedSinceSHAFile(t *testing.T) {
+	cleanup := setupGitRepo(t, workspaceDir, []string{
+		"utils/utils.go",
+	})
+	defer cleanup()
+
+	// Record the initial commit as the last green SHA, then commit the change
+	sha := gitOutput(t, workspaceDir, "rev-parse", "HEAD")
+	runGit(t, workspaceDir, "commit", "-am", "change utils")
+
+	shaFile := filepath.Join(t.TempDir(), "last-green")
+	if err := os.WriteFile(shaFile, []byte(sha+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write sha file: %v", err)
+	}
+
+	// --base HEAD alone would see no changes, the SHA file must take precedence
+	output, err := runKnit(t, "affected", "-p", workspaceDir, "--base", "HEAD", "--since-sha-file", shaFile)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "example.com/utils") {
+		t.Errorf("expected example.com/utils in output, got:\n%s", output)
+	}
+	if strings.Contains(output, "example.com/core") {
+		t.Errorf("unexpected example.com/core in output:\n%s", output)
+	}
+
+	output, err = runKnit(t, "affected", "-p", workspaceDir, "--base-sha", sha)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "example.com/utils") {
+		t.Errorf("expected example.com/utils in output, got:\n%s", output)
+	}
+}
+
+// runKnitWithStdin executes the knit binary with the given stdin and arguments
+func runKnitWithStdin(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(binaryPath, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func TestE2E_AffectedFilesFromStdin(t *testing.T) {
+	// No git repo needed: the file list replaces git diff entirely
+	stdin := "core/core.go\n\n" + filepath.Join(workspaceDir, "app", "main.go") + "\n"
+	output, err := runKnitWithStdin(t, stdin, "affected", "-p", workspaceDir, "--files-from", "-")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+
+	if !strings.Contains(output, "example.com/core") {
+		t.Errorf("expected example.com/core in output, got:\n%s", output)
+	}
+	if !strings.Contains(output, "example.com/app") {
+		t.Errorf("expected example.com/app (absolute path) in output, got:\n%s", output)
+	}
+	if strings.Contains(output, "example.com/api") || strings.Contains(output, "example.com/utils") {
+		t.Errorf("unexpected unchanged module in output:\n%s", output)
+	}
+}
+
+// copyDir recursively copies the src directory into dst
+func copyDir(t *testing.T, src, dst string) {
+	t.Helper()
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, info.Mode())
+	})
+	if err != nil {
+		t.Fatalf("failed to copy %s to %s: %v", src, dst, err)
+	}
+}
+
+func TestE2E_AffectedWorkspaceInSubdirectory(t *testing.T) {
+	// Repository root contains the workspace under backend/, plus unrelated files
+	repoDir := t.TempDir()
+	backendDir := filepath.Join(repoDir, "backend")
+	copyDir(t, workspaceDir, backendDir)
+	if err := os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("root\n"), 0644); err != nil {
+		t.Fatalf("failed to write README: %v", err)
+	}
+
+	setupGitRepo(t, repoDir, []string{
+		"backend/api/api.go",
+		"README.md",
+	})
+
+	output, err := runKnit(t, "affected", "-p", backendDir, "--base", "HEAD")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+
+	if !strings.Contains(output, "example.com/api") {
+		t.Errorf("expected example.com/api in output, got:\n%s", output)
+	}
+	for _, mod := range []string{"example.com/core", "example.com/utils", "example.com/app"} {
+		if strings.Contains(output, mod) {
+			t.Errorf("unexpected %s in output:\n%s", mod, output)
+		}
+	}
+}
+
+func TestE2E_AffectedGoGitBackend(t *testing.T) {
+	cleanup := setupGitRepo(t, workspaceDir, []string{
+		"core/core.go",
+	})
+	defer cleanup()
+
+	// One committed change on a branch, one uncommitted change in the worktree
+	runGit(t, workspaceDir, "checkout", "-q", "-b", "feature")
+	runGit(t, workspaceDir, "commit", "-qam", "change core")
+	f, err := os.OpenFile(filepath.Join(workspaceDir, "utils", "utils.go"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open utils.go: %v", err)
+	}
+	f.WriteString("\n// modified for test\n")
+	f.Close()
+	defer func() {
+		path := filepath.Join(workspaceDir, "utils", "utils.go")
+		data, _ := os.ReadFile(path)
+		os.WriteFile(path, []byte(strings.ReplaceAll(string(data), "\n// modified for test\n", "")), 0644)
+	}()
+
+	base := gitOutput(t, workspaceDir, "rev-parse", "HEAD~1")
+	for _, backend := range []string{"exec", "go-git"} {
+		output, err := runKnit(t, "affected", "-p", workspaceDir, "--base", base, "--merge-base", "--git-backend", backend)
+		if err != nil {
+			t.Fatalf("[%s] command failed: %v\noutput: %s", backend, err, output)
+		}
+		for _, mod := range []string{"example.com/core", "example.com/utils"} {
+			if !strings.Contains(output, mod) {
+				t.Errorf("[%s] expected %s in output, got:\n%s", backend, mod, output)
+			}
+		}
+		for _, mod := range []string{"example.com/api", "example.com/app"} {
+			if strings.Contains(output, mod) {
+				t.Errorf("[%s] unexpected %s in output:\n%s", backend, mod, output)
+			}
+		}
+	}
+}
+
+func TestE2E_AffectedExitCode(t *testing.T) {
+	cleanup := setupGitRepo(t, workspaceDir, []string{})
+	defer cleanup()
+
+	output, err := runKnit(t, "affected", "-p", workspaceDir, "--base", "HEAD", "--exit-code")
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit code 3 with no affected modules, got %v\noutput: %s", err, output)
+	}
+
+	// Without --exit-code an empty result is still a success
+	output, err = runKnit(t, "affected", "-p", workspaceDir, "--base", "HEAD")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+}
+
+func TestE2E_AffectedDirsFormat(t *testing.T) {
+	stdin := "api/api.go\n"
+	output, err := runKnitWithStdin(t, stdin, "affected", "-p", workspaceDir, "--files-from", "-", "-f", "rel-dirs")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.TrimSpace(output) != "api" {
+		t.Errorf("expected 'api', got:\n%s", output)
+	}
+
+	output, err = runKnitWithStdin(t, stdin, "affected", "-p", workspaceDir, "--files-from", "-", "-f", "dirs")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.TrimSpace(output) != filepath.Join(workspaceDir, "api") {
+		t.Errorf("expected absolute api directory, got:\n%s", output)
+	}
+}
+
+func TestE2E_AffectedNulSeparatedFormat(t *testing.T) {
+	stdin := "api/api.go\ncore/core.go\n"
+	output, err := runKnitWithStdin(t, stdin, "affected", "-p", workspaceDir, "--files-from", "-", "-f", "list0")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/core\x00example.com/api\x00" && output != "example.com/api\x00example.com/core\x00" {
+		t.Errorf("expected NUL-terminated module paths, got %q", output)
+	}
+
+	output, err = runKnitWithStdin(t, stdin, "affected", "-p", workspaceDir, "--files-from", "-", "-f", "rel-dirs0")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.Count(output, "\x00") != 2 || strings.Contains(output, "\n") {
+		t.Errorf("expected two NUL-terminated directories, got %q", output)
+	}
+}
+
+func TestE2E_TemplateFormat(t *testing.T) {
+	output, err := runKnitWithStdin(t, "api/api.go\n", "affected", "-p", workspaceDir, "--files-from", "-",
+		"-f", "template", "--template", `{{range .Modules}}{{.Path}},{{.RelDir}}\n{{end}}`)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/api,api\n" {
+		t.Errorf("expected 'example.com/api,api', got %q", output)
+	}
+
+	output, err = runKnit(t, "graph", "-p", workspaceDir,
+		"-f", "template", "--template", `{{range .Modules}}{{.Name}}={{join .Dependencies ","}};{{end}}`)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "api=example.com/core,example.com/utils;") {
+		t.Errorf("expected api dependencies in template output, got %q", output)
+	}
+}
+
+func TestE2E_AffectedGitLabCIFormat(t *testing.T) {
+	output, err := runKnitWithStdin(t, "api/api.go\n", "affected", "-p", workspaceDir, "--files-from", "-", "-f", "gitlab-ci")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, `"test:example.com/api":`) {
+		t.Errorf("expected a job for example.com/api, got:\n%s", output)
+	}
+	if !strings.Contains(output, `- "knit test -t example.com/api"`) {
+		t.Errorf("expected the default job command, got:\n%s", output)
+	}
+
+	// GitLab rejects child pipelines without jobs
+	output, err = runKnitWithStdin(t, "", "affected", "-p", workspaceDir, "--files-from", "-", "-f", "gitlab-ci")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "no-affected-modules:") {
+		t.Errorf("expected a placeholder job for an empty affected set, got:\n%s", output)
+	}
+}
+
+func TestE2E_AffectedCircleCIFormat(t *testing.T) {
+	output, err := runKnitWithStdin(t, "api/api.go\ncore/core.go\n", "affected", "-p", workspaceDir, "--files-from", "-", "-f", "circleci")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.TrimSpace(output) != `{"run-api":true,"run-core":true}` {
+		t.Errorf("expected CircleCI parameters for api and core, got:\n%s", output)
+	}
+}
+
+func TestE2E_AffectedAzureMatrixFormat(t *testing.T) {
+	output, err := runKnitWithStdin(t, "api/api.go\n", "affected", "-p", workspaceDir, "--files-from", "-", "-f", "azure-matrix")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.TrimSpace(output) != `{"api":{"module":"example.com/api","moduleDir":"api"}}` {
+		t.Errorf("expected Azure matrix for api, got:\n%s", output)
+	}
+}
+
+func TestE2E_AffectedCIJobNameCollisions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a-b", "a.b", "a_b"} {
+		writeFile(t, filepath.Join(dir, name, "go.mod"), "module example.com/"+name+"\n\ngo 1.22.4\n")
+		writeFile(t, filepath.Join(dir, name, "lib.go"), "package lib\n")
+	}
+	writeFile(t, filepath.Join(dir, "go.work"), "go 1.22.4\n\nuse (\n\t./a-b\n\t./a.b\n\t./a_b\n)\n")
+	files := "a-b/lib.go\na.b/lib.go\na_b/lib.go\n"
+
+	output, err := runKnitWithStdin(t, files, "affected", "-p", dir, "--files-from", "-", "-f", "azure-matrix")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	var matrix map[string]struct {
+		Module string `json:"module"`
+	}
+	if err := json.Unmarshal([]byte(output), &matrix); err != nil {
+		t.Fatalf("invalid Azure matrix: %v\n%s", err, output)
+	}
+	modules := make(map[string]bool)
+	for name, entry := range matrix {
+		if !strings.HasPrefix(name, "a_b") {
+			t.Errorf("unexpected job name %q", name)
+		}
+		modules[entry.Module] = true
+	}
+	if len(matrix) != 3 || len(modules) != 3 {
+		t.Errorf("expected a job per module for colliding directories, got:\n%s", output)
+	}
+
+	output, err = runKnitWithStdin(t, files, "affected", "-p", dir, "--files-from", "-", "-f", "circleci")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.TrimSpace(output) != `{"run-a-b":true,"run-a-b-2":true,"run-a-b-3":true}` {
+		t.Errorf("expected a CircleCI parameter per module for colliding directories, got:\n%s", output)
+	}
+}
+
+func TestE2E_ShardCoversAllModules(t *testing.T) {
+	durations := filepath.Join(t.TempDir(), "durations.json")
+	os.WriteFile(durations, []byte(`{"example.com/app": 60, "example.com/api": 10, "example.com/core": 10, "example.com/utils": 10}`), 0644)
+
+	seen := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		output, err := runKnit(t, "shard", "-p", workspaceDir, "--total", "2", "--index", fmt.Sprint(i), "--durations", durations)
+		if err != nil {
+			t.Fatalf("command failed: %v\noutput: %s", err, output)
+		}
+		lines := strings.Fields(output)
+		// The slow app module must be alone in its group
+		if i == 0 && (len(lines) != 1 || lines[0] != "example.com/app") {
+			t.Errorf("expected example.com/app alone in group 0, got %v", lines)
+		}
+		for _, m := range lines {
+			seen[m]++
+		}
+	}
+
+	for _, mod := range []string{"example.com/core", "example.com/utils", "example.com/api", "example.com/app"} {
+		if seen[mod] != 1 {
+			t.Errorf("expected %s in exactly one shard, got %d", mod, seen[mod])
+		}
+	}
+
+	if output, err := runKnit(t, "shard", "-p", workspaceDir, "--total", "2", "--index", "2"); err == nil {
+		t.Errorf("expected an error for an out of range index, got:\n%s", output)
+	}
+}
+
+func TestE2E_ShardTemplate(t *testing.T) {
+	output, err := runKnit(t, "shard", "-p", workspaceDir, "--total", "1", "--index", "0", "-f", "template", "--template", "{{range .Modules}}[{{.Name}}]{{end}}")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.TrimSpace(output) != "[api][app][core][utils]" {
+		t.Errorf("expected every module rendered by the template, got:\n%s", output)
+	}
+}
+
+func TestE2E_EmptyWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "excludeModules: [example.com/...]\n")
+
+	// Commands running over every module have nothing to do
+	for _, args := range [][]string{{"list", "-p", dir}, {"test", "-p", dir}} {
+		if output, err := runKnit(t, args...); err != nil {
+			t.Errorf("%s failed: %v\noutput: %s", args[0], err, output)
+		}
+	}
+	output, err := runKnit(t, "shard", "-p", dir, "--total", "1", "--index", "0")
+	if err == nil || !strings.Contains(output, "no modules found in workspace") {
+		t.Errorf("expected shard to fail on an empty workspace, got %v:\n%s", err, output)
+	}
+}
+
+func TestE2E_RerunFailed(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	os.RemoveAll(filepath.Join(dir, ".knit"))
+
+	failing := "package utils\n\nimport \"testing\"\n\nfunc TestBroken(t *testing.T) { t.Fatal(\"broken\") }\n"
+	if err := os.WriteFile(filepath.Join(dir, "utils", "broken_test.go"), []byte(failing), 0644); err != nil {
+		t.Fatalf("failed to write failing test: %v", err)
+	}
+
+	output, err := runKnit(t, "test", "-p", dir)
+	if err == nil {
+		t.Fatalf("expected knit test to fail, got:\n%s", output)
+	}
+	if !strings.Contains(output, "1 of 4 modules failed") {
+		t.Errorf("expected failure summary, got:\n%s", output)
+	}
+
+	output, _ = runKnit(t, "rerun-failed", "-p", dir)
+	if !strings.Contains(output, "[example.com/utils]") {
+		t.Errorf("expected utils to be re-run, got:\n%s", output)
+	}
+	for _, mod := range []string{"[example.com/core]", "[example.com/api]", "[example.com/app]"} {
+		if strings.Contains(output, mod) {
+			t.Errorf("unexpected %s in re-run output:\n%s", mod, output)
+		}
+	}
+
+	// Once fixed, the module is no longer recorded as failed
+	os.Remove(filepath.Join(dir, "utils", "broken_test.go"))
+	if output, err := runKnit(t, "test", "-p", dir, "--failed"); err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	output, err = runKnit(t, "test", "-p", dir, "--failed")
+	if err != nil || !strings.Contains(output, "No failed modules") {
+		t.Errorf("expected no failed modules left, got %v:\n%s", err, output)
+	}
+}
+
+func TestE2E_Why(t *testing.T) {
+	output, err := runKnit(t, "why", "-p", workspaceDir, "example.com/app", "example.com/utils")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+
+	// app only reaches utils through api
+	if !strings.Contains(output, "1 shortest path(s)") {
+		t.Errorf("expected a single shortest path, got:\n%s", output)
+	}
+	for _, want := range []string{"example.com/app imports example.com/api", "example.com/api imports example.com/utils"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+
+	output, err = runKnit(t, "why", "-p", workspaceDir, "example.com/core", "example.com/utils")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "does not depend on") {
+		t.Errorf("expected no path from core to utils, got:\n%s", output)
+	}
+
+	if output, err := runKnit(t, "why", "-p", workspaceDir, "example.com/app", "example.com/missing"); err == nil {
+		t.Errorf("expected unknown module to fail, got:\n%s", output)
+	}
+}
+
+func TestE2E_Impacted(t *testing.T) {
+	output, err := runKnit(t, "impacted", "-p", workspaceDir, "example.com/utils")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/api\nexample.com/app\n" {
+		t.Errorf("expected api and app, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "impacted", "-p", workspaceDir, "--depth", "1", "-f", "rel-dirs", "example.com/core")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	for _, dir := range []string{"utils", "api", "app"} {
+		if !strings.Contains(output, dir+"\n") {
+			t.Errorf("expected direct dependent %s, got:\n%s", dir, output)
+		}
+	}
+
+	output, err = runKnit(t, "impacted", "-p", workspaceDir, "--depth", "1", "example.com/utils")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/api\n" {
+		t.Errorf("expected only api within depth 1, got:\n%s", output)
+	}
+}
+
+func TestE2E_BuildTags(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "go.work"), "go 1.22.4\n\nuse (\n\t./core\n\t./utils\n\t./api\n\t./app\n\t./tools\n)\n")
+	writeFile(t, filepath.Join(dir, "tools", "go.mod"), "module example.com/tools\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(dir, "tools", "tools.go"), "package tools\n")
+	writeFile(t, filepath.Join(dir, "tools", "integration.go"), "//go:build integration\n\npackage tools\n\nimport _ \"example.com/core\"\n")
+	writeFile(t, filepath.Join(dir, "tools", "tools_windows.go"), "package tools\n\nimport _ \"example.com/utils\"\n")
+
+	impacted := func(args ...string) string {
+		t.Helper()
+		output, err := runKnit(t, append(append([]string{"impacted", "-p", dir}, args...), "example.com/core")...)
+		if err != nil {
+			t.Fatalf("command failed: %v\noutput: %s", err, output)
+		}
+		return output
+	}
+	if output := impacted(); strings.Contains(output, "example.com/tools") {
+		t.Errorf("expected the tagged import to be ignored by default, got:\n%s", output)
+	}
+	if output := impacted("--tags", "integration"); !strings.Contains(output, "example.com/tools") {
+		t.Errorf("expected tools to depend on core with the integration tag, got:\n%s", output)
+	}
+	// The cached analysis of another configuration is not reused
+	if output := impacted(); strings.Contains(output, "example.com/tools") {
+		t.Errorf("expected the host analysis again, got:\n%s", output)
+	}
+
+	output, err := runKnit(t, "why", "-p", dir, "--goos", "windows", "example.com/tools", "example.com/core")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "example.com/tools imports example.com/utils") {
+		t.Errorf("expected the windows file to import utils, got:\n%s", output)
+	}
+}
+
+func TestE2E_TestOnlyDeps(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "go.work"), "go 1.22.4\n\nuse (\n\t./core\n\t./utils\n\t./api\n\t./app\n\t./tools\n)\n")
+	writeFile(t, filepath.Join(dir, "tools", "go.mod"), "module example.com/tools\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(dir, "tools", "tools.go"), "package tools\n")
+	writeFile(t, filepath.Join(dir, "tools", "tools_test.go"), "package tools_test\n\nimport _ \"example.com/core\"\n")
+	cleanup := setupGitRepo(t, dir, []string{"core/core.go"})
+	defer cleanup()
+
+	output, err := runKnit(t, "graph", "-p", dir)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "└── example.com/core [test]") {
+		t.Errorf("expected the dependency of tools on core to be marked test-only, got:\n%s", output)
+	}
+	output, err = runKnit(t, "graph", "-p", dir, "-f", "json")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, `"testDependencies": [`) {
+		t.Errorf("expected test dependencies in the JSON graph, got:\n%s", output)
+	}
+
+	// Rerunning the tests of dependents follows the imports of tests, the
+	// impact on the build does not
+	output, err = runKnit(t, "affected", "-p", dir, "--base", "HEAD", "--include-dependents")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/core\nexample.com/utils\nexample.com/api\nexample.com/app\nexample.com/tools\n" {
+		t.Errorf("expected core and every dependent, got:\n%s", output)
+	}
+	output, err = runKnit(t, "affected", "-p", dir, "--base", "HEAD", "--include-dependents", "--no-test-deps")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.Contains(output, "example.com/tools") {
+		t.Errorf("expected tools to be left out without test dependencies, got:\n%s", output)
+	}
+}
+
+func TestE2E_VendoredWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	// api requires a module vendored by go work vendor, whose vendor
+	// directory is out of date
+	writeFile(t, filepath.Join(dir, "api", "go.mod"), "module example.com/api\n\ngo 1.22.4\n\nrequire example.com/dep v1.0.0\n")
+	writeFile(t, filepath.Join(dir, "vendor", "modules.txt"), "## workspace\n# example.com/dep v0.9.0\n## explicit; go 1.22\nexample.com/dep\n")
+	writeFile(t, filepath.Join(dir, "vendor", "example.com", "dep", "dep.go"), "package dep\n")
+	cleanup := setupGitRepo(t, dir, []string{"vendor/example.com/dep/dep.go"})
+	defer cleanup()
+
+	// The vendored file is attributed to the module requiring it
+	output, err := runKnit(t, "affected", "-p", dir, "--base", "HEAD")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/api\n" {
+		t.Errorf("expected api only, got:\n%s", output)
+	}
+}
+
+func TestE2E_ExcludeModules(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "go.work"), "go 1.22.4\n\nuse (\n\t./core\n\t./utils\n\t./api\n\t./app\n\t./tools\n)\n")
+	writeFile(t, filepath.Join(dir, "tools", "go.mod"), "module example.com/tools\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(dir, "tools", "tools.go"), "package tools\n\nimport _ \"example.com/core\"\n")
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "excludeModules: [tools, example.com/app]\n")
+	cleanup := setupGitRepo(t, dir, []string{"core/core.go"})
+	defer cleanup()
+
+	for _, args := range [][]string{
+		{"list", "-p", dir},
+		{"graph", "-p", dir},
+		{"affected", "-p", dir, "--base", "HEAD", "--include-deps"},
+	} {
+		output, err := runKnit(t, args...)
+		if err != nil {
+			t.Fatalf("%s failed: %v\noutput: %s", args[0], err, output)
+		}
+		if !strings.Contains(output, "example.com/core") {
+			t.Errorf("expected %s to keep core, got:\n%s", args[0], output)
+		}
+		if strings.Contains(output, "example.com/tools") || strings.Contains(output, "example.com/app") {
+			t.Errorf("expected %s to ignore the excluded modules, got:\n%s", args[0], output)
+		}
+	}
+}
+
+func TestE2E_Build(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	out := filepath.Join(dir, "dist")
+	host := runtime.GOOS + "/" + runtime.GOARCH
+
+	output, err := runKnit(t, "build", "-p", dir, "-o", out, "--platforms", host+",plan9/nope")
+	if err == nil {
+		t.Fatalf("expected the unsupported platform to fail the build, output: %s", output)
+	}
+	if !strings.Contains(output, "plan9/nope: example.com/app") || !strings.Contains(output, "1 of 2 builds failed") {
+		t.Errorf("expected the failure reported per platform, got:\n%s", output)
+	}
+	binary := fmt.Sprintf("app_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	if _, err := os.Stat(filepath.Join(out, binary)); err != nil {
+		t.Errorf("expected the host binary to be built despite the failure: %v", err)
+	}
+	// Only app has a main package
+	if entries, _ := os.ReadDir(out); len(entries) != 1 {
+		t.Errorf("expected a single binary, got %v", entries)
+	}
+
+	if output, err := runKnit(t, "build", "-p", dir, "--platforms", "linux"); err == nil || !strings.Contains(output, "expected goos/goarch") {
+		t.Errorf("expected an invalid platform error, got: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_BuildNameCollisions(t *testing.T) {
+	dir := t.TempDir()
+	for mod, path := range map[string]string{"a/api": "example.com/a/api", "b/api": "example.com/b/api", "svc": "example.com/svc/v2"} {
+		writeFile(t, filepath.Join(dir, mod, "go.mod"), "module "+path+"\n\ngo 1.22.4\n")
+		writeFile(t, filepath.Join(dir, mod, "main.go"), "package main\n\nfunc main() {}\n")
+	}
+	writeFile(t, filepath.Join(dir, "go.work"), "go 1.22.4\n\nuse (\n\t./a/api\n\t./b/api\n\t./svc\n)\n")
+	out := filepath.Join(dir, "dist")
+
+	if output, err := runKnit(t, "build", "-p", dir, "-o", out); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, output)
+	}
+	var got []string
+	entries, _ := os.ReadDir(out)
+	for _, e := range entries {
+		got = append(got, strings.TrimSuffix(e.Name(), fmt.Sprintf("_%s_%s", runtime.GOOS, runtime.GOARCH)+filepath.Ext(e.Name())))
+	}
+	slices.Sort(got)
+	if strings.Join(got, ",") != "api,api-2,svc" {
+		t.Errorf("expected a binary per module without the major version, got %v", entries)
+	}
+}
+
+func TestE2E_ModuleToolchains(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	// go.work ignores the toolchain directives of the modules, none is
+	// downloaded as long as the tasks do not run the go command
+	writeFile(t, filepath.Join(dir, "core", "go.mod"), "module example.com/core\n\ngo 1.22.4\n\ntoolchain go1.99.0\n")
+	writeFile(t, filepath.Join(dir, "utils", "go.mod"), "module example.com/utils\n\ngo 1.22.4\n\ntoolchain go1.22.5\n\nrequire example.com/core v0.0.0\n")
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "tasks:\n  toolchain:\n    run: echo \"toolchain=$GOTOOLCHAIN\"\n")
+
+	output, err := runKnit(t, "run", "-p", dir, "toolchain")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "[example.com/core]  toolchain=go1.99.0+auto") {
+		t.Errorf("expected core to run with its newer toolchain, got:\n%s", output)
+	}
+	if strings.Contains(output, "[example.com/utils] toolchain=go1") || strings.Contains(output, "[example.com/api]   toolchain=go1") {
+		t.Errorf("expected the other modules to keep the workspace toolchain, got:\n%s", output)
+	}
+	if !strings.Contains(output, "warning: toolchain directives older than the workspace") || !strings.Contains(output, "example.com/utils (go1.22.5)") {
+		t.Errorf("expected a warning about the older toolchain of utils, got:\n%s", output)
+	}
+}
+
+func TestE2E_EnvForwarding(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `forwardEnv: [KNIT_FWD_*]
+scrubEnv: [KNIT_FWD_SECRET]
+tasks:
+  env:
+    run: echo "fwd=$KNIT_FWD_ONE secret=$KNIT_FWD_SECRET other=$KNIT_OTHER token=$KNIT_TOKEN extra=$KNIT_EXTRA"
+    before:
+      - run: echo "hook secret=$KNIT_FWD_SECRET extra=$KNIT_EXTRA"
+`)
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command(binaryPath, append([]string{"run", "-p", dir, "-t", "example.com/core"}, args...)...)
+		cmd.Env = append(os.Environ(), "KNIT_FWD_ONE=1", "KNIT_FWD_SECRET=s3cret", "KNIT_OTHER=other", "KNIT_TOKEN=t0ken")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("run failed: %v\n%s", err, output)
+		}
+		return string(output)
+	}
+
+	output := run("env")
+	if !strings.Contains(output, "fwd=1 secret= other= token= extra=") {
+		t.Errorf("expected only the forwarded variables, got:\n%s", output)
+	}
+	if !strings.Contains(output, "hook secret= extra=") {
+		t.Errorf("expected the hooks to get the same environment, got:\n%s", output)
+	}
+
+	output = run("--forward-env", "KNIT_TOKEN", "--scrub-env", "KNIT_FWD_ONE", "--env", "KNIT_EXTRA=x", "--env", "KNIT_FWD_SECRET=given", "env")
+	if !strings.Contains(output, "fwd= secret=given other= token=t0ken extra=x") || !strings.Contains(output, "hook secret=given extra=x") {
+		t.Errorf("expected the flags to add to knit.yaml and --env to set variables, got:\n%s", output)
+	}
+
+	if output, err := runKnit(t, "run", "-p", dir, "--env", "NOVALUE", "env"); err == nil || !strings.Contains(output, "expected KEY=value") {
+		t.Errorf("expected an invalid --env error, got: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_GeneratedSources(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "proto", "payments", "payment.proto"), "syntax = \"proto3\";\n")
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  generate:
+    run: echo {{.ShortName}} >> ../generated.log
+  check:
+    run: echo checked {{.ShortName}}
+generated:
+  - inputs: [proto/**/*.proto]
+    modules: [example.com/api]
+    generate: generate
+`)
+	cleanup := setupGitRepo(t, dir, []string{"proto/payments/payment.proto"})
+	defer cleanup()
+
+	output, err := runKnit(t, "affected", "-p", dir, "--base", "HEAD")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.TrimSpace(output) != "example.com/api" {
+		t.Errorf("expected the .proto change to only affect api, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "run", "-p", dir, "-a", "-b", "HEAD", "check")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	generated := strings.Index(output, "Running generate first on the modules consuming")
+	if generated < 0 || generated > strings.Index(output, "checked api") {
+		t.Errorf("expected generate to run before check, got:\n%s", output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "generated.log")); string(data) != "api\n" {
+		t.Errorf("expected generate to run on api only, got %q", data)
+	}
+}
+
+func TestE2E_TestRace(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "race:\n  default: true\n  exclude: [utils]\n")
+
+	output, err := runKnit(t, "test", "-p", dir, "-t", "example.com/core", "-t", "example.com/utils")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "[example.com/core]  Run task -> go test -race ./...") {
+		t.Errorf("expected core to be tested with -race by default, got:\n%s", output)
+	}
+	if !strings.Contains(output, "[example.com/utils] Run task -> go test ./...") {
+		t.Errorf("expected the excluded utils to be tested without -race, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "test", "-p", dir, "--race=false", "-t", "example.com/core")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.Contains(output, "-race") {
+		t.Errorf("expected --race=false to override the default, got:\n%s", output)
+	}
+}
+
+func TestE2E_CoverageReport(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	if output, err := runKnit(t, "coverage", "report", "-p", dir); err == nil {
+		t.Errorf("expected an error without coverage profiles, got:\n%s", output)
+	}
+
+	output, err := runKnit(t, "test", "--cover", "-p", dir, "-t", "example.com/core", "-t", "example.com/app")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "go test -coverprofile=coverage.out ./...") {
+		t.Errorf("expected the tests to write a coverage profile, got:\n%s", output)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	output, err = runKnit(t, "coverage", "report", "-p", dir, "--html", out)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	for _, want := range []string{"example.com/core   100.0%", "example.com/utils  no profile", "all modules"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the report, got:\n%s", want, output)
+		}
+	}
+	for _, page := range []string{"index.html", "workspace.html", "core.html", "app.html", "coverage.out"} {
+		if _, err := os.Stat(filepath.Join(out, page)); err != nil {
+			t.Errorf("expected %s in the HTML report: %v", page, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "utils.html")); err == nil {
+		t.Error("expected no page for utils, which has no profile")
+	}
+	index, err := os.ReadFile(filepath.Join(out, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), `href="core.html"`) || !strings.Contains(string(index), `href="workspace.html"`) {
+		t.Errorf("expected the index to link the module and workspace pages, got:\n%s", index)
+	}
+
+	// The profile of app, not tested by the last run, is left by an older one
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "app", "coverage.out"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runKnit(t, "test", "--cover", "-p", dir, "-t", "example.com/core"); err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	output, err = runKnit(t, "coverage", "report", "-p", dir)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "example.com/core   100.0%\n") || !regexp.MustCompile(`example.com/app +[0-9.]+% \(stale\)`).MatchString(output) {
+		t.Errorf("expected only the profile of app to be stale, got:\n%s", output)
+	}
+	if !strings.Contains(output, "warning: the stale profiles are older than the last 'knit test --cover' run") {
+		t.Errorf("expected a warning about the stale profile, got:\n%s", output)
+	}
+}
+
+func TestE2E_TestJSON(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "core", "broken_test.go"), `package core
+
+import "testing"
+
+func TestBroken(t *testing.T) {
+	t.Error("broken")
+}
+
+func TestLater(t *testing.T) {
+	t.Skip("later")
+}
+`)
+	summary := filepath.Join(t.TempDir(), "summary.md")
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+
+	output, err := runKnit(t, "test", "--json", "-p", dir, "-t", "example.com/core", "-t", "example.com/utils")
+	if err == nil {
+		t.Fatalf("expected the failing test to fail the run, got:\n%s", output)
+	}
+	for _, want := range []string{
+		"go test -json ./...",
+		"broken_test.go:6: broken",
+		"--- FAIL: TestBroken",
+		"Tests: 5 passed, 1 failed, 1 skipped",
+		"Failed tests:\n  example.com/core TestBroken\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, `"Action"`) || strings.Contains(output, "=== RUN   TestVersion") {
+		t.Errorf("expected the events of the passed tests to be left out, got:\n%s", output)
+	}
+
+	data, err := os.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"| Module | Result | Duration | Tests |",
+		"| 2 passed, 1 failed, 1 skipped |",
+		"- `example.com/core.TestBroken`",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in the step summary, got:\n%s", want, data)
+		}
+	}
+}
+
+func TestE2E_FlakyTests(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	// TestFlaky fails every other run, the marker being left untracked
+	writeFile(t, filepath.Join(dir, "core", "flaky_test.go"), `package core
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFlaky(t *testing.T) {
+	if _, err := os.Stat("flaky.marker"); err == nil {
+		os.Remove("flaky.marker")
+		return
+	}
+	os.WriteFile("flaky.marker", nil, 0644)
+	t.Error("flaky")
+}
+`)
+	writeFile(t, filepath.Join(dir, ".gitignore"), ".knit/\n")
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+	// The go command would replay the passing runs from its test cache
+	t.Setenv("GOFLAGS", "-count=1")
+
+	output, err := runKnit(t, "flaky", "-p", dir)
+	if err != nil || !strings.Contains(output, "No flaky tests") {
+		t.Fatalf("expected no flaky tests before any run, got: %v\n%s", err, output)
+	}
+	if output, err := runKnit(t, "test", "--json", "-p", dir, "-t", "example.com/core"); err == nil {
+		t.Fatalf("expected the first run to fail, got:\n%s", output)
+	}
+	if output, err := runKnit(t, "test", "--json", "-p", dir, "-t", "example.com/core"); err != nil {
+		t.Fatalf("expected the second run to pass: %v\n%s", err, output)
+	}
+
+	output, err = runKnit(t, "flaky", "-p", dir)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "example.com/core.TestFlaky  1 of 1 revisions  1 passed, 1 failed") {
+		t.Errorf("expected TestFlaky to be reported, got:\n%s", output)
+	}
+
+	// The third run fails, then passes on retrying the known-flaky test
+	summary := filepath.Join(t.TempDir(), "summary.md")
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+	output, err = runKnit(t, "test", "--retry-flaky", "1", "-p", dir, "-t", "example.com/core")
+	if err != nil {
+		t.Fatalf("expected the retry to pass the module: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "Retrying the flaky tests (attempt 1 of 1)") || !strings.Contains(output, "TestFlaky (flaky)") {
+		t.Errorf("expected the flaky test to be retried and marked, got:\n%s", output)
+	}
+	data, err := os.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "✅ passed on retrying flaky tests") || !strings.Contains(string(data), "- `example.com/core.TestFlaky` (flaky)") {
+		t.Errorf("expected the retry in the step summary, got:\n%s", data)
+	}
+
+	// A test flaky under the race detector is retried with it
+	output, err = runKnit(t, "test", "--race", "--retry-flaky", "1", "-p", dir, "-t", "example.com/core")
+	if err != nil {
+		t.Fatalf("expected the retry with -race to pass the module: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "go test -race -json -count=1 -run '^(TestFlaky)$' .") {
+		t.Errorf("expected the flaky test to be retried with -race, got:\n%s", output)
+	}
+}
+
+func TestE2E_TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "core", "sub", "sub_test.go"), `package sub
+
+import "testing"
+
+func TestSubA(t *testing.T) {}
+
+func TestSubB(t *testing.T) {
+	t.Error("broken")
+}
+
+func TestSubC(t *testing.T) {}
+`)
+
+	// Without recorded durations the 5 tests weigh the same
+	output, err := runKnit(t, "test", "--split", "2", "--split-index", "0", "-p", dir, "-t", "example.com/core")
+	if err != nil {
+		t.Fatalf("expected the first split to pass: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "go test -run '^(TestDefaultConfig)$' . || status=1; go test -run '^(TestSubA|TestSubC)$' ./sub") {
+		t.Errorf("expected the first split to run TestDefaultConfig, TestSubA and TestSubC, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "test", "--split", "2", "--split-index", "1", "-p", dir, "-t", "example.com/core")
+	if err == nil {
+		t.Fatalf("expected the failure of TestSubB to fail the second split, got:\n%s", output)
+	}
+	if !strings.Contains(output, "go test -run '^(TestVersion)$' . || status=1; go test -run '^(TestSubB)$' ./sub") {
+		t.Errorf("expected the second split to run TestVersion and TestSubB, got:\n%s", output)
+	}
+
+	if output, err := runKnit(t, "test", "--split", "2", "--split-index", "2", "-p", dir); err == nil {
+		t.Errorf("expected an error for an index out of range, got:\n%s", output)
+	}
+}
+
+func TestE2E_BenchGate(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "core", "bench_test.go"), `package core
+
+import "testing"
+
+func BenchmarkVersion(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = Version
+	}
+}
+`)
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+	// The change makes BenchmarkVersion sleep on every iteration
+	writeFile(t, filepath.Join(dir, "core", "bench_test.go"), `package core
+
+import (
+	"testing"
+	"time"
+)
+
+func BenchmarkVersion(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkNew(b *testing.B) {}
+`)
+
+	args := []string{"bench", "-p", dir, "--base", "HEAD", "--benchtime", "20x", "--count", "1"}
+	output, err := runKnit(t, args...)
+	if err != nil {
+		t.Fatalf("expected no failure without --gate: %v\n%s", err, output)
+	}
+	for _, want := range []string{"MODULE            BENCHMARK", "example.com/core  BenchmarkNew", "new"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+
+	output, err = runKnit(t, append(args, "--gate", "5%")...)
+	if err == nil {
+		t.Fatalf("expected the regression to fail the gate, got:\n%s", output)
+	}
+	if !strings.Contains(output, "✗") || !strings.Contains(output, "1 benchmark(s) regressed by more than 5%") {
+		t.Errorf("expected BenchmarkVersion to be reported as a regression, got:\n%s", output)
+	}
+	if output, err := runKnit(t, append(args, "--gate", "fast")...); err == nil || !strings.Contains(output, "invalid --gate") {
+		t.Errorf("expected an invalid gate to be rejected, got:\n%s", output)
+	}
+}
+
+func TestE2E_TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	out := filepath.Join(dir, "out")
+	// Packages whose names could map to the same profile as another
+	for _, sub := range []string{"core", "a/b", "a_b"} {
+		writeFile(t, filepath.Join(dir, "core", sub, "sub_test.go"), "package sub\n\nimport \"testing\"\n\nfunc TestSub(t *testing.T) {}\n")
+	}
+
+	output, err := runKnit(t, "test", "-p", dir, "-t", "example.com/core", "--cpuprofile-dir", out, "--memprofile-dir", out)
+	if err != nil {
+		t.Fatalf("knit test --cpuprofile-dir failed: %v\n%s", err, output)
+	}
+	for _, file := range []string{"_root.cpu.pprof", "_root.mem.pprof", "core.cpu.pprof", "a%2Fb.cpu.pprof", "a_b.cpu.pprof"} {
+		if _, err := os.Stat(filepath.Join(out, "core", file)); err != nil {
+			t.Errorf("expected the profile %s of core: %v", file, err)
+		}
+	}
+	for _, want := range []string{"Top cpu consumers of example.com/core", "Top mem consumers of example.com/core"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+
+	if output, err := runKnit(t, "test", "-p", dir, "--cpuprofile-dir", out, "--cover"); err == nil || !strings.Contains(output, "cannot be combined") {
+		t.Errorf("expected --cpuprofile-dir to be rejected with --cover, got:\n%s", output)
+	}
+}
+
+func TestE2E_Unused(t *testing.T) {
+	output, err := runKnit(t, "unused", "-p", workspaceDir)
+	if err != nil {
+		t.Fatalf("knit unused failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{"example.com/utils\n  func utils.StringSliceContains  utils/utils.go:", "func api.ConfigResponse", "3 unused exported identifier(s)"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+	// core is used by every other module
+	if strings.Contains(output, "core.") {
+		t.Errorf("expected nothing of core to be unused, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "unused", "-p", workspaceDir, "--json", "-t", "example.com/utils")
+	if err != nil {
+		t.Fatalf("knit unused --json failed: %v\n%s", err, output)
+	}
+	var identifiers []struct{ Module, Name string }
+	if err := json.Unmarshal([]byte(output), &identifiers); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, output)
+	}
+	if len(identifiers) != 1 || identifiers[0].Name != "StringSliceContains" {
+		t.Errorf("expected StringSliceContains only, got %+v", identifiers)
+	}
+}
+
+func TestE2E_CI(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "tasks:\n  lint:\n    run: echo linting {{.ShortName}}\nci:\n  tasks: [vet, lint, test]\n")
+	cleanup := setupGitRepo(t, dir, []string{"utils/utils.go"})
+	defer cleanup()
+
+	// utils changed, api and app depend on it, core is left out
+	output, err := runKnit(t, "ci", "-p", dir, "--base", "HEAD")
+	if err != nil {
+		t.Fatalf("ci failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{"1 affected module(s), 3 with their dependents", "Running lint on 3 module(s)", "[example.com/app]   linting app", "CI report, 3 module(s):\n  ✓ vet\n  ✓ lint\n  ✓ test\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "[example.com/core]") {
+		t.Errorf("expected core not to run, got:\n%s", output)
+	}
+
+	writeFile(t, filepath.Join(dir, "api", "fail_test.go"), "package api\n\nimport \"testing\"\n\nfunc TestFail(t *testing.T) { t.Fatal(\"boom\") }\n")
+	output, err = runKnit(t, "ci", "-p", dir, "--base", "HEAD", "--task", "test", "--task", "build", "--fail-fast")
+	if err == nil {
+		t.Fatalf("expected the failing test to fail ci, got:\n%s", output)
+	}
+	for _, want := range []string{"  ✗ test: 1 module(s) failed: example.com/api\n", "  - build: skipped\n", "1 of 2 task(s) failed"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+
+	if output, err := runKnit(t, "ci", "-p", dir, "--task", "deploy"); err == nil || !strings.Contains(output, `unknown task "deploy"`) {
+		t.Errorf("expected an unknown task to be rejected, got:\n%s", output)
+	}
+}
+
+func TestE2E_CIGenerate(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "tasks:\n  lint:\n    run: echo linting\nci:\n  tasks: [fmt, lint, test]\n")
+
+	output, err := runKnit(t, "ci", "generate", "-p", dir, "--provider", "github")
+	if err != nil {
+		t.Fatalf("ci generate failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{
+		"          knit affected $MERGE_BASE --base \"$BASE\" --include-dependents --github-output\n",
+		"any: ${{ steps.affected.outputs.any_affected }}",
+		"      - name: \"fmt\"\n        run: knit ci --all --task fmt -t ${{ matrix.module.path }}\n",
+		"      - name: \"test\"\n        if: ${{ !cancelled() }}\n        run: knit ci --all --task test -t ${{ matrix.module.path }}\n",
+		"go-version-file: go.work",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the workflow, got:\n%s", want, output)
+		}
+	}
+
+	output, err = runKnit(t, "ci", "generate", "-p", dir, "--provider", "gitlab", "-o", ".gitlab-ci.yml")
+	if err != nil || !strings.Contains(output, "Wrote .gitlab-ci.yml") {
+		t.Fatalf("ci generate -o failed: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".gitlab-ci.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"image: golang:1.22.4", "--job-command 'knit ci --all --task fmt --task lint --task test -t {{.Path}}' >> child.yml", "      - artifact: child.yml\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in the pipeline, got:\n%s", want, data)
+		}
+	}
+	if output, err := runKnit(t, "ci", "generate", "-p", dir, "--provider", "gitlab", "-o", ".gitlab-ci.yml", "--check"); err != nil {
+		t.Errorf("expected the pipeline to be up to date: %v\n%s", err, output)
+	}
+
+	// The first push of a branch has a zero CI_COMMIT_BEFORE_SHA, every
+	// module gets a job then
+	var pipeline struct {
+		Affected struct {
+			Script []string `yaml:"script"`
+		} `yaml:"affected"`
+	}
+	if err := yaml.Unmarshal(data, &pipeline); err != nil || len(pipeline.Affected.Script) < 2 {
+		t.Fatalf("failed to parse the pipeline: %v\n%s", err, data)
+	}
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+	// The first line installs knit, which the tests already built
+	script := strings.Join(pipeline.Affected.Script[1:], "\n")
+	if output, err := runShell(t, dir, script, "CI_COMMIT_BEFORE_SHA=0000000000000000000000000000000000000000"); err != nil {
+		t.Fatalf("affected job failed: %v\n%s", err, output)
+	}
+	child, _ := os.ReadFile(filepath.Join(dir, "child.yml"))
+	if !strings.Contains(string(child), `"test:example.com/core"`) || !strings.Contains(string(child), `"test:example.com/app"`) {
+		t.Errorf("expected a job per module on the first push, got:\n%s", child)
+	}
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "ci:\n  tasks: [vet]\n")
+	if output, err := runKnit(t, "ci", "generate", "-p", dir, "--provider", "gitlab", "-o", ".gitlab-ci.yml", "--check"); err == nil || !strings.Contains(output, ".gitlab-ci.yml is out of date") {
+		t.Errorf("expected the changed tasks to fail the check, got:\n%s", output)
+	}
+	if output, err := runKnit(t, "ci", "generate", "-p", dir, "--provider", "jenkins"); err == nil || !strings.Contains(output, `unknown provider "jenkins"`) {
+		t.Errorf("expected an unknown provider to be rejected, got:\n%s", output)
+	}
+
+	// The jobs of a module run the tasks on it only
+	output, err = runKnit(t, "ci", "-p", dir, "--all", "--task", "vet", "-t", "example.com/api")
+	if err != nil || !strings.Contains(output, "Running vet on 1 module(s)") || strings.Contains(output, "[example.com/core]") {
+		t.Errorf("expected vet to run on api only, got: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_AffectedGitHubOutput(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	cleanup := setupGitRepo(t, dir, []string{"api/api.go"})
+	defer cleanup()
+	outputFile := filepath.Join(t.TempDir(), "github_output")
+	t.Setenv("GITHUB_OUTPUT", outputFile)
+
+	output, err := runKnit(t, "affected", "-p", dir, "--base", "HEAD", "--include-dependents", "--github-output")
+	if err != nil || output != "example.com/api\nexample.com/app\n" {
+		t.Fatalf("expected the usual output too, got: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `modules=["example.com/api","example.com/app"]
+any_affected=true
+matrix={"module":[{"path":"example.com/api","dir":"api","name":"api"},{"path":"example.com/app","dir":"app","name":"app"}]}
+`
+	if string(data) != want {
+		t.Errorf("unexpected GITHUB_OUTPUT:\n%s\nwant:\n%s", data, want)
+	}
+
+	// Outputs are appended, as GitHub Actions expects
+	if output, err := runKnit(t, "affected", "-p", dir, "--base", "HEAD", "--github-output", "--exclude", "example.com/*"); err != nil {
+		t.Fatalf("affected failed: %v\n%s", err, output)
+	}
+	data, err = os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "\nmodules=[]\nany_affected=false\nmatrix={\"module\":[]}\n") {
+		t.Errorf("expected empty outputs appended, got:\n%s", data)
+	}
+
+	t.Setenv("GITHUB_OUTPUT", "")
+	if output, err := runKnit(t, "affected", "-p", dir, "--base", "HEAD", "--github-output"); err == nil || !strings.Contains(output, "--github-output requires GITHUB_OUTPUT") {
+		t.Errorf("expected --github-output to require GITHUB_OUTPUT, got:\n%s", output)
+	}
+}
+
+func TestE2E_OutputSchemas(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	cleanup := setupGitRepo(t, dir, []string{"api/api.go"})
+	defer cleanup()
+
+	output, err := runKnit(t, "version", "--json")
+	if err != nil {
+		t.Fatalf("version failed: %v\n%s", err, output)
+	}
+	var info struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal([]byte(output), &info); err != nil {
+		t.Fatalf("invalid version JSON: %v\n%s", err, output)
+	}
+
+	// Every versioned output carries the schema version of knit version
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	outputs := map[string][]string{
+		"affected": {"affected", "-p", dir, "--base", "HEAD", "-f", "json"},
+		"graph":    {"graph", "-p", dir, "-f", "json"},
+		"report":   {"test", "-p", dir, "-t", "example.com/core", "--report", reportFile},
+	}
+	for name, args := range outputs {
+		output, err := runKnit(t, args...)
+		if err != nil {
+			t.Fatalf("%s failed: %v\n%s", name, err, output)
+		}
+		if name == "report" {
+			data, err := os.ReadFile(reportFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output = string(data)
+		}
+		var got struct {
+			SchemaVersion int `json:"schemaVersion"`
+		}
+		if err := json.Unmarshal([]byte(output), &got); err != nil {
+			t.Fatalf("invalid %s JSON: %v\n%s", name, err, output)
+		}
+		if got.SchemaVersion != info.SchemaVersion {
+			t.Errorf("expected schemaVersion %d in %s, got:\n%s", info.SchemaVersion, name, output)
+		}
+	}
+
+	output, err = runKnit(t, "affected", "-p", dir, "--base", "HEAD", "-f", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var affected struct {
+		Modules []struct {
+			Path   string `json:"path"`
+			RelDir string `json:"relDir"`
+		} `json:"modules"`
+	}
+	if err := json.Unmarshal([]byte(output), &affected); err != nil || len(affected.Modules) != 1 ||
+		affected.Modules[0].Path != "example.com/api" || affected.Modules[0].RelDir != "api" {
+		t.Errorf("expected example.com/api in api affected, got:\n%s", output)
+	}
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"task": "test"`) || !strings.Contains(string(data), `"path": "example.com/core"`) {
+		t.Errorf("expected the test run of example.com/core in the report, got:\n%s", data)
+	}
+
+	for _, args := range [][]string{
+		{"affected", "-f", "json", "--schema"},
+		{"affected", "-f", "github-matrix", "--schema"},
+		{"graph", "--schema"},
+		{"test", "--schema"},
+	} {
+		output, err := runKnit(t, args...)
+		if err != nil {
+			t.Fatalf("%v failed: %v\n%s", args, err, output)
+		}
+		var schema struct {
+			Schema string `json:"$schema"`
+		}
+		if err := json.Unmarshal([]byte(output), &schema); err != nil || schema.Schema == "" {
+			t.Errorf("expected a JSON schema from %v, got:\n%s", args, output)
+		}
+	}
+	if output, err := runKnit(t, "affected", "--schema"); err == nil || !strings.Contains(output, "--schema requires -f json or -f github-matrix") {
+		t.Errorf("expected --schema to require a JSON format, got:\n%s", output)
+	}
+}
+
+func TestE2E_Logs(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	os.RemoveAll(filepath.Join(dir, ".knit"))
+
+	if output, err := runKnit(t, "logs", "-p", dir); err == nil || !strings.Contains(output, "no previous run found") {
+		t.Errorf("expected no previous run, got:\n%s", output)
+	}
+
+	writeFile(t, filepath.Join(dir, "api", "fail_test.go"), "package api\n\nimport \"testing\"\n\nfunc TestFail(t *testing.T) { t.Fatal(\"broken on purpose\") }\n")
+	if output, err := runKnit(t, "test", "-p", dir); err == nil {
+		t.Fatalf("expected the test of example.com/api to fail:\n%s", output)
+	}
+
+	output, err := runKnit(t, "logs", "-p", dir, "--failed")
+	if err != nil {
+		t.Fatalf("logs --failed failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{"[example.com/api] --- FAIL: TestFail", "broken on purpose", "[example.com/api] ✗ Failed (exit 1)"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the logs, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "example.com/core") {
+		t.Errorf("expected only the failed modules, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "logs", "-p", dir, "core")
+	if err != nil || !strings.Contains(output, "[example.com/core] ✓ Done") || strings.Contains(output, "example.com/api") {
+		t.Errorf("expected the logs of example.com/core only, got: %v\n%s", err, output)
+	}
+
+	// A new run of the task replaces the logs of the previous one
+	if output, err := runKnit(t, "test", "-p", dir, "-t", "example.com/core"); err != nil {
+		t.Fatalf("test failed: %v\n%s", err, output)
+	}
+	output, err = runKnit(t, "logs", "-p", dir, "--task", "test")
+	if err != nil || !strings.Contains(output, "example.com/core") || strings.Contains(output, "example.com/api") {
+		t.Errorf("expected the logs of the last run only, got: %v\n%s", err, output)
+	}
+	if output, err := runKnit(t, "logs", "-p", dir, "--task", "lint"); err == nil || !strings.Contains(output, "no logs of a previous lint run") {
+		t.Errorf("expected no logs of lint, got:\n%s", output)
+	}
+
+	// Blank lines of the output are logged too
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "tasks:\n  banner:\n    run: printf 'start\\n\\nend\\n'\n")
+	if output, err := runKnit(t, "run", "-p", dir, "-t", "example.com/core", "banner"); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, output)
+	}
+	output, err = runKnit(t, "logs", "-p", dir, "--task", "banner")
+	if err != nil || !strings.Contains(output, "[example.com/core] start\n[example.com/core] \n[example.com/core] end\n") {
+		t.Errorf("expected the blank line in the logs, got: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_KeepOutput(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	os.RemoveAll(filepath.Join(dir, ".knit"))
+	kept := t.TempDir()
+
+	output, err := runKnit(t, "test", "-p", dir, "-t", "example.com/core", "-t", "example.com/utils", "--cover", "--keep-output", kept)
+	if err != nil {
+		t.Fatalf("test --keep-output failed: %v\n%s", err, output)
+	}
+	runs, err := filepath.Glob(filepath.Join(kept, "test-*"))
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("expected a directory of the run in %s, got %v", kept, runs)
+	}
+	if !strings.Contains(output, "Kept the output of the run in "+runs[0]) {
+		t.Errorf("expected the directory of the run in the output, got:\n%s", output)
+	}
+	for _, rel := range []string{
+		"report.json",
+		"history.json",
+		filepath.Join("logs", "example.com", "core.log"),
+		filepath.Join("logs", "example.com", "utils.log"),
+		filepath.Join("coverage", "example.com", "core", "coverage.out"),
+	} {
+		if _, err := os.Stat(filepath.Join(runs[0], rel)); err != nil {
+			t.Errorf("expected %s to be kept: %v", rel, err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(runs[0], "logs", "example.com", "core.log"))
+	if err != nil || !strings.Contains(string(data), "✓ Done") {
+		t.Errorf("expected the log of example.com/core, got: %v\n%s", err, data)
+	}
+	if _, err := os.Stat(filepath.Join(runs[0], "logs", "example.com", "api.log")); !os.IsNotExist(err) {
+		t.Errorf("expected only the modules of the run to be kept: %v", err)
+	}
+}
+
+func TestE2E_Timestamps(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	prefixes := map[string]*regexp.Regexp{
+		"relative": regexp.MustCompile(`^\d+\.\d \[example\.com/core\] `),
+		"clock":    regexp.MustCompile(`^\d{2}:\d{2}:\d{2} \[example\.com/core\] `),
+		"iso":      regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}(Z|[+-]\d{2}:\d{2}) \[example\.com/core\] `),
+		"none":     regexp.MustCompile(`^\[example\.com/core\] `),
+	}
+	for format, prefix := range prefixes {
+		output, err := runKnit(t, "test", "-p", dir, "-t", "example.com/core", "--timestamps", format)
+		if err != nil {
+			t.Fatalf("test --timestamps %s failed: %v\n%s", format, err, output)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			if !prefix.MatchString(line) {
+				t.Errorf("expected the lines of --timestamps %s to match %s, got %q", format, prefix, line)
+			}
+		}
+	}
+
+	if output, err := runKnit(t, "test", "-p", dir, "--timestamps", "unix"); err == nil || !strings.Contains(output, `unknown timestamp format "unix"`) {
+		t.Errorf("expected an unknown format to fail, got:\n%s", output)
+	}
+}
+
+func TestE2E_PrefixWidth(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	// The output of the modules starts at the column of the longest one
+	output, err := runKnit(t, "test", "-p", dir, "--timestamps", "none")
+	if err != nil {
+		t.Fatalf("test failed: %v\n%s", err, output)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if !strings.HasPrefix(line, "[example.com/") || len(line) < 21 || line[19] != ' ' || line[20] == ' ' {
+			t.Errorf("expected the output to start at column 20, got %q", line)
+		}
+	}
+	if !strings.Contains(output, "[example.com/api]   ✓ Done") {
+		t.Errorf("expected example.com/api padded to example.com/utils, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "test", "-p", dir, "--timestamps", "none", "--prefix-width", "8")
+	if err != nil {
+		t.Fatalf("test --prefix-width failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{"[…com/api] ✓ Done", "[…m/utils] ✓ Done"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q, the end of the module kept, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestE2E_ExclusiveModule(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	log := filepath.Join(t.TempDir(), "runs.log")
+	writeFile(t, filepath.Join(dir, "knit.yaml"), fmt.Sprintf(`modules:
+  example.com/core:
+    exclusive: true
+tasks:
+  slow:
+    run: echo start {{.ShortName}} >> %[1]s && sleep 0.2 && echo end {{.ShortName}} >> %[1]s
+`, log))
+
+	if output, err := runKnit(t, "run", "-p", dir, "slow"); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	start := slices.Index(lines, "start core")
+	if len(lines) != 8 || start < 0 || start+1 >= len(lines) || lines[start+1] != "end core" {
+		t.Errorf("expected example.com/core to run alone, got %q", lines)
+	}
+}
+
+func TestE2E_Query(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	tags := "modules:\n  example.com/core:\n    tags: [shared]\n  example.com/utils:\n    tags: [shared]\n"
+	if err := os.WriteFile(filepath.Join(dir, "knit.yaml"), []byte(tags), 0644); err != nil {
+		t.Fatalf("failed to write knit.yaml: %v", err)
+	}
+	cleanup := setupGitRepo(t, dir, []string{"utils/utils.go"})
+	defer cleanup()
+
+	output, err := runKnit(t, "query", "-p", dir, "rdeps(example.com/core) & changed(HEAD)")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/utils\n" {
+		t.Errorf("expected only utils, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "query", "-p", dir, "-f", "rel-dirs", "all() - tag(shared)")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "api\napp\n" {
+		t.Errorf("expected api and app, got:\n%s", output)
+	}
+
+	if output, err := runKnit(t, "query", "-p", dir, "deps(example.com/app"); err == nil {
+		t.Errorf("expected an invalid query to fail, got:\n%s", output)
+	}
+
+	// Run commands only keep the modules selected by --query
+	output, err = runKnit(t, "test", "-p", dir, "--query", "deps(example.com/api, 1) - example.com/core")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "[example.com/api]") || !strings.Contains(output, "[example.com/utils]") {
+		t.Errorf("expected api and utils to be tested, got:\n%s", output)
+	}
+	for _, mod := range []string{"[example.com/core]", "[example.com/app]"} {
+		if strings.Contains(output, mod) {
+			t.Errorf("unexpected %s in output:\n%s", mod, output)
+		}
+	}
+}
+
+func TestE2E_GraphMermaid(t *testing.T) {
+	output, err := runKnit(t, "graph", "-p", workspaceDir, "-f", "mermaid")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.HasPrefix(output, "graph TD\n") {
+		t.Errorf("expected a graph TD header, got:\n%s", output)
+	}
+	// Modules are numbered in workspace order: core, utils, api, app
+	for _, want := range []string{`m0["core"]`, `m3["app"]`, "m1 --> m0", "m3 --> m2"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestE2E_GraphReverse(t *testing.T) {
+	output, err := runKnit(t, "graph", "-p", workspaceDir, "--reverse", "-f", "template",
+		"--template", `{{range .Modules}}{{.Name}}:{{range .Dependencies}} {{.}}{{end}}\n{{end}}`)
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	for _, want := range []string{"utils: example.com/api\n", "app:\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+
+	output, err = runKnit(t, "graph", "-p", workspaceDir, "--reverse")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "Module Dependents Graph") || !strings.Contains(output, "(no workspace dependents)") {
+		t.Errorf("expected a dependents tree, got:\n%s", output)
+	}
+}
+
+func TestE2E_GraphExternal(t *testing.T) {
+	// The knit module itself requires external modules
+	output, err := runKnit(t, "graph", "-p", "..", "--external", "-f", "json")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, `"path": "github.com/urfave/cli/v2"`) || !strings.Contains(output, `"external": true`) {
+		t.Errorf("expected urfave/cli as an external node, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "graph", "-p", "..", "--collapse-external")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "── github.com/urfave/...\n") {
+		t.Errorf("expected external modules grouped by organization, got:\n%s", output)
+	}
+	if strings.Contains(output, "@v") {
+		t.Errorf("unexpected version in collapsed output:\n%s", output)
+	}
+
+	// Without --external only workspace modules are shown
+	output, err = runKnit(t, "graph", "-p", workspaceDir, "-f", "json")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.Contains(output, `"external"`) {
+		t.Errorf("unexpected external node in output:\n%s", output)
+	}
+}
+
+func TestE2E_GraphHTML(t *testing.T) {
+	output, err := runKnit(t, "graph", "-p", workspaceDir, "-f", "html")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.HasPrefix(output, "<!DOCTYPE html>") {
+		t.Errorf("expected an HTML page, got:\n%s", output)
+	}
+	for _, want := range []string{`{"id":"example.com/core","label":"core"}`, `{"source":"example.com/app","target":"example.com/api"}`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %s in the embedded graph, got:\n%s", want, output)
+		}
+	}
+	// The page is self-contained
+	if strings.Contains(output, "<script src=") || strings.Contains(output, "<link ") {
+		t.Errorf("unexpected external resource in output:\n%s", output)
+	}
+}
+
+func TestE2E_GraphHighlightAffected(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	cleanup := setupGitRepo(t, dir, []string{"utils/utils.go"})
+	defer cleanup()
+
+	output, err := runKnit(t, "graph", "-p", dir, "--highlight-affected", "--base", "HEAD", "-f", "dot")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	for _, want := range []string{
+		`"example.com/utils" [label="utils", style="rounded,filled", color="#dd3333"`,
+		`"example.com/api" [label="api", style="rounded,filled", color="#e8890c"`,
+		`"example.com/app" [label="app", style="rounded,filled", color="#e8890c"`,
+		`"example.com/core" [label="core"];`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %s in output, got:\n%s", want, output)
+		}
+	}
+
+	output, err = runKnit(t, "graph", "-p", dir, "--highlight-affected", "--base", "HEAD")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "📦 example.com/utils (changed)") || !strings.Contains(output, "📦 example.com/app (impacted)") {
+		t.Errorf("expected changed and impacted markers in the tree, got:\n%s", output)
+	}
+}
+
+func TestE2E_GraphTopo(t *testing.T) {
+	output, err := runKnit(t, "graph", "-p", workspaceDir, "-f", "topo")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/core\nexample.com/utils\nexample.com/api\nexample.com/app\n" {
+		t.Errorf("expected build order core, utils, api, app, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "graph", "-p", workspaceDir, "-f", "topo-levels")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	var levels [][]string
+	if err := json.Unmarshal([]byte(output), &levels); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, output)
+	}
+	if len(levels) != 4 || levels[0][0] != "example.com/core" || levels[3][0] != "example.com/app" {
+		t.Errorf("unexpected levels: %v", levels)
+	}
+}
+
+func TestE2E_List(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	os.Remove(filepath.Join(dir, "core", "core_test.go"))
+
+	output, err := runKnit(t, "list", "-p", dir, "--json")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	var modules []struct {
+		Path      string `json:"path"`
+		RelDir    string `json:"relDir"`
+		GoVersion string `json:"goVersion"`
+		HasMain   bool   `json:"hasMain"`
+		HasTests  bool   `json:"hasTests"`
+	}
+	if err := json.Unmarshal([]byte(output), &modules); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, output)
+	}
+	if len(modules) != 4 {
+		t.Fatalf("expected 4 modules, got:\n%s", output)
+	}
+	for _, m := range modules {
+		if m.HasMain != (m.Path == "example.com/app") {
+			t.Errorf("unexpected hasMain=%v for %s", m.HasMain, m.Path)
+		}
+		if m.HasTests != (m.Path != "example.com/core") {
+			t.Errorf("unexpected hasTests=%v for %s", m.HasTests, m.Path)
+		}
+		if m.GoVersion == "" || m.RelDir == "" {
+			t.Errorf("expected goVersion and relDir for %s, got %+v", m.Path, m)
+		}
+	}
+
+	output, err = runKnit(t, "list", "-p", dir, "--filter", "example.com/a*", "--has-tests")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "example.com/api") || !strings.Contains(output, "example.com/app") {
+		t.Errorf("expected api and app, got:\n%s", output)
+	}
+	if strings.Contains(output, "example.com/core") || strings.Contains(output, "example.com/utils") {
+		t.Errorf("unexpected module in filtered output:\n%s", output)
+	}
+
+	output, err = runKnit(t, "list", "-p", dir, "--has-tests", "--json")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.Contains(output, `"example.com/core"`) {
+		t.Errorf("unexpected core without tests in output:\n%s", output)
+	}
+
+	output, err = runKnit(t, "list", "-p", dir, "--mains", "--json")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	var mains []struct {
+		Path  string   `json:"path"`
+		Kind  string   `json:"kind"`
+		Mains []string `json:"mains"`
+	}
+	if err := json.Unmarshal([]byte(output), &mains); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, output)
+	}
+	if len(mains) != 1 || mains[0].Path != "example.com/app" || mains[0].Kind != "binary" ||
+		len(mains[0].Mains) != 1 || mains[0].Mains[0] != "example.com/app" {
+		t.Errorf("expected only app and its main package, got:\n%s", output)
+	}
+	if output, _ := runKnit(t, "list", "-p", dir, "--json"); !strings.Contains(output, `"kind": "library"`) {
+		t.Errorf("expected libraries in the JSON output:\n%s", output)
+	}
+}
+
+func TestE2E_Exclude(t *testing.T) {
+	output, err := runKnit(t, "test", "-p", workspaceDir, "--exclude", "example.com/core", "--exclude", "example.com/a*")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "[example.com/utils]") {
+		t.Errorf("expected utils to be tested, got:\n%s", output)
+	}
+	for _, mod := range []string{"[example.com/core]", "[example.com/api]", "[example.com/app]"} {
+		if strings.Contains(output, mod) {
+			t.Errorf("unexpected %s in output:\n%s", mod, output)
+		}
+	}
+
+	// Excluded modules are dropped even when pulled in by --include-deps
+	output, err = runKnitWithStdin(t, "api/api.go\n", "affected", "-p", workspaceDir, "--files-from", "-", "--include-deps", "--exclude", "example.com/core")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/utils\nexample.com/api\n" {
+		t.Errorf("expected utils and api, got:\n%s", output)
+	}
+}
+
+func TestE2E_TestMultipleAndGlobTargets(t *testing.T) {
+	output, err := runKnit(t, "test", "-p", workspaceDir, "-t", "example.com/core", "-t", "a*")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	// core by path, api and app by directory glob
+	for _, mod := range []string{"[example.com/core]", "[example.com/api]", "[example.com/app]"} {
+		if !strings.Contains(output, mod) {
+			t.Errorf("expected %s in output, got:\n%s", mod, output)
+		}
+	}
+	if strings.Contains(output, "[example.com/utils]") {
+		t.Errorf("unexpected example.com/utils in output:\n%s", output)
+	}
+
+	output, err = runKnit(t, "test", "-p", workspaceDir, "-t", "example.com/...", "--exclude", "./utils/")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if strings.Contains(output, "[example.com/utils]") || !strings.Contains(output, "[example.com/core]") {
+		t.Errorf("expected every module but utils, got:\n%s", output)
+	}
+}
+
+func TestE2E_TargetSuggestions(t *testing.T) {
+	output, err := runKnit(t, "test", "-p", workspaceDir, "-t", "example.com/uitls")
+	if err == nil {
+		t.Fatalf("expected an unknown target to fail, got:\n%s", output)
+	}
+	if !strings.Contains(output, "did you mean example.com/utils?") {
+		t.Errorf("expected a suggestion, got:\n%s", output)
+	}
+
+	// An unambiguous suffix selects the module
+	output, err = runKnit(t, "test", "-p", workspaceDir, "-t", "utils")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "[example.com/utils]") || strings.Contains(output, "[example.com/core]") {
+		t.Errorf("expected only utils to be tested, got:\n%s", output)
+	}
+}
+
+func TestE2E_Owners(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	rules := "* @org/all\n/api/ @org/backend\n/app/ @org/backend @bob\n"
+	os.MkdirAll(filepath.Join(dir, ".github"), 0755)
+	if err := os.WriteFile(filepath.Join(dir, ".github", "CODEOWNERS"), []byte(rules), 0644); err != nil {
+		t.Fatalf("failed to write CODEOWNERS: %v", err)
+	}
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+
+	output, err := runKnit(t, "owners", "-p", dir, "--json")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	var owners map[string][]string
+	if err := json.Unmarshal([]byte(output), &owners); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, output)
+	}
+	if fmt.Sprint(owners["example.com/core"]) != "[@org/all]" || fmt.Sprint(owners["example.com/app"]) != "[@org/backend @bob]" {
+		t.Errorf("unexpected owners: %v", owners)
+	}
+
+	output, err = runKnit(t, "test", "-p", dir, "--owner", "@org/backend")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "[example.com/api]") || !strings.Contains(output, "[example.com/app]") {
+		t.Errorf("expected api and app to be tested, got:\n%s", output)
+	}
+	if strings.Contains(output, "[example.com/core]") || strings.Contains(output, "[example.com/utils]") {
+		t.Errorf("unexpected module not owned by @org/backend in output:\n%s", output)
+	}
+
+	output, err = runKnitWithStdin(t, "core/core.go\napp/main.go\n", "affected", "-p", dir, "--files-from", "-", "--owner", "@BOB")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	if output != "example.com/app\n" {
+		t.Errorf("expected only app, got:\n%s", output)
+	}
+}
+
+func TestE2E_CheckArch(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "knit.yaml"), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write knit.yaml: %v", err)
+		}
+	}
+
+	writeConfig(`modules:
+  example.com/core:
+    tags: [lib]
+  example.com/utils:
+    tags: [lib]
+architecture:
+  - name: libraries only use libraries
+    from: tag(lib)
+    allow: tag(lib)
+  - name: nothing uses app
+    deny: example.com/app
+`)
+	output, err := runKnit(t, "check-arch", "-p", dir)
+	if err != nil {
+		t.Fatalf("expected the rules to pass: %v\noutput: %s", err, output)
+	}
+	if !strings.Contains(output, "2 architecture rule(s) passed") {
+		t.Errorf("expected a success message, got:\n%s", output)
+	}
+
+	writeConfig(`architecture:
+  - name: api is a leaf
+    from: example.com/api
+    deny: example.com/*
+`)
+	output, err = runKnit(t, "check-arch", "-p", dir)
+	if err == nil {
+		t.Fatalf("expected violations, got:\n%s", output)
+	}
+	for _, want := range []string{
+		"✗ api is a leaf",
+		"example.com/api -> example.com/core (",
+		"example.com/api -> example.com/utils (example.com/api imports example.com/utils)",
+		"2 architecture violation(s)",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestE2E_CheckArchBannedImports(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	config := `bannedImports:
+  - import: encoding/...
+    allow: [example.com/app/...]
+    message: only app may encode
+`
+	if err := os.WriteFile(filepath.Join(dir, "knit.yaml"), []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write knit.yaml: %v", err)
+	}
+
+	output, err := runKnit(t, "check-arch", "-p", dir)
+	if err == nil {
+		t.Fatalf("expected violations, got:\n%s", output)
+	}
+	if !strings.Contains(output, "✗ banned import encoding/...: only app may encode") {
+		t.Errorf("expected the rule header, got:\n%s", output)
+	}
+	if !strings.Contains(output, "api/api.go:5: example.com/api imports encoding/json") {
+		t.Errorf("expected the offending file and line, got:\n%s", output)
+	}
+	if strings.Contains(output, "example.com/app imports") {
+		t.Errorf("unexpected violation in an allowed package:\n%s", output)
+	}
+}
+
+func TestE2E_CheckAPI(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+
+	output, err := runKnit(t, "check-api", "-p", dir, "--base", "HEAD", "--all")
+	if err != nil {
+		t.Fatalf("expected an unchanged API to pass: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "✓ example.com/core: compatible") {
+		t.Errorf("expected core to be compatible, got:\n%s", output)
+	}
+
+	// Rename an exported function and add a new one
+	corePath := filepath.Join(dir, "core", "core.go")
+	data, err := os.ReadFile(corePath)
+	if err != nil {
+		t.Fatalf("failed to read core.go: %v", err)
+	}
+	changed := strings.Replace(string(data), "func Version()", "func CurrentVersion()", 1)
+	if err := os.WriteFile(corePath, []byte(changed+"\nfunc Extra() {}\n"), 0644); err != nil {
+		t.Fatalf("failed to write core.go: %v", err)
+	}
+
+	output, err = runKnit(t, "check-api", "-p", dir, "--base", "HEAD", "-v")
+	if err == nil {
+		t.Fatalf("expected incompatible changes, got:\n%s", output)
+	}
+	if !strings.Contains(output, "✗ example.com/core: 1 incompatible change(s)") {
+		t.Errorf("expected core to be reported, got:\n%s", output)
+	}
+	if !strings.Contains(output, "Version: removed") {
+		t.Errorf("expected the removed function, got:\n%s", output)
+	}
+	if !strings.Contains(output, "compatible: CurrentVersion: added") {
+		t.Errorf("expected the added function with -v, got:\n%s", output)
+	}
+}
+
+func TestE2E_ReleaseAuto(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+	runGit(t, dir, "tag", "core/v1.0.0")
+
+	commitFile := func(file, message string) {
+		t.Helper()
+		f, err := os.OpenFile(filepath.Join(dir, file), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", file, err)
+		}
+		f.WriteString("\n// " + message + "\n")
+		f.Close()
+		runGit(t, dir, "commit", "-am", message)
+	}
+	commitFile("core/core.go", "feat(core): add options")
+	commitFile("core/core.go", "docs: explain options")
+	commitFile("utils/utils.go", "fix: trim input")
+
+	output, err := runKnit(t, "release", "-p", dir, "--auto")
+	if err != nil {
+		t.Fatalf("release failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "example.com/core: core/v1.0.0 -> core/v1.1.0 (minor, 2 commit(s))") {
+		t.Errorf("expected a minor bump of core, got:\n%s", output)
+	}
+	if !strings.Contains(output, "example.com/utils: (none) -> utils/v0.0.1 (patch, 2 commit(s))") {
+		t.Errorf("expected a first patch release of utils, got:\n%s", output)
+	}
+	if strings.Contains(output, "example.com/api") {
+		t.Errorf("expected api without releasable commits to be left out, got:\n%s", output)
+	}
+
+	output, err = runKnit(t, "release", "-p", dir, "--auto", "--create")
+	if err != nil {
+		t.Fatalf("release --create failed: %v\n%s", err, output)
+	}
+	tags := gitOutput(t, dir, "tag", "--list")
+	if !strings.Contains(tags, "core/v1.1.0") || !strings.Contains(tags, "utils/v0.0.1") {
+		t.Errorf("expected the new tags to be created, got:\n%s", tags)
+	}
+
+	output, err = runKnit(t, "release", "-p", dir, "--auto")
+	if err != nil || !strings.Contains(output, "No module needs a release") {
+		t.Errorf("expected nothing left to release, got: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_Licenses(t *testing.T) {
+	// The knit module itself requires external modules
+	output, err := runKnit(t, "licenses", "-p", "..", "--json")
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	var groups []struct {
+		License string `json:"license"`
+		Modules []struct {
+			Path string `json:"path"`
+		} `json:"modules"`
+	}
+	if err := json.Unmarshal([]byte(output), &groups); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, output)
+	}
+	found := false
+	for _, g := range groups {
+		for _, m := range g.Modules {
+			if m.Path == "github.com/urfave/cli/v2" {
+				found = g.License == "MIT"
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected urfave/cli under MIT, got:\n%s", output)
+	}
+
+	// A workspace without third-party dependencies
+	output, err = runKnit(t, "licenses", "-p", workspaceDir)
+	if err != nil || !strings.Contains(output, "No third-party modules found") {
+		t.Errorf("expected no third-party modules, got: %v\n%s", err, output)
+	}
+}
+
+// writeFile writes content to a file, creating its parent directories
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestE2E_Align(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "core", "go.mod"), "module example.com/core\n\ngo 1.22.4\n\nrequire github.com/urfave/cli/v2 v2.25.0\n")
+	writeFile(t, filepath.Join(dir, "utils", "go.mod"), "module example.com/utils\n\ngo 1.22.4\n\nrequire github.com/urfave/cli/v2 v2.27.2\n")
+	writeFile(t, filepath.Join(dir, "api", "go.mod"), "module example.com/api\n\ngo 1.22.4\n\nrequire github.com/urfave/cli/v2 v2.25.0\n")
+
+	output, err := runKnit(t, "align", "-p", dir, "--dry-run")
+	if err != nil {
+		t.Fatalf("align --dry-run failed: %v\n%s", err, output)
+	}
+	want := "github.com/urfave/cli/v2 -> v2.27.2\n    example.com/api (v2.25.0)\n    example.com/core (v2.25.0)\n"
+	if output != want {
+		t.Errorf("unexpected plan:\n%s\nwant:\n%s", output, want)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "core", "go.mod"))
+	if !strings.Contains(string(data), "v2.25.0") {
+		t.Errorf("expected --dry-run to leave go.mod untouched, got:\n%s", data)
+	}
+
+	output, err = runKnit(t, "align", "-p", dir, "--no-tidy")
+	if err != nil {
+		t.Fatalf("align failed: %v\n%s", err, output)
+	}
+	for _, module := range []string{"core", "api"} {
+		data, _ := os.ReadFile(filepath.Join(dir, module, "go.mod"))
+		if !strings.Contains(string(data), "require github.com/urfave/cli/v2 v2.27.2") {
+			t.Errorf("expected %s to require v2.27.2, got:\n%s", module, data)
+		}
+	}
+
+	// An explicit version applies to every module requiring the dependency
+	output, err = runKnit(t, "align", "-p", dir, "--no-tidy", "--dry-run", "--version", "v2.26.0", "github.com/urfave/cli/v2")
+	if err != nil || strings.Count(output, "(v2.27.2)") != 3 {
+		t.Errorf("expected the 3 modules to move to v2.26.0, got: %v\n%s", err, output)
+	}
+
+	output, err = runKnit(t, "align", "-p", dir, "github.com/unknown/dep")
+	if err == nil || !strings.Contains(output, "no workspace module requires github.com/unknown/dep") {
+		t.Errorf("expected an unknown dependency error, got:\n%s", output)
+	}
+}
+
+func TestE2E_Upgrade(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	dep := t.TempDir()
+	writeFile(t, filepath.Join(dep, "go.mod"), "module example.com/dep\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(dep, "dep.go"), "package dep\n\nconst V = 1\n")
+	for _, module := range []string{"core", "utils"} {
+		writeFile(t, filepath.Join(dir, module, "go.mod"), fmt.Sprintf("module example.com/%s\n\ngo 1.22.4\n\nrequire example.com/dep v1.0.0\n\nreplace example.com/dep => %s\n", module, dep))
+		writeFile(t, filepath.Join(dir, module, "dep.go"), fmt.Sprintf("package %s\n\nimport _ \"example.com/dep\"\n", module))
+	}
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "modules:\n  example.com/core:\n    tags: [backend]\n")
+
+	output, err := runKnit(t, "upgrade", "-p", dir, "--tag", "backend", "example.com/dep@v1.2.0")
+	if err != nil {
+		t.Fatalf("knit upgrade --tag failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "✓ example.com/core: example.com/dep v1.0.0 -> v1.2.0\n1 of 1 module(s) changed") {
+		t.Errorf("expected core only to be upgraded, got:\n%s", output)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "utils", "go.mod"))
+	if !strings.Contains(string(data), "example.com/dep v1.0.0") {
+		t.Errorf("expected utils to be left out by --tag, got:\n%s", data)
+	}
+
+	// go mod tidy does not see go.work, utils importing core needs --no-tidy
+	output, err = runKnit(t, "upgrade", "-p", dir, "--no-tidy", "example.com/dep@v1.2.0")
+	if err != nil {
+		t.Fatalf("knit upgrade failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{"- example.com/core: unchanged", "✓ example.com/utils: example.com/dep v1.0.0 -> v1.2.0", "1 of 2 module(s) changed"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+
+	if output, err := runKnit(t, "upgrade", "-p", dir, "example.com/unknown@v1.0.0"); err == nil || !strings.Contains(output, "no workspace module requires example.com/unknown") {
+		t.Errorf("expected an unknown dependency error, got:\n%s", output)
+	}
+}
+
+// writeProxyModule adds a version of a module to a GOPROXY directory,
+// served with GOPROXY=file://dir
+func writeProxyModule(t *testing.T, proxy, path, version string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(proxy, path, "@v")
+	writeFile(t, filepath.Join(dir, version+".info"), fmt.Sprintf(`{"Version":%q,"Time":"2024-01-01T00:00:00Z"}`, version))
+	writeFile(t, filepath.Join(dir, version+".mod"), files["go.mod"])
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(path + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, version+".zip"), buf.String())
+	list, _ := os.ReadFile(filepath.Join(dir, "list"))
+	writeFile(t, filepath.Join(dir, "list"), string(list)+version+"\n")
+}
+
+func TestE2E_UpgradeAllVerify(t *testing.T) {
+	proxy := t.TempDir()
+	for _, version := range []string{"v1.0.0", "v1.2.0"} {
+		writeProxyModule(t, proxy, "example.com/dep", version, map[string]string{
+			"go.mod": "module example.com/dep\n\ngo 1.22.4\n",
+			"dep.go": fmt.Sprintf("package dep\n\nconst Version = %q\n", version),
+		})
+	}
+	t.Setenv("GOPROXY", "file://"+filepath.ToSlash(proxy))
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOFLAGS", "")
+
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "core", "go.mod"), "module example.com/core\n\ngo 1.22.4\n\nrequire example.com/dep v1.0.0\n")
+	writeFile(t, filepath.Join(dir, "core", "dep.go"), "package core\n\nimport \"example.com/dep\"\n\nconst DepVersion = dep.Version\n")
+	// The new version of the dependency breaks a test of core
+	writeFile(t, filepath.Join(dir, "core", "dep_test.go"), "package core\n\nimport \"testing\"\n\nfunc TestDepVersion(t *testing.T) {\n\tif DepVersion != \"v1.0.0\" {\n\t\tt.Fatal(DepVersion)\n\t}\n}\n")
+
+	report := filepath.Join(t.TempDir(), "pr.md")
+	output, err := runKnit(t, "upgrade", "-p", dir, "--all", "--verify", "--report", report)
+	if err == nil {
+		t.Fatalf("expected the broken test to fail the verification, got:\n%s", output)
+	}
+	for _, want := range []string{"✓ example.com/core: example.com/dep v1.0.0 -> v1.2.0", "Verifying the upgrades: test of 4 module(s)", "the verification of the upgrades failed"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatalf("expected a report: %v\n%s", err, output)
+	}
+	for _, want := range []string{
+		"| `example.com/core` | `example.com/dep` | v1.0.0 | v1.2.0 |",
+		"Built and tested the 4 module(s) impacted by the upgrades.",
+		"| Module | Build | Test |",
+		"| `example.com/core` | ✅ | ❌ |",
+		"| `example.com/utils` | ✅ | ✅ |",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in the report, got:\n%s", want, data)
+		}
+	}
+
+	output, err = runKnit(t, "upgrade", "-p", dir, "--all")
+	if err != nil || !strings.Contains(output, "Dependencies are up to date") {
+		t.Errorf("expected nothing left to upgrade: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_Vendor(t *testing.T) {
+	proxy := t.TempDir()
+	writeProxyModule(t, proxy, "example.com/dep", "v1.0.0", map[string]string{
+		"go.mod": "module example.com/dep\n\ngo 1.22.4\n",
+		"dep.go": "package dep\n\nconst Version = \"v1.0.0\"\n",
+	})
+	t.Setenv("GOPROXY", "file://"+filepath.ToSlash(proxy))
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOFLAGS", "")
+
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "core", "go.mod"), "module example.com/core\n\ngo 1.22.4\n\nrequire example.com/dep v1.0.0\n")
+	writeFile(t, filepath.Join(dir, "core", "dep.go"), "package core\n\nimport \"example.com/dep\"\n\nconst DepVersion = dep.Version\n")
+	// Outside of workspace mode go mod download writes core/go.sum
+	cmd := exec.Command("go", "mod", "download", "example.com/dep")
+	cmd.Dir = filepath.Join(dir, "core")
+	cmd.Env = append(os.Environ(), "GOWORK=off")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go mod download failed: %v\n%s", err, output)
+	}
+
+	output, err := runKnit(t, "vendor", "-p", dir, "--check")
+	if err == nil || !strings.Contains(output, "✗ vendor is out of date\n    + vendor/example.com/dep/dep.go\n") {
+		t.Errorf("expected the missing vendor directory to fail the check, got: %v\n%s", err, output)
+	}
+
+	output, err = runKnit(t, "vendor", "-p", dir)
+	if err != nil || !strings.Contains(output, "✓ Vendored vendor") {
+		t.Fatalf("knit vendor failed: %v\n%s", err, output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vendor", "example.com", "dep", "dep.go")); err != nil {
+		t.Fatalf("expected go work vendor to vendor example.com/dep: %v", err)
+	}
+	output, err = runKnit(t, "vendor", "-p", dir, "--check")
+	if err != nil || !strings.Contains(output, "✓ vendor is up to date") {
+		t.Errorf("expected the vendor directory to be up to date: %v\n%s", err, output)
+	}
+
+	writeFile(t, filepath.Join(dir, "vendor", "example.com", "dep", "dep.go"), "package dep\n\nconst Version = \"patched\"\n")
+	writeFile(t, filepath.Join(dir, "vendor", "example.com", "dep", "extra.go"), "package dep\n")
+	output, err = runKnit(t, "vendor", "-p", dir, "--check")
+	if err == nil {
+		t.Fatalf("expected an edited vendor directory to fail the check, got:\n%s", output)
+	}
+	for _, want := range []string{"    ~ vendor/example.com/dep/dep.go\n", "    - vendor/example.com/dep/extra.go\n", "1 vendor directory(ies) out of date, run 'knit vendor'"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+
+	if output, err := runKnit(t, "vendor", "-p", dir, "-t", "example.com/core"); err == nil || !strings.Contains(output, "--target requires --per-module") {
+		t.Errorf("expected --target to require --per-module, got:\n%s", output)
+	}
+	output, err = runKnit(t, "vendor", "-p", dir, "--per-module", "-t", "example.com/core")
+	if err != nil || !strings.Contains(output, "✓ Vendored core/vendor") {
+		t.Fatalf("knit vendor --per-module failed: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_Clean(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	os.RemoveAll(filepath.Join(dir, ".knit"))
+	writeFile(t, filepath.Join(dir, ".knit", "history.json"), "{}\n")
+	writeFile(t, filepath.Join(dir, ".knit", "cache", "entry"), "cached\n")
+	writeFile(t, filepath.Join(dir, "core", "coverage.out"), "mode: set\n")
+	writeFile(t, filepath.Join(dir, "dist", "app"), "binary\n")
+	removed := []string{filepath.Join(".knit", "cache"), filepath.Join("core", "coverage.out"), "dist"}
+
+	output, err := runKnit(t, "clean", "-p", dir, "--dir", "dist", "--dry-run")
+	if err != nil {
+		t.Fatalf("clean --dry-run failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{".knit/cache", "core/coverage.out", "dist", "Would reclaim 24 B"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+	for _, rel := range removed {
+		if _, err := os.Stat(filepath.Join(dir, rel)); err != nil {
+			t.Errorf("expected --dry-run to keep %s: %v", rel, err)
+		}
+	}
+
+	for _, bad := range []string{"..", ".", "core", "../" + filepath.Base(dir), filepath.Dir(dir)} {
+		if output, err := runKnit(t, "clean", "-p", dir, "--dir", bad); err == nil || !strings.Contains(output, "refusing to remove it") {
+			t.Errorf("expected clean --dir %s to be refused, got: %v\n%s", bad, err, output)
+		}
+	}
+	for _, rel := range append(removed, filepath.Join("core", "go.mod")) {
+		if _, err := os.Stat(filepath.Join(dir, rel)); err != nil {
+			t.Errorf("expected a refused --dir to keep %s: %v", rel, err)
+		}
+	}
+
+	writeFile(t, filepath.Join(dir, "utils", "coverage.out"), "mode: set\n")
+	output, err = runKnit(t, "clean", "-p", dir, "-t", "example.com/utils")
+	if err != nil || !strings.Contains(output, "utils/coverage.out") || !strings.Contains(output, "Reclaimed 10 B") {
+		t.Fatalf("clean -t failed: %v\n%s", err, output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "utils", "coverage.out")); !os.IsNotExist(err) {
+		t.Errorf("expected clean -t to remove utils/coverage.out: %v", err)
+	}
+	for _, rel := range removed {
+		if _, err := os.Stat(filepath.Join(dir, rel)); err != nil {
+			t.Errorf("expected clean -t example.com/utils to keep %s: %v", rel, err)
+		}
+	}
+
+	output, err = runKnit(t, "clean", "-p", dir, "--dir", "dist")
+	if err != nil || !strings.Contains(output, "Reclaimed 24 B") {
+		t.Fatalf("clean failed: %v\n%s", err, output)
+	}
+	for _, rel := range removed {
+		if _, err := os.Stat(filepath.Join(dir, rel)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed: %v", rel, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".knit", "history.json")); err != nil {
+		t.Errorf("expected the history to be kept: %v", err)
+	}
+
+	output, err = runKnit(t, "clean", "-p", dir)
+	if err != nil || output != "Nothing to clean\n" {
+		t.Errorf("expected nothing left to clean, got: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_Download(t *testing.T) {
+	proxy := t.TempDir()
+	writeProxyModule(t, proxy, "example.com/dep", "v1.0.0", map[string]string{
+		"go.mod": "module example.com/dep\n\ngo 1.22.4\n",
+		"dep.go": "package dep\n",
+	})
+	t.Setenv("GOPROXY", "file://"+filepath.ToSlash(proxy))
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOFLAGS", "")
+	modcache := t.TempDir()
+	t.Setenv("GOMODCACHE", modcache)
+	// The module cache is read-only, go clean makes it removable
+	t.Cleanup(func() { exec.Command("go", "clean", "-modcache").Run() })
+
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "core", "go.mod"), "module example.com/core\n\ngo 1.22.4\n\nrequire example.com/dep v1.0.0\n")
+	writeFile(t, filepath.Join(dir, "utils", "go.mod"), "module example.com/utils\n\ngo 1.22.4\n\nrequire example.com/dep v1.0.0\n")
+
+	// Required by two modules, the dependency is downloaded once
+	output, err := runKnit(t, "download", "-p", dir, "--dry-run")
+	if err != nil || output != "example.com/dep@v1.0.0\n1 dependency(ies) of 4 module(s) to download\n" {
+		t.Fatalf("expected a single dependency, got: %v\n%s", err, output)
+	}
+	if _, err := os.Stat(filepath.Join(modcache, "cache", "download", "example.com", "dep", "@v", "v1.0.0.zip")); !os.IsNotExist(err) {
+		t.Fatalf("expected --dry-run not to download: %v", err)
+	}
+
+	output, err = runKnit(t, "download", "-p", dir)
+	if err != nil || !strings.Contains(output, "✓ Downloaded 1 dependency(ies) of 4 module(s)") {
+		t.Fatalf("download failed: %v\n%s", err, output)
+	}
+	if _, err := os.Stat(filepath.Join(modcache, "cache", "download", "example.com", "dep", "@v", "v1.0.0.zip")); err != nil {
+		t.Errorf("expected example.com/dep in the module cache: %v", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "core", "go.mod"), "module example.com/core\n\ngo 1.22.4\n\nrequire example.com/dep v1.0.0\n\nrequire example.com/missing v1.0.0\n")
+	if output, err := runKnit(t, "download", "-p", dir, "-t", "example.com/core"); err == nil || !strings.Contains(output, "go list -m all failed in example.com/core") {
+		t.Errorf("expected an unknown dependency to fail, got:\n%s", output)
+	}
+}
+
+func TestE2E_SyncGo(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	output, err := runKnit(t, "sync-go", "-p", dir, "--check")
+	if err != nil || !strings.Contains(output, "✓ 5 file(s) use go 1.22.4") {
+		t.Fatalf("expected aligned versions, got: %v\n%s", err, output)
+	}
+
+	output, err = runKnit(t, "sync-go", "-p", dir, "--version", "1.23.2", "--toolchain", "go1.23.4")
+	if err != nil {
+		t.Fatalf("sync-go failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "core/go.mod: go 1.22.4 -> 1.23.2") || !strings.Contains(output, "Updated 5 of 5 file(s)") {
+		t.Errorf("unexpected output:\n%s", output)
+	}
+	for _, file := range []string{"go.work", "core/go.mod", "app/go.mod"} {
+		data, _ := os.ReadFile(filepath.Join(dir, file))
+		if !strings.Contains(string(data), "go 1.23.2\n") || !strings.Contains(string(data), "toolchain go1.23.4\n") {
+			t.Errorf("expected %s to be updated, got:\n%s", file, data)
+		}
+	}
+
+	output, err = runKnit(t, "sync-go", "-p", dir, "--check", "--version", "1.23.2", "--toolchain", "go1.23.4")
+	if err != nil {
+		t.Errorf("expected the check to pass after sync: %v\n%s", err, output)
+	}
+
+	// A drifted module fails the check
+	writeFile(t, filepath.Join(dir, "utils", "go.mod"), "module example.com/utils\n\ngo 1.22\n")
+	output, err = runKnit(t, "sync-go", "-p", dir, "--check")
+	if err == nil || !strings.Contains(output, "utils/go.mod: go 1.22\n") {
+		t.Errorf("expected a drift failure, got: %v\n%s", err, output)
+	}
+	output, err = runKnit(t, "sync-go", "-p", dir, "--check", "--version", "1.23.2")
+	if err == nil || !strings.Contains(output, "✗ utils/go.mod: go 1.22, want 1.23.2") {
+		t.Errorf("expected utils to be reported, got: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_CheckMajors(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	output, err := runKnit(t, "check-majors", "-p", dir)
+	if err != nil || !strings.Contains(output, "✓ Every dependency is required at a single major version") {
+		t.Fatalf("expected no drift in the workspace: %v\n%s", err, output)
+	}
+
+	writeFile(t, filepath.Join(dir, "core", "go.mod"), "module example.com/core\n\ngo 1.22.4\n\nrequire github.com/foo/bar v1.2.0\n")
+	writeFile(t, filepath.Join(dir, "api", "go.mod"), "module example.com/api\n\ngo 1.22.4\n\nrequire (\n\tgithub.com/foo/bar/v3 v3.1.0\n\tgopkg.in/yaml.v3 v3.0.1\n)\n")
+	writeFile(t, filepath.Join(dir, "utils", "go.mod"), "module example.com/utils\n\ngo 1.22.4\n\nrequire (\n\tgithub.com/foo/bar/v3 v3.0.1\n\tgopkg.in/yaml.v3 v3.0.1\n)\n")
+	output, err = runKnit(t, "check-majors", "-p", dir)
+	if err == nil {
+		t.Fatalf("expected the drift to fail, got:\n%s", output)
+	}
+	for _, want := range []string{
+		"✗ github.com/foo/bar is required at 2 major versions\n",
+		"    github.com/foo/bar: example.com/core (v1.2.0)\n",
+		"    github.com/foo/bar/v3: example.com/api (v3.1.0), example.com/utils (v3.0.1)\n",
+		"1 dependency(ies) required at different major versions",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "gopkg.in/yaml") {
+		t.Errorf("expected a dependency required at a single major version to be left out, got:\n%s", output)
+	}
+}
+
+func TestE2E_CheckReplace(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "repo")
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(base, "fork", "go.mod"), "module github.com/fork/lib\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(dir, "vendored", "go.mod"), "module github.com/other/lib\n\ngo 1.22.4\n")
+
+	output, err := runKnit(t, "check-replace", "-p", dir)
+	if err != nil || !strings.Contains(output, "✓ No problematic replace directive") {
+		t.Fatalf("expected no problems, got: %v\n%s", err, output)
+	}
+
+	writeFile(t, filepath.Join(dir, "app", "go.mod"), `module example.com/app
+
+go 1.22.4
+
+replace (
+	example.com/core => ../core
+	github.com/fork/lib => ../../fork
+	github.com/missing/lib => ../missing
+	github.com/other/lib => ../vendored
+)
+`)
+	output, err = runKnit(t, "check-replace", "-p", dir)
+	if err == nil {
+		t.Fatalf("expected problems, got:\n%s", output)
+	}
+	for _, want := range []string{
+		"app/go.mod: replace example.com/core => ../core: example.com/core is a workspace module",
+		"replace github.com/fork/lib => ../../fork: ../../fork is outside the workspace",
+		"replace github.com/missing/lib => ../missing: ../missing does not exist",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "github.com/other/lib") {
+		t.Errorf("unexpected problem with a replacement inside the workspace:\n%s", output)
+	}
+
+	output, err = runKnit(t, "check-replace", "-p", dir, "--fix")
+	if err != nil || !strings.Contains(output, "Removed 3 replace directive(s)") {
+		t.Fatalf("expected the fix to succeed, got: %v\n%s", err, output)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "app", "go.mod"))
+	if !strings.Contains(string(data), "replace github.com/other/lib => ../vendored") || strings.Contains(string(data), "example.com/core") {
+		t.Errorf("unexpected go.mod after --fix:\n%s", data)
+	}
+}
+
+func TestE2E_WorkSync(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	output, err := runKnit(t, "work", "sync", "-p", dir, "--check")
+	if err != nil || !strings.Contains(output, "✓ go.work uses all 4 module(s)") {
+		t.Fatalf("expected go.work to be up to date, got: %v\n%s", err, output)
+	}
+
+	// A new module and a removed one
+	writeFile(t, filepath.Join(dir, "services", "billing", "go.mod"), "module example.com/billing\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(dir, "services", "billing", "billing.go"), "package billing\n")
+	writeFile(t, filepath.Join(dir, "_archive", "go.mod"), "module example.com/archive\n\ngo 1.22.4\n")
+	os.RemoveAll(filepath.Join(dir, "app"))
+
+	output, err = runKnit(t, "work", "sync", "-p", dir, "--check")
+	if err == nil || !strings.Contains(output, "+ ./services/billing\n- ./app (no go.mod)\n") {
+		t.Errorf("expected the check to fail, got: %v\n%s", err, output)
+	}
+	if strings.Contains(output, "_archive") {
+		t.Errorf("unexpected module in an ignored directory:\n%s", output)
+	}
+
+	output, err = runKnit(t, "work", "sync", "-p", dir)
+	if err != nil || !strings.Contains(output, "Updated go.work: 1 added, 1 dropped") {
+		t.Fatalf("expected go.work to be updated, got: %v\n%s", err, output)
+	}
+	output, err = runKnit(t, "list", "-p", dir)
+	if err != nil || !strings.Contains(output, "example.com/billing") || strings.Contains(output, "example.com/app") {
+		t.Errorf("expected the synced workspace to be usable, got: %v\n%s", err, output)
+	}
+
+	// go.work is created when missing
+	os.Remove(filepath.Join(dir, "go.work"))
+	output, err = runKnit(t, "work", "sync", "-p", dir)
+	if err != nil || !strings.Contains(output, "Updated go.work: 4 added, 0 dropped") {
+		t.Errorf("expected go.work to be created, got: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_Init(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	os.Remove(filepath.Join(dir, "go.work"))
+
+	output, err := runKnit(t, "init", "-p", dir, "--github-actions")
+	if err != nil {
+		t.Fatalf("init failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "Wrote knit.yaml") || !strings.Contains(output, "Wrote .github/workflows/knit.yml") {
+		t.Errorf("unexpected output:\n%s", output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.work")); err != nil {
+		t.Errorf("expected go.work to be created: %v", err)
+	}
+	workflow, _ := os.ReadFile(filepath.Join(dir, ".github", "workflows", "knit.yml"))
+	if !strings.Contains(string(workflow), "-f github-matrix") {
+		t.Errorf("expected the workflow to use the GitHub matrix, got:\n%s", workflow)
+	}
+
+	// The first push of a branch reports a zero SHA as its previous commit,
+	// every module is affected then
+	_, step, _ := strings.Cut(string(workflow), "- id: affected\n")
+	_, step, _ = strings.Cut(step, "run: |\n")
+	step, _, _ = strings.Cut(step, "\n\n")
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+	outputFile := filepath.Join(t.TempDir(), "github_output")
+	output, err = runShell(t, dir, strings.ReplaceAll(step, "\n          ", "\n"), "BASE=0000000000000000000000000000000000000000", "GITHUB_OUTPUT="+outputFile)
+	if err != nil {
+		t.Fatalf("affected step failed: %v\n%s", err, output)
+	}
+	if data, _ := os.ReadFile(outputFile); !strings.Contains(string(data), "example.com/app") || !strings.Contains(string(data), "any=true") {
+		t.Errorf("expected every module affected on the first push, got:\n%s", data)
+	}
+
+	// The starter configuration tags modules by kind
+	output, err = runKnit(t, "query", "-p", dir, "tag(service)")
+	if err != nil || strings.TrimSpace(output) != "example.com/app" {
+		t.Errorf("expected app to be tagged service, got: %v\n%s", err, output)
+	}
+
+	// Existing files are kept
+	os.WriteFile(filepath.Join(dir, "knit.yaml"), []byte("modules: {}\n"), 0644)
+	output, err = runKnit(t, "init", "-p", dir)
+	if err != nil || !strings.Contains(output, "knit.yaml already exists, kept") {
+		t.Errorf("expected knit.yaml to be kept, got: %v\n%s", err, output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "knit.yaml")); string(data) != "modules: {}\n" {
+		t.Errorf("knit.yaml was overwritten:\n%s", data)
+	}
+}
+
+func TestE2E_New(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	output, err := runKnit(t, "new", "-p", dir, "--template", "service", "services/billing")
+	if err != nil || !strings.Contains(output, "Created example.com/services/billing in services/billing") {
+		t.Fatalf("new failed: %v\n%s", err, output)
+	}
+	goMod, _ := os.ReadFile(filepath.Join(dir, "services", "billing", "go.mod"))
+	if string(goMod) != "module example.com/services/billing\n\ngo 1.22.4\n" {
+		t.Errorf("unexpected go.mod:\n%s", goMod)
+	}
+
+	output, err = runKnit(t, "new", "-p", dir, "--module", "example.com/lib/money-utils", "lib/money")
+	if err != nil {
+		t.Fatalf("new failed: %v\n%s", err, output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "lib", "money", "moneyutils_test.go")); err != nil {
+		t.Errorf("expected the library starter test: %v", err)
+	}
+
+	// The new modules are in the workspace, tagged, and build
+	output, err = runKnit(t, "query", "-p", dir, "tag(service) | tag(library)")
+	if err != nil || output != "example.com/lib/money-utils\nexample.com/services/billing\n" {
+		t.Errorf("expected the new modules to be tagged, got: %v\n%s", err, output)
+	}
+	output, err = runKnit(t, "test", "-p", dir, "-t", "example.com/lib/money-utils")
+	if err != nil {
+		t.Errorf("expected the library to pass its tests: %v\n%s", err, output)
+	}
+
+	output, err = runKnit(t, "new", "-p", dir, "services/billing")
+	if err == nil || !strings.Contains(output, "services/billing already holds a module") {
+		t.Errorf("expected an existing module error, got:\n%s", output)
+	}
+}
+
+func TestE2E_Doctor(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+
+	output, err := runKnit(t, "doctor", "-p", dir, "--base", "HEAD")
+	if err != nil {
+		t.Fatalf("expected every check to pass: %v\n%s", err, output)
+	}
+	for _, want := range []string{"✓ git: git version", "✓ go: go", "✓ go.work: uses all 4 module(s)", "✓ dependency graph: no cycles between 4 module(s)", "✓ orphan modules", "✓ base branch: HEAD exists"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in:\n%s", want, output)
+		}
+	}
+
+	// A module cycle without package cycle, a module missing from go.work and a missing branch
+	writeFile(t, filepath.Join(dir, "core", "extra", "extra.go"), "package extra\n\nimport \"example.com/utils\"\n\nvar _ = utils.GetVersionInfo\n")
+	writeFile(t, filepath.Join(dir, "tools", "go.mod"), "module example.com/tools\n\ngo 1.22.4\n")
+	output, err = runKnit(t, "doctor", "-p", dir, "--base", "origin/release")
+	if err == nil {
+		t.Fatalf("expected failures, got:\n%s", output)
+	}
+	for _, want := range []string{
+		"✗ go.work: 1 module(s) missing (./tools)",
+		"    fix: run 'knit work sync'",
+		"✗ dependency graph: 1 cycle(s): example.com/core <-> example.com/utils",
+		"✗ base branch: origin/release does not exist",
+		"    fix: fetch it with 'git fetch origin release'",
+		"3 of 6 check(s) failed",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in:\n%s", want, output)
+		}
+	}
+}
+
+func TestE2E_DoctorOrphans(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "lonely", "go.mod"), "module example.com/lonely\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(dir, "lonely", "lonely.go"), "package lonely\n\nfunc Lonely() {}\n")
+	writeFile(t, filepath.Join(dir, "go.work"), "go 1.22.4\n\nuse (\n\t./api\n\t./app\n\t./core\n\t./lonely\n\t./utils\n)\n")
+	cleanup := setupGitRepo(t, dir, nil)
+	defer cleanup()
+
+	output, err := runKnit(t, "doctor", "-p", dir, "--base", "HEAD")
+	if err != nil {
+		t.Fatalf("expected an orphan module to only warn: %v\n%s", err, output)
+	}
+	// app has a main package, the other modules are dependencies
+	if !strings.Contains(output, "! orphan modules: 1 library module(s) no module depends on: example.com/lonely\n") {
+		t.Errorf("expected example.com/lonely to be an orphan, got:\n%s", output)
+	}
+}
+func TestE2E_Version(t *testing.T) {
+	output, err := runKnit(t, "version", "--json")
+	if err != nil {
+		t.Fatalf("version failed: %v\n%s", err, output)
+	}
+	var info struct {
+		Version       string              `json:"version"`
+		Commit        string              `json:"commit"`
+		GoVersion     string              `json:"goVersion"`
+		SchemaVersion int                 `json:"schemaVersion"`
+		Formats       map[string][]string `json:"formats"`
+	}
+	if err := json.Unmarshal([]byte(output), &info); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, output)
+	}
+	if info.Version == "" || info.Commit == "" || !strings.HasPrefix(info.GoVersion, "go") {
+		t.Errorf("expected build metadata, got %+v", info)
+	}
+	if info.SchemaVersion != 1 {
+		t.Errorf("expected schema version 1, got %d", info.SchemaVersion)
+	}
+	if !strings.Contains(strings.Join(info.Formats["affected"], ","), "github-matrix") {
+		t.Errorf("expected affected formats, got %v", info.Formats)
+	}
+
+	output, err = runKnit(t, "version")
+	if err != nil || !strings.HasPrefix(output, "knit ") || !strings.Contains(output, "schema:   1\n") {
+		t.Errorf("unexpected output: %v\n%s", err, output)
+	}
+}
+
+// releaseServer serves a fake GitHub release of tag holding a knit archive
+// for this platform whose binary is script
+func releaseServer(t *testing.T, tag, script string) *httptest.Server {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "knit", Mode: 0755, Size: int64(len(script)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(script))
+	tw.Close()
+	gz.Close()
+	archive := buf.Bytes()
+	name := fmt.Sprintf("knit_%s_%s_%s.tar.gz", strings.TrimPrefix(tag, "v"), runtime.GOOS, runtime.GOARCH)
+	sum := sha256.Sum256(archive)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases/latest":
+			fmt.Fprintf(w, `{"tag_name":%q,"assets":[{"name":%q,"browser_download_url":"%s/dl/archive"},{"name":"checksums.txt","browser_download_url":"%s/dl/checksums.txt"}]}`,
+				tag, name, server.URL, server.URL)
+		case "/dl/archive":
+			w.Write(archive)
+		case "/dl/checksums.txt":
+			fmt.Fprintf(w, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestE2E_SelfUpdate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake release binary is a shell script")
+	}
+	server := releaseServer(t, "v9.0.0", "#!/bin/sh\necho knit v9.0.0\n")
+
+	// Update a copy, not the binary shared by the other tests
+	exe := filepath.Join(t.TempDir(), "knit")
+	data, err := os.ReadFile(binaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(exe, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "KNIT_RELEASES_URL="+server.URL+"/releases")
+
+	cmd := exec.Command(exe, "self-update", "--check")
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "knit v9.0.0 is available") {
+		t.Errorf("expected --check to report the update: %v\n%s", err, output)
+	}
+
+	cmd = exec.Command(exe, "self-update")
+	cmd.Env = env
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("self-update failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "-> v9.0.0") {
+		t.Errorf("expected the update to be reported, got:\n%s", output)
+	}
+
+	output, err = exec.Command(exe).CombinedOutput()
+	if err != nil || string(output) != "knit v9.0.0\n" {
+		t.Errorf("expected the binary to be replaced: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_SelfUpdateMirrorWithoutToken(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+		auth  []string
+	)
+	server := releaseServer(t, "v9.0.0", "#!/bin/sh\n")
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		if a := r.Header.Get("Authorization"); a != "" {
+			auth = append(auth, a)
+		}
+		mu.Unlock()
+		http.Redirect(w, r, server.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer mirror.Close()
+
+	cmd := exec.Command(binaryPath, "self-update", "--check")
+	cmd.Env = append(os.Environ(), "KNIT_RELEASES_URL="+mirror.URL+"/releases", "GITHUB_TOKEN=secret")
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "knit v9.0.0 is available") {
+		t.Errorf("expected --check to report the update from the mirror: %v\n%s", err, output)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) == 0 {
+		t.Fatal("expected the mirror to be queried")
+	}
+	if len(auth) > 0 {
+		t.Errorf("expected GITHUB_TOKEN not to be sent to the mirror, got Authorization %q", auth)
+	}
+}
+
+func TestE2E_SelfUpdateChecksumMismatch(t *testing.T) {
+	server := releaseServer(t, "v9.0.0", "#!/bin/sh\n")
+	tampered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dl/checksums.txt" {
+			fmt.Fprintf(w, "%064d  knit_9.0.0_%s_%s.tar.gz\n", 0, runtime.GOOS, runtime.GOARCH)
+			return
+		}
+		resp, err := http.Get(server.URL + r.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		w.Write(bytes.ReplaceAll(body, []byte(server.URL), []byte("http://"+r.Host)))
+	}))
+	defer tampered.Close()
+
+	exe := filepath.Join(t.TempDir(), "knit")
+	data, err := os.ReadFile(binaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(exe, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "self-update")
+	cmd.Env = append(os.Environ(), "KNIT_RELEASES_URL="+tampered.URL+"/releases")
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch: %v\n%s", err, output)
+	}
+	if after, _ := os.ReadFile(exe); !bytes.Equal(after, data) {
+		t.Error("expected the binary to be kept on a checksum mismatch")
+	}
+}
+
+func TestE2E_Daemon(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsAddr := listener.Addr().String()
+	listener.Close()
+
+	daemon := exec.Command(binaryPath, "daemon", "--metrics", metricsAddr, "-p", dir)
+	if err := daemon.Start(); err != nil {
+		t.Fatalf("failed to start the daemon: %v", err)
+	}
+	defer func() {
+		daemon.Process.Kill()
+		daemon.Wait()
+	}()
+
+	// waitFor polls the daemon status until ok accepts it
+	waitFor := func(what string, ok func(status map[string]any) bool) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			output, err := runKnit(t, "daemon", "--status", "--json", "-p", dir)
+			var status map[string]any
+			if err == nil && json.Unmarshal([]byte(output), &status) == nil && ok(status) {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	waitFor("the daemon", func(map[string]any) bool { return true })
+
+	appDeps := func() []string {
+		t.Helper()
+		output, err := runKnit(t, "graph", "-f", "json", "-p", dir)
+		if err != nil {
+			t.Fatalf("graph failed: %v\n%s", err, output)
+		}
+		var graph struct {
+			Modules []struct {
+				Path         string   `json:"path"`
+				Dependencies []string `json:"dependencies"`
+			} `json:"modules"`
+		}
+		if err := json.Unmarshal([]byte(output), &graph); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, output)
+		}
+		for _, m := range graph.Modules {
+			if m.Path == "example.com/app" {
+				return m.Dependencies
+			}
+		}
+		t.Fatalf("app missing from the graph:\n%s", output)
+		return nil
+	}
+
+	if deps := strings.Join(appDeps(), ","); deps != "example.com/api,example.com/core" {
+		t.Errorf("unexpected dependencies of app: %s", deps)
+	}
+	waitFor("the query to be served", func(s map[string]any) bool { return s["queries"].(float64) >= 1 })
+
+	// A new import is picked up without restarting the daemon
+	writeFile(t, filepath.Join(dir, "app", "extra.go"), "package main\n\nimport \"example.com/utils\"\n\nvar _ = utils.GetVersionInfo\n")
+	waitFor("the refresh", func(s map[string]any) bool { return s["refreshes"].(float64) >= 1 })
+	if deps := strings.Join(appDeps(), ","); deps != "example.com/api,example.com/core,example.com/utils" {
+		t.Errorf("expected the daemon to see the new import, got %s", deps)
+	}
+
+	changed := filepath.Join(t.TempDir(), "changed.txt")
+	writeFile(t, changed, "core/core.go\n")
+	output, err := runKnit(t, "affected", "--files-from", changed, "-p", dir)
+	if err != nil || output != "example.com/core\n" {
+		t.Errorf("unexpected affected output: %v\n%s", err, output)
+	}
+
+	// The commands report their runs and affected sets to the metrics
+	if output, err := runKnit(t, "fmt", "-p", dir); err != nil {
+		t.Fatalf("fmt failed: %v\n%s", err, output)
+	}
+	resp, err := http.Get("http://" + metricsAddr + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to get the metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`knit_task_runs_total{task="fmt",result="success"} 4`,
+		`knit_task_duration_seconds_count{task="fmt"} 4`,
+		`knit_affected_modules_count 1`,
+		`knit_affected_modules_sum 1`,
+		`knit_workspace_modules 4`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected %q in the metrics:\n%s", want, body)
+		}
+	}
+
+	output, err = runKnit(t, "daemon", "--stop", "-p", dir)
+	if err != nil || !strings.Contains(output, "Stopped the daemon") {
+		t.Fatalf("stop failed: %v\n%s", err, output)
+	}
+	if err := daemon.Wait(); err != nil {
+		t.Errorf("daemon exited with %v", err)
+	}
+	if output, err := runKnit(t, "daemon", "--status", "-p", dir); err == nil {
+		t.Errorf("expected no daemon after --stop, got:\n%s", output)
+	}
+}
+
+func TestE2E_Serve(t *testing.T) {
+	requests := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"knit.modules"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"knit.affected","params":{"files":["utils/utils.go"]}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"knit.dependents","params":{"module":"example.com/utils"}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"knit.run","params":{"command":"echo hello","modules":["example.com/core"],"token":"t1"}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"knit.dependencies"}`,
+		`{"jsonrpc":"2.0","id":6,"method":"knit.unknown"}`,
+	}, "\n") + "\n"
+	output, err := runKnitWithStdin(t, requests, "serve", "-p", workspaceDir)
+	if err != nil {
+		t.Fatalf("serve failed: %v\n%s", err, output)
+	}
+
+	type message struct {
+		ID     *int            `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	responses := make(map[int]message)
+	var notifications []message
+	d := json.NewDecoder(strings.NewReader(output))
+	for d.More() {
+		var m message
+		if err := d.Decode(&m); err != nil {
+			t.Fatalf("invalid output: %v\n%s", err, output)
+		}
+		if m.ID == nil {
+			notifications = append(notifications, m)
+		} else {
+			responses[*m.ID] = m
+		}
+	}
+
+	modulePaths := func(id int) string {
+		t.Helper()
+		var modules []struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(responses[id].Result, &modules); err != nil {
+			t.Fatalf("response %d: %v\n%s", id, err, output)
+		}
+		paths := make([]string, len(modules))
+		for i, m := range modules {
+			paths[i] = m.Path
+		}
+		return strings.Join(paths, ",")
+	}
+	if got := modulePaths(1); got != "example.com/core,example.com/utils,example.com/api,example.com/app" {
+		t.Errorf("unexpected modules: %s", got)
+	}
+	if got := modulePaths(2); got != "example.com/utils" {
+		t.Errorf("unexpected affected modules: %s", got)
+	}
+	if got := modulePaths(3); got != "example.com/api,example.com/app" {
+		t.Errorf("unexpected dependents: %s", got)
+	}
+	if !strings.Contains(string(responses[4].Result), `"exitCode":0`) {
+		t.Errorf("unexpected run result: %s", responses[4].Result)
+	}
+	if !strings.Contains(string(responses[5].Result), `"example.com/app":["example.com/api","example.com/core"]`) {
+		t.Errorf("unexpected dependencies: %s", responses[5].Result)
+	}
+	if responses[6].Error == nil || responses[6].Error.Code != -32601 {
+		t.Errorf("expected method not found, got %+v", responses[6])
+	}
+
+	var sawOutput, sawDone bool
+	for _, n := range notifications {
+		params := string(n.Params)
+		switch n.Method {
+		case "knit.output":
+			sawOutput = strings.Contains(params, `"line":"hello"`) && strings.Contains(params, `"token":"t1"`)
+		case "knit.taskDone":
+			sawDone = strings.Contains(params, `"module":"example.com/core"`)
+		}
+	}
+	if !sawOutput || !sawDone {
+		t.Errorf("expected output and taskDone notifications, got:\n%s", output)
+	}
+}
+
+func TestE2E_ServeListen(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	serve := exec.Command(binaryPath, "serve", "--listen", addr, "-p", dir)
+	if err := serve.Start(); err != nil {
+		t.Fatalf("failed to start knit serve: %v", err)
+	}
+	defer func() {
+		serve.Process.Kill()
+		serve.Wait()
+	}()
+
+	tokenFile := filepath.Join(dir, ".knit", "serve.token")
+	var token []byte
+	for i := 0; i < 100; i++ {
+		if token, err = os.ReadFile(tokenFile); err == nil && len(token) > 0 {
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				conn.Close()
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(token) == 0 {
+		t.Fatalf("timed out waiting for knit serve: %v", err)
+	}
+	if info, err := os.Stat(tokenFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected a token readable by its user only: %v", err)
+	}
+
+	// exchange sends lines on a new connection and returns the responses
+	// until the server closes it
+	exchange := func(lines ...string) []map[string]any {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		conn.(*net.TCPConn).CloseWrite()
+		var messages []map[string]any
+		d := json.NewDecoder(conn)
+		for {
+			var m map[string]any
+			if err := d.Decode(&m); err != nil {
+				return messages
+			}
+			messages = append(messages, m)
+		}
+	}
+	run := `{"jsonrpc":"2.0","id":2,"method":"knit.run","params":{"command":"touch pwned","modules":["example.com/core"]}}`
+
+	// A web page can POST a JSON body to loopback: the request line ends it
+	if messages := exchange("POST / HTTP/1.1", "Host: "+addr, "Content-Type: text/plain", "", run); len(messages) != 1 {
+		t.Errorf("expected a single parse error for an HTTP request, got %v", messages)
+	}
+	if messages := exchange(run); len(messages) != 1 || messages[0]["error"] == nil {
+		t.Errorf("expected an unauthenticated knit.run to be refused, got %v", messages)
+	}
+	if messages := exchange(`{"jsonrpc":"2.0","id":1,"method":"rpc.authenticate","params":{"token":"guess"}}`, run); len(messages) != 1 || messages[0]["error"] == nil {
+		t.Errorf("expected a wrong token to be refused, got %v", messages)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "core", "pwned")); !os.IsNotExist(err) {
+		t.Errorf("expected no command to run without the token: %v", err)
+	}
+
+	auth := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"rpc.authenticate","params":{"token":%q}}`, strings.TrimSpace(string(token)))
+	messages := exchange(auth, `{"jsonrpc":"2.0","id":2,"method":"knit.modules"}`)
+	if len(messages) != 2 || messages[0]["result"] != true || messages[1]["result"] == nil {
+		t.Errorf("expected an authenticated connection to be served, got %v", messages)
+	}
+}
+
+func TestE2E_Plugin(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	cleanup := setupGitRepo(t, dir, []string{"utils/utils.go"})
+	defer cleanup()
+
+	bin := t.TempDir()
+	writeFile(t, filepath.Join(bin, "knit-hello"), `#!/bin/sh
+echo "args: $*"
+echo "workspace: $KNIT_WORKSPACE"
+"$KNIT_BIN" version >/dev/null && echo "callback: ok"
+cat "$KNIT_CONTEXT_FILE"
+exit 4
+`)
+	if err := os.Chmod(filepath.Join(bin, "knit-hello"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(binaryPath, "hello", "--flag", "value")
+	cmd.Dir = filepath.Join(dir, "api")
+	cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "KNIT_BASE=HEAD")
+	out, err := cmd.CombinedOutput()
+	output := string(out)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 4 {
+		t.Fatalf("expected the plugin exit code 4, got %v\n%s", err, output)
+	}
+
+	realDir, _ := filepath.EvalSymlinks(dir)
+	for _, want := range []string{"args: --flag value\n", "callback: ok\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output:\n%s", want, output)
+		}
+	}
+	if !strings.Contains(output, "workspace: "+dir+"\n") && !strings.Contains(output, "workspace: "+realDir+"\n") {
+		t.Errorf("expected the workspace root in the environment:\n%s", output)
+	}
+
+	var pc struct {
+		Modules []struct {
+			Path string `json:"path"`
+		} `json:"modules"`
+		Affected []struct {
+			Path string `json:"path"`
+		} `json:"affected"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(output[strings.Index(output, "{"):]), &pc); err != nil {
+		t.Fatalf("invalid context: %v\n%s", err, output)
+	}
+	if len(pc.Modules) != 4 || len(pc.Affected) != 1 || pc.Affected[0].Path != "example.com/utils" || len(pc.Errors) != 0 {
+		t.Errorf("unexpected context: %+v", pc)
+	}
+
+	output, err = runKnit(t, "no-such-plugin")
+	if err == nil || !strings.Contains(output, "no knit-no-such-plugin in PATH") {
+		t.Errorf("expected an unknown command error: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_TaskHooks(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	log := filepath.Join(dir, "hooks.log")
+	readLog := func() string {
+		t.Helper()
+		data, _ := os.ReadFile(log)
+		os.Remove(log)
+		return string(data)
+	}
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  test:
+    before:
+      - run: echo before >> hooks.log
+      - run: echo "module $(basename "$PWD")" >> ../hooks.log
+        scope: module
+    after:
+      - run: echo after >> hooks.log
+      - run: exit 1
+        scope: module
+        onFailure: ignore
+`)
+	output, err := runKnit(t, "test", "-p", dir, "-t", "example.com/core", "-t", "example.com/utils")
+	if err != nil {
+		t.Fatalf("test failed: %v\n%s", err, output)
+	}
+	got := readLog()
+	if !strings.HasPrefix(got, "before\n") || !strings.HasSuffix(got, "after\n") ||
+		!strings.Contains(got, "module core\n") || !strings.Contains(got, "module utils\n") {
+		t.Errorf("unexpected hook order:\n%s", got)
+	}
+	if !strings.Contains(output, "[test:before]") || !strings.Contains(output, "go test ./... (with module hooks)") {
+		t.Errorf("expected the hooks in the output:\n%s", output)
+	}
+
+	// A failing before hook skips every module but still runs the after hooks
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  test:
+    before:
+      - run: exit 2
+    after:
+      - run: echo after >> hooks.log
+`)
+	output, err = runKnit(t, "test", "-p", dir, "-t", "example.com/core")
+	if err == nil || !strings.Contains(output, "a before hook of test failed") {
+		t.Errorf("expected the before hook to fail the run: %v\n%s", err, output)
+	}
+	if strings.Contains(output, "[example.com/core]") || readLog() != "after\n" {
+		t.Errorf("expected only the after hook to run:\n%s", output)
+	}
+
+	// A failing module before hook skips that module's command
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  test:
+    before:
+      - run: test "$(basename "$PWD")" != core
+        scope: module
+`)
+	output, err = runKnit(t, "test", "-p", dir, "-t", "example.com/core", "-t", "example.com/utils")
+	if err == nil || !strings.Contains(output, "1 of 2 modules failed") {
+		t.Errorf("expected core to fail: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_AfterHooksOnInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts are signals")
+	}
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	marks := t.TempDir()
+	writeFile(t, filepath.Join(dir, "knit.yaml"), fmt.Sprintf(`tasks:
+  slow:
+    run: touch %[1]s/started && exec sleep 10
+    after:
+      - run: sleep 0.2 && echo teardown > %[1]s/after.log
+`, marks))
+
+	cmd := exec.Command(binaryPath, "run", "-p", dir, "-t", "example.com/core", "slow")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start knit: %v", err)
+	}
+	defer cmd.Process.Kill()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(filepath.Join(marks, "started")); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cmd.Process.Signal(os.Interrupt)
+	cmd.Wait()
+
+	data, err := os.ReadFile(filepath.Join(marks, "after.log"))
+	if err != nil || string(data) != "teardown\n" {
+		t.Errorf("expected the after hook to run once interrupted: %v, %q", err, data)
+	}
+}
+
+func TestE2E_ConfigEnv(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `env:
+  KNIT_E2E_GREETING: hello ${KNIT_E2E_NAME}
+modules:
+  example.com/core:
+    env:
+      KNIT_E2E_MODULE: core of ${KNIT_E2E_GREETING}
+tasks:
+  test:
+    before:
+      - run: echo "run $KNIT_E2E_GREETING" >> env.log
+    after:
+      - run: echo "module $KNIT_E2E_MODULE" >> ../env.log
+        scope: module
+`)
+	t.Setenv("KNIT_E2E_NAME", "world")
+	output, err := runKnit(t, "test", "-p", dir, "-t", "example.com/core", "-t", "example.com/utils")
+	if err != nil {
+		t.Fatalf("test failed: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "env.log"))
+	if err != nil {
+		t.Fatalf("failed to read env.log: %v", err)
+	}
+	got := string(data)
+	if !strings.HasPrefix(got, "run hello world\n") ||
+		!strings.Contains(got, "module core of hello world\n") || !strings.Contains(got, "module \n") {
+		t.Errorf("unexpected environment:\n%s", got)
+	}
+}
+
+func TestE2E_EnvFile(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	writeFile(t, filepath.Join(dir, "local.env"), `# local secrets
+KNIT_E2E_TOKEN=file-token
+KNIT_E2E_SHADOWED=from-file
+`)
+	writeFile(t, filepath.Join(dir, "core", ".env"), `KNIT_E2E_TOKEN="core token"
+`)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `env:
+  KNIT_E2E_URL: https://${KNIT_E2E_TOKEN}@example.com
+tasks:
+  test:
+    after:
+      - run: echo "$(basename "$PWD") $KNIT_E2E_TOKEN $KNIT_E2E_SHADOWED $KNIT_E2E_URL" >> ../env.log
+        scope: module
+`)
+	t.Setenv("KNIT_E2E_SHADOWED", "from-env")
+	readLog := func() string {
+		t.Helper()
+		data, _ := os.ReadFile(filepath.Join(dir, "env.log"))
+		os.Remove(filepath.Join(dir, "env.log"))
+		return string(data)
+	}
+
+	// Module .env files are ignored unless enabled in knit.yaml
+	envFile := filepath.Join(dir, "local.env")
+	output, err := runKnit(t, "test", "-p", dir, "--env-file", envFile, "-t", "example.com/core")
+	if err != nil {
+		t.Fatalf("test failed: %v\n%s", err, output)
+	}
+	if got := readLog(); got != "core file-token from-env https://file-token@example.com\n" {
+		t.Errorf("unexpected environment: %q", got)
+	}
+
+	config, _ := os.ReadFile(filepath.Join(dir, "knit.yaml"))
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "moduleEnvFile: .env\n"+string(config))
+	output, err = runKnit(t, "test", "-p", dir, "--env-file", envFile, "-t", "example.com/core", "-t", "example.com/utils")
+	if err != nil {
+		t.Fatalf("test failed: %v\n%s", err, output)
+	}
+	got := readLog()
+	if !strings.Contains(got, "core core token from-env https://core token@example.com\n") ||
+		!strings.Contains(got, "utils file-token from-env https://file-token@example.com\n") {
+		t.Errorf("unexpected environment:\n%s", got)
+	}
+
+	output, err = runKnit(t, "test", "-p", dir, "--env-file", filepath.Join(dir, "missing.env"))
+	if err == nil || !strings.Contains(output, "missing.env") {
+		t.Errorf("expected a missing env file to fail: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_RunTask(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  image:
+    run: echo "build registry/{{.ShortName}} {{.Module.RelDir}} in {{.WorkspaceRoot}} {{.Module.Path}}"
+  check:
+    run: test "{{.ShortName}}" != api
+`)
+	output, err := runKnit(t, "run", "-p", dir, "-t", "example.com/core", "image")
+	if err != nil {
+		t.Fatalf("run failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "build registry/core core in "+dir+" example.com/core") {
+		t.Errorf("expected the expanded command output:\n%s", output)
+	}
+
+	output, err = runKnit(t, "run", "-p", dir, "check")
+	if err == nil || !strings.Contains(output, "1 of 4 modules failed") {
+		t.Errorf("expected api to fail: %v\n%s", err, output)
+	}
+	output, err = runKnit(t, "rerun-failed", "-p", dir)
+	if err == nil || !strings.Contains(output, "[example.com/api]") || strings.Contains(output, "[example.com/core]") {
+		t.Errorf("expected only api to rerun: %v\n%s", err, output)
+	}
+
+	output, err = runKnit(t, "run", "-p", dir, "missing")
+	if err == nil || !strings.Contains(output, `no task "missing" with a run command`) {
+		t.Errorf("expected an unknown task to fail: %v\n%s", err, output)
+	}
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  broken:
+    run: echo {{.Module.Nope}}
+`)
+	output, err = runKnit(t, "run", "-p", dir, "broken")
+	if err == nil || !strings.Contains(output, "failed to expand command") {
+		t.Errorf("expected an invalid template to fail: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_TaskOnlyIf(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `modules:
+  example.com/api:
+    tags: [service]
+tasks:
+  build:
+    run: echo "building {{.ShortName}}"
+    onlyIf: hasMainPackage
+  deploy:
+    run: echo "deploying {{.ShortName}}"
+    onlyIf: tag == "service" || hasMainPackage
+  test:
+    onlyIf: hasTests && tag != "service"
+`)
+	output, err := runKnit(t, "run", "-p", dir, "build")
+	if err != nil {
+		t.Fatalf("run failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "Skipping 3 of 4 modules not matching hasMainPackage") ||
+		!strings.Contains(output, "building app") || strings.Contains(output, "building core") {
+		t.Errorf("expected only the main module to build:\n%s", output)
+	}
+
+	output, err = runKnit(t, "run", "-p", dir, "deploy")
+	if err != nil || !strings.Contains(output, "deploying api") || !strings.Contains(output, "deploying app") ||
+		strings.Contains(output, "deploying utils") {
+		t.Errorf("expected api and app to deploy: %v\n%s", err, output)
+	}
+
+	output, err = runKnit(t, "test", "-p", dir, "-t", "example.com/api")
+	if err != nil || !strings.Contains(output, "No modules match the onlyIf condition of test") {
+		t.Errorf("expected api to be skipped: %v\n%s", err, output)
+	}
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  test:
+    onlyIf: hasDocs
+`)
+	output, err = runKnit(t, "test", "-p", dir)
+	if err == nil || !strings.Contains(output, `invalid onlyIf of task test: unknown property "hasDocs"`) {
+		t.Errorf("expected an invalid condition to fail: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_Artifacts(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	artifactsDir := filepath.Join(t.TempDir(), "artifacts")
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  build:
+    run: mkdir -p bin && echo {{.ShortName}} > bin/{{.ShortName}} && echo cover > coverage.out && test {{.ShortName}} != utils
+    outputs: [bin, "*.out"]
+`)
+	output, err := runKnit(t, "run", "-p", dir, "--artifacts-dir", artifactsDir, "-t", "example.com/core", "-t", "example.com/utils", "build")
+	if err == nil || !strings.Contains(output, "1 of 2 modules failed") {
+		t.Errorf("expected utils to fail: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "Collected 4 artifacts from 2 modules") {
+		t.Errorf("expected the outputs of failed modules too:\n%s", output)
+	}
+	for file, content := range map[string]string{
+		"build/core/bin/core":      "core\n",
+		"build/core/coverage.out":  "cover\n",
+		"build/utils/bin/utils":    "utils\n",
+		"build/utils/coverage.out": "cover\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(artifactsDir, file))
+		if err != nil || string(data) != content {
+			t.Errorf("%s: expected %q, got %q (%v)", file, content, data, err)
+		}
+	}
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  build:
+    run: "true"
+    outputs: [../secrets]
+`)
+	output, err = runKnit(t, "run", "-p", dir, "--artifacts-dir", artifactsDir, "build")
+	if err == nil || !strings.Contains(output, "must stay inside the module directory") {
+		t.Errorf("expected an output outside the module to fail: %v\n%s", err, output)
+	}
+}
+
+func TestE2E_TaskCache(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	runs := func() int {
+		t.Helper()
+		data, _ := os.ReadFile(filepath.Join(dir, "runs.log"))
+		return strings.Count(string(data), "\n")
+	}
+	build := func(flags ...string) string {
+		t.Helper()
+		args := append([]string{"run", "-p", dir, "-t", "example.com/core", "-t", "example.com/utils"}, flags...)
+		output, err := runKnit(t, append(args, "build")...)
+		if err != nil {
+			t.Fatalf("run failed: %v\n%s", err, output)
+		}
+		return output
+	}
+	touchCore := func(comment string) {
+		t.Helper()
+		f, err := os.OpenFile(filepath.Join(dir, "core", "core.go"), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(f, "\n// %s\n", comment)
+		f.Close()
+	}
+
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  build:
+    run: echo ran >> ../runs.log && mkdir -p bin && echo {{.ShortName}} > bin/{{.ShortName}}
+    outputs: [bin]
+    cache: true
+`)
+	build()
+	if runs() != 2 {
+		t.Fatalf("expected 2 runs, got %d", runs())
+	}
+
+	// Unchanged modules are not run again and get their outputs back
+	os.RemoveAll(filepath.Join(dir, "core", "bin"))
+	output := build()
+	if runs() != 2 || strings.Count(output, "✓ Cached") != 2 {
+		t.Errorf("expected both modules to be cached, %d runs:\n%s", runs(), output)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "core", "bin", "core")); err != nil || string(data) != "core\n" {
+		t.Errorf("expected the output to be restored, got %q (%v)", data, err)
+	}
+
+	// A change to core invalidates core and utils, which depends on it
+	touchCore("changed")
+	build()
+	if runs() != 4 {
+		t.Errorf("expected core and utils to run again, got %d runs", runs())
+	}
+
+	build("--force")
+	if runs() != 6 {
+		t.Errorf("expected --force to run both modules, got %d runs", runs())
+	}
+
+	// --cache-readonly uses the cache but does not write it
+	touchCore("changed again")
+	build("--cache-readonly")
+	build("--cache-readonly")
+	if runs() != 10 {
+		t.Errorf("expected --cache-readonly not to write the cache, got %d runs", runs())
+	}
+	build()
+	output = build("--cache-readonly")
+	if runs() != 12 || strings.Count(output, "✓ Cached") != 2 {
+		t.Errorf("expected --cache-readonly to read the cache, %d runs:\n%s", runs(), output)
+	}
+
+	build("--no-cache")
+	if runs() != 14 {
+		t.Errorf("expected --no-cache to run both modules, got %d runs", runs())
+	}
+
+	// Files embedded in core are part of the keys of core and utils, like
+	// its Go sources
+	writeFile(t, filepath.Join(dir, "core", "assets", "banner.txt"), "hello\n")
+	writeFile(t, filepath.Join(dir, "core", "banner.go"), "package core\n\nimport _ \"embed\"\n\n//go:embed assets\nvar banner string\n")
+	build()
+	build()
+	if runs() != 16 {
+		t.Errorf("expected core and utils to run once after adding the embed, got %d runs", runs())
+	}
+	writeFile(t, filepath.Join(dir, "core", "assets", "banner.txt"), "hello again\n")
+	build()
+	if runs() != 18 {
+		t.Errorf("expected a change to an embedded file to run core and utils again, got %d runs", runs())
+	}
+
+	// As are its assembly files
+	writeFile(t, filepath.Join(dir, "core", "nop.s"), "// nop\n")
+	build()
+	writeFile(t, filepath.Join(dir, "core", "nop.s"), "// nop again\n")
+	build()
+	if runs() != 22 {
+		t.Errorf("expected a change to an assembly file to run core and utils again, got %d runs", runs())
+	}
+}
+
+func TestE2E_TaskCacheKeys(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  check:
+    run: echo ran >> ../runs.log
+    cache: true
+    inputs: [testdata]
+    inputEnv: [KNIT_E2E_STAGE]
+`)
+	runs := func() int {
+		t.Helper()
+		data, _ := os.ReadFile(filepath.Join(dir, "runs.log"))
+		return strings.Count(string(data), "\n")
+	}
+	check := func(env ...string) {
+		t.Helper()
+		cmd := exec.Command(binaryPath, "run", "-p", dir, "-t", "example.com/core", "check")
+		cmd.Env = append(os.Environ(), env...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("run failed: %v\n%s", err, output)
+		}
+	}
+
+	check()
+	check()
+	if runs() != 1 {
+		t.Fatalf("expected a cache hit, got %d runs", runs())
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		env    []string
+	}{
+		{"go.sum", func() { writeFile(t, filepath.Join(dir, "core", "go.sum"), "example.com/dep v1.0.0 h1:abc=\n") }, nil},
+		{"declared input", func() { writeFile(t, filepath.Join(dir, "core", "testdata", "golden.txt"), "v1\n") }, nil},
+		{"go build env", func() {}, []string{"GOFLAGS=-tags=integration"}},
+		{"declared env", func() {}, []string{"GOFLAGS=-tags=integration", "KNIT_E2E_STAGE=ci"}},
+	}
+	want := 1
+	for _, step := range steps {
+		step.change()
+		check(step.env...)
+		check(step.env...)
+		if want++; runs() != want {
+			t.Errorf("%s: expected a single new run, got %d runs instead of %d", step.name, runs(), want)
+			want = runs()
+		}
+	}
+
+	// Files outside the declared inputs do not change the key
+	writeFile(t, filepath.Join(dir, "core", "README.md"), "docs\n")
+	check("GOFLAGS=-tags=integration", "KNIT_E2E_STAGE=ci")
+	if runs() != want {
+		t.Errorf("expected an undeclared file to keep the cache, got %d runs", runs())
+	}
+}
+
+func TestE2E_OTelTrace(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), `tasks:
+  check:
+    run: test {{.ShortName}} != utils
+    cache: true
+`)
+
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Attributes   []struct {
+			Key   string         `json:"key"`
+			Value map[string]any `json:"value"`
+		} `json:"attributes"`
+		Status struct {
+			Code int `json:"code"`
+		} `json:"status"`
+	}
+	traces := make(chan []span, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		traces <- req.ResourceSpans[0].ScopeSpans[0].Spans
+	}))
+	defer server.Close()
+
+	run := func() []span {
+		t.Helper()
+		cmd := exec.Command(binaryPath, "run", "-p", dir, "-t", "example.com/core", "-t", "example.com/utils", "check")
+		cmd.Env = append(os.Environ(), "OTEL_EXPORTER_OTLP_ENDPOINT="+server.URL)
+		output, _ := cmd.CombinedOutput()
+		if strings.Contains(string(output), "warning") {
+			t.Errorf("unexpected warning:\n%s", output)
+		}
+		select {
+		case spans := <-traces:
+			return spans
+		default:
+			t.Fatalf("no trace exported:\n%s", output)
+			return nil
+		}
+	}
+	attr := func(s span, key string) any {
+		for _, a := range s.Attributes {
+			if a.Key == key {
+				for _, v := range a.Value {
+					return v
+				}
+			}
+		}
+		return nil
+	}
+
+	spans := run()
+	if len(spans) != 3 || spans[0].Name != "knit check" || spans[0].Status.Code != 2 {
+		t.Fatalf("expected a failed root and 2 module spans, got %+v", spans)
+	}
+	root := spans[0]
+	for _, s := range spans[1:] {
+		if s.ParentSpanID != root.SpanID || s.TraceID != root.TraceID || attr(s, "knit.cache_hit") != false {
+			t.Errorf("unexpected module span: %+v", s)
+		}
+		failed := attr(s, "knit.module") == "example.com/utils"
+		if (s.Status.Code == 2) != failed || (attr(s, "knit.exit_code") == "0") == failed {
+			t.Errorf("unexpected status of %s: %+v", s.Name, s)
+		}
+	}
+
+	// The successful module is a cache hit on the next run
+	hits := 0
+	for _, s := range run() {
+		if attr(s, "knit.cache_hit") == true {
+			hits++
+		}
+	}
+	if hits != 1 {
+		t.Errorf("expected a cache hit span, got %d", hits)
+	}
+}
+
+func TestE2E_ReportPRComment(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	if output, err := runKnit(t, "fmt", "-p", dir); err != nil {
+		t.Fatalf("fmt failed: %v\n%s", err, output)
+	}
+	changed := filepath.Join(t.TempDir(), "changed.txt")
+	writeFile(t, changed, "utils/utils.go\n")
+
+	output, err := runKnit(t, "report", "pr-comment", "--dry-run", "--task", "fmt", "--files-from", changed, "-p", dir)
+	if err != nil {
+		t.Fatalf("pr-comment failed: %v\n%s", err, output)
+	}
+	for _, want := range []string{
+		"### knit: 1 module affected, 2 modules depending on them",
+		"| `example.com/utils` | changed | ✅ passed in",
+		"| `example.com/api` | dependent | ✅ passed in",
+		"| `example.com/app` | dependent |",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the comment:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "example.com/core") {
+		t.Errorf("expected core, a dependency of utils, to be left out:\n%s", output)
+	}
+
+	// The comment is posted on the pull request of the event
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		posted = append(posted, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	event := filepath.Join(t.TempDir(), "event.json")
+	writeFile(t, event, `{"pull_request":{"number":9}}`)
+	t.Setenv("GITHUB_REPOSITORY", "org/repo")
+	t.Setenv("GITHUB_API_URL", server.URL)
+	t.Setenv("GITHUB_EVENT_PATH", event)
+	t.Setenv("GITHUB_TOKEN", "secret")
+	output, err = runKnit(t, "report", "pr-comment", "--task", "fmt", "--files-from", changed, "-p", dir)
+	if err != nil || !strings.Contains(output, "Posted a knit comment on org/repo #9") {
+		t.Fatalf("pr-comment failed: %v\n%s", err, output)
+	}
+	if strings.Join(posted, ",") != "GET /repos/org/repo/issues/9/comments,POST /repos/org/repo/issues/9/comments" {
+		t.Errorf("unexpected requests: %v", posted)
+	}
+}
+
+func TestE2E_StepSummary(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "tasks:\n  cover:\n    run: go test -cover ./...\n")
+	summary := filepath.Join(t.TempDir(), "summary.md")
+	writeFile(t, summary, "# Previous step\n")
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+
+	if output, err := runKnit(t, "run", "-t", "example.com/core", "-p", dir, "cover"); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Previous step\n### ✅ knit cover: 1 module passed\n",
+		"| Module | Result | Duration | Coverage |",
+		"| `example.com/core` | ✅ passed | ",
+		" | 100.0% |",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in the step summary:\n%s", want, data)
+		}
+	}
+
+	// A failure is reported, without a coverage column when none is printed
+	writeFile(t, filepath.Join(dir, "knit.yaml"), "tasks:\n  broken:\n    run: exit 2\n")
+	if output, err := runKnit(t, "run", "-t", "example.com/core", "-p", dir, "broken"); err == nil {
+		t.Fatalf("expected the run to fail:\n%s", output)
+	}
+	data, _ = os.ReadFile(summary)
+	if !strings.Contains(string(data), "### ❌ knit broken: 1 of 1 module failed\n\n| Module | Result | Duration |\n") ||
+		!strings.Contains(string(data), "❌ failed (exit 2)") {
+		t.Errorf("unexpected step summary of the failure:\n%s", data)
+	}
+
+	t.Setenv("KNIT_STEP_SUMMARY", "off")
+	writeFile(t, summary, "")
+	runKnit(t, "run", "-t", "example.com/core", "-p", dir, "broken")
+	if data, _ := os.ReadFile(summary); len(data) != 0 {
+		t.Errorf("expected no step summary with KNIT_STEP_SUMMARY=off, got:\n%s", data)
+	}
+}
+
+func TestE2E_AnalysisCache(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, workspaceDir, dir)
+	os.RemoveAll(filepath.Join(dir, ".knit"))
+	t.Setenv("KNIT_DAEMON", "off")
+
+	deps := func() string {
+		t.Helper()
+		output, err := runKnit(t, "why", "-p", dir, "example.com/app", "example.com/utils")
+		if err != nil && !strings.Contains(output, "does not depend") {
+			t.Fatalf("why failed: %v\n%s", err, output)
+		}
+		return output
+	}
+	if output := deps(); !strings.Contains(output, "example.com/api") {
+		t.Fatalf("expected app to reach utils through api:\n%s", output)
+	}
+	file := filepath.Join(dir, ".knit", "analysis.json")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("expected the imports to be cached: %v", err)
+	}
+
+	// An unchanged tree is answered from the cache, tampered here to tell
+	var a struct {
+		Key     string                    `json:"key"`
+		Imports map[string]map[string]any `json:"imports"`
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		t.Fatal(err)
+	}
+	delete(a.Imports["example.com/api"], "example.com/utils")
+	data, _ = json.Marshal(a)
+	writeFile(t, file, string(data))
+	if output := deps(); strings.Contains(output, "example.com/api") {
+		t.Errorf("expected the cached imports to be used:\n%s", output)
+	}
+
+	// A change of the sources lists the imports again
+	writeFile(t, filepath.Join(dir, "app", "extra.go"), "package main\n")
+	if output := deps(); !strings.Contains(output, "example.com/api") {
+		t.Errorf("expected the imports to be listed again after a change:\n%s", output)
+	}
+}
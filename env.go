@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/config"
+)
+
+// loadEnvFiles merges the variables of the given .env files, later files
+// overriding earlier ones. Every file must exist.
+func loadEnvFiles(paths []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, path := range paths {
+		file, err := config.LoadDotenv(path, true)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range file {
+			vars[key] = value
+		}
+	}
+	return vars, nil
+}
+
+// taskEnvironment returns the variables added to the environment of the
+// tasks of module, or of the run hooks when module is nil. The .env
+// variables come first, those of the module's own .env file when
+// cfg.ModuleEnvFile is set overriding dotenv, and never override the
+// environment knit runs in, as CI usually sets it, nor environ when not nil.
+// The env of knit.yaml follows, expanded against both.
+func taskEnvironment(cfg *config.Config, dotenv map[string]string, environ []string, module *analyzer.Module) ([]string, error) {
+	vars := dotenv
+	modulePath := ""
+	if module != nil {
+		modulePath = module.Path
+		if cfg.ModuleEnvFile != "" {
+			moduleVars, err := config.LoadDotenv(filepath.Join(module.Dir, cfg.ModuleEnvFile), false)
+			if err != nil {
+				return nil, err
+			}
+			vars = make(map[string]string, len(dotenv)+len(moduleVars))
+			for key, value := range dotenv {
+				vars[key] = value
+			}
+			for key, value := range moduleVars {
+				vars[key] = value
+			}
+		}
+	}
+
+	parent := envLookup(environ)
+	env := make([]string, 0, len(vars))
+	for key, value := range vars {
+		if _, ok := parent(key); !ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	sort.Strings(env)
+
+	lookup := func(name string) (string, bool) {
+		if v, ok := parent(name); ok {
+			return v, true
+		}
+		v, ok := vars[name]
+		return v, ok
+	}
+	return append(env, cfg.Environment(modulePath, lookup)...), nil
+}
+
+// essentialEnv are the variables always passed to the tasks when forwardEnv
+// restricts the others, as the shell and the go command need them
+var essentialEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "TERM", "LANG", "LC_*", "TZ"}
+
+// forwardedEnvironment returns the part of environ, KEY=value pairs, passed to
+// the tasks: the variables whose name matches forward or essentialEnv when
+// forward is set, all of them otherwise, less those matching scrub. It
+// returns nil when neither is set, the tasks inheriting the environment.
+func forwardedEnvironment(environ, forward, scrub []string) []string {
+	if len(forward) == 0 && len(scrub) == 0 {
+		return nil
+	}
+	if len(forward) > 0 {
+		forward = append(append([]string{}, essentialEnv...), forward...)
+	}
+	matches := func(patterns []string, name string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	kept := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if (len(forward) == 0 || matches(forward, name)) && !matches(scrub, name) {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// envLookup looks a variable up in environ, or in the environment knit runs
+// in when environ is nil
+func envLookup(environ []string) func(string) (string, bool) {
+	if environ == nil {
+		return os.LookupEnv
+	}
+	vars := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			vars[name] = value
+		}
+	}
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+// checkEnvAssignments checks that every --env value is a KEY=value pair
+func checkEnvAssignments(env []string) error {
+	for _, kv := range env {
+		if name, _, ok := strings.Cut(kv, "="); !ok || name == "" {
+			return fmt.Errorf("invalid --env %q, expected KEY=value", kv)
+		}
+	}
+	return nil
+}
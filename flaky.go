@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/nicolasgere/knit/lib/git"
+	"github.com/nicolasgere/knit/lib/gotest"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/nicolasgere/knit/lib/runner"
+	"github.com/nicolasgere/knit/lib/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// createFlakyCommand creates the 'flaky' command listing the tests that both
+// passed and failed on a same revision
+func createFlakyCommand() *cli.Command {
+	var path string
+	var jsonOutput bool
+
+	return &cli.Command{
+		Name:  "flaky",
+		Usage: "List the tests that both passed and failed on the same code",
+		Description: `List the tests that both passed and failed on a same revision of the code, the
+HEAD commit and the changes to the tracked files, in the runs of
+'knit test --json' recorded in .knit/history.json. The outcomes of the last
+10 revisions each test ran on are kept.
+
+'knit test --retry-flaky N' retries the failed tests of a module up to N
+times when they are all known to be flaky, passing the module if they do.
+
+Examples:
+  knit flaky
+  knit flaky --json`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "json",
+				Usage:       "Output as JSON",
+				Destination: &jsonOutput,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+			h, err := history.Load(absPath)
+			if err != nil {
+				return err
+			}
+			flaky := h.FlakyTests()
+
+			if jsonOutput {
+				if flaky == nil {
+					flaky = []history.FlakyTest{}
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(flaky)
+			}
+			if len(flaky) == 0 {
+				fmt.Println("No flaky tests in the runs of 'knit test --json' recorded")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "TEST\tFLAKY ON\tRUNS")
+			for _, f := range flaky {
+				fmt.Fprintf(w, "%s\t%d of %d revisions\t%d passed, %d failed\n", f.Test, f.FlakyRevisions, f.Revisions, f.Passed, f.Failed)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// recordTestOutcomes records the results of the tests of a run in the
+// history, the outcomes keyed by the revision of the workspace. Outcomes are
+// not recorded outside of a git repository, where revisions cannot be told
+// apart.
+func recordTestOutcomes(workspaceRoot string, h *history.History, tests []gotest.Results) string {
+	revision, _ := git.Revision(workspaceRoot)
+	for i := range tests {
+		recordResults(h, revision, &tests[i])
+	}
+	return revision
+}
+
+// recordResults records the outcomes of the tests that ran, and the
+// durations of the test functions, the results of the test cache being
+// replays of a recorded run
+func recordResults(h *history.History, revision string, tests *gotest.Results) {
+	for _, t := range tests.Tests() {
+		if t.Status == gotest.Skipped || tests.Cached(t.Package) {
+			continue
+		}
+		if revision != "" {
+			h.RecordTest(testKey(t), revision, t.Status == gotest.Failed)
+		}
+		if t.TopLevel() {
+			h.RecordTestDuration(testKey(t), t.Elapsed)
+		}
+	}
+}
+
+// flakyRetry returns the go test command running again the failed tests of a
+// module with flags, when they are all known to be flaky and are the only
+// failures of their packages
+func flakyRetry(module, flags string, tests *gotest.Results, h *history.History) (string, bool) {
+	names := make(map[string]bool)
+	packages := make(map[string]bool)
+	for _, t := range tests.Tests() {
+		if t.Status != gotest.Failed || !t.TopLevel() {
+			continue
+		}
+		if !h.IsFlaky(testKey(t)) {
+			return "", false
+		}
+		names[regexp.QuoteMeta(t.Test)] = true
+		packages[t.Package] = true
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	for _, p := range tests.FailedPackages() {
+		if !packages[p] {
+			return "", false
+		}
+	}
+
+	dirs := make([]string, 0, len(packages))
+	for p := range packages {
+		dir := "."
+		if rel, ok := strings.CutPrefix(p, module+"/"); ok {
+			dir = "./" + rel
+		}
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	pattern := "^(" + strings.Join(sortedKeys(names), "|") + ")$"
+	return "go test" + flags + " -json -count=1 -run " + shellQuote(pattern) + " " + strings.Join(dirs, " "), true
+}
+
+// retryFlakyTests runs the failed tests of the failed tasks again with the go
+// test flags of the task, up to attempts times, when they are all known to be
+// flaky. A task whose tests pass on a retry is marked as retried and passed.
+// The outcomes of the retries are recorded on revision, unless empty, like by
+// recordResults.
+func retryFlakyTests(r *runner.Runner, tasks []runner.Task, flags []string, results []runner.TaskResult, run *testRun, revision string, attempts int) {
+	retries := make([][]*gotest.Results, len(tasks))
+	var wg sync.WaitGroup
+	for i := range tasks {
+		if results[i].Status == 0 {
+			continue
+		}
+		cmd, ok := flakyRetry(tasks[i].Id, flags[i], &run.tests[i], run.history)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for attempt := 1; attempt <= attempts; attempt++ {
+				utils.LogWithTaskId(tasks[i].Id, fmt.Sprintf("Retrying the flaky tests (attempt %d of %d)", attempt, attempts), utils.INFO)
+				task := tasks[i]
+				task.Cmd, task.Label = cmd, ""
+				retry := &gotest.Results{}
+				retries[i] = append(retries[i], retry)
+				var result runner.TaskResult
+				handleTaskFuture(r.RunTask(task), &result, retry.Record, nil)
+				if result.Status == 0 {
+					results[i].Status = 0
+					run.retried[i] = true
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, tries := range retries {
+		for _, retry := range tries {
+			recordResults(run.history, revision, retry)
+		}
+	}
+}
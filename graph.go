@@ -0,0 +1,573 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/nicolasgere/knit/lib/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// createGraphCommand creates the 'graph' command to visualize module dependencies
+func createGraphCommand() *cli.Command {
+	var (
+		path         string
+		format       string
+		tmplText     string
+		reverse      bool
+		external     bool
+		collapse     bool
+		highlight    bool
+		base         string
+		useMergeBase bool
+		changes      changeFlags
+		useColor     bool
+		schema       bool
+		build        buildFlags
+	)
+
+	return &cli.Command{
+		Name:  "graph",
+		Usage: "Display the dependency graph of all modules in the workspace",
+		Description: `Show all modules and their dependencies within the monorepo. Dependencies
+only _test.go files create are marked [test], dashed in dot and mermaid.
+
+Examples:
+  knit graph                    # Show dependency graph
+  knit graph -f dot             # Output in DOT format (for Graphviz)
+  knit graph -f json            # Output in JSON format
+  knit graph --schema           # Print the JSON schema of -f json
+  knit graph -f mermaid         # Output a Mermaid diagram (renders in GitHub/GitLab markdown)
+  knit graph -f html > deps.html  # Interactive page, zoomable and searchable
+  knit graph -f topo            # Modules in build order, dependencies first
+  knit graph -f topo-levels     # JSON array of levels, each level can build in parallel
+  knit graph --reverse          # Show who uses each module instead of what it uses
+  knit graph --external -f dot  # Include third-party modules with their version
+  knit graph --tags integration --goos windows  # Imports of the files built for this configuration
+  knit graph --no-test-deps     # Leave out the dependencies of tests
+  knit graph --highlight-affected --base origin/main -f mermaid  # Changed modules in red, impacted ones in orange
+  knit graph -f template --template '{{range .Modules}}{{.Path}}: {{join .Dependencies ","}}\n{{end}}'`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "Output format: tree (default), dot, json, mermaid, html, topo, topo-levels, template",
+				Aliases:     []string{"f"},
+				Value:       "tree",
+				Destination: &format,
+			},
+			&cli.StringFlag{
+				Name:        "template",
+				Usage:       "Go template used with -f template (fields: .WorkspaceRoot, .Modules with .Path .Dir .RelDir .Name .GoVersion .Main .Dependencies)",
+				Destination: &tmplText,
+			},
+			&cli.BoolFlag{
+				Name:        "reverse",
+				Usage:       "Draw edges from dependencies to their dependents (.Dependencies then lists dependents)",
+				Aliases:     []string{"r"},
+				Destination: &reverse,
+			},
+			&cli.BoolFlag{
+				Name:        "external",
+				Usage:       "Also show modules required from outside the workspace, with their version",
+				Aliases:     []string{"e"},
+				Destination: &external,
+			},
+			&cli.BoolFlag{
+				Name:        "collapse-external",
+				Usage:       "Show external modules grouped by organization, e.g. github.com/org/... (implies --external)",
+				Destination: &collapse,
+			},
+			&cli.BoolFlag{
+				Name:        "highlight-affected",
+				Usage:       "Color changed modules red and the modules depending on them orange",
+				Destination: &highlight,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Git reference to compare against with --highlight-affected (default: main)",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.BoolFlag{
+				Name:        "merge-base",
+				Usage:       "Compare against merge-base (common ancestor) with --highlight-affected",
+				Aliases:     []string{"m"},
+				Destination: &useMergeBase,
+			},
+			&cli.BoolFlag{
+				Name:        "color",
+				Usage:       "Enable colored output in the tree format",
+				Aliases:     []string{"c"},
+				Destination: &useColor,
+			},
+			schemaFlag("-f json", &schema),
+		}, append(changes.flags(), build.flags()...)...),
+		Action: func(c *cli.Context) error {
+			if schema {
+				return printSchema(schemaGraph)
+			}
+			utils.SetColorEnabled(useColor)
+
+			opts := graphOptions{
+				Format:   format,
+				Template: tmplText,
+				Reverse:  reverse,
+				External: external,
+				Collapse: collapse,
+				Build:    build.context(),
+			}
+			if highlight || changes.filesFrom != "" {
+				src, err := changes.source(base, useMergeBase)
+				if err != nil {
+					return err
+				}
+				opts.Highlight = &src
+			}
+			return runGraph(path, opts)
+		},
+	}
+}
+
+// graphOptions controls what runGraph renders
+type graphOptions struct {
+	Format   string
+	Template string
+	Reverse  bool
+	External bool
+	Collapse bool
+	// Build is the configuration the imports are analyzed for
+	Build analyzer.BuildContext
+	// Highlight, when set, is the change source of the modules to highlight
+	Highlight *changeSource
+}
+
+// Statuses of highlighted nodes
+const (
+	statusChanged  = "changed"
+	statusImpacted = "impacted"
+)
+
+func runGraph(path string, opts graphOptions) error {
+	absPath, modules, imports, err := loadModuleImports(path, true, opts.Build)
+	if err != nil {
+		return err
+	}
+	if len(modules) == 0 {
+		return fmt.Errorf("no modules found in workspace")
+	}
+
+	// Build dependency graph
+	g := analyzer.GraphFromImports(modules, imports)
+
+	// Get adjacency map
+	adjMap, err := (*g).AdjacencyMap()
+	if err != nil {
+		return fmt.Errorf("failed to get adjacency map: %w", err)
+	}
+
+	nodes := make([]graphNode, 0, len(modules))
+	for _, m := range modules {
+		nodes = append(nodes, graphNode{Id: m.Path, Path: m.Path, Dir: m.Dir})
+	}
+	if opts.Highlight != nil {
+		changed, err := affectedModules(modules, absPath, *opts.Highlight)
+		if err != nil {
+			return err
+		}
+		status := make(map[string]string)
+		for _, m := range changed {
+			for dep := range resolver.Dependents(adjMap, m.Path, 0) {
+				status[dep] = statusImpacted
+			}
+		}
+		for _, m := range changed {
+			status[m.Path] = statusChanged
+		}
+		for i := range nodes {
+			nodes[i].Status = status[nodes[i].Id]
+		}
+	}
+	if opts.External || opts.Collapse {
+		if nodes, err = addExternalNodes(nodes, adjMap, modules, opts.Collapse); err != nil {
+			return err
+		}
+	}
+	tests := testOnlyEdges(imports, opts.Reverse)
+	if opts.Reverse {
+		adjMap = reverseAdjacency(adjMap)
+	}
+
+	// Output in requested format
+	switch opts.Format {
+	case "tree":
+		return outputGraphTree(nodes, adjMap, tests, opts.Reverse)
+	case "dot":
+		return outputGraphDot(nodes, adjMap, tests)
+	case "json":
+		return outputGraphJSON(nodes, adjMap, tests)
+	case "mermaid":
+		return outputGraphMermaid(nodes, adjMap, tests)
+	case "html":
+		return outputGraphHTML(nodes, adjMap, absPath)
+	case "topo":
+		return outputGraphTopo(nodes, adjMap, tests, false)
+	case "topo-levels":
+		return outputGraphTopo(nodes, adjMap, tests, true)
+	case "template":
+		return renderTemplate(opts.Template, newTemplateData(modules, absPath, adjMap))
+	default:
+		return fmt.Errorf("unknown format: %s (use tree, dot, json, mermaid, html, topo, topo-levels, or template)", opts.Format)
+	}
+}
+
+// edge is a rendered dependency edge, from a node to another
+type edge struct{ from, to string }
+
+// testOnlyEdges returns the dependencies only _test.go files create, in the
+// direction they are drawn
+func testOnlyEdges(imports map[string]map[string][]analyzer.Import, reverse bool) map[edge]bool {
+	tests := make(map[edge]bool)
+	for src, deps := range imports {
+		for dep, edgeImports := range deps {
+			if !analyzer.TestOnly(edgeImports) {
+				continue
+			}
+			if reverse {
+				tests[edge{dep, src}] = true
+			} else {
+				tests[edge{src, dep}] = true
+			}
+		}
+	}
+	return tests
+}
+
+// graphFormats lists every format of the graph command
+var graphFormats = []string{"tree", "dot", "json", "mermaid", "html", "topo", "topo-levels", "template"}
+
+// graphNode is a vertex of the rendered graph: a workspace module or, with
+// --external, a module required from outside the workspace
+type graphNode struct {
+	Id       string
+	Path     string
+	Dir      string
+	Version  string
+	External bool
+	// Status is statusChanged, statusImpacted or empty
+	Status string
+}
+
+// label is the name displayed in diagrams
+func (n graphNode) label() string {
+	if !n.External {
+		return shortName(n.Path)
+	}
+	return n.Id
+}
+
+// addExternalNodes adds a node per external module required by the go.mod
+// files, and the matching edges to adjMap. Each version of a module is its
+// own node, so version skew between modules shows up. With collapse, modules
+// are grouped by their first two path elements, e.g. github.com/org/...
+func addExternalNodes[T any](nodes []graphNode, adjMap map[string]map[string]T, modules []analyzer.Module, collapse bool) ([]graphNode, error) {
+	requirements, err := analyzer.ListRequirements(modules)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var external []graphNode
+	for _, m := range modules {
+		for _, r := range requirements[m.Path] {
+			n := graphNode{Id: r.Path + "@" + r.Version, Path: r.Path, Version: r.Version, External: true}
+			if collapse {
+				prefix := orgPrefix(r.Path)
+				n = graphNode{Id: prefix, Path: prefix, External: true}
+			}
+			if !seen[n.Id] {
+				seen[n.Id] = true
+				external = append(external, n)
+			}
+			if adjMap[m.Path] == nil {
+				adjMap[m.Path] = make(map[string]T)
+			}
+			var edge T
+			adjMap[m.Path][n.Id] = edge
+		}
+	}
+
+	sort.Slice(external, func(i, j int) bool { return external[i].Id < external[j].Id })
+	return append(nodes, external...), nil
+}
+
+// orgPrefix returns the first two elements of a module path followed by
+// "/...", or the path itself when it is that short
+func orgPrefix(modulePath string) string {
+	parts := strings.SplitN(modulePath, "/", 3)
+	if len(parts) < 3 {
+		return modulePath
+	}
+	return parts[0] + "/" + parts[1] + "/..."
+}
+
+// reverseAdjacency flips every edge of the adjacency map, so each module maps
+// to the modules depending on it
+func reverseAdjacency[T any](adjMap map[string]map[string]T) map[string]map[string]T {
+	reversed := make(map[string]map[string]T, len(adjMap))
+	for src := range adjMap {
+		reversed[src] = make(map[string]T)
+	}
+	for src, deps := range adjMap {
+		for dst, edge := range deps {
+			if reversed[dst] == nil {
+				reversed[dst] = make(map[string]T)
+			}
+			reversed[dst][src] = edge
+		}
+	}
+	return reversed
+}
+
+// sortedDeps returns the ids adjacent to id, in lexical order
+func sortedDeps[T any](adjMap map[string]map[string]T, id string) []string {
+	deps := make([]string, 0, len(adjMap[id]))
+	for dep := range adjMap[id] {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+func outputGraphTree[T any](nodes []graphNode, adjMap map[string]map[string]T, tests map[edge]bool, reverse bool) error {
+	title, empty := "Module Dependency Graph", "(no workspace dependencies)"
+	if reverse {
+		title, empty = "Module Dependents Graph", "(no workspace dependents)"
+	}
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("=", len(title)))
+	fmt.Println()
+
+	status := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		status[n.Id] = n.Status
+	}
+
+	for _, n := range nodes {
+		deps := sortedDeps(adjMap, n.Id)
+		// External modules are only roots when they have dependents
+		if n.External && len(deps) == 0 {
+			continue
+		}
+
+		fmt.Printf("📦 %s\n", withStatus(n.Id, status[n.Id]))
+		if len(deps) == 0 {
+			fmt.Println("   " + empty)
+		}
+		for i, dep := range deps {
+			entry := withStatus(dep, status[dep])
+			if tests[edge{n.Id, dep}] {
+				entry += " [test]"
+			}
+			if i == len(deps)-1 {
+				fmt.Printf("   └── %s\n", entry)
+			} else {
+				fmt.Printf("   ├── %s\n", entry)
+			}
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// withStatus appends the highlight status to a tree entry, in red for changed
+// modules and in orange for impacted ones when color is enabled
+func withStatus(id, status string) string {
+	if status == "" {
+		return id
+	}
+	suffix := " (" + status + ")"
+	if utils.IsColorEnabled() {
+		color := utils.Orange
+		if status == statusChanged {
+			color = utils.Red
+		}
+		return color + id + suffix + utils.Reset
+	}
+	return id + suffix
+}
+
+func outputGraphDot[T any](nodes []graphNode, adjMap map[string]map[string]T, tests map[edge]bool) error {
+	fmt.Println("digraph dependencies {")
+	fmt.Println("  rankdir=TB;")
+	fmt.Println("  node [shape=box, style=rounded];")
+	fmt.Println()
+
+	// Add all nodes
+	for _, n := range nodes {
+		// Use short name for display
+		attrs := ""
+		switch {
+		case n.Status == statusChanged:
+			attrs = ", style=\"rounded,filled\", color=\"#dd3333\", fillcolor=\"#f8d7da\""
+		case n.Status == statusImpacted:
+			attrs = ", style=\"rounded,filled\", color=\"#e8890c\", fillcolor=\"#ffe5cc\""
+		case n.External:
+			attrs = ", style=\"rounded,dashed\""
+		}
+		fmt.Printf("  \"%s\" [label=\"%s\"%s];\n", n.Id, n.label(), attrs)
+	}
+	fmt.Println()
+
+	// Add edges
+	for _, n := range nodes {
+		for _, dep := range sortedDeps(adjMap, n.Id) {
+			attrs := ""
+			if tests[edge{n.Id, dep}] {
+				attrs = " [style=dashed, label=\"test\"]"
+			}
+			fmt.Printf("  \"%s\" -> \"%s\"%s;\n", n.Id, dep, attrs)
+		}
+	}
+
+	fmt.Println("}")
+	return nil
+}
+
+func outputGraphMermaid[T any](nodes []graphNode, adjMap map[string]map[string]T, tests map[edge]bool) error {
+	fmt.Println("graph TD")
+
+	// Module paths are not valid Mermaid identifiers, so nodes get generated ids
+	ids := make(map[string]string, len(nodes))
+	for i, n := range nodes {
+		ids[n.Id] = fmt.Sprintf("m%d", i)
+		if n.External {
+			fmt.Printf("  %s([\"%s\"])\n", ids[n.Id], n.label())
+		} else {
+			fmt.Printf("  %s[\"%s\"]\n", ids[n.Id], n.label())
+		}
+	}
+
+	for _, n := range nodes {
+		for _, dep := range sortedDeps(adjMap, n.Id) {
+			arrow := "-->"
+			if tests[edge{n.Id, dep}] {
+				arrow = "-. test .->"
+			}
+			fmt.Printf("  %s %s %s\n", ids[n.Id], arrow, ids[dep])
+		}
+	}
+
+	// Highlighted nodes get a class each
+	classes := map[string][]string{}
+	for _, n := range nodes {
+		if n.Status != "" {
+			classes[n.Status] = append(classes[n.Status], ids[n.Id])
+		}
+	}
+	if len(classes) > 0 {
+		fmt.Println("  classDef changed fill:#f8d7da,stroke:#dd3333")
+		fmt.Println("  classDef impacted fill:#ffe5cc,stroke:#e8890c")
+	}
+	for _, status := range []string{statusChanged, statusImpacted} {
+		if len(classes[status]) > 0 {
+			fmt.Printf("  class %s %s\n", strings.Join(classes[status], ","), status)
+		}
+	}
+	return nil
+}
+
+func outputGraphJSON[T any](nodes []graphNode, adjMap map[string]map[string]T, tests map[edge]bool) error {
+	type ModuleNode struct {
+		Path         string   `json:"path"`
+		Dir          string   `json:"dir,omitempty"`
+		Version      string   `json:"version,omitempty"`
+		External     bool     `json:"external,omitempty"`
+		Status       string   `json:"status,omitempty"`
+		Dependencies []string `json:"dependencies"`
+		// TestDependencies are the dependencies only tests create
+		TestDependencies []string `json:"testDependencies,omitempty"`
+	}
+
+	type GraphOutput struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		Modules       []ModuleNode `json:"modules"`
+	}
+
+	output := GraphOutput{
+		SchemaVersion: outputSchemaVersion,
+		Modules:       make([]ModuleNode, 0, len(nodes)),
+	}
+
+	for _, n := range nodes {
+		deps := sortedDeps(adjMap, n.Id)
+		var testDeps []string
+		for _, dep := range deps {
+			if tests[edge{n.Id, dep}] {
+				testDeps = append(testDeps, dep)
+			}
+		}
+		output.Modules = append(output.Modules, ModuleNode{
+			Path:             n.Path,
+			Dir:              n.Dir,
+			Version:          n.Version,
+			External:         n.External,
+			Status:           n.Status,
+			Dependencies:     deps,
+			TestDependencies: testDeps,
+		})
+	}
+
+	data, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// outputGraphTopo prints the modules in topological order, dependencies
+// first, one per line or as a JSON array of parallelizable levels. Test-only
+// dependencies do not order the build and may form cycles, they are ignored.
+func outputGraphTopo[T any](nodes []graphNode, adjMap map[string]map[string]T, tests map[edge]bool, grouped bool) error {
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.Id
+	}
+	build := make(map[string]map[string]T, len(adjMap))
+	for src, deps := range adjMap {
+		build[src] = make(map[string]T, len(deps))
+		for dep, e := range deps {
+			if !tests[edge{src, dep}] {
+				build[src][dep] = e
+			}
+		}
+	}
+	levels := resolver.Levels(build, ids)
+
+	if grouped {
+		data, err := json.MarshalIndent(levels, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	for _, level := range levels {
+		printLines(level, "\n")
+	}
+	return nil
+}
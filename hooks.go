@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/runner"
+)
+
+// afterHooksTimeout bounds the run-scoped after hooks of a task, which are
+// not killed by an interrupt
+const afterHooksTimeout = 5 * time.Minute
+
+// runHooks runs the run-scoped hooks of a task phase one after the other at
+// the workspace root, with env added to environ, or to the environment knit
+// runs in when nil. It reports false once a hook fails without being
+// ignored; before hooks stop there, after hooks all run regardless. After
+// hooks tear down what the run set up, so they still run once knit is
+// interrupted, killed only after afterHooksTimeout.
+func runHooks(workspaceRoot, name, phase string, r *runner.Runner, hooks []config.Hook, environ, env []string) bool {
+	if phase == "after" && len(hooks) > 0 {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), afterHooksTimeout)
+		defer cancel()
+		detached := r.WithContext(ctx)
+		r = &detached
+	}
+
+	ok := true
+	for _, h := range hooks {
+		tf := r.RunTask(runner.Task{Id: name + ":" + phase, Name: name, Cmd: h.Run, Root: workspaceRoot, Env: env, Environ: environ})
+		var result runner.TaskResult
+		handleTaskFuture(tf, &result, nil, nil)
+
+		if result.Status != 0 && h.OnFailure != config.FailureIgnore {
+			ok = false
+			if phase == "before" {
+				return false
+			}
+		}
+	}
+	return ok
+}
+
+// hookedCommand wraps cmd with the module-scoped hooks of a task in a single
+// shell script, so that they run in the module directory and their output is
+// shown with the task's. Before hooks stop at the first failure not ignored,
+// which skips cmd. After hooks always run, and one failing without being
+// ignored fails the module when cmd succeeded.
+func hookedCommand(cmd string, before, after []config.Hook) string {
+	if len(before) == 0 && len(after) == 0 {
+		return cmd
+	}
+
+	var b strings.Builder
+	b.WriteString("knit_status=0\n")
+	for _, h := range before {
+		onFailure := "knit_status=$?"
+		if h.OnFailure == config.FailureIgnore {
+			onFailure = "true"
+		}
+		fmt.Fprintf(&b, "if [ $knit_status -eq 0 ]; then\n(\n%s\n) || %s\nfi\n", h.Run, onFailure)
+	}
+	fmt.Fprintf(&b, "if [ $knit_status -eq 0 ]; then\n(\n%s\n) || knit_status=$?\nfi\n", cmd)
+	for _, h := range after {
+		onFailure := "{ knit_hook=$?; [ $knit_status -ne 0 ] || knit_status=$knit_hook; }"
+		if h.OnFailure == config.FailureIgnore {
+			onFailure = "true"
+		}
+		fmt.Fprintf(&b, "(\n%s\n) || %s\n", h.Run, onFailure)
+	}
+	b.WriteString("exit $knit_status\n")
+	return b.String()
+}
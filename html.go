@@ -0,0 +1,54 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+)
+
+//go:embed assets/graph.html
+var graphHTML string
+
+var graphHTMLTemplate = template.Must(template.New("graph").Parse(graphHTML))
+
+// htmlNode and htmlLink are the graph data embedded in the HTML page
+type htmlNode struct {
+	Id       string `json:"id"`
+	Label    string `json:"label"`
+	External bool   `json:"external,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+type htmlLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// outputGraphHTML writes a self-contained HTML page rendering the graph with
+// a zoomable, searchable force-directed layout. It needs no network access.
+func outputGraphHTML[T any](nodes []graphNode, adjMap map[string]map[string]T, workspaceRoot string) error {
+	graph := struct {
+		Nodes []htmlNode `json:"nodes"`
+		Links []htmlLink `json:"links"`
+	}{
+		Nodes: make([]htmlNode, 0, len(nodes)),
+		Links: make([]htmlLink, 0),
+	}
+	for _, n := range nodes {
+		graph.Nodes = append(graph.Nodes, htmlNode{Id: n.Id, Label: n.label(), External: n.External, Status: n.Status})
+		for _, dep := range sortedDeps(adjMap, n.Id) {
+			graph.Links = append(graph.Links, htmlLink{Source: n.Id, Target: dep})
+		}
+	}
+
+	err := graphHTMLTemplate.Execute(os.Stdout, map[string]any{
+		"Title": "Dependency graph of " + filepath.Base(workspaceRoot),
+		"Graph": graph,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render HTML: %w", err)
+	}
+	return nil
+}
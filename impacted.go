@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/urfave/cli/v2"
+)
+
+// createImpactedCommand creates the 'impacted' command listing the dependents of a module
+func createImpactedCommand() *cli.Command {
+	var (
+		path       string
+		depth      int
+		format     string
+		tmplText   string
+		jobCommand string
+		build      buildFlags
+	)
+
+	return &cli.Command{
+		Name:      "impacted",
+		Usage:     "List modules depending on a module, directly or transitively",
+		ArgsUsage: "<module>",
+		Description: `Print every module that would be impacted by a change to <module>.
+
+Examples:
+  knit impacted example.com/core             # All transitive dependents
+  knit impacted --depth 1 example.com/core   # Direct dependents only
+  knit impacted -f rel-dirs example.com/core # Same formats as 'affected'
+  knit impacted --tags integration example.com/core  # Count the imports of tagged files`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.IntFlag{
+				Name:        "depth",
+				Usage:       "Maximum number of dependency edges to follow (0 for no limit)",
+				Destination: &depth,
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "Output format, same as 'affected': list (default), go-args, github-matrix, dirs, rel-dirs, list0, dirs0, rel-dirs0, template, gitlab-ci, circleci, azure-matrix",
+				Aliases:     []string{"f"},
+				Value:       "list",
+				Destination: &format,
+			},
+			&cli.StringFlag{
+				Name:        "template",
+				Usage:       "Go template used with -f template",
+				Destination: &tmplText,
+			},
+			&cli.StringFlag{
+				Name:        "job-command",
+				Usage:       "Go template of the command run by each generated CI job",
+				Value:       defaultJobCommand,
+				Destination: &jobCommand,
+			},
+		}, build.flags()...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected 1 argument: <module>")
+			}
+			if depth < 0 {
+				return fmt.Errorf("--depth must be positive")
+			}
+			return runImpacted(path, c.Args().First(), depth, affectedOptions{
+				Format:     OutputFormat(format),
+				Template:   tmplText,
+				JobCommand: jobCommand,
+				Build:      build.context(),
+			})
+		},
+	}
+}
+
+func runImpacted(path, module string, depth int, opts affectedOptions) error {
+	absPath, modules, err := loadModules(path)
+	if err != nil {
+		return err
+	}
+	if !hasModule(modules, module) {
+		return fmt.Errorf("unknown module: %s", module)
+	}
+
+	imports, err := listModuleImports(absPath, modules, opts.Build)
+	if err != nil {
+		return fmt.Errorf("failed to build dependency graph: %w", err)
+	}
+	adjMap, err := (*analyzer.GraphFromImports(modules, imports)).AdjacencyMap()
+	if err != nil {
+		return fmt.Errorf("failed to get adjacency map: %w", err)
+	}
+
+	dependents := resolver.Dependents(adjMap, module, depth)
+
+	// Keep the workspace order
+	impacted := make([]analyzer.Module, 0, len(dependents))
+	for _, m := range modules {
+		if _, ok := dependents[m.Path]; ok {
+			impacted = append(impacted, m)
+		}
+	}
+
+	return outputAffected(impacted, opts, absPath)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/urfave/cli/v2"
+)
+
+// workflowFile is where 'knit init --github-actions' writes its workflow,
+// relative to the workspace root
+var workflowFile = filepath.Join(".github", "workflows", "knit.yml")
+
+// createInitCommand creates the 'init' command turning a directory into a
+// knit workspace
+func createInitCommand() *cli.Command {
+	var (
+		path          string
+		githubActions bool
+		base          string
+		force         bool
+	)
+
+	return &cli.Command{
+		Name:  "init",
+		Usage: "Set up a knit workspace: go.work, a starter knit.yaml and optionally CI",
+		Description: `Create or update go.work with every module found below the directory, as
+'knit work sync' does, and write a starter knit.yaml tagging modules with a
+main package "service" and the others "library". With --github-actions, also
+write a GitHub Actions workflow testing the affected modules in a matrix.
+Existing knit.yaml and workflow files are kept unless --force is set.
+
+Examples:
+  knit init
+  knit init --github-actions --base develop`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "github-actions",
+				Usage:       "Also write " + workflowFile,
+				Destination: &githubActions,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Default branch the workflow compares pushes against",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.BoolFlag{
+				Name:        "force",
+				Usage:       "Overwrite existing knit.yaml and workflow files",
+				Destination: &force,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+			if err := runWorkSync(absPath, false); err != nil {
+				return err
+			}
+
+			_, modules, err := loadModules(absPath)
+			if err != nil {
+				return err
+			}
+			listed, err := describeModules(absPath, modules)
+			if err != nil {
+				return err
+			}
+			if err := writeStarterFile(absPath, config.FileName, starterConfig(listed), force); err != nil {
+				return err
+			}
+			if githubActions {
+				if err := writeStarterFile(absPath, workflowFile, starterWorkflow(base), force); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// writeStarterFile writes content to name, relative to the workspace root,
+// unless the file exists and force is false
+func writeStarterFile(absPath, name, content string, force bool) error {
+	file := filepath.Join(absPath, name)
+	if _, err := os.Stat(file); err == nil && !force {
+		fmt.Printf("%s already exists, kept (use --force to overwrite)\n", name)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(name), err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	fmt.Printf("Wrote %s\n", name)
+	return nil
+}
+
+// starterConfig returns a knit.yaml tagging each module by kind, with
+// commented examples of the other settings
+func starterConfig(modules []listedModule) string {
+	var b strings.Builder
+	b.WriteString("# Knit configuration, see the Configuration section of the knit readme.\n\n")
+	b.WriteString("# Tags select modules in queries, e.g. knit test --query 'tag(service)'\n")
+	b.WriteString("modules:\n")
+	for _, m := range modules {
+		tag := "library"
+		if m.HasMain {
+			tag = "service"
+		}
+		fmt.Fprintf(&b, "  %s:\n    tags: [%s]\n", m.Path, tag)
+	}
+	b.WriteString(`
+# Layering rules checked by 'knit check-arch'
+# architecture:
+#   - name: libraries do not depend on services
+#     from: tag(library)
+#     deny: tag(service)
+
+# Package imports allowed only in some packages, also checked by 'knit check-arch'
+# bannedImports:
+#   - import: database/sql
+#     allow: [example.com/platform/db/...]
+#     message: use example.com/platform/db instead
+`)
+	return b.String()
+}
+
+// firstPushGuard returns the shell lines, indented by indent, setting
+// MERGE_BASE to --merge-base unless $BASE is the zero SHA the first push of a
+// branch reports as its previous commit. BASE is then the empty tree, so that
+// every module is affected.
+func firstPushGuard(indent string) string {
+	return strings.Join([]string{
+		"MERGE_BASE=--merge-base",
+		`if [ "$BASE" = 0000000000000000000000000000000000000000 ]; then`,
+		`  BASE=$(git hash-object -t tree /dev/null) MERGE_BASE=`,
+		"fi",
+	}, "\n"+indent)
+}
+
+// starterWorkflow returns a GitHub Actions workflow testing the modules
+// affected by a pull request or push, one matrix job per module
+func starterWorkflow(base string) string {
+	return `# Generated by knit init
+name: knit
+
+on:
+  pull_request:
+  push:
+    branches: [` + base + `]
+
+jobs:
+  affected:
+    runs-on: ubuntu-latest
+    outputs:
+      matrix: ${{ steps.affected.outputs.matrix }}
+      any: ${{ steps.affected.outputs.any }}
+    steps:
+      - uses: actions/checkout@v4
+        with:
+          fetch-depth: 0
+      - uses: actions/setup-go@v5
+        with:
+          go-version-file: go.work
+      - run: go install github.com/nicolasgere/knit@latest
+      - id: affected
+        env:
+          BASE: ${{ github.event_name == 'pull_request' && format('origin/{0}', github.base_ref) || github.event.before }}
+        run: |
+          ` + firstPushGuard("          ") + `
+          matrix=$(knit affected $MERGE_BASE --base "$BASE" -f github-matrix)
+          echo "matrix=$matrix" >> "$GITHUB_OUTPUT"
+          if [ "$matrix" = '{"module":[]}' ]; then echo "any=false"; else echo "any=true"; fi >> "$GITHUB_OUTPUT"
+
+  test:
+    needs: affected
+    if: needs.affected.outputs.any == 'true'
+    runs-on: ubuntu-latest
+    strategy:
+      fail-fast: false
+      matrix: ${{ fromJson(needs.affected.outputs.matrix) }}
+    name: test ${{ matrix.module.name }}
+    steps:
+      - uses: actions/checkout@v4
+      - uses: actions/setup-go@v5
+        with:
+          go-version-file: go.work
+      - run: go install github.com/nicolasgere/knit@latest
+      - run: knit test -t ${{ matrix.module.path }}
+`
+}
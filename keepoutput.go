@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/daemon"
+	"github.com/nicolasgere/knit/lib/history"
+)
+
+// keepRunOutput gathers what a run of a task leaves behind into a new
+// directory of dir named after the task and the time of the run, and returns
+// it: report.json, the report of --report, the logs of 'knit logs' as
+// logs/<module>.log, the coverage profiles as coverage/<module>/coverage.out
+// when cover is set, and history.json, the durations of the modules and of
+// the tests the next runs are ordered by
+func keepRunOutput(dir, workspaceRoot string, report daemon.Report, modules []analyzer.Module, cover bool) (string, error) {
+	out := filepath.Join(dir, fmt.Sprintf("%s-%s", url.PathEscape(report.Task), time.Now().UTC().Format("20060102T150405Z")))
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", out, err)
+	}
+	if err := writeRunReport(filepath.Join(out, "report.json"), report); err != nil {
+		return "", err
+	}
+
+	logs := taskLogsDir(workspaceRoot, report.Task)
+	for _, m := range modules {
+		name := filepath.FromSlash(m.Path)
+		if err := keepFile(filepath.Join(logs, url.PathEscape(m.Path)+".log"), filepath.Join(out, "logs", name+".log")); err != nil {
+			return "", err
+		}
+		if cover {
+			if err := keepFile(filepath.Join(m.Dir, coverProfile), filepath.Join(out, "coverage", name, coverProfile)); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := keepFile(filepath.Join(workspaceRoot, history.Dir, "history.json"), filepath.Join(out, "history.json")); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// keepFile copies src to dst, creating its directory, unless src does not
+// exist
+func keepFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+			err = os.WriteFile(dst, data, 0644)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to keep %s: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package analyzer
+
+import (
+	"fmt"
+	"go/version"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+)
+
+// ListRequirements reads the go.mod of each module and returns its direct
+// requirements on modules outside the workspace, keyed by module path
+func ListRequirements(modules []Module) (map[string][]Requirement, error) {
+	workspaceModules := make(map[string]bool, len(modules))
+	for _, m := range modules {
+		workspaceModules[m.Path] = true
+	}
+
+	requirements := make(map[string][]Requirement, len(modules))
+	for _, m := range modules {
+		data, err := os.ReadFile(m.GoMod)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", m.GoMod, err)
+		}
+		f, err := modfile.ParseLax(m.GoMod, data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", m.GoMod, err)
+		}
+
+		reqs := make([]Requirement, 0, len(f.Require))
+		for _, r := range f.Require {
+			if r.Indirect || workspaceModules[r.Mod.Path] {
+				continue
+			}
+			reqs = append(reqs, Requirement{Path: r.Mod.Path, Version: r.Mod.Version})
+		}
+		requirements[m.Path] = reqs
+	}
+	return requirements, nil
+}
+
+// SetRequirement rewrites the requirement on modulePath in a go.mod file to
+// version, keeping the rest of the file as is
+func SetRequirement(goModFile, modulePath, version string) error {
+	data, err := os.ReadFile(goModFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", goModFile, err)
+	}
+	f, err := modfile.Parse(goModFile, data, nil)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", goModFile, err)
+	}
+	if err := f.AddRequire(modulePath, version); err != nil {
+		return fmt.Errorf("failed to update %s in %s: %w", modulePath, goModFile, err)
+	}
+	f.Cleanup()
+
+	out, err := f.Format()
+	if err != nil {
+		return fmt.Errorf("failed to format %s: %w", goModFile, err)
+	}
+	if err := os.WriteFile(goModFile, out, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", goModFile, err)
+	}
+	return nil
+}
+
+// GoDirectives are the go and toolchain directives of a go.mod or go.work file
+type GoDirectives struct {
+	Go        string
+	Toolchain string
+}
+
+// goFile is the part of modfile.File and modfile.WorkFile used to edit
+// their go and toolchain directives
+type goFile interface {
+	AddGoStmt(version string) error
+	AddToolchainStmt(name string) error
+	DropToolchainStmt()
+	Cleanup()
+}
+
+// parseGoFile parses a go.mod file, or a go.work file when named so
+func parseGoFile(file string) (goFile, GoDirectives, *modfile.FileSyntax, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, GoDirectives{}, nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	var d GoDirectives
+	if filepath.Base(file) == "go.work" {
+		f, err := modfile.ParseWork(file, data, nil)
+		if err != nil {
+			return nil, d, nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if f.Go != nil {
+			d.Go = f.Go.Version
+		}
+		if f.Toolchain != nil {
+			d.Toolchain = f.Toolchain.Name
+		}
+		return f, d, f.Syntax, nil
+	}
+
+	f, err := modfile.Parse(file, data, nil)
+	if err != nil {
+		return nil, d, nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if f.Go != nil {
+		d.Go = f.Go.Version
+	}
+	if f.Toolchain != nil {
+		d.Toolchain = f.Toolchain.Name
+	}
+	return f, d, f.Syntax, nil
+}
+
+// ReadGoDirectives returns the go and toolchain directives of a go.mod or
+// go.work file, empty when missing
+func ReadGoDirectives(file string) (GoDirectives, error) {
+	_, d, _, err := parseGoFile(file)
+	return d, err
+}
+
+// SetGoDirectives rewrites the go directive of a go.mod or go.work file, and
+// its toolchain directive when d.Toolchain is set. An existing toolchain
+// older than the new go version is dropped, as the go command would.
+func SetGoDirectives(file string, d GoDirectives) error {
+	f, current, syntax, err := parseGoFile(file)
+	if err != nil {
+		return err
+	}
+
+	if err := f.AddGoStmt(d.Go); err != nil {
+		return fmt.Errorf("failed to set go %s in %s: %w", d.Go, file, err)
+	}
+	toolchain := d.Toolchain
+	if toolchain == "" {
+		toolchain = current.Toolchain
+	}
+	if toolchain != "" && toolchain != "default" && version.Compare(toolchain, "go"+d.Go) <= 0 {
+		toolchain = ""
+	}
+	if toolchain != "" {
+		if err := f.AddToolchainStmt(toolchain); err != nil {
+			return fmt.Errorf("failed to set toolchain %s in %s: %w", toolchain, file, err)
+		}
+	} else {
+		f.DropToolchainStmt()
+	}
+	f.Cleanup()
+
+	if err := os.WriteFile(file, modfile.Format(syntax), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
+
+// Replace is a replace directive of a go.mod file
+type Replace struct {
+	OldPath    string
+	OldVersion string
+	NewPath    string
+	NewVersion string
+}
+
+// IsLocal reports whether the replacement is a directory rather than a module
+func (r Replace) IsLocal() bool {
+	return modfile.IsDirectoryPath(r.NewPath)
+}
+
+func (r Replace) String() string {
+	old, replacement := r.OldPath, r.NewPath
+	if r.OldVersion != "" {
+		old += " " + r.OldVersion
+	}
+	if r.NewVersion != "" {
+		replacement += " " + r.NewVersion
+	}
+	return old + " => " + replacement
+}
+
+// ListReplaces reads the go.mod of each module and returns its replace
+// directives, keyed by module path
+func ListReplaces(modules []Module) (map[string][]Replace, error) {
+	replaces := make(map[string][]Replace, len(modules))
+	for _, m := range modules {
+		data, err := os.ReadFile(m.GoMod)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", m.GoMod, err)
+		}
+		f, err := modfile.Parse(m.GoMod, data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", m.GoMod, err)
+		}
+
+		rs := make([]Replace, 0, len(f.Replace))
+		for _, r := range f.Replace {
+			rs = append(rs, Replace{OldPath: r.Old.Path, OldVersion: r.Old.Version, NewPath: r.New.Path, NewVersion: r.New.Version})
+		}
+		replaces[m.Path] = rs
+	}
+	return replaces, nil
+}
+
+// DropReplaces removes replace directives from a go.mod file
+func DropReplaces(goModFile string, replaces []Replace) error {
+	data, err := os.ReadFile(goModFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", goModFile, err)
+	}
+	f, err := modfile.Parse(goModFile, data, nil)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", goModFile, err)
+	}
+	for _, r := range replaces {
+		if err := f.DropReplace(r.OldPath, r.OldVersion); err != nil {
+			return fmt.Errorf("failed to drop replace of %s in %s: %w", r.OldPath, goModFile, err)
+		}
+	}
+	f.Cleanup()
+
+	out, err := f.Format()
+	if err != nil {
+		return fmt.Errorf("failed to format %s: %w", goModFile, err)
+	}
+	if err := os.WriteFile(goModFile, out, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", goModFile, err)
+	}
+	return nil
+}
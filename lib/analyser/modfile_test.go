@@ -0,0 +1,143 @@
+package analyzer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListRequirements(t *testing.T) {
+	dir := t.TempDir()
+	goMod := filepath.Join(dir, "go.mod")
+	content := `module example.com/app
+
+go 1.22
+
+require (
+	example.com/core v0.0.0
+	github.com/urfave/cli/v2 v2.27.2
+	golang.org/x/sys v0.29.0 // indirect
+)
+`
+	if err := os.WriteFile(goMod, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	modules := []Module{
+		{Path: "example.com/app", Dir: dir, GoMod: goMod},
+		{Path: "example.com/core", Dir: dir, GoMod: goMod},
+	}
+	reqs, err := ListRequirements(modules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Workspace modules and indirect requirements are skipped
+	got := reqs["example.com/app"]
+	if len(got) != 1 || got[0].Path != "github.com/urfave/cli/v2" || got[0].Version != "v2.27.2" {
+		t.Errorf("expected only github.com/urfave/cli/v2 v2.27.2, got %v", got)
+	}
+}
+
+func TestSetRequirement(t *testing.T) {
+	dir := t.TempDir()
+	goMod := filepath.Join(dir, "go.mod")
+	content := `module example.com/app
+
+go 1.22
+
+require (
+	// command line parsing
+	github.com/urfave/cli/v2 v2.25.0
+	golang.org/x/sys v0.29.0 // indirect
+)
+`
+	if err := os.WriteFile(goMod, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetRequirement(goMod, "github.com/urfave/cli/v2", "v2.27.2"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(goMod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(content, "v2.25.0", "v2.27.2", 1)
+	if string(data) != want {
+		t.Errorf("unexpected go.mod:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestSetGoDirectives(t *testing.T) {
+	dir := t.TempDir()
+	goMod := filepath.Join(dir, "go.mod")
+	goWork := filepath.Join(dir, "go.work")
+	if err := os.WriteFile(goMod, []byte("module example.com/app\n\ngo 1.21\n\ntoolchain go1.22.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(goWork, []byte("go 1.21\n\nuse ./app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The toolchain is kept while newer than the go version
+	if err := SetGoDirectives(goMod, GoDirectives{Go: "1.22"}); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := ReadGoDirectives(goMod); err != nil || d != (GoDirectives{Go: "1.22", Toolchain: "go1.22.1"}) {
+		t.Errorf("unexpected directives %+v, %v", d, err)
+	}
+
+	if err := SetGoDirectives(goMod, GoDirectives{Go: "1.23"}); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := ReadGoDirectives(goMod); err != nil || d != (GoDirectives{Go: "1.23"}) {
+		t.Errorf("expected the outdated toolchain to be dropped, got %+v, %v", d, err)
+	}
+
+	if err := SetGoDirectives(goWork, GoDirectives{Go: "1.23", Toolchain: "go1.23.4"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(goWork)
+	if string(data) != "go 1.23\n\ntoolchain go1.23.4\n\nuse ./app\n" {
+		t.Errorf("unexpected go.work:\n%s", data)
+	}
+}
+
+func TestReplaces(t *testing.T) {
+	dir := t.TempDir()
+	goMod := filepath.Join(dir, "go.mod")
+	content := `module example.com/app
+
+go 1.22
+
+replace (
+	example.com/core => ../core
+	github.com/urfave/cli/v2 v2.25.0 => github.com/fork/cli/v2 v2.25.1
+)
+`
+	if err := os.WriteFile(goMod, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modules := []Module{{Path: "example.com/app", Dir: dir, GoMod: goMod}}
+
+	replaces, err := ListReplaces(modules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := replaces["example.com/app"]
+	if len(got) != 2 || !got[0].IsLocal() || got[1].IsLocal() {
+		t.Fatalf("unexpected replaces %+v", got)
+	}
+	if got[1].String() != "github.com/urfave/cli/v2 v2.25.0 => github.com/fork/cli/v2 v2.25.1" {
+		t.Errorf("unexpected string %q", got[1].String())
+	}
+
+	if err := DropReplaces(goMod, got[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if replaces, err := ListReplaces(modules); err != nil || len(replaces["example.com/app"]) != 1 {
+		t.Errorf("expected one replace left, got %+v, %v", replaces, err)
+	}
+}
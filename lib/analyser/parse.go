@@ -1,19 +1,36 @@
 package analyzer
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/dominikbraun/graph"
+	"github.com/nicolasgere/knit/lib/runner"
 )
 
 // ListModule discovers all modules in a Go workspace using `go list -m -json`
 func ListModule(dir string) (modules []Module, err error) {
-	output, err := runCommand(dir, "go list -m -json")
+	return ListModuleContext(context.Background(), dir)
+}
+
+// ListModuleContext is ListModule, killing go list when ctx is done
+func ListModuleContext(ctx context.Context, dir string) (modules []Module, err error) {
+	output, err := runCommandContext(ctx, dir, "go list -m -json")
+	if err != nil && strings.Contains(err.Error(), "inconsistent vendoring") {
+		// See ListPackagesFor
+		output, err = runCommandContext(ctx, dir, "go list -m -json "+noVendorFlag(dir))
+	}
 	if err != nil {
 		return
 	}
@@ -29,9 +46,36 @@ func ListModule(dir string) (modules []Module, err error) {
 	return
 }
 
+// WorkFile returns the go.work file used in dir, or an empty string when
+// dir is not in a workspace
+func WorkFile(dir string) (string, error) {
+	output, err := runCommand(dir, "go env GOWORK")
+	if err != nil {
+		return "", err
+	}
+	file := strings.TrimSpace(output)
+	if file == "off" {
+		return "", nil
+	}
+	return file, nil
+}
+
 // ListPackages lists all packages in the workspace using `go list -json`
 // For workspaces, it queries each module directory explicitly
 func ListPackages(workspaceRoot string, modules []Module) (packages []Package, err error) {
+	return ListPackagesContext(context.Background(), workspaceRoot, modules)
+}
+
+// ListPackagesContext is ListPackages, killing go list when ctx is done. One
+// go list runs per module, concurrently through a runner, and the packages
+// are decoded as they are printed. They are returned in module order.
+func ListPackagesContext(ctx context.Context, workspaceRoot string, modules []Module) (packages []Package, err error) {
+	return ListPackagesFor(ctx, workspaceRoot, modules, BuildContext{})
+}
+
+// ListPackagesFor is ListPackagesContext listing the packages for the build
+// configuration b rather than the host
+func ListPackagesFor(ctx context.Context, workspaceRoot string, modules []Module, b BuildContext) (packages []Package, err error) {
 	if len(modules) == 0 {
 		return nil, nil
 	}
@@ -42,7 +86,149 @@ func ListPackages(workspaceRoot string, modules []Module) (packages []Package, e
 		absWorkspaceRoot = workspaceRoot
 	}
 
-	// Build the list of module paths to query (relative to workspace root)
+	results, errs := listModulePackages(ctx, absWorkspaceRoot, modules, b, "")
+
+	// A vendor directory out of date with go.mod makes go list fail rather
+	// than fall back to the module cache, which these modules are listed from
+	var stale []Module
+	var staleIdx []int
+	for i, err := range errs {
+		if err != nil && strings.Contains(err.Error(), "inconsistent vendoring") {
+			stale, staleIdx = append(stale, modules[i]), append(staleIdx, i)
+		}
+	}
+	if len(stale) > 0 {
+		retried, retryErrs := listModulePackages(ctx, absWorkspaceRoot, stale, b, " "+noVendorFlag(absWorkspaceRoot))
+		for j, i := range staleIdx {
+			results[i], errs[i] = retried[j], retryErrs[j]
+		}
+	}
+
+	for i := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		packages = append(packages, results[i]...)
+	}
+	return packages, nil
+}
+
+// listModulePackages runs go list with flags in each module, concurrently,
+// and returns the packages and error of each
+func listModulePackages(ctx context.Context, absWorkspaceRoot string, modules []Module, b BuildContext, flags string) ([][]Package, []error) {
+	patterns := modulePatterns(absWorkspaceRoot, modules)
+	tasks := make([]runner.Task, len(patterns))
+	for i, pattern := range patterns {
+		tasks[i] = runner.Task{Id: modules[i].Path, Root: absWorkspaceRoot, Cmd: "go list -json" + flags + b.flags() + " " + pattern, Env: b.env()}
+	}
+	r := runner.NewRunner(ctx, runtime.GOMAXPROCS(0)).Quiet()
+	futures := r.RunTasks(tasks)
+
+	results := make([][]Package, len(futures))
+	errs := make([]error, len(futures))
+	var wg sync.WaitGroup
+	for i, tf := range futures {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = decodePackages(tf, tasks[i].Cmd)
+		}()
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// noVendorFlag returns the -mod flag making go list ignore the vendor
+// directory: -mod=mod for a module, -mod=readonly in workspace mode, the only
+// value other than vendor it allows
+func noVendorFlag(absWorkspaceRoot string) string {
+	if os.Getenv("GOWORK") != "off" {
+		if _, err := os.Stat(filepath.Join(absWorkspaceRoot, "go.work")); err == nil {
+			return "-mod=readonly"
+		}
+	}
+	return "-mod=mod"
+}
+
+// decodePackages decodes the packages go list prints on the stdout of tf,
+// line by line as they arrive
+func decodePackages(tf *runner.TaskFuture, command string) ([]Package, error) {
+	pr, pw := io.Pipe()
+	type decoded struct {
+		packages []Package
+		err      error
+	}
+	done := make(chan decoded, 1)
+	go func() {
+		var result decoded
+		d := json.NewDecoder(pr)
+		for d.More() {
+			var p Package
+			if result.err = d.Decode(&p); result.err != nil {
+				// Keep reading so that the output loop never blocks
+				io.Copy(io.Discard, pr)
+				break
+			}
+			result.packages = append(result.packages, p)
+		}
+		done <- result
+	}()
+
+	var stderr bytes.Buffer
+	stdout, errout := tf.Stdout, tf.Stderr
+	for stdout != nil || errout != nil {
+		select {
+		case line, ok := <-stdout:
+			if !ok {
+				stdout = nil
+				continue
+			}
+			pw.Write(append(line, '\n'))
+		case line, ok := <-errout:
+			if !ok {
+				errout = nil
+				continue
+			}
+			stderr.Write(append(line, '\n'))
+		}
+	}
+	pw.Close()
+	status := <-tf.Done
+	result := <-done
+
+	if status.Status != 0 || status.Err != nil {
+		return nil, fmt.Errorf("command execution failed: %s: %v\nOutput: %s", command, status.Err, stderr.Bytes())
+	}
+	if result.err != nil {
+		return nil, fmt.Errorf("failed to decode the output of %s: %w", command, result.err)
+	}
+	return result.packages, nil
+}
+
+// flags returns the go list flags selecting the build tags of b, quoted for
+// the shell and with a leading space
+func (b BuildContext) flags() string {
+	if len(b.Tags) == 0 {
+		return ""
+	}
+	return " '-tags=" + strings.ReplaceAll(strings.Join(b.Tags, ","), "'", `'\''`) + "'"
+}
+
+// env returns the variables selecting the target of b
+func (b BuildContext) env() []string {
+	var env []string
+	if b.GOOS != "" {
+		env = append(env, "GOOS="+b.GOOS)
+	}
+	if b.GOARCH != "" {
+		env = append(env, "GOARCH="+b.GOARCH)
+	}
+	return env
+}
+
+// modulePatterns returns the package patterns matching every package of
+// each module, relative to the workspace root
+func modulePatterns(absWorkspaceRoot string, modules []Module) []string {
 	var patterns []string
 	for _, m := range modules {
 		// Get relative path from workspace root to module
@@ -53,50 +239,132 @@ func ListPackages(workspaceRoot string, modules []Module) (packages []Package, e
 		}
 		patterns = append(patterns, "./"+relPath+"/...")
 	}
+	return patterns
+}
+
+// ListDependencies returns the modules outside the workspace providing the
+// packages that workspace packages import, directly or not, sorted by path.
+// Test-only dependencies are not included.
+func ListDependencies(workspaceRoot string, modules []Module) ([]Module, error) {
+	if len(modules) == 0 {
+		return nil, nil
+	}
+	absWorkspaceRoot, err := filepath.Abs(workspaceRoot)
+	if err != nil {
+		absWorkspaceRoot = workspaceRoot
+	}
 
-	// Query all modules in a single go list command
-	cmd := "go list -json " + strings.Join(patterns, " ")
+	workspaceModules := make(map[string]bool, len(modules))
+	for _, m := range modules {
+		workspaceModules[m.Path] = true
+	}
+
+	format := `'{{with .Module}}{{.Path}}{{"\t"}}{{.Version}}{{"\t"}}{{.Dir}}{{end}}'`
+	cmd := "go list -deps -f " + format + " " + strings.Join(modulePatterns(absWorkspaceRoot, modules), " ")
 	output, err := runCommand(absWorkspaceRoot, cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	d := json.NewDecoder(strings.NewReader(output))
-	for d.More() {
-		var p Package
-		if err = d.Decode(&p); err != nil {
-			return nil, err
+	seen := make(map[string]bool)
+	var deps []Module
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || workspaceModules[fields[0]] || seen[fields[0]] {
+			continue
 		}
-		packages = append(packages, p)
+		seen[fields[0]] = true
+		deps = append(deps, Module{Path: fields[0], Version: fields[1], Dir: fields[2]})
 	}
-	return packages, nil
+	sort.Slice(deps, func(i, j int) bool { return deps[i].Path < deps[j].Path })
+	return deps, nil
 }
 
 // BuildDependencyGraph builds a directed acyclic graph of module dependencies
 // by analyzing package imports across the workspace
 func BuildDependencyGraph(modules []Module) (*graph.Graph[string, string], error) {
+	if len(modules) == 0 {
+		return GraphFromImports(modules, nil), nil
+	}
+
+	moduleImports, err := ListModuleImports(modules)
+	if err != nil {
+		return nil, err
+	}
+	return GraphFromImports(modules, moduleImports), nil
+}
+
+// GraphFromImports builds the dependency graph of modules from the module
+// imports returned by ListModuleImports
+func GraphFromImports(modules []Module, moduleImports map[string]map[string][]Import) *graph.Graph[string, string] {
 	g := graph.New(graph.StringHash, graph.Directed(), graph.Acyclic())
 
-	// Build a set of workspace module paths for quick lookup
-	workspaceModules := make(map[string]bool)
 	for _, m := range modules {
-		workspaceModules[m.Path] = true
 		if err := g.AddVertex(m.Path); err != nil {
 			// Vertex may already exist, ignore
 		}
 	}
 
-	// We need a workspace root - use the first module's parent or find go.work
-	// For now, use the directory of the first module (assumes it's the main module)
+	// Add edges to the graph
+	for srcModule, deps := range moduleImports {
+		for depModule := range deps {
+			if err := g.AddEdge(srcModule, depModule); err != nil {
+				// Edge may already exist or would create cycle, ignore
+			}
+		}
+	}
+
+	return &g
+}
+
+// ListModuleImports analyzes package imports across the workspace and returns,
+// for each workspace module, the workspace modules it depends on along with
+// the package imports creating each dependency. The imports of _test.go files
+// are included, with Test set unless a non-test file has the same import.
+func ListModuleImports(modules []Module) (map[string]map[string][]Import, error) {
+	return ListModuleImportsContext(context.Background(), modules)
+}
+
+// ListModuleImportsContext is ListModuleImports, killing go list when ctx is done
+func ListModuleImportsContext(ctx context.Context, modules []Module) (map[string]map[string][]Import, error) {
+	return ListModuleImportsFor(ctx, modules, BuildContext{})
+}
+
+// ListModuleImportsFor is ListModuleImportsContext for the build
+// configuration b: imports of files excluded by its build constraints do not
+// create dependencies
+func ListModuleImportsFor(ctx context.Context, modules []Module, b BuildContext) (map[string]map[string][]Import, error) {
+	a, err := AnalyzeModules(ctx, modules, b)
+	if err != nil {
+		return nil, err
+	}
+	return a.Imports, nil
+}
+
+// AnalyzeModules lists the packages of the modules for the build
+// configuration b, once, and returns their imports and embedded files
+func AnalyzeModules(ctx context.Context, modules []Module, b BuildContext) (*Analysis, error) {
+	// Build a set of workspace module paths for quick lookup
+	workspaceModules := make(map[string]bool)
+	for _, m := range modules {
+		workspaceModules[m.Path] = true
+	}
+
+	// Track dependencies: module -> dependent module -> imports
+	moduleImports := make(map[string]map[string][]Import)
+	for _, m := range modules {
+		moduleImports[m.Path] = make(map[string][]Import)
+	}
+
 	if len(modules) == 0 {
-		return &g, nil
+		return &Analysis{Imports: moduleImports}, nil
 	}
 
 	// Find workspace root by looking for go.work or use the main module's dir
 	workspaceRoot := findWorkspaceRoot(modules)
 
 	// Get all packages in the workspace
-	packages, err := ListPackages(workspaceRoot, modules)
+	packages, err := ListPackagesFor(ctx, workspaceRoot, modules, b)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list packages: %w", err)
 	}
@@ -110,12 +378,6 @@ func BuildDependencyGraph(modules []Module) (*graph.Graph[string, string], error
 		}
 	}
 
-	// Track dependencies: module -> set of dependent modules
-	moduleDeps := make(map[string]map[string]bool)
-	for _, m := range modules {
-		moduleDeps[m.Path] = make(map[string]bool)
-	}
-
 	// Analyze each package's imports
 	for _, pkg := range packages {
 		if pkg.Module == nil {
@@ -128,25 +390,71 @@ func BuildDependencyGraph(modules []Module) (*graph.Graph[string, string], error
 			continue
 		}
 
-		for _, imp := range pkg.Imports {
+		imported := make(map[string]bool, len(pkg.Imports))
+		add := func(imp string, test bool) {
 			// Find which module this import belongs to
 			depModule := findModuleForImport(imp, importToModule, workspaceModules)
-			if depModule != "" && depModule != srcModule {
-				moduleDeps[srcModule][depModule] = true
+			if depModule == "" || depModule == srcModule || imported[imp] {
+				return
 			}
+			imported[imp] = true
+			moduleImports[srcModule][depModule] = append(moduleImports[srcModule][depModule], Import{
+				Package:  pkg.ImportPath,
+				Imported: imp,
+				Test:     test,
+			})
+		}
+		for _, imp := range pkg.Imports {
+			add(imp, false)
+		}
+		if b.NoTests {
+			continue
+		}
+		for _, imp := range append(append([]string{}, pkg.TestImports...), pkg.XTestImports...) {
+			add(imp, true)
 		}
 	}
 
-	// Add edges to the graph
-	for srcModule, deps := range moduleDeps {
-		for depModule := range deps {
-			if err := g.AddEdge(srcModule, depModule); err != nil {
-				// Edge may already exist or would create cycle, ignore
+	embeds, nonGoFiles := moduleFiles(packages, modules)
+	return &Analysis{Imports: moduleImports, Embeds: embeds, NonGoFiles: nonGoFiles}, nil
+}
+
+// moduleFiles collects the //go:embed patterns and the non-Go files of the
+// packages by module
+func moduleFiles(packages []Package, modules []Module) (embeds, nonGoFiles map[string][]string) {
+	dirs := make(map[string]string, len(modules))
+	for _, m := range modules {
+		dirs[m.Path] = m.Dir
+	}
+	embeds = make(map[string][]string)
+	nonGoFiles = make(map[string][]string)
+	for _, pkg := range packages {
+		if pkg.Module == nil {
+			continue
+		}
+		dir, ok := dirs[pkg.Module.Path]
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(dir, pkg.Dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range pkg.NonGoFiles() {
+			nonGoFiles[pkg.Module.Path] = append(nonGoFiles[pkg.Module.Path], path.Join(filepath.ToSlash(rel), file))
+		}
+		seen := make(map[string]bool)
+		for _, patterns := range [][]string{pkg.EmbedPatterns, pkg.TestEmbedPatterns, pkg.XTestEmbedPatterns} {
+			for _, pattern := range patterns {
+				pattern = path.Join(filepath.ToSlash(rel), strings.TrimPrefix(pattern, "all:"))
+				if !seen[pattern] {
+					seen[pattern] = true
+					embeds[pkg.Module.Path] = append(embeds[pkg.Module.Path], pattern)
+				}
 			}
 		}
 	}
-
-	return &g, nil
+	return embeds, nonGoFiles
 }
 
 // findWorkspaceRoot finds the workspace root directory by looking for go.work
@@ -215,7 +523,11 @@ func GetDependencyPaths(g *graph.Graph[string, string], vertex string) ([]string
 }
 
 func runCommand(dir, command string) (output string, err error) {
-	cmd := exec.Command("sh", "-c", command)
+	return runCommandContext(context.Background(), dir, command)
+}
+
+func runCommandContext(ctx context.Context, dir, command string) (output string, err error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
 	cmd.Dir = dir
 	var outputBytes []byte
 	outputBytes, err = cmd.CombinedOutput()
@@ -1,7 +1,12 @@
 package analyzer
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -41,6 +46,182 @@ func TestListPackages(t *testing.T) {
 	}
 }
 
+func TestListPackagesPerModule(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.work", "go 1.22.4\n\nuse (\n\t./a\n\t./b\n)\n")
+	write("a/go.mod", "module example.com/a\n\ngo 1.22.4\n")
+	write("a/a.go", "package a\n")
+	write("a/sub/sub.go", "package sub\n")
+	write("b/go.mod", "module example.com/b\n\ngo 1.22.4\n")
+	write("b/b.go", "package b\n\nimport _ \"example.com/a\"\n")
+	modules, err := ListModule(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packages, err := ListPackages(root, modules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, p := range packages {
+		paths = append(paths, p.ImportPath)
+	}
+	if got := strings.Join(paths, ","); got != "example.com/a,example.com/a/sub,example.com/b" {
+		t.Errorf("expected the packages in module order, got %s", got)
+	}
+
+	// The module failing to list is named in the error
+	write("b/b.go", "package b\n\nimport (\n")
+	if _, err := ListPackages(root, modules); err == nil || !strings.Contains(err.Error(), "./b/...") {
+		t.Errorf("expected b to fail, got %v", err)
+	}
+}
+
+func TestListModuleImportsFor(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.work", "go 1.22.4\n\nuse (\n\t./a\n\t./b\n\t./c\n)\n")
+	write("a/go.mod", "module example.com/a\n\ngo 1.22.4\n")
+	write("a/a.go", "package a\n")
+	write("b/go.mod", "module example.com/b\n\ngo 1.22.4\n")
+	write("b/b.go", "package b\n")
+	write("c/go.mod", "module example.com/c\n\ngo 1.22.4\n")
+	write("c/c.go", "package c\n")
+	write("c/integration.go", "//go:build integration\n\npackage c\n\nimport _ \"example.com/a\"\n")
+	write("c/c_plan9.go", "package c\n\nimport _ \"example.com/b\"\n")
+	modules, err := ListModule(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		build BuildContext
+		deps  string
+	}{
+		{BuildContext{}, ""},
+		{BuildContext{Tags: []string{"integration"}}, "example.com/a"},
+		{BuildContext{GOOS: "plan9"}, "example.com/b"},
+		{BuildContext{Tags: []string{"other", "integration"}, GOOS: "plan9", GOARCH: "amd64"}, "example.com/a,example.com/b"},
+	} {
+		imports, err := ListModuleImportsFor(context.Background(), modules, tc.build)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var deps []string
+		for dep := range imports["example.com/c"] {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		if got := strings.Join(deps, ","); got != tc.deps {
+			t.Errorf("%+v: expected c to depend on %q, got %q", tc.build, tc.deps, got)
+		}
+	}
+}
+
+func TestListModuleImportsTests(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.work", "go 1.22.4\n\nuse (\n\t./a\n\t./b\n)\n")
+	write("a/go.mod", "module example.com/a\n\ngo 1.22.4\n")
+	write("a/a.go", "package a\n")
+	write("a/a_test.go", "package a_test\n\nimport _ \"example.com/b\"\n")
+	write("b/go.mod", "module example.com/b\n\ngo 1.22.4\n")
+	write("b/b.go", "package b\n\nimport _ \"example.com/a\"\n")
+	write("b/b_test.go", "package b\n\nimport _ \"example.com/a\"\n")
+	modules, err := ListModule(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imports, err := ListModuleImports(modules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edge := imports["example.com/a"]["example.com/b"]; !TestOnly(edge) {
+		t.Errorf("expected a to depend on b in tests only, got %+v", edge)
+	}
+	// The test file repeats an import of the package, the edge is not test-only
+	if edge := imports["example.com/b"]["example.com/a"]; len(edge) != 1 || TestOnly(edge) {
+		t.Errorf("expected b to depend on a in its build, got %+v", edge)
+	}
+
+	for _, filtered := range []map[string]map[string][]Import{WithoutTests(imports), nil} {
+		if filtered == nil {
+			if filtered, err = ListModuleImportsFor(context.Background(), modules, BuildContext{NoTests: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(filtered["example.com/a"]) != 0 || len(filtered["example.com/b"]["example.com/a"]) != 1 {
+			t.Errorf("expected the test-only edge to be left out, got %+v", filtered)
+		}
+	}
+}
+
+func TestAnalyzeModulesFiles(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.work", "go 1.22.4\n\nuse ./a\n")
+	write("a/go.mod", "module example.com/a\n\ngo 1.22.4\n")
+	write("a/a.go", "package a\n")
+	write("a/web/web.go", "package web\n\nimport \"embed\"\n\n//go:embed all:static *.html\nvar content embed.FS\n")
+	write("a/web/web_test.go", "package web\n\nimport _ \"embed\"\n\n//go:embed testdata/golden.txt\nvar golden string\n")
+	write("a/web/index.html", "<p>index</p>\n")
+	write("a/web/static/app.css", "p {}\n")
+	write("a/web/testdata/golden.txt", "golden\n")
+	write("a/shim/shim.go", "package shim\n\n// #include \"shim.h\"\nimport \"C\"\n")
+	write("a/shim/shim.h", "int shim(void);\n")
+	write("a/shim/shim.c", "int shim(void) { return 0; }\n")
+	modules, err := ListModule(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := AnalyzeModules(context.Background(), modules, BuildContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	embeds := a.Embeds["example.com/a"]
+	sort.Strings(embeds)
+	if got := strings.Join(embeds, ","); got != "web/*.html,web/static,web/testdata/golden.txt" {
+		t.Errorf("expected the embed patterns relative to the module, got %s", got)
+	}
+	nonGo := a.NonGoFiles["example.com/a"]
+	sort.Strings(nonGo)
+	if got := strings.Join(nonGo, ","); got != "shim/shim.c,shim/shim.h" {
+		t.Errorf("expected the cgo files relative to the module, got %s", got)
+	}
+}
+
 func TestBuildDependencyGraph(t *testing.T) {
 	modules, err := ListModule("./__playground__/workspace/")
 	if err != nil {
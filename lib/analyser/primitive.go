@@ -7,6 +7,8 @@ type Module struct {
 	Dir       string `json:"Dir"`
 	GoMod     string `json:"GoMod"`
 	GoVersion string `json:"GoVersion"`
+	// Version is empty for workspace modules
+	Version string `json:"Version"`
 }
 
 // Package represents a Go package from `go list -json ./...`
@@ -16,4 +18,116 @@ type Package struct {
 	Name       string   `json:"Name"`
 	Module     *Module  `json:"Module"`
 	Imports    []string `json:"Imports"`
+	// TestImports and XTestImports are the imports of the _test.go files of
+	// the package and of its external test package
+	TestImports  []string `json:"TestImports"`
+	XTestImports []string `json:"XTestImports"`
+	GoFiles      []string `json:"GoFiles"`
+	// TestGoFiles and XTestGoFiles are the _test.go files of the package
+	TestGoFiles  []string `json:"TestGoFiles"`
+	XTestGoFiles []string `json:"XTestGoFiles"`
+	// EmbedPatterns are the //go:embed patterns of the package, relative to
+	// its directory, and EmbedFiles the files they match; the Test and XTest
+	// ones are those of its _test.go files
+	EmbedPatterns      []string `json:"EmbedPatterns"`
+	EmbedFiles         []string `json:"EmbedFiles"`
+	TestEmbedPatterns  []string `json:"TestEmbedPatterns"`
+	TestEmbedFiles     []string `json:"TestEmbedFiles"`
+	XTestEmbedPatterns []string `json:"XTestEmbedPatterns"`
+	XTestEmbedFiles    []string `json:"XTestEmbedFiles"`
+	// CFiles, CXXFiles, MFiles, HFiles, FFiles, SFiles and SysoFiles are the
+	// C, C++, Objective-C, header, Fortran, assembly and object files built
+	// into the package
+	CFiles    []string `json:"CFiles"`
+	CXXFiles  []string `json:"CXXFiles"`
+	MFiles    []string `json:"MFiles"`
+	HFiles    []string `json:"HFiles"`
+	FFiles    []string `json:"FFiles"`
+	SFiles    []string `json:"SFiles"`
+	SysoFiles []string `json:"SysoFiles"`
+}
+
+// NonGoFiles returns the files other than .go ones built into the package,
+// relative to its directory
+func (p Package) NonGoFiles() []string {
+	var files []string
+	for _, list := range [][]string{p.CFiles, p.CXXFiles, p.MFiles, p.HFiles, p.FFiles, p.SFiles, p.SysoFiles} {
+		files = append(files, list...)
+	}
+	return files
+}
+
+// Analysis is what the packages of the workspace modules tell about them
+type Analysis struct {
+	// Imports is the result of ListModuleImports
+	Imports map[string]map[string][]Import `json:"imports"`
+	// Embeds maps the path of every module to the //go:embed patterns of its
+	// packages and tests, relative to the module directory in slash form and
+	// without their all: prefix
+	Embeds map[string][]string `json:"embeds,omitempty"`
+	// NonGoFiles maps the path of every module to the cgo, assembly and object
+	// files of its packages, see Package.NonGoFiles, relative to the module
+	// directory in slash form
+	NonGoFiles map[string][]string `json:"nonGoFiles,omitempty"`
+}
+
+// Import is a package importing another package
+type Import struct {
+	Package  string
+	Imported string
+	// Test is set when only the _test.go files of the package import it
+	Test bool `json:",omitempty"`
+}
+
+// TestOnly reports whether the imports creating a dependency are all in
+// _test.go files, so that the dependency is not part of the build
+func TestOnly(imports []Import) bool {
+	for _, imp := range imports {
+		if !imp.Test {
+			return false
+		}
+	}
+	return len(imports) > 0
+}
+
+// WithoutTests returns the module imports of ListModuleImports without the
+// imports of _test.go files, dropping the test-only dependencies
+func WithoutTests(moduleImports map[string]map[string][]Import) map[string]map[string][]Import {
+	if moduleImports == nil {
+		return nil
+	}
+	filtered := make(map[string]map[string][]Import, len(moduleImports))
+	for src, deps := range moduleImports {
+		filtered[src] = make(map[string][]Import)
+		for dep, imports := range deps {
+			for _, imp := range imports {
+				if !imp.Test {
+					filtered[src][dep] = append(filtered[src][dep], imp)
+				}
+			}
+		}
+	}
+	return filtered
+}
+
+// Requirement is a module required by a go.mod file
+type Requirement struct {
+	Path    string
+	Version string
+}
+
+// BuildContext is the build configuration packages are listed for: their
+// files and imports are those go build selects with these build tags and
+// target. The zero value is the configuration of the host.
+type BuildContext struct {
+	Tags   []string `json:"tags,omitempty"`
+	GOOS   string   `json:"goos,omitempty"`
+	GOARCH string   `json:"goarch,omitempty"`
+	// NoTests leaves out the imports of _test.go files
+	NoTests bool `json:"noTests,omitempty"`
+}
+
+// IsZero reports whether b is the configuration of the host, tests included
+func (b BuildContext) IsZero() bool {
+	return len(b.Tags) == 0 && b.GOOS == "" && b.GOARCH == "" && !b.NoTests
 }
@@ -0,0 +1,89 @@
+package analyzer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Vendor is a vendor directory written by go mod vendor or go work vendor
+type Vendor struct {
+	// Dir is the vendor directory
+	Dir string
+	// Modules are the paths of the vendored modules, from vendor/modules.txt
+	Modules []string
+}
+
+// ReadVendor reads the vendor directory of the module or workspace in dir,
+// returning nil when dir is not vendored
+func ReadVendor(dir string) (*Vendor, error) {
+	vendorDir := filepath.Join(dir, "vendor")
+	f, err := os.Open(filepath.Join(vendorDir, "modules.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	v := &Vendor{Dir: vendorDir}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// "# path version [=> replacement]" starts the packages of a module,
+		// "## ..." lines annotate it
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "# ") {
+			continue
+		}
+		if fields := strings.Fields(line[2:]); len(fields) > 0 {
+			v.Modules = append(v.Modules, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.Name(), err)
+	}
+	return v, nil
+}
+
+// ModuleOf returns the vendored module providing the file at rel, a path
+// relative to the vendor directory in slash form, or an empty string for
+// modules.txt and the files of no vendored module
+func (v *Vendor) ModuleOf(rel string) string {
+	module := ""
+	for _, m := range v.Modules {
+		if strings.HasPrefix(rel, m+"/") && len(m) > len(module) {
+			module = m
+		}
+	}
+	return module
+}
+
+// Requiring returns the paths of the modules whose go.mod requires module,
+// directly or indirectly, in the order of modules
+func Requiring(modules []Module, module string) ([]string, error) {
+	var requiring []string
+	for _, m := range modules {
+		data, err := os.ReadFile(m.GoMod)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", m.GoMod, err)
+		}
+		f, err := modfile.ParseLax(m.GoMod, data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", m.GoMod, err)
+		}
+		for _, r := range f.Require {
+			if r.Mod.Path == module {
+				requiring = append(requiring, m.Path)
+				break
+			}
+		}
+	}
+	return requiring, nil
+}
@@ -0,0 +1,82 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadVendor(t *testing.T) {
+	root := t.TempDir()
+	if v, err := ReadVendor(root); v != nil || err != nil {
+		t.Fatalf("expected no vendor directory, got %+v (%v)", v, err)
+	}
+
+	os.MkdirAll(filepath.Join(root, "vendor"), 0755)
+	modulesTxt := "## workspace\n# example.com/dep v1.0.0\n## explicit; go 1.22\nexample.com/dep\n# example.com/dep/v2 v2.1.0 => ../dep2\n## explicit\nexample.com/dep/v2/sub\n"
+	os.WriteFile(filepath.Join(root, "vendor", "modules.txt"), []byte(modulesTxt), 0644)
+	v, err := ReadVendor(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(v.Modules); got != "[example.com/dep example.com/dep/v2]" {
+		t.Errorf("unexpected vendored modules %s", got)
+	}
+	for rel, want := range map[string]string{
+		"example.com/dep/dep.go":        "example.com/dep",
+		"example.com/dep/v2/sub/sub.go": "example.com/dep/v2",
+		"modules.txt":                   "",
+		"example.com/other/other.go":    "",
+	} {
+		if got := v.ModuleOf(rel); got != want {
+			t.Errorf("ModuleOf(%s) = %q, expected %q", rel, got, want)
+		}
+	}
+}
+
+func TestRequiring(t *testing.T) {
+	root := t.TempDir()
+	var modules []Module
+	for name, goMod := range map[string]string{
+		"a": "module example.com/a\n\nrequire example.com/dep v1.0.0\n",
+		"b": "module example.com/b\n\nrequire example.com/dep v1.0.0 // indirect\n",
+		"c": "module example.com/c\n",
+	} {
+		os.MkdirAll(filepath.Join(root, name), 0755)
+		os.WriteFile(filepath.Join(root, name, "go.mod"), []byte(goMod), 0644)
+		modules = append(modules, Module{Path: "example.com/" + name, GoMod: filepath.Join(root, name, "go.mod")})
+	}
+
+	requiring, err := Requiring(modules, "example.com/dep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requiring) != 2 {
+		t.Errorf("expected a and b to require the module, got %v", requiring)
+	}
+}
+
+func TestListPackagesInconsistentVendoring(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a"), 0755)
+	os.MkdirAll(filepath.Join(root, "vendor"), 0755)
+	os.WriteFile(filepath.Join(root, "go.work"), []byte("go 1.22.4\n\nuse ./a\n"), 0644)
+	os.WriteFile(filepath.Join(root, "a", "go.mod"), []byte("module example.com/a\n\ngo 1.22.4\n"), 0644)
+	os.WriteFile(filepath.Join(root, "a", "a.go"), []byte("package a\n"), 0644)
+	// Vendored for a requirement a dropped since
+	os.WriteFile(filepath.Join(root, "vendor", "modules.txt"), []byte("## workspace\n# example.com/dep v1.0.0\n## explicit; go 1.22\nexample.com/dep\n"), 0644)
+
+	modules, err := ListModule(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packages, err := ListPackagesFor(context.Background(), root, modules, BuildContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || packages[0].ImportPath != "example.com/a" {
+		t.Errorf("expected the package of a, got %+v", packages)
+	}
+}
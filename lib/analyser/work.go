@@ -0,0 +1,116 @@
+package analyzer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nicolasgere/knit/lib/ignore"
+	"golang.org/x/mod/modfile"
+)
+
+// FindModuleDirs walks root and returns the directories holding a go.mod
+// file, relative to root in the "./dir" form of go.work use directives.
+// Like the go command, it skips vendor and testdata directories and the
+// directories whose name starts with "." or "_". It also skips node_modules
+// and the directories ignored by git.
+func FindModuleDirs(root string) ([]string, error) {
+	var dirs []string
+	matcher := ignore.New(root)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata" || matcher.SkipDir(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, useDir(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// useDir formats a relative directory as go work use does
+func useDir(rel string) string {
+	rel = filepath.ToSlash(rel)
+	if rel == "." || strings.HasPrefix(rel, "../") {
+		return rel
+	}
+	return "./" + rel
+}
+
+// ReadWorkUses returns the directories of the use directives of a go.work file
+func ReadWorkUses(workFile string) ([]string, error) {
+	f, err := parseWorkFile(workFile)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(f.Use))
+	for _, u := range f.Use {
+		dirs = append(dirs, u.Path)
+	}
+	return dirs, nil
+}
+
+// SyncWorkUses adds use directives for dirs to a go.work file and drops
+// those in drop, creating the file with the given go version when missing
+func SyncWorkUses(workFile, goVersion string, add, drop []string) error {
+	var f *modfile.WorkFile
+	if _, err := os.Stat(workFile); os.IsNotExist(err) {
+		f = new(modfile.WorkFile)
+		f.Syntax = new(modfile.FileSyntax)
+		if err := f.AddGoStmt(goVersion); err != nil {
+			return fmt.Errorf("failed to set go %s in %s: %w", goVersion, workFile, err)
+		}
+	} else if f, err = parseWorkFile(workFile); err != nil {
+		return err
+	}
+
+	for _, dir := range drop {
+		if err := f.DropUse(dir); err != nil {
+			return fmt.Errorf("failed to drop use %s in %s: %w", dir, workFile, err)
+		}
+	}
+	for _, dir := range add {
+		if err := f.AddUse(dir, ""); err != nil {
+			return fmt.Errorf("failed to add use %s in %s: %w", dir, workFile, err)
+		}
+	}
+	f.SortBlocks()
+	f.Cleanup()
+
+	if err := os.WriteFile(workFile, modfile.Format(f.Syntax), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", workFile, err)
+	}
+	return nil
+}
+
+func parseWorkFile(workFile string) (*modfile.WorkFile, error) {
+	data, err := os.ReadFile(workFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", workFile, err)
+	}
+	f, err := modfile.ParseWork(workFile, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", workFile, err)
+	}
+	return f, nil
+}
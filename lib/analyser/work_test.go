@@ -0,0 +1,62 @@
+package analyzer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindModuleDirs(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{".", "core", "services/api", ".hidden", "_old", "core/testdata/mod", "vendor/x"} {
+		os.MkdirAll(filepath.Join(root, dir), 0755)
+		os.WriteFile(filepath.Join(root, dir, "go.mod"), []byte("module example.com/x\n"), 0644)
+	}
+
+	dirs, err := FindModuleDirs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(dirs); got != "[. ./core ./services/api]" {
+		t.Errorf("unexpected module dirs %s", got)
+	}
+
+	// node_modules and the directories ignored by git are skipped too
+	for _, dir := range []string{"web/node_modules/pkg", "build/gen", "services/api/tmp"} {
+		os.MkdirAll(filepath.Join(root, dir), 0755)
+		os.WriteFile(filepath.Join(root, dir, "go.mod"), []byte("module example.com/x\n"), 0644)
+	}
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("/build/\ntmp\n"), 0644)
+	dirs, err = FindModuleDirs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(dirs); got != "[. ./core ./services/api]" {
+		t.Errorf("expected ignored modules to be skipped, got %s", got)
+	}
+}
+
+func TestSyncWorkUses(t *testing.T) {
+	workFile := filepath.Join(t.TempDir(), "go.work")
+
+	// A missing go.work is created
+	if err := SyncWorkUses(workFile, "1.22", []string{"./core", "./api"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(workFile)
+	if string(data) != "go 1.22\n\nuse (\n\t./api\n\t./core\n)\n" {
+		t.Errorf("unexpected go.work:\n%s", data)
+	}
+
+	if err := SyncWorkUses(workFile, "1.22", []string{"./utils"}, []string{"./api"}); err != nil {
+		t.Fatal(err)
+	}
+	uses, err := ReadWorkUses(workFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(uses); got != "[./core ./utils]" {
+		t.Errorf("unexpected uses %s", got)
+	}
+}
@@ -0,0 +1,74 @@
+package apicheck
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/apidiff"
+	"golang.org/x/tools/go/packages"
+)
+
+// Change is a difference between two versions of a module's exported API
+type Change struct {
+	Message    string
+	Compatible bool
+}
+
+// Compare loads the public packages of the module rooted at oldDir and at
+// newDir and returns the changes of the exported API, incompatible first.
+// Main and internal packages are not part of the API.
+func Compare(modulePath, oldDir, newDir string) ([]Change, error) {
+	oldModule, err := load(modulePath, oldDir)
+	if err != nil {
+		return nil, err
+	}
+	newModule, err := load(modulePath, newDir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := apidiff.ModuleChanges(oldModule, newModule)
+	changes := make([]Change, 0, len(report.Changes))
+	for _, compatible := range []bool{false, true} {
+		for _, c := range report.Changes {
+			if c.Compatible == compatible {
+				changes = append(changes, Change{Message: c.Message, Compatible: c.Compatible})
+			}
+		}
+	}
+	return changes, nil
+}
+
+func load(modulePath, dir string) (*apidiff.Module, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedTypes | packages.NeedImports | packages.NeedDeps,
+		Dir:  dir,
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages in %s: %w", dir, err)
+	}
+
+	module := &apidiff.Module{Path: modulePath}
+	for _, p := range pkgs {
+		if len(p.Errors) > 0 {
+			return nil, fmt.Errorf("failed to load %s: %v", p.PkgPath, p.Errors[0])
+		}
+		if p.Name == "main" || isInternal(p.PkgPath) {
+			continue
+		}
+		module.Packages = append(module.Packages, p.Types)
+	}
+	return module, nil
+}
+
+// isInternal reports whether a package path has an internal element, which
+// makes it unimportable from other modules
+func isInternal(pkgPath string) bool {
+	for _, elem := range strings.Split(pkgPath, "/") {
+		if elem == "internal" {
+			return true
+		}
+	}
+	return false
+}
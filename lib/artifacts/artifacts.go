@@ -0,0 +1,171 @@
+// Package artifacts finds and copies the files declared as the outputs of a
+// task in knit.yaml
+package artifacts
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Validate checks that pattern is a valid output glob relative to a module
+// directory
+func Validate(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty output pattern")
+	}
+	clean := path.Clean(filepath.ToSlash(pattern))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("output %q must stay inside the module directory", pattern)
+	}
+	if _, err := path.Match(clean, ""); err != nil {
+		return fmt.Errorf("invalid output %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// Match returns the files below dir matching one of the patterns, as sorted
+// slash-separated paths relative to dir. Patterns are path.Match globs over
+// those paths where a "**" element matches any number of directories, and a
+// pattern matching a directory matches every file below it. Nested modules,
+// directories with a go.mod file, are skipped and a missing dir matches
+// nothing.
+func Match(dir string, patterns []string) ([]string, error) {
+	split := make([][]string, len(patterns))
+	for i, p := range patterns {
+		if err := Validate(p); err != nil {
+			return nil, err
+		}
+		split[i] = strings.Split(path.Clean(filepath.ToSlash(p)), "/")
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if file == dir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if file == dir {
+			return nil
+		}
+		if d.IsDir() {
+			if _, err := os.Stat(filepath.Join(file, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		elems := strings.Split(filepath.ToSlash(rel), "/")
+		if d.IsDir() {
+			// A matching directory is walked as any other, its files are
+			// selected by the pattern matching one of their directories
+			if d.Name() == ".git" && !matchAny(split, elems) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && selected(split, elems) {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// MatchPath reports whether Match would select the file at a slash-separated
+// relative path with pattern, either matching the file or a directory above it
+func MatchPath(pattern, name string) bool {
+	split := strings.Split(path.Clean(filepath.ToSlash(pattern)), "/")
+	return selected([][]string{split}, strings.Split(path.Clean(name), "/"))
+}
+
+// selected reports whether one of the patterns matches the path or one of
+// the directories above it
+func selected(patterns [][]string, elems []string) bool {
+	for i := 1; i <= len(elems); i++ {
+		if matchAny(patterns, elems[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether one of the patterns matches the path
+func matchAny(patterns [][]string, elems []string) bool {
+	for _, p := range patterns {
+		if match(p, elems) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the elements of a path match those of a pattern
+func match(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elems); i++ {
+			if match(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], elems[0])
+	return ok && match(pattern[1:], elems[1:])
+}
+
+// Copy copies files, slash-separated paths relative to src, into dst under
+// the same relative paths, keeping their permissions
+func Copy(src, dst string, files []string) error {
+	for _, f := range files {
+		if err := copyFile(filepath.Join(src, filepath.FromSlash(f)), filepath.Join(dst, filepath.FromSlash(f))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return out.Close()
+}
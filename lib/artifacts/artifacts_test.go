@@ -0,0 +1,121 @@
+package artifacts
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir,
+		"coverage.out",
+		"main.go",
+		"bin/app",
+		"bin/tools/gen",
+		"internal/api/api.pb.go",
+		"internal/api/api.go",
+		"api.pb.go",
+		".git/coverage.out",
+		"nested/go.mod",
+		"nested/coverage.out",
+		"dist/a.txt",
+		"dist/sub/b.txt",
+	)
+
+	tests := []struct {
+		patterns []string
+		expected []string
+	}{
+		{[]string{"coverage.out"}, []string{"coverage.out"}},
+		{[]string{"bin"}, []string{"bin/app", "bin/tools/gen"}},
+		{[]string{"bin/*"}, []string{"bin/app", "bin/tools/gen"}},
+		{[]string{"**/*.pb.go"}, []string{"api.pb.go", "internal/api/api.pb.go"}},
+		{[]string{"internal/**/*.go"}, []string{"internal/api/api.go", "internal/api/api.pb.go"}},
+		{[]string{"./coverage.out", "coverage.out"}, []string{"coverage.out"}},
+		{[]string{"missing/*"}, nil},
+		// Files below several matching directories are listed once
+		{[]string{"dist/**"}, []string{"dist/a.txt", "dist/sub/b.txt"}},
+		{[]string{"dist", "dist/sub", "dist/sub/b.txt"}, []string{"dist/a.txt", "dist/sub/b.txt"}},
+	}
+	for _, tt := range tests {
+		files, err := Match(dir, tt.patterns)
+		if err != nil {
+			t.Errorf("%v: %v", tt.patterns, err)
+			continue
+		}
+		if !reflect.DeepEqual(files, tt.expected) {
+			t.Errorf("%v: expected %v, got %v", tt.patterns, tt.expected, files)
+		}
+	}
+
+	if files, err := Match(filepath.Join(dir, "missing"), []string{"*"}); err != nil || len(files) != 0 {
+		t.Errorf("expected a missing directory to match nothing, got %v, %v", files, err)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		expected      bool
+	}{
+		{"proto/payments/*.proto", "proto/payments/payment.proto", true},
+		{"proto/payments/*.proto", "proto/payments/v1/payment.proto", false},
+		{"proto/**/*.proto", "proto/payments/v1/payment.proto", true},
+		{"proto/payments", "proto/payments/v1/payment.proto", true},
+		{"proto/pay", "proto/payments/payment.proto", false},
+		{"./schema.sql", "schema.sql", true},
+	}
+	for _, tt := range tests {
+		if got := MatchPath(tt.pattern, tt.name); got != tt.expected {
+			t.Errorf("MatchPath(%q, %q): expected %v, got %v", tt.pattern, tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, pattern := range []string{"", "../secrets", "/etc/passwd", "bin/[", "a/../../b"} {
+		if err := Validate(pattern); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+	for _, pattern := range []string{"bin/app", "**/*.out", "./dist", "a/../b"} {
+		if err := Validate(pattern); err != nil {
+			t.Errorf("%q: %v", pattern, err)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "artifacts")
+	writeFiles(t, src, "bin/app", "coverage.out")
+	if err := os.Chmod(filepath.Join(src, "bin", "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Copy(src, dst, []string{"bin/app", "coverage.out"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "coverage.out"))
+	if err != nil || string(data) != "coverage.out" {
+		t.Errorf("unexpected copy: %q, %v", data, err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "bin", "app"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("expected the executable to keep its mode: %v, %v", info, err)
+	}
+}
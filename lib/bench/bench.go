@@ -0,0 +1,108 @@
+// Package bench parses the output of go test -bench and compares the
+// benchmarks of two runs, such as the ones of a change and of its base.
+package bench
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Results maps a benchmark, as package.Benchmark, to the ns/op of its runs
+type Results map[string][]float64
+
+// Parse reads the output of go test -bench. The package of a benchmark is
+// the one of the last pkg: line, as go test prints it before the benchmarks
+// of every package.
+func Parse(r io.Reader) (Results, error) {
+	results := make(Results)
+	pkg := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if p, ok := strings.CutPrefix(line, "pkg: "); ok {
+			pkg = strings.TrimSpace(p)
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		// Benchmark-N iterations value unit [value unit]...
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			if fields[i+1] != "ns/op" {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			name := fields[0]
+			if pkg != "" {
+				name = pkg + "." + name
+			}
+			results[name] = append(results[name], v)
+			break
+		}
+	}
+	return results, scanner.Err()
+}
+
+// Median returns the median of the runs, less sensitive than the mean to the
+// odd slow run
+func Median(runs []float64) float64 {
+	if len(runs) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), runs...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Comparison compares a benchmark between a base run and a head run
+type Comparison struct {
+	Name string
+	// Base and Head are the median ns/op, Base is 0 for a new benchmark
+	Base, Head float64
+	// Delta is the change from Base to Head in percent
+	Delta float64
+	// Regressed is set when Delta exceeds the gate
+	Regressed bool
+}
+
+// New reports whether the benchmark has no base run
+func (c Comparison) New() bool {
+	return c.Base == 0
+}
+
+// Compare compares the benchmarks of head with the ones of base, sorted by
+// name. A benchmark regresses when it slows down by more than gate percent;
+// a gate of 0 or less never fails. Benchmarks removed in head are left out.
+func Compare(base, head Results, gate float64) []Comparison {
+	names := make([]string, 0, len(head))
+	for name := range head {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	comparisons := make([]Comparison, 0, len(names))
+	for _, name := range names {
+		c := Comparison{Name: name, Head: Median(head[name])}
+		if runs, ok := base[name]; ok && Median(runs) > 0 {
+			c.Base = Median(runs)
+			c.Delta = (c.Head - c.Base) / c.Base * 100
+			c.Regressed = gate > 0 && c.Delta > gate
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
@@ -0,0 +1,72 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+)
+
+const output = `goos: linux
+goarch: amd64
+pkg: example.com/core
+cpu: AMD EPYC
+BenchmarkAdd-8   	 1000000	       100.0 ns/op	      16 B/op	       1 allocs/op
+BenchmarkAdd-8   	 1000000	       120.0 ns/op	      16 B/op	       1 allocs/op
+BenchmarkAdd-8   	 1000000	       110.0 ns/op	      16 B/op	       1 allocs/op
+BenchmarkSub-8   	    5000	      2000 ns/op
+PASS
+ok  	example.com/core	1.2s
+pkg: example.com/core/sub
+BenchmarkSub-8   	    5000	      3000 ns/op
+--- FAIL: BenchmarkBroken
+`
+
+func TestParse(t *testing.T) {
+	results, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 benchmarks, got %v", results)
+	}
+	if runs := results["example.com/core.BenchmarkAdd-8"]; len(runs) != 3 || Median(runs) != 110 {
+		t.Errorf("expected 3 runs of BenchmarkAdd with a median of 110, got %v", runs)
+	}
+	if runs := results["example.com/core/sub.BenchmarkSub-8"]; len(runs) != 1 || runs[0] != 3000 {
+		t.Errorf("expected the BenchmarkSub of the sub package, got %v", runs)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	if m := Median([]float64{4, 1, 3, 2}); m != 2.5 {
+		t.Errorf("expected 2.5, got %v", m)
+	}
+	if m := Median(nil); m != 0 {
+		t.Errorf("expected 0 without runs, got %v", m)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := Results{"p.BenchmarkA": {100}, "p.BenchmarkB": {100}, "p.BenchmarkRemoved": {1}}
+	head := Results{"p.BenchmarkA": {104}, "p.BenchmarkB": {110}, "p.BenchmarkNew": {50}}
+
+	comparisons := Compare(base, head, 5)
+	if len(comparisons) != 3 {
+		t.Fatalf("expected 3 comparisons, got %+v", comparisons)
+	}
+	a, b, n := comparisons[0], comparisons[1], comparisons[2]
+	if a.Name != "p.BenchmarkA" || a.Regressed || a.Delta < 3.9 || a.Delta > 4.1 {
+		t.Errorf("expected BenchmarkA to slow down by 4%% within the gate, got %+v", a)
+	}
+	if b.Name != "p.BenchmarkB" || !b.Regressed {
+		t.Errorf("expected BenchmarkB to regress, got %+v", b)
+	}
+	if n.Name != "p.BenchmarkNew" || !n.New() || n.Regressed {
+		t.Errorf("expected BenchmarkNew to be new, got %+v", n)
+	}
+
+	for _, c := range Compare(base, head, 0) {
+		if c.Regressed {
+			t.Errorf("expected no gate to never regress, got %+v", c)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Package cache stores the successful results of the knit.yaml tasks with
+// cache enabled, keyed by a hash of what they depend on, so that modules whose
+// inputs did not change are not run again. Entries live in .knit/cache with
+// a copy of the declared outputs of the task, restored on a hit.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nicolasgere/knit/lib/artifacts"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/nicolasgere/knit/lib/ignore"
+)
+
+// version is part of every key, to invalidate entries when the way keys
+// are derived changes
+const version = "knit-cache-3"
+
+const (
+	entryFile = "entry.json"
+	filesDir  = "files"
+)
+
+// Cache is the task cache of a workspace
+type Cache struct {
+	dir string
+}
+
+// Entry is a successful run of a task in a module
+type Entry struct {
+	Task    string        `json:"task"`
+	Module  string        `json:"module"`
+	Created time.Time     `json:"created"`
+	Elapsed time.Duration `json:"elapsed"`
+	// Outputs are the files the run produced, relative to the module
+	// directory, restored on a hit
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+// Open returns the cache of the workspace rooted at workspaceRoot
+func Open(workspaceRoot string) *Cache {
+	return &Cache{dir: filepath.Join(workspaceRoot, history.Dir, "cache")}
+}
+
+func (c *Cache) entryDir(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// Get returns the entry stored for key, or nil when there is none
+func (c *Cache) Get(key string) (*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(c.entryDir(key), entryFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		// A corrupted entry is a miss, overwritten by the next Put
+		return nil, nil
+	}
+	return &e, nil
+}
+
+// Put stores e for key with a copy of its outputs, read from moduleDir.
+// Entries are written to a temporary directory first so that a concurrent
+// Get never sees a partial one.
+func (c *Cache) Put(key string, e *Entry, moduleDir string) error {
+	if err := os.MkdirAll(filepath.Dir(c.entryDir(key)), 0755); err != nil {
+		return fmt.Errorf("failed to create the cache: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(c.entryDir(key)), key+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create the cache entry: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := artifacts.Copy(moduleDir, filepath.Join(tmp, filesDir), e.Outputs); err != nil {
+		return fmt.Errorf("failed to cache the outputs of %s: %w", e.Module, err)
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, entryFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	if err := os.RemoveAll(c.entryDir(key)); err != nil {
+		return fmt.Errorf("failed to replace cache entry: %w", err)
+	}
+	if err := os.Rename(tmp, c.entryDir(key)); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// Restore copies the outputs of the entry stored for key into moduleDir
+func (c *Cache) Restore(key string, e *Entry, moduleDir string) error {
+	if err := artifacts.Copy(filepath.Join(c.entryDir(key), filesDir), moduleDir, e.Outputs); err != nil {
+		return fmt.Errorf("failed to restore the outputs of %s: %w", e.Module, err)
+	}
+	return nil
+}
+
+// Key derives a cache key from everything a task run depends on
+type Key struct {
+	h hash.Hash
+}
+
+// NewKey starts the key of a task command
+func NewKey(task, command string) *Key {
+	k := &Key{h: sha256.New()}
+	k.Add("version", version)
+	k.Add("task", task)
+	k.Add("command", command)
+	return k
+}
+
+// Add adds a named value to the key
+func (k *Key) Add(name, value string) {
+	fmt.Fprintf(k.h, "%s %d %s\n", name, len(value), value)
+}
+
+// AddFile adds the content of a file to the key, under its name
+func (k *Key) AddFile(name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", file, err)
+	}
+	k.Add("file "+name, hex.EncodeToString(sum.Sum(nil)))
+	return nil
+}
+
+// AddGoSources adds the go.mod, go.sum and .go files of the module in dir to
+// the key, skipping nested modules, the directories the go command ignores
+// and the directories ignored by git. A vendor directory is covered by its
+// modules.txt. skip lists files, relative to dir in slash form, left out
+// because the task produces them.
+func (k *Key) AddGoSources(name, dir string, skip map[string]bool) error {
+	files, err := moduleFiles(dir, true, func(rel string) bool {
+		return (rel == "go.mod" || rel == "go.sum" || strings.HasSuffix(rel, ".go")) && !skip[rel]
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hash the sources of %s: %w", name, err)
+	}
+	k.Add("module", name)
+	return k.AddFiles(name, dir, files)
+}
+
+// nonGoExts are the extensions of the files other than .go ones the go
+// command may build into a package
+var nonGoExts = map[string]bool{
+	".c": true, ".cc": true, ".cpp": true, ".cxx": true, ".m": true, ".h": true, ".hh": true,
+	".hpp": true, ".hxx": true, ".f": true, ".F": true, ".for": true, ".f90": true,
+	".s": true, ".S": true, ".sx": true, ".syso": true,
+}
+
+// AddNonGoSources adds the C, C++, Objective-C, header, Fortran, assembly
+// and object files of the module in dir to the key, whichever package builds
+// them, skipping the directories AddGoSources skips
+func (k *Key) AddNonGoSources(name, dir string) error {
+	files, err := moduleFiles(dir, false, func(rel string) bool { return nonGoExts[path.Ext(rel)] })
+	if err != nil {
+		return fmt.Errorf("failed to hash the sources of %s: %w", name, err)
+	}
+	return k.AddFiles(name, dir, files)
+}
+
+// moduleFiles returns the sorted regular files of the module in dir keep
+// returns true for, relative to dir in slash form, skipping nested modules,
+// the directories the go command ignores and the directories ignored by git.
+// A vendor directory yields its modules.txt when vendor is set, nothing
+// otherwise.
+func moduleFiles(dir string, vendor bool, keep func(rel string) bool) ([]string, error) {
+	var files []string
+	matcher := ignore.New(dir)
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if file == dir {
+				return nil
+			}
+			if base := d.Name(); strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") || matcher.SkipDir(file) {
+				return filepath.SkipDir
+			}
+			if d.Name() == "vendor" {
+				if _, err := os.Stat(filepath.Join(file, "modules.txt")); err == nil && vendor {
+					rel, _ := filepath.Rel(dir, file)
+					files = append(files, filepath.ToSlash(filepath.Join(rel, "modules.txt")))
+				}
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(file, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if keep(rel) && d.Type().IsRegular() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// AddEmbedded adds the files matched by the //go:embed patterns of the
+// module in dir to the key, the patterns being relative to dir in slash form.
+// A directory matched adds every file below it.
+func (k *Key) AddEmbedded(name, dir string, patterns []string) error {
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			// go build rejects the pattern, nothing is embedded
+			continue
+		}
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(file string, d fs.DirEntry, err error) error {
+				if err != nil || !d.Type().IsRegular() {
+					return err
+				}
+				rel, err := filepath.Rel(dir, file)
+				if err != nil {
+					return err
+				}
+				if rel = filepath.ToSlash(rel); !seen[rel] {
+					seen[rel] = true
+					files = append(files, rel)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to hash the embedded files of %s: %w", name, err)
+			}
+		}
+	}
+	sort.Strings(files)
+	return k.AddFiles(name+" embed", dir, files)
+}
+
+// AddFiles adds files, slash-separated paths relative to dir, to the key
+// under their path prefixed with name
+func (k *Key) AddFiles(name, dir string, files []string) error {
+	for _, f := range files {
+		if err := k.AddFile(name+"/"+f, filepath.Join(dir, filepath.FromSlash(f))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sum returns the key, in hexadecimal
+func (k *Key) Sum() string {
+	return hex.EncodeToString(k.h.Sum(nil))
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sourcesKey(t *testing.T, dir string, skip map[string]bool) string {
+	t.Helper()
+	k := NewKey("build", "go build ./...")
+	if err := k.AddGoSources("example.com/core", dir, skip); err != nil {
+		t.Fatal(err)
+	}
+	return k.Sum()
+}
+
+func TestKey(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/core\n")
+	writeFile(t, filepath.Join(dir, "core.go"), "package core\n")
+	key := sourcesKey(t, dir, nil)
+
+	if again := sourcesKey(t, dir, nil); again != key {
+		t.Errorf("expected a stable key, got %s then %s", key, again)
+	}
+	if other := NewKey("test", "go build ./...").Sum(); other == NewKey("build", "go build ./...").Sum() {
+		t.Error("expected the task name to change the key")
+	}
+
+	// Files the go command ignores, nested modules and skipped files do not
+	// change the key
+	writeFile(t, filepath.Join(dir, "README.md"), "docs\n")
+	writeFile(t, filepath.Join(dir, ".hidden", "x.go"), "package x\n")
+	writeFile(t, filepath.Join(dir, "nested", "go.mod"), "module example.com/nested\n")
+	writeFile(t, filepath.Join(dir, "nested", "nested.go"), "package nested\n")
+	writeFile(t, filepath.Join(dir, "gen.go"), "package core\n")
+	if got := sourcesKey(t, dir, map[string]bool{"gen.go": true}); got != key {
+		t.Errorf("expected ignored files to keep the key")
+	}
+
+	writeFile(t, filepath.Join(dir, ".gitignore"), "/build/\n")
+	key = sourcesKey(t, dir, map[string]bool{"gen.go": true})
+	writeFile(t, filepath.Join(dir, "build", "x.go"), "package x\n")
+	writeFile(t, filepath.Join(dir, "node_modules", "x", "x.go"), "package x\n")
+	writeFile(t, filepath.Join(dir, "vendor", "example.com", "dep", "dep.go"), "package dep\n")
+	if got := sourcesKey(t, dir, map[string]bool{"gen.go": true}); got != key {
+		t.Errorf("expected ignored directories and vendored sources to keep the key")
+	}
+	writeFile(t, filepath.Join(dir, "vendor", "modules.txt"), "# example.com/dep v1.0.0\n")
+	if got := sourcesKey(t, dir, map[string]bool{"gen.go": true}); got == key {
+		t.Errorf("expected vendor/modules.txt to change the key")
+	}
+	key = sourcesKey(t, dir, map[string]bool{"gen.go": true})
+
+	writeFile(t, filepath.Join(dir, "go.sum"), "example.com/dep v1.0.0 h1:abc=\n")
+	withSum := sourcesKey(t, dir, map[string]bool{"gen.go": true})
+	if withSum == key {
+		t.Errorf("expected go.sum to change the key")
+	}
+	writeFile(t, filepath.Join(dir, "core.go"), "package core\n\nfunc F() {}\n")
+	if got := sourcesKey(t, dir, map[string]bool{"gen.go": true}); got == withSum {
+		t.Errorf("expected a source change to change the key")
+	}
+
+	a, b := NewKey("build", "go build"), NewKey("build", "go build")
+	a.Add("env GOOS", "linux")
+	b.Add("env GOOS", "darwin")
+	if a.Sum() == b.Sum() {
+		t.Errorf("expected values to change the key")
+	}
+}
+
+func TestAddNonGoSources(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "core.go"), "package core\n")
+	writeFile(t, filepath.Join(dir, "shim", "shim.c"), "int shim(void) { return 0; }\n")
+	nonGo := func() string {
+		t.Helper()
+		k := NewKey("build", "go build ./...")
+		if err := k.AddNonGoSources("example.com/core", dir); err != nil {
+			t.Fatal(err)
+		}
+		return k.Sum()
+	}
+	key := nonGo()
+
+	writeFile(t, filepath.Join(dir, "core.go"), "package core // changed\n")
+	writeFile(t, filepath.Join(dir, "vendor", "modules.txt"), "# example.com/dep v1.0.0\n")
+	if got := nonGo(); got != key {
+		t.Errorf("expected Go sources and vendor/modules.txt to keep the key")
+	}
+	writeFile(t, filepath.Join(dir, "asm_amd64.s"), "// nop\n")
+	if got := nonGo(); got == key {
+		t.Errorf("expected a new assembly file to change the key")
+	}
+}
+
+func TestAddEmbedded(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "web", "index.html"), "<p>index</p>\n")
+	writeFile(t, filepath.Join(dir, "web", "static", "app.css"), "p {}\n")
+	writeFile(t, filepath.Join(dir, "web", "README.md"), "docs\n")
+	writeFile(t, filepath.Join(dir, "schema.sql"), "create table t;\n")
+	embedded := func() string {
+		t.Helper()
+		k := NewKey("build", "go build ./...")
+		if err := k.AddEmbedded("example.com/core", dir, []string{"web/*.html", "web/static", "schema.sql", "missing/*", "[bad"}); err != nil {
+			t.Fatal(err)
+		}
+		return k.Sum()
+	}
+	key := embedded()
+
+	writeFile(t, filepath.Join(dir, "web", "README.md"), "more docs\n")
+	if got := embedded(); got != key {
+		t.Errorf("expected files no pattern matches to keep the key")
+	}
+	for _, file := range []string{"web/index.html", "web/static/app.css", "schema.sql"} {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(file)), "changed\n")
+		if got := embedded(); got == key {
+			t.Errorf("expected a change to %s to change the key", file)
+		}
+		key = embedded()
+	}
+	writeFile(t, filepath.Join(dir, "web", "about.html"), "<p>about</p>\n")
+	if got := embedded(); got == key {
+		t.Errorf("expected a new file matching a pattern to change the key")
+	}
+}
+
+func TestPutGetRestore(t *testing.T) {
+	c := Open(t.TempDir())
+	key := NewKey("build", "go build").Sum()
+	if e, err := c.Get(key); err != nil || e != nil {
+		t.Fatalf("expected a miss, got %v, %v", e, err)
+	}
+
+	moduleDir := t.TempDir()
+	writeFile(t, filepath.Join(moduleDir, "bin", "app"), "binary")
+	entry := &Entry{Task: "build", Module: "example.com/app", Created: time.Now(), Elapsed: time.Second, Outputs: []string{"bin/app"}}
+	if err := c.Put(key, entry, moduleDir); err != nil {
+		t.Fatal(err)
+	}
+	// A second Put replaces the entry
+	if err := c.Put(key, entry, moduleDir); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := c.Get(key)
+	if err != nil || e == nil || e.Module != "example.com/app" || e.Elapsed != time.Second {
+		t.Fatalf("expected a hit, got %+v, %v", e, err)
+	}
+	restored := t.TempDir()
+	if err := c.Restore(key, e, restored); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(restored, "bin", "app")); err != nil || string(data) != "binary" {
+		t.Errorf("unexpected restored output: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,135 @@
+package codeowners
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Locations are the places a CODEOWNERS file is looked for, relative to the
+// repository root, in the order GitHub uses
+var Locations = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// Rule is a line of a CODEOWNERS file
+type Rule struct {
+	Pattern string
+	Owners  []string
+	re      *regexp.Regexp
+}
+
+// Ruleset is a parsed CODEOWNERS file
+type Ruleset struct {
+	// Path is the file the rules were read from
+	Path  string
+	Rules []Rule
+}
+
+// Load reads the first CODEOWNERS file found in Locations under root. It
+// returns an error wrapping os.ErrNotExist when there is none.
+func Load(root string) (*Ruleset, error) {
+	for _, loc := range Locations {
+		path := filepath.Join(root, loc)
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer f.Close()
+
+		rs, err := Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		rs.Path = path
+		return rs, nil
+	}
+	return nil, fmt.Errorf("no CODEOWNERS file in %s: %w", root, os.ErrNotExist)
+}
+
+// Parse reads CODEOWNERS rules. Comments, blank lines and GitLab section
+// headers are skipped.
+func Parse(r io.Reader) (*Ruleset, error) {
+	rs := &Ruleset{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "^[") {
+			continue
+		}
+		if i := strings.Index(line, " #"); i != -1 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		pattern := strings.ReplaceAll(fields[0], `\#`, "#")
+		re, err := compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		rs.Rules = append(rs.Rules, Rule{Pattern: pattern, Owners: fields[1:], re: re})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Owners returns the owners of a slash-separated path relative to the
+// repository root. As in GitHub, the last matching rule wins, and a rule
+// without owners leaves the path unowned.
+func (rs *Ruleset) Owners(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	for i := len(rs.Rules) - 1; i >= 0; i-- {
+		if rs.Rules[i].re.MatchString(path) {
+			if len(rs.Rules[i].Owners) == 0 {
+				return nil
+			}
+			return rs.Rules[i].Owners
+		}
+	}
+	return nil
+}
+
+// compile turns a gitignore-style pattern into a regular expression matching
+// a path and, when it names a directory, everything below it
+func compile(pattern string) (*regexp.Regexp, error) {
+	p := pattern
+	// A slash anywhere but at the end anchors the pattern to the root
+	anchored := strings.Contains(strings.TrimSuffix(p, "/"), "/")
+	p = strings.TrimPrefix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case strings.HasPrefix(p[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "/**") && i+3 == len(p):
+			b.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("(/.*)?$")
+	return regexp.Compile(b.String())
+}
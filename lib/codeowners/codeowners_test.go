@@ -0,0 +1,67 @@
+package codeowners
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sample = `# Default owners
+*                    @org/everyone
+
+[Backend]
+/services/           @org/backend
+/services/payments/  @org/payments @alice  # money
+*.md                 @org/docs
+/libs/**/internal    @org/platform
+/generated/
+`
+
+func TestOwners(t *testing.T) {
+	rs, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"go.mod", []string{"@org/everyone"}},
+		{"services/api/go.mod", []string{"@org/backend"}},
+		{"services/payments/go.mod", []string{"@org/payments", "@alice"}},
+		{"services/payments/README.md", []string{"@org/docs"}},
+		{"libs/a/b/internal/go.mod", []string{"@org/platform"}},
+		{"libs/internal/go.mod", []string{"@org/platform"}},
+		{"libs/a/go.mod", []string{"@org/everyone"}},
+		{"generated/go.mod", nil},
+		{"other/services/go.mod", []string{"@org/everyone"}},
+	}
+	for _, tt := range tests {
+		if got := rs.Owners(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+	if _, err := Load(root); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+
+	os.MkdirAll(filepath.Join(root, ".github"), 0755)
+	if err := os.WriteFile(filepath.Join(root, ".github", "CODEOWNERS"), []byte("* @org/team\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Owners("a/go.mod"); len(got) != 1 || got[0] != "@org/team" {
+		t.Errorf("unexpected owners %v", got)
+	}
+}
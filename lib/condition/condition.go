@@ -0,0 +1,266 @@
+// Package condition parses and evaluates the onlyIf conditions of knit.yaml
+// tasks, boolean expressions over the properties of a module such as
+//
+//	hasTests && !(tag == "experimental" || tag == "legacy")
+package condition
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Module holds the properties of a module a condition is evaluated against
+type Module struct {
+	// HasMainPackage reports whether the module has a main package
+	HasMainPackage bool
+	// HasTests reports whether the module has _test.go files
+	HasTests bool
+	Tags     []string
+}
+
+// Condition is a parsed condition
+type Condition interface {
+	// Eval reports whether m satisfies the condition
+	Eval(m Module) bool
+	String() string
+}
+
+// property is a boolean property of a module
+type property struct {
+	name string
+}
+
+// tagTest compares the tags of a module with a tag
+type tagTest struct {
+	tag    string
+	negate bool
+}
+
+// not negates a condition
+type not struct {
+	c Condition
+}
+
+// binary combines two conditions with && or ||
+type binary struct {
+	op          string
+	left, right Condition
+}
+
+// properties are the boolean properties a condition may use
+var properties = map[string]func(Module) bool{
+	"hasMainPackage": func(m Module) bool { return m.HasMainPackage },
+	"hasTests":       func(m Module) bool { return m.HasTests },
+}
+
+func (p property) Eval(m Module) bool { return properties[p.name](m) }
+
+func (t tagTest) Eval(m Module) bool {
+	for _, tag := range m.Tags {
+		if tag == t.tag {
+			return !t.negate
+		}
+	}
+	return t.negate
+}
+
+func (n not) Eval(m Module) bool { return !n.c.Eval(m) }
+
+func (b binary) Eval(m Module) bool {
+	if b.op == "&&" {
+		return b.left.Eval(m) && b.right.Eval(m)
+	}
+	return b.left.Eval(m) || b.right.Eval(m)
+}
+
+func (p property) String() string { return p.name }
+
+func (t tagTest) String() string {
+	op := "=="
+	if t.negate {
+		op = "!="
+	}
+	return fmt.Sprintf("tag %s %q", op, t.tag)
+}
+
+func (n not) String() string { return "!" + n.c.String() }
+
+func (b binary) String() string {
+	return "(" + b.left.String() + " " + b.op + " " + b.right.String() + ")"
+}
+
+// operators are the two-character operators, '!' being the only single one
+var operators = map[string]bool{"&&": true, "||": true, "==": true, "!=": true}
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokEOF
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case i+1 < len(runes) && operators[string(runes[i:i+2])]:
+			tokens = append(tokens, token{tokOp, string(runes[i : i+2]), i})
+			i += 2
+		case r == '!':
+			tokens = append(tokens, token{tokOp, "!", i})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{tokString, string(runes[i+1 : end]), i})
+			i = end + 1
+		case unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, string(runes[start:i]), start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", r, i)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(runes)}), nil
+}
+
+// Parse parses a condition. The grammar, from the lowest precedence:
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" expr ")" | hasMainPackage | hasTests
+//	        | tag ( "==" | "!=" ) string
+func Parse(s string) (Condition, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, unexpected(t, "end of condition")
+	}
+	return c, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (Condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().value == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Condition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().value == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Condition, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokOp && t.value == "!":
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{c}, nil
+	case t.kind == tokLParen:
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, unexpected(t, "')'")
+		}
+		return c, nil
+	case t.kind == tokIdent && t.value == "tag":
+		op := p.next()
+		if op.kind != tokOp || (op.value != "==" && op.value != "!=") {
+			return nil, unexpected(op, "'==' or '!=' after tag")
+		}
+		value := p.next()
+		if value.kind != tokString {
+			return nil, unexpected(value, "a quoted tag")
+		}
+		return tagTest{tag: value.value, negate: op.value == "!="}, nil
+	case t.kind == tokIdent:
+		if _, ok := properties[t.value]; !ok {
+			return nil, fmt.Errorf("unknown property %q at offset %d (use hasMainPackage, hasTests or tag)", t.value, t.pos)
+		}
+		return property{t.value}, nil
+	}
+	return nil, unexpected(t, "a property")
+}
+
+func unexpected(t token, what string) error {
+	if t.kind == tokEOF {
+		return fmt.Errorf("expected %s at end of condition", what)
+	}
+	return fmt.Errorf("expected %s at offset %d, got %q", what, t.pos, t.value)
+}
@@ -0,0 +1,69 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	service := Module{HasMainPackage: true, Tags: []string{"service"}}
+	library := Module{HasTests: true, Tags: []string{"shared"}}
+
+	tests := []struct {
+		condition string
+		service   bool
+		library   bool
+	}{
+		{"hasMainPackage", true, false},
+		{"hasTests", false, true},
+		{"!hasTests", true, false},
+		{`tag == "service"`, true, false},
+		{`tag != 'service'`, false, true},
+		{`hasTests || tag == "service"`, true, true},
+		{`hasMainPackage && hasTests`, false, false},
+		{`!(hasMainPackage || tag == "shared")`, false, false},
+		{`hasTests || hasMainPackage && tag == "other"`, false, true},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.condition)
+		if err != nil {
+			t.Errorf("%s: %v", tt.condition, err)
+			continue
+		}
+		if got := c.Eval(service); got != tt.service {
+			t.Errorf("%s on a service: expected %v, got %v", tt.condition, tt.service, got)
+		}
+		if got := c.Eval(library); got != tt.library {
+			t.Errorf("%s on a library: expected %v, got %v", tt.condition, tt.library, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"":                    "expected a property at end of condition",
+		"hasDocs":             `unknown property "hasDocs"`,
+		"tag":                 "expected '==' or '!=' after tag",
+		"tag == service":      "expected a quoted tag",
+		`tag == "unclosed`:    "unterminated string",
+		"(hasTests":           "expected ')'",
+		"hasTests hasTests":   "expected end of condition",
+		"hasTests & hasTests": "unexpected character '&'",
+	}
+	for condition, expected := range tests {
+		_, err := Parse(condition)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected an error containing %q, got %v", condition, expected, err)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	c, err := Parse(`!hasTests&&(tag=="a"||tag!="b")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.String(); got != `(!hasTests && (tag == "a" || tag != "b"))` {
+		t.Errorf("unexpected string: %s", got)
+	}
+}
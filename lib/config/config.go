@@ -0,0 +1,423 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the optional configuration file at the workspace root
+const FileName = "knit.yaml"
+
+// Config is the content of knit.yaml
+type Config struct {
+	// Modules holds per-module settings, keyed by module path
+	Modules map[string]ModuleConfig `yaml:"modules" json:"modules"`
+	// Architecture holds the layering rules checked by 'knit check-arch'
+	Architecture []ArchRule `yaml:"architecture" json:"architecture"`
+	// BannedImports holds the package import denylist checked by 'knit check-arch'
+	BannedImports []BannedImport `yaml:"bannedImports" json:"bannedImports"`
+	// Tasks holds per-task settings, keyed by task name, e.g. test
+	Tasks map[string]TaskConfig `yaml:"tasks" json:"tasks"`
+	// Env holds environment variables set for the tasks of every module
+	Env map[string]string `yaml:"env" json:"env,omitempty"`
+	// ModuleEnvFile, when set, names a .env file loaded from every module
+	// directory into the environment of its tasks, e.g. .env
+	ModuleEnvFile string `yaml:"moduleEnvFile" json:"moduleEnvFile,omitempty"`
+	// ExcludeModules are module paths or directories relative to the
+	// workspace root, or globs of them, of the modules of go.work every
+	// command ignores, such as generated or mirrored modules
+	ExcludeModules []string `yaml:"excludeModules" json:"excludeModules,omitempty"`
+	// ForwardEnv, when set, restricts the variables of the environment knit
+	// runs in passed to the tasks and hooks to the names matching these
+	// globs, e.g. GO* or *_PROXY, besides PATH, HOME and the like
+	ForwardEnv []string `yaml:"forwardEnv" json:"forwardEnv,omitempty"`
+	// ScrubEnv are globs of the names of the variables of the environment
+	// knit runs in never passed to the tasks and hooks, e.g. AWS_*
+	ScrubEnv []string `yaml:"scrubEnv" json:"scrubEnv,omitempty"`
+	// Generated maps the inputs of code generators, such as .proto files, to
+	// the modules using the generated code
+	Generated []GeneratedSource `yaml:"generated" json:"generated,omitempty"`
+	// Race holds the race detector settings of 'knit test'
+	Race RaceConfig `yaml:"race" json:"race"`
+	// Format holds the formatter settings of 'knit fmt'
+	Format FormatConfig `yaml:"format" json:"format"`
+	// CI holds the settings of 'knit ci'
+	CI CIConfig `yaml:"ci" json:"ci"`
+}
+
+// CIConfig holds the settings of 'knit ci'
+type CIConfig struct {
+	// Tasks are run in order on the affected modules and their dependents:
+	// fmt, vet, test and build, or tasks of knit.yaml with a run command,
+	// e.g. lint. The run command of a task wins over the built-in one.
+	Tasks []string `yaml:"tasks" json:"tasks,omitempty"`
+}
+
+// FormatConfig holds the formatter settings of 'knit fmt', which runs
+// go fmt ./... in every module by default
+type FormatConfig struct {
+	// Run is the command formatting a module, run in its directory, e.g.
+	// gofumpt -w . or goimports -local example.com -w .
+	Run string `yaml:"run" json:"run,omitempty"`
+	// Check is the command of 'knit fmt --check', printing the files of the
+	// module Run would change, e.g. gofumpt -l .; the module fails when it
+	// prints one. It is required with Run.
+	Check string `yaml:"check" json:"check,omitempty"`
+}
+
+// RaceConfig holds the race detector settings of 'knit test'
+type RaceConfig struct {
+	// Default runs the tests with -race unless --race=false is given
+	Default bool `yaml:"default" json:"default"`
+	// Exclude are the paths or directories of the modules always tested
+	// without -race, globs allowed as with --target, e.g. the ones built
+	// with cgo disabled
+	Exclude []string `yaml:"exclude" json:"exclude,omitempty"`
+}
+
+// GeneratedSource maps the inputs of a code generator to the modules
+// consuming its output, which a change to one of the inputs affects
+type GeneratedSource struct {
+	// Inputs are globs relative to the workspace root, ** matching any
+	// number of directories, e.g. proto/payments/**/*.proto
+	Inputs []string `yaml:"inputs" json:"inputs"`
+	// Modules are the paths or directories of the consuming modules, globs
+	// allowed as with --target
+	Modules []string `yaml:"modules" json:"modules"`
+	// Generate, when set, names a task run on the affected consuming modules
+	// before another task is run on them with --affected
+	Generate string `yaml:"generate" json:"generate,omitempty"`
+}
+
+// Hook scopes
+const (
+	// ScopeRun runs a hook once per knit run, at the workspace root
+	ScopeRun = "run"
+	// ScopeModule runs a hook in each module directory, around the task command
+	ScopeModule = "module"
+)
+
+// Hook failure modes
+const (
+	// FailureFail makes a failing before hook skip the task, or the module
+	// with ScopeModule, and a failing after hook fail the run or the module
+	FailureFail = "fail"
+	// FailureIgnore only reports a failing hook
+	FailureIgnore = "ignore"
+)
+
+// TaskConfig holds the settings of a task
+type TaskConfig struct {
+	// Run is the command of a task run with 'knit run <name>' in every
+	// module. It may reference module variables as a Go template, e.g.
+	// {{.ShortName}}.
+	Run string `yaml:"run" json:"run,omitempty"`
+	// OnlyIf restricts the task to the modules satisfying a condition over
+	// hasMainPackage, hasTests and tag, e.g. hasTests && tag != "legacy"
+	OnlyIf string `yaml:"onlyIf" json:"onlyIf,omitempty"`
+	// Outputs are globs, relative to the module directory, of the files the
+	// task produces, such as binaries or coverage profiles
+	Outputs []string `yaml:"outputs" json:"outputs,omitempty"`
+	// Cache reuses the successful result of the task in a module, and
+	// restores its outputs, while its inputs are unchanged: the Go sources,
+	// go.mod and go.sum of the module and of its workspace dependencies, the
+	// Go toolchain, the environment and Inputs
+	Cache bool `yaml:"cache" json:"cache,omitempty"`
+	// Inputs are globs, relative to the module directory, of the non-Go files
+	// the result of the task depends on, such as testdata or templates
+	Inputs []string `yaml:"inputs" json:"inputs,omitempty"`
+	// InputEnv names the environment variables the result of the task
+	// depends on, besides the Go build settings such as GOOS and GOFLAGS
+	InputEnv []string `yaml:"inputEnv" json:"inputEnv,omitempty"`
+	// Before hooks run in order before the task; after hooks run in order
+	// after it, whether the task succeeded or not
+	Before []Hook `yaml:"before" json:"before"`
+	After  []Hook `yaml:"after" json:"after"`
+}
+
+// Hook is a shell command run around a task
+type Hook struct {
+	Run string `yaml:"run" json:"run"`
+	// Scope is ScopeRun (default) or ScopeModule
+	Scope string `yaml:"scope" json:"scope"`
+	// OnFailure is FailureFail (default) or FailureIgnore
+	OnFailure string `yaml:"onFailure" json:"onFailure"`
+}
+
+// ArchRule restricts the workspace dependencies of a set of modules. From,
+// Deny and Allow are module queries. A module selected by From may not
+// depend on a module selected by Deny, and when Allow is set, only on
+// modules selected by Allow.
+type ArchRule struct {
+	Name string `yaml:"name" json:"name"`
+	// From defaults to every module
+	From  string `yaml:"from" json:"from"`
+	Deny  string `yaml:"deny" json:"deny"`
+	Allow string `yaml:"allow" json:"allow"`
+}
+
+// BannedImport forbids importing packages matching Import, a glob where
+// "/..." matches a whole prefix, from any package not matching one of the
+// Allow patterns
+type BannedImport struct {
+	Import  string   `yaml:"import" json:"import"`
+	Allow   []string `yaml:"allow" json:"allow"`
+	Message string   `yaml:"message" json:"message"`
+}
+
+// ModuleConfig holds the settings of a single module
+type ModuleConfig struct {
+	Tags []string `yaml:"tags" json:"tags"`
+	// Env holds environment variables set for the tasks of the module,
+	// overriding the workspace ones
+	Env map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	// Weight is the number of concurrency slots the tasks of the module
+	// take, e.g. 2 for tests starting containers, one when unset
+	Weight int `yaml:"weight,omitempty" json:"weight,omitempty"`
+	// Exclusive runs the tasks of the module alone, taking every slot
+	Exclusive bool `yaml:"exclusive,omitempty" json:"exclusive,omitempty"`
+}
+
+// Load reads knit.yaml from the workspace root. A missing file is not an
+// error and yields an empty configuration.
+func Load(root string) (*Config, error) {
+	cfg := &Config{Modules: make(map[string]ModuleConfig)}
+
+	path := filepath.Join(root, FileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if cfg.Modules == nil {
+		cfg.Modules = make(map[string]ModuleConfig)
+	}
+	if err := cfg.normalizeHooks(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	for _, globs := range []struct {
+		field    string
+		patterns []string
+	}{{"excludeModules", cfg.ExcludeModules}, {"forwardEnv", cfg.ForwardEnv}, {"scrubEnv", cfg.ScrubEnv}, {"race.exclude", cfg.Race.Exclude}} {
+		if err := checkGlobs(globs.patterns); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s: %w", path, globs.field, err)
+		}
+	}
+	if err := cfg.checkGenerated(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	for modulePath, m := range cfg.Modules {
+		if m.Weight < 0 {
+			return nil, fmt.Errorf("invalid %s: modules.%s: weight must be positive, got %d", path, modulePath, m.Weight)
+		}
+	}
+	if cfg.Format.Run != "" && cfg.Format.Check == "" {
+		return nil, fmt.Errorf("invalid %s: format.check is required with format.run, for knit fmt --check", path)
+	}
+	return cfg, nil
+}
+
+// checkGenerated checks that every generated source has inputs and modules,
+// and that its generate task has a run command
+func (c *Config) checkGenerated() error {
+	for i, g := range c.Generated {
+		if len(g.Inputs) == 0 || len(g.Modules) == 0 {
+			return fmt.Errorf("generated[%d]: inputs and modules are required", i)
+		}
+		if err := checkGlobs(g.Inputs); err != nil {
+			return fmt.Errorf("generated[%d]: inputs: %w", i, err)
+		}
+		if g.Generate != "" && c.Tasks[g.Generate].Run == "" {
+			return fmt.Errorf("generated[%d]: generate: no task %q with a run command", i, g.Generate)
+		}
+	}
+	return nil
+}
+
+// checkGlobs checks that the patterns are valid path.Match globs
+func checkGlobs(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// normalizeHooks checks the hooks of every task and fills in their defaults
+func (c *Config) normalizeHooks() error {
+	for name, task := range c.Tasks {
+		for _, hooks := range [][]Hook{task.Before, task.After} {
+			for i := range hooks {
+				h := &hooks[i]
+				if strings.TrimSpace(h.Run) == "" {
+					return fmt.Errorf("tasks.%s: hook %d has no run command", name, i+1)
+				}
+				switch h.Scope {
+				case "":
+					h.Scope = ScopeRun
+				case ScopeRun, ScopeModule:
+				default:
+					return fmt.Errorf("tasks.%s: unknown hook scope %q (use run or module)", name, h.Scope)
+				}
+				switch h.OnFailure {
+				case "":
+					h.OnFailure = FailureFail
+				case FailureFail, FailureIgnore:
+				default:
+					return fmt.Errorf("tasks.%s: unknown onFailure %q (use fail or ignore)", name, h.OnFailure)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Hooks returns the before and after hooks of a task with the given scope
+func (t TaskConfig) Hooks(scope string) (before, after []Hook) {
+	for _, h := range t.Before {
+		if h.Scope == scope {
+			before = append(before, h)
+		}
+	}
+	for _, h := range t.After {
+		if h.Scope == scope {
+			after = append(after, h)
+		}
+	}
+	return before, after
+}
+
+// Tags returns the tags of every module, keyed by module path
+func (c *Config) Tags() map[string][]string {
+	tags := make(map[string][]string, len(c.Modules))
+	for path, m := range c.Modules {
+		if len(m.Tags) > 0 {
+			tags[path] = m.Tags
+		}
+	}
+	return tags
+}
+
+// Environment returns the variables to add to the environment of the tasks
+// of a module, or of tasks run at the workspace root when modulePath is
+// empty, as sorted KEY=value pairs. Values expand ${VAR} and $VAR from the
+// process environment, module values also from the workspace variables, and
+// $$ stands for a literal $.
+func (c *Config) Environment(modulePath string, lookup func(string) (string, bool)) []string {
+	vars := make(map[string]string, len(c.Env))
+	expand := func(value string, scope map[string]string) string {
+		return os.Expand(value, func(name string) string {
+			if name == "$" {
+				return "$"
+			}
+			if v, ok := scope[name]; ok {
+				return v
+			}
+			v, _ := lookup(name)
+			return v
+		})
+	}
+
+	for key, value := range c.Env {
+		vars[key] = expand(value, nil)
+	}
+	if m, ok := c.Modules[modulePath]; ok && modulePath != "" {
+		workspace := make(map[string]string, len(vars))
+		for key, value := range vars {
+			workspace[key] = value
+		}
+		for key, value := range m.Env {
+			vars[key] = expand(value, workspace)
+		}
+	}
+
+	env := make([]string, 0, len(vars))
+	for key, value := range vars {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// AddModule sets the settings of a module in knit.yaml at the workspace
+// root, creating the file when missing. The comments and key order of an
+// existing file are kept, but not its blank lines.
+func AddModule(root, modulePath string, m ModuleConfig) error {
+	path := filepath.Join(root, FileName)
+	var doc yaml.Node
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	top := doc.Content[0]
+	if top.Kind != yaml.MappingNode {
+		return fmt.Errorf("failed to update %s: expected a mapping at the top level", path)
+	}
+
+	modules := mappingValue(top, "modules")
+	if modules == nil {
+		modules = &yaml.Node{Kind: yaml.MappingNode}
+		top.Content = append(top.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "modules"}, modules)
+	}
+	if modules.Kind != yaml.MappingNode {
+		// An empty "modules:" key holds null
+		*modules = yaml.Node{Kind: yaml.MappingNode}
+	}
+	modules.Style = 0
+
+	var value yaml.Node
+	if err := value.Encode(m); err != nil {
+		return fmt.Errorf("failed to encode %s settings: %w", modulePath, err)
+	}
+	for _, n := range value.Content {
+		if n.Kind == yaml.SequenceNode {
+			n.Style = yaml.FlowStyle
+		}
+	}
+	if existing := mappingValue(modules, modulePath); existing != nil {
+		*existing = value
+	} else {
+		modules.Content = append(modules.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: modulePath}, &value)
+	}
+
+	var b strings.Builder
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// mappingValue returns the value of key in a YAML mapping node, or nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Modules) != 0 {
+		t.Errorf("expected an empty config, got %+v", cfg)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+	content := `modules:
+  example.com/core:
+    tags: [shared, lib]
+  example.com/app:
+    tags: [service]
+architecture:
+  - name: libraries stay independent of services
+    from: tag(lib)
+    deny: tag(service)
+bannedImports:
+  - import: database/sql
+    allow: [example.com/platform/db/...]
+    message: use platform/db
+excludeModules: [example.com/generated/..., third_party/*]
+forwardEnv: [GO*, "*_PROXY"]
+scrubEnv: [AWS_*]
+`
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := cfg.Tags()
+	if len(tags["example.com/core"]) != 2 || tags["example.com/app"][0] != "service" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+	if len(cfg.Architecture) != 1 || cfg.Architecture[0].From != "tag(lib)" || cfg.Architecture[0].Deny != "tag(service)" {
+		t.Errorf("unexpected architecture rules: %+v", cfg.Architecture)
+	}
+	if len(cfg.BannedImports) != 1 || cfg.BannedImports[0].Allow[0] != "example.com/platform/db/..." {
+		t.Errorf("unexpected banned imports: %+v", cfg.BannedImports)
+	}
+	if len(cfg.ExcludeModules) != 2 || cfg.ExcludeModules[1] != "third_party/*" {
+		t.Errorf("unexpected excluded modules: %v", cfg.ExcludeModules)
+	}
+	if len(cfg.ForwardEnv) != 2 || cfg.ForwardEnv[1] != "*_PROXY" || len(cfg.ScrubEnv) != 1 {
+		t.Errorf("unexpected environment globs: %v %v", cfg.ForwardEnv, cfg.ScrubEnv)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte("modules: [oops"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(root); err == nil {
+		t.Error("expected an error for invalid yaml")
+	}
+}
+
+func TestLoadInvalidExcludeModules(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte("excludeModules: [\"gen/[\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(root); err == nil || !strings.Contains(err.Error(), "excludeModules") {
+		t.Errorf("expected an error for the malformed pattern, got %v", err)
+	}
+}
+
+func TestAddModule(t *testing.T) {
+	root := t.TempDir()
+
+	// A missing file is created
+	if err := AddModule(root, "example.com/core", ModuleConfig{Tags: []string{"library"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(root, FileName))
+	if string(data) != "modules:\n  example.com/core:\n    tags: [library]\n" {
+		t.Errorf("unexpected knit.yaml:\n%s", data)
+	}
+
+	content := `# Module settings
+modules:
+  example.com/core:
+    tags: [library] # shared code
+
+# Layering rules
+architecture:
+  - name: libraries stay independent of services
+    deny: tag(service)
+`
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddModule(root, "example.com/billing", ModuleConfig{Tags: []string{"service"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(root, FileName))
+	// Comments are kept, new modules come last
+	for _, want := range []string{"# Module settings\n", "tags: [library] # shared code\n  example.com/billing:\n    tags: [service]\n", "# Layering rules\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in knit.yaml:\n%s", want, data)
+		}
+	}
+
+	cfg, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := cfg.Tags(); len(tags) != 2 || tags["example.com/billing"][0] != "service" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
+
+func TestLoadHooks(t *testing.T) {
+	root := t.TempDir()
+	content := `tasks:
+  test:
+    before:
+      - run: docker compose up -d
+      - run: ./seed.sh
+        scope: module
+        onFailure: ignore
+    after:
+      - run: docker compose down
+`
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := cfg.Tasks["test"]
+	before, after := task.Hooks(ScopeRun)
+	if len(before) != 1 || before[0].OnFailure != FailureFail || len(after) != 1 || after[0].Run != "docker compose down" {
+		t.Errorf("unexpected run hooks: %+v %+v", before, after)
+	}
+	before, after = task.Hooks(ScopeModule)
+	if len(before) != 1 || before[0].Run != "./seed.sh" || before[0].OnFailure != FailureIgnore || len(after) != 0 {
+		t.Errorf("unexpected module hooks: %+v %+v", before, after)
+	}
+}
+
+func TestLoadInvalidHooks(t *testing.T) {
+	for _, content := range []string{
+		"tasks:\n  test:\n    before:\n      - run: x\n        scope: forever\n",
+		"tasks:\n  test:\n    after:\n      - run: x\n        onFailure: retry\n",
+		"tasks:\n  test:\n    after:\n      - scope: run\n",
+	} {
+		root := t.TempDir()
+		if err := os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(root); err == nil {
+			t.Errorf("expected an error for:\n%s", content)
+		}
+	}
+}
+
+func TestLoadInvalidGenerated(t *testing.T) {
+	for _, content := range []string{
+		"generated:\n  - inputs: [proto/*.proto]\n",
+		"generated:\n  - inputs: [\"proto/[\"]\n    modules: [example.com/api]\n",
+		"generated:\n  - inputs: [proto/*.proto]\n    modules: [example.com/api]\n    generate: missing\n",
+	} {
+		root := t.TempDir()
+		if err := os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(root); err == nil || !strings.Contains(err.Error(), "generated[0]") {
+			t.Errorf("expected an error for:\n%s\ngot %v", content, err)
+		}
+	}
+}
+
+func TestLoadFormat(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte("format:\n  run: gofumpt -w .\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(root); err == nil || !strings.Contains(err.Error(), "format.check is required") {
+		t.Errorf("expected an error for a formatter without check, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte("format:\n  run: gofumpt -w .\n  check: gofumpt -l .\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Format.Run != "gofumpt -w ." || cfg.Format.Check != "gofumpt -l ." {
+		t.Errorf("unexpected format settings: %+v", cfg.Format)
+	}
+}
+
+func TestLoadWeights(t *testing.T) {
+	root := t.TempDir()
+	content := "modules:\n  example.com/db:\n    weight: 2\n  example.com/e2e:\n    exclusive: true\n"
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Modules["example.com/db"].Weight != 2 || !cfg.Modules["example.com/e2e"].Exclusive {
+		t.Errorf("unexpected module settings: %+v", cfg.Modules)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte("modules:\n  example.com/db:\n    weight: -1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(root); err == nil || !strings.Contains(err.Error(), "modules.example.com/db: weight must be positive") {
+		t.Errorf("expected an error for a negative weight, got %v", err)
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	root := t.TempDir()
+	content := `env:
+  LOG_LEVEL: debug
+  DATABASE_URL: postgres://${DB_HOST}/main
+  PRICE: $$5
+modules:
+  example.com/billing:
+    env:
+      DATABASE_URL: postgres://${DB_HOST}/billing?log=${LOG_LEVEL}
+      HOME_DIR: $HOME
+`
+	if err := os.WriteFile(filepath.Join(root, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := map[string]string{"DB_HOST": "db:5432", "HOME": "/home/me"}[name]
+		return v, ok
+	}
+
+	want := "DATABASE_URL=postgres://db:5432/main LOG_LEVEL=debug PRICE=$5"
+	if got := strings.Join(cfg.Environment("", lookup), " "); got != want {
+		t.Errorf("workspace env: expected %s, got %s", want, got)
+	}
+	if got := strings.Join(cfg.Environment("example.com/other", lookup), " "); got != want {
+		t.Errorf("unconfigured module env: expected %s, got %s", want, got)
+	}
+	want = "DATABASE_URL=postgres://db:5432/billing?log=debug HOME_DIR=/home/me LOG_LEVEL=debug PRICE=$5"
+	if got := strings.Join(cfg.Environment("example.com/billing", lookup), " "); got != want {
+		t.Errorf("module env: expected %s, got %s", want, got)
+	}
+}
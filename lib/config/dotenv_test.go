@@ -0,0 +1,73 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	content := `# local settings
+DB_HOST=localhost
+export DB_PORT = 5432
+EMPTY=
+COMMENTED=value # a comment
+HASH=a#b
+SINGLE='$HOME \n stays'
+DOUBLE="line1\nline2 \"quoted\"" # trailing comment
+`
+	vars, err := ParseDotenv(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"DB_HOST":   "localhost",
+		"DB_PORT":   "5432",
+		"EMPTY":     "",
+		"COMMENTED": "value",
+		"HASH":      "a#b",
+		"SINGLE":    `$HOME \n stays`,
+		"DOUBLE":    "line1\nline2 \"quoted\"",
+	}
+	if len(vars) != len(expected) {
+		t.Errorf("expected %d variables, got %v", len(expected), vars)
+	}
+	for key, value := range expected {
+		if vars[key] != value {
+			t.Errorf("%s: expected %q, got %q", key, value, vars[key])
+		}
+	}
+}
+
+func TestParseDotenvInvalid(t *testing.T) {
+	for _, content := range []string{
+		"NO_EQUALS",
+		"1KEY=value",
+		"BAD KEY=value",
+		`OPEN="never closed`,
+		`AFTER='quoted' extra`,
+	} {
+		if _, err := ParseDotenv(strings.NewReader("OK=1\n" + content)); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("%q: expected an error on line 2, got %v", content, err)
+		}
+	}
+}
+
+func TestLoadDotenv(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, ".env")
+	if vars, err := LoadDotenv(missing, false); err != nil || len(vars) != 0 {
+		t.Errorf("expected a missing optional file to be empty, got %v, %v", vars, err)
+	}
+	if _, err := LoadDotenv(missing, true); err == nil {
+		t.Error("expected a missing required file to fail")
+	}
+
+	if err := os.WriteFile(missing, []byte("TOKEN=secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if vars, err := LoadDotenv(missing, true); err != nil || vars["TOKEN"] != "secret" {
+		t.Errorf("unexpected variables: %v, %v", vars, err)
+	}
+}
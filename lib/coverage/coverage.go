@@ -0,0 +1,163 @@
+// Package coverage reads and merges the coverage profiles written by go test
+// -coverprofile, so that the profiles of the modules of a workspace can be
+// reported together.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coverage modes of a profile
+const (
+	ModeSet    = "set"
+	ModeCount  = "count"
+	ModeAtomic = "atomic"
+)
+
+// Block is a code block of a profile: the statements between two positions
+// of a file and how many times they ran, or whether they did in set mode
+type Block struct {
+	// Position is file:startLine.startCol,endLine.endCol
+	Position   string
+	Statements int
+	Count      int
+}
+
+// Profile is a parsed coverage profile
+type Profile struct {
+	Mode   string
+	Blocks []Block
+}
+
+// Parse reads a coverage profile
+func Parse(r io.Reader) (*Profile, error) {
+	p := &Profile{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if mode, ok := strings.CutPrefix(text, "mode: "); ok {
+			if p.Mode != "" && p.Mode != mode {
+				return nil, fmt.Errorf("line %d: mode %s after mode %s", line, mode, p.Mode)
+			}
+			p.Mode = mode
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 || !strings.Contains(fields[0], ":") {
+			return nil, fmt.Errorf("line %d: invalid block %q", line, text)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid statement count %q", line, fields[1])
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid count %q", line, fields[2])
+		}
+		p.Blocks = append(p.Blocks, Block{Position: fields[0], Statements: statements, Count: count})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if p.Mode == "" {
+		return nil, fmt.Errorf("missing mode line")
+	}
+	return p, nil
+}
+
+// ParseFile reads the coverage profile at path
+func ParseFile(path string) (*Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Merge merges profiles. A block found in several of them, such as a
+// package covered by the tests of two modules with -coverpkg, ran the sum of
+// their counts. Profiles of different modes, e.g. of modules tested with and
+// without -race, merge in the least precise: set, then count.
+func Merge(profiles ...*Profile) (*Profile, error) {
+	merged := &Profile{Mode: ModeAtomic}
+	for _, p := range profiles {
+		switch p.Mode {
+		case ModeSet:
+			merged.Mode = ModeSet
+		case ModeCount:
+			if merged.Mode == ModeAtomic {
+				merged.Mode = ModeCount
+			}
+		case ModeAtomic:
+		default:
+			return nil, fmt.Errorf("unknown coverage mode %q", p.Mode)
+		}
+	}
+	if len(profiles) == 0 {
+		merged.Mode = ModeSet
+	}
+
+	index := make(map[string]int)
+	for _, p := range profiles {
+		for _, b := range p.Blocks {
+			if merged.Mode == ModeSet {
+				b.Count = min(b.Count, 1)
+			}
+			i, ok := index[b.Position]
+			if !ok {
+				index[b.Position] = len(merged.Blocks)
+				merged.Blocks = append(merged.Blocks, b)
+				continue
+			}
+			if merged.Mode == ModeSet {
+				merged.Blocks[i].Count = max(merged.Blocks[i].Count, b.Count)
+			} else {
+				merged.Blocks[i].Count += b.Count
+			}
+		}
+	}
+	sort.SliceStable(merged.Blocks, func(i, j int) bool { return merged.Blocks[i].Position < merged.Blocks[j].Position })
+	return merged, nil
+}
+
+// Percent returns the percentage of the statements that ran, false when the
+// profile has none
+func (p *Profile) Percent() (float64, bool) {
+	total, covered := 0, 0
+	for _, b := range p.Blocks {
+		total += b.Statements
+		if b.Count > 0 {
+			covered += b.Statements
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return 100 * float64(covered) / float64(total), true
+}
+
+// Write writes the profile in the format of go test -coverprofile
+func (p *Profile) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", p.Mode)
+	for _, b := range p.Blocks {
+		fmt.Fprintf(bw, "%s %d %d\n", b.Position, b.Statements, b.Count)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,83 @@
+package coverage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse(strings.NewReader(`mode: count
+example.com/core/core.go:3.20,5.2 1 4
+example.com/core/core.go:7.20,9.2 2 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Mode != ModeCount || len(p.Blocks) != 2 || p.Blocks[0].Count != 4 || p.Blocks[1].Statements != 2 {
+		t.Errorf("unexpected profile: %+v", p)
+	}
+	if percent, ok := p.Percent(); !ok || percent < 33.3 || percent > 33.4 {
+		t.Errorf("expected 33.3%% of the statements covered, got %v %v", percent, ok)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, content := range []string{
+		"example.com/core/core.go:3.20,5.2 1 4\n",
+		"mode: set\nexample.com/core/core.go:3.20,5.2 one 4\n",
+		"mode: set\nnot a block\n",
+		"mode: set\nmode: count\n",
+	} {
+		if _, err := Parse(strings.NewReader(content)); err == nil {
+			t.Errorf("expected an error for:\n%s", content)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	parse := func(content string) *Profile {
+		t.Helper()
+		p, err := Parse(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a := parse("mode: count\nexample.com/core/core.go:3.20,5.2 1 4\nexample.com/core/core.go:7.20,9.2 2 0\n")
+	b := parse("mode: count\nexample.com/core/core.go:7.20,9.2 2 1\nexample.com/api/api.go:3.20,5.2 1 0\n")
+
+	merged, err := Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := merged.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `mode: count
+example.com/api/api.go:3.20,5.2 1 0
+example.com/core/core.go:3.20,5.2 1 4
+example.com/core/core.go:7.20,9.2 2 1
+`
+	if out.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out.String())
+	}
+	if percent, _ := merged.Percent(); percent != 75 {
+		t.Errorf("expected 75%% covered, got %v", percent)
+	}
+
+	// A set profile makes the counts booleans
+	set := parse("mode: set\nexample.com/core/core.go:3.20,5.2 1 1\n")
+	merged, err = Merge(a, set)
+	if err != nil || merged.Mode != ModeSet || merged.Blocks[0].Count != 1 {
+		t.Errorf("expected a set profile with the block run, got %+v, %v", merged, err)
+	}
+	atomic := parse("mode: atomic\nexample.com/core/core.go:3.20,5.2 1 2\n")
+	merged, err = Merge(atomic, a)
+	if err != nil || merged.Mode != ModeCount || merged.Blocks[0].Count != 6 {
+		t.Errorf("expected the atomic and count profiles to merge as count, got %+v, %v", merged, err)
+	}
+	if _, err := Merge(&Profile{Mode: "bogus"}); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
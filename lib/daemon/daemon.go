@@ -0,0 +1,478 @@
+package daemon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/ignore"
+)
+
+// Methods of the daemon protocol
+const (
+	MethodState  = "state"
+	MethodStatus = "status"
+	MethodStop   = "stop"
+	// MethodReport records the Report of a request in the metrics
+	MethodReport = "report"
+)
+
+// debounce is how long the server waits after a change before refreshing,
+// so that a checkout or a formatter touching many files refreshes once
+const debounce = 100 * time.Millisecond
+
+// State is the workspace snapshot kept warm by the daemon
+type State struct {
+	Modules []analyzer.Module `json:"modules"`
+	// Imports is the result of analyzer.ListModuleImports
+	Imports map[string]map[string][]analyzer.Import `json:"imports"`
+	// Embeds are the //go:embed patterns of the modules, see analyzer.Analysis
+	Embeds map[string][]string `json:"embeds,omitempty"`
+	// NonGoFiles are the cgo, assembly and object files of the modules
+	NonGoFiles map[string][]string `json:"nonGoFiles,omitempty"`
+}
+
+// Status describes a running daemon
+type Status struct {
+	Root      string    `json:"root"`
+	PID       int       `json:"pid"`
+	Started   time.Time `json:"started"`
+	Refreshed time.Time `json:"refreshed"`
+	// Refreshes counts the reloads triggered by file changes
+	Refreshes int `json:"refreshes"`
+	// Queries counts the state requests served
+	Queries int `json:"queries"`
+	// Files is the number of hashed go.mod, go.work, .gitignore and .go files
+	Files int `json:"files"`
+	// Error is the error of the last refresh; state requests fail until the next one succeeds
+	Error string `json:"error,omitempty"`
+}
+
+// Request is a message sent by a client, one JSON object per connection
+type Request struct {
+	Method string  `json:"method"`
+	Report *Report `json:"report,omitempty"`
+}
+
+// Response answers a Request
+type Response struct {
+	State  *State  `json:"state,omitempty"`
+	Status *Status `json:"status,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// SocketPath returns the unix socket of the daemon serving the workspace
+// rooted at root, named after a hash of root as socket paths are limited to
+// about 100 bytes. It lives in a directory of the user only, see socketDir,
+// so that other users can neither query nor stop the daemon.
+func SocketPath(root string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(root)))
+	return filepath.Join(socketDir(), hex.EncodeToString(sum[:6])+".sock")
+}
+
+// socketDir returns the directory of the sockets of the daemons of the user:
+// knit in $XDG_RUNTIME_DIR or in the user cache directory, or else a
+// directory of the temporary directory named after the user id
+func socketDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "knit")
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "knit")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("knit-%d", os.Getuid()))
+}
+
+// createSocketDir creates the directory of socket, accessible to the user
+// only. Chmod fails on a directory another user created.
+func createSocketDir(socket string) error {
+	dir := filepath.Dir(socket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return fmt.Errorf("failed to restrict %s to its user: %w", dir, err)
+	}
+	return nil
+}
+
+// Server keeps the state of a workspace warm and serves it on a unix socket
+type Server struct {
+	root    string
+	watcher *fsnotify.Watcher
+	// ignore skips the directories ignored by git, reloaded on refresh so
+	// that .gitignore changes apply
+	ignore *ignore.Matcher
+
+	mu     sync.RWMutex
+	state  *State
+	hashes map[string]string
+	status Status
+	// metrics aggregates the reports of the commands
+	metrics *metrics
+	// stale is set from a relevant change until the next refresh, which
+	// closes fresh; state requests wait for it rather than answer stale data
+	stale bool
+	fresh chan struct{}
+}
+
+// NewServer loads the state of the workspace rooted at root
+func NewServer(ctx context.Context, root string) (*Server, error) {
+	s := &Server{
+		root:    root,
+		hashes:  make(map[string]string),
+		status:  Status{Root: root, PID: os.Getpid(), Started: time.Now()},
+		metrics: newMetrics(),
+		ignore:  ignore.New(root),
+	}
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Serve watches the workspace and answers requests until ctx is done or a
+// client sends MethodStop
+func (s *Server) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	socket := SocketPath(s.root)
+	if _, err := Query(socket, MethodStatus); err == nil {
+		return fmt.Errorf("a daemon already serves %s on %s", s.root, socket)
+	}
+	if err := createSocketDir(socket); err != nil {
+		return err
+	}
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	defer os.Remove(socket)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to watch %s: %w", s.root, err)
+	}
+	defer watcher.Close()
+	s.watcher = watcher
+	if err := s.watchTree(s.root); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go s.watch(ctx)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept a connection: %w", err)
+		}
+		go s.handle(conn, cancel)
+	}
+}
+
+func (s *Server) handle(conn net.Conn, stop context.CancelFunc) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(Response{Error: "invalid request: " + err.Error()})
+		return
+	}
+
+	if req.Method == MethodState {
+		s.mu.RLock()
+		stale, fresh := s.stale, s.fresh
+		s.mu.RUnlock()
+		if stale {
+			select {
+			case <-fresh:
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+
+	var resp Response
+	s.mu.Lock()
+	switch req.Method {
+	case MethodState:
+		if s.status.Error != "" {
+			resp.Error = s.status.Error
+		} else {
+			s.status.Queries++
+			resp.State = s.state
+		}
+	case MethodStatus:
+		status := s.status
+		resp.Status = &status
+	case MethodStop:
+		status := s.status
+		resp.Status = &status
+		defer stop()
+	case MethodReport:
+		if req.Report == nil {
+			resp.Error = "missing report"
+		} else {
+			s.metrics.record(*req.Report)
+		}
+	default:
+		resp.Error = "unknown method: " + req.Method
+	}
+	s.mu.Unlock()
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// watch refreshes the state once files stop changing
+func (s *Server) watch(ctx context.Context) {
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if s.changed(event) {
+				s.markStale()
+				timer = time.After(debounce)
+			}
+		case _, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been dropped, refresh to be safe
+			s.markStale()
+			timer = time.After(debounce)
+		case <-timer:
+			timer = nil
+			s.mu.Lock()
+			s.status.Refreshes++
+			s.mu.Unlock()
+			s.refresh(ctx)
+		}
+	}
+}
+
+// changed reports whether an event may change the state: a new directory,
+// which is watched from now on, or a relevant file whose content changed
+func (s *Server) changed(event fsnotify.Event) bool {
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if s.skip(event.Name) {
+				return false
+			}
+			s.watchTree(event.Name)
+			return true
+		}
+	}
+	if !relevant(event.Name) {
+		return false
+	}
+
+	hash, _ := hashFile(event.Name)
+	s.mu.RLock()
+	previous := s.hashes[event.Name]
+	s.mu.RUnlock()
+	return hash != previous
+}
+
+func (s *Server) markStale() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stale {
+		s.stale = true
+		s.fresh = make(chan struct{})
+	}
+}
+
+// refresh reloads the state and the file hashes. On failure the error is
+// reported to clients, which load the workspace themselves, until the next
+// refresh.
+func (s *Server) refresh(ctx context.Context) error {
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.stale {
+			s.stale = false
+			close(s.fresh)
+		}
+	}()
+
+	matcher := ignore.New(s.root)
+	s.mu.Lock()
+	s.ignore = matcher
+	s.mu.Unlock()
+	hashes, err := s.hashTree()
+	if err == nil {
+		var state *State
+		if state, err = load(ctx, s.root); err == nil {
+			s.mu.Lock()
+			s.state, s.hashes = state, hashes
+			s.status.Refreshed, s.status.Files, s.status.Error = time.Now(), len(hashes), ""
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	s.mu.Lock()
+	s.status.Error = err.Error()
+	s.mu.Unlock()
+	return err
+}
+
+// load lists the modules, their imports, embedded and non-Go files, as the
+// commands do
+func load(ctx context.Context, root string) (*State, error) {
+	modules, err := analyzer.ListModuleContext(ctx, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list modules: %w", err)
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no modules found in workspace")
+	}
+	a, err := analyzer.AnalyzeModules(ctx, modules, analyzer.BuildContext{})
+	if err != nil {
+		return nil, err
+	}
+	return &State{Modules: modules, Imports: a.Imports, Embeds: a.Embeds, NonGoFiles: a.NonGoFiles}, nil
+}
+
+// watchTree watches dir and its subdirectories
+func (s *Server) watchTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && s.skip(path) {
+			return filepath.SkipDir
+		}
+		if err := s.watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// hashTree hashes every relevant file below the root
+func (s *Server) hashTree() (map[string]string, error) {
+	root := s.root
+	hashes := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && s.skip(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !relevant(path) {
+			return nil
+		}
+		if hash, err := hashFile(path); err == nil {
+			hashes[path] = hash
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", root, err)
+	}
+	return hashes, nil
+}
+
+// skip reports whether the directory at path is left out of the state: one
+// skipped by skipDir, a heavy directory or one ignored by git
+func (s *Server) skip(path string) bool {
+	s.mu.RLock()
+	matcher := s.ignore
+	s.mu.RUnlock()
+	return skipDir(filepath.Base(path)) || matcher.SkipDir(path)
+}
+
+// skipDir reports whether a directory is ignored, as the go command does
+// with ./... patterns
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata"
+}
+
+// relevant reports whether the content of a file affects the state
+func relevant(path string) bool {
+	switch name := filepath.Base(path); name {
+	case "go.mod", "go.work", ".gitignore":
+		return true
+	default:
+		return strings.HasSuffix(name, ".go")
+	}
+}
+
+// hashFile returns the SHA-256 of a file, empty when it cannot be read
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ErrNotRunning is returned by Query when no daemon listens on the socket
+var ErrNotRunning = errors.New("no daemon running")
+
+// Query sends a request to the daemon listening on socket
+func Query(socket, method string) (*Response, error) {
+	return Send(socket, Request{Method: method})
+}
+
+// Send sends req to the daemon listening on socket
+func Send(socket string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", socket, 200*time.Millisecond)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,134 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nicolasgere/knit/lib/ignore"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "go.work"), "go 1.22.4\n\nuse (\n\t./a\n\t./b\n)\n")
+	writeFile(t, filepath.Join(root, "a", "go.mod"), "module example.com/a\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(root, "a", "a.go"), "package a\n\nfunc A() {}\n")
+	writeFile(t, filepath.Join(root, "b", "go.mod"), "module example.com/b\n\ngo 1.22.4\n")
+	writeFile(t, filepath.Join(root, "b", "b.go"), "package b\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := NewServer(ctx, root)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx) }()
+
+	socket := SocketPath(root)
+	var resp *Response
+	for i := 0; i < 50; i++ {
+		if resp, err = Query(socket, MethodState); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("state query failed: %v", err)
+	}
+	if len(resp.State.Modules) != 2 || len(resp.State.Imports["example.com/b"]) != 0 {
+		t.Fatalf("unexpected initial state %+v", resp.State)
+	}
+	if info, err := os.Stat(filepath.Dir(socket)); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0700 {
+		t.Errorf("expected the socket in a directory of its user only, got %v", info.Mode())
+	}
+	if filepath.Dir(socket) != filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "knit") {
+		t.Errorf("expected the socket in $XDG_RUNTIME_DIR, got %s", socket)
+	}
+
+	// Importing a from b must be seen by the next query
+	writeFile(t, filepath.Join(root, "b", "b.go"), "package b\n\nimport \"example.com/a\"\n\nvar _ = a.A\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if resp, err = Query(socket, MethodState); err == nil && len(resp.State.Imports["example.com/b"]) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected b to import a after the change, got %+v (%v)", resp, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	resp, err = Query(socket, MethodStatus)
+	if err != nil {
+		t.Fatalf("status query failed: %v", err)
+	}
+	if resp.Status.Refreshes < 1 || resp.Status.Queries < 2 || resp.Status.Files != 5 {
+		t.Errorf("unexpected status %+v", resp.Status)
+	}
+
+	if _, err := Query(socket, MethodStop); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after stop")
+	}
+	if _, err := Query(socket, MethodStatus); err != ErrNotRunning {
+		t.Errorf("expected ErrNotRunning once stopped, got %v", err)
+	}
+}
+
+func TestSkipDirAndRelevant(t *testing.T) {
+	for _, name := range []string{".git", "_build", "vendor", "testdata"} {
+		if !skipDir(name) {
+			t.Errorf("expected %s to be skipped", name)
+		}
+	}
+	if skipDir("api") {
+		t.Error("expected api to be watched")
+	}
+	for path, want := range map[string]bool{"a/go.mod": true, "go.work": true, "a/x.go": true, "README.md": false, "go.sum": false} {
+		if got := relevant(path); got != want {
+			t.Errorf("relevant(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestHashTreeSkipsIgnored(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".gitignore"), "gen/\n")
+	writeFile(t, filepath.Join(root, "go.mod"), "module example.com/a\n")
+	writeFile(t, filepath.Join(root, "a.go"), "package a\n")
+	writeFile(t, filepath.Join(root, "gen", "gen.go"), "package gen\n")
+	writeFile(t, filepath.Join(root, "web", "node_modules", "x", "x.go"), "package x\n")
+
+	s := &Server{root: root, ignore: ignore.New(root)}
+	hashes, err := s.hashTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 3 {
+		t.Errorf("expected go.mod, a.go and .gitignore to be hashed, got %v", hashes)
+	}
+}
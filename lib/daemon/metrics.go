@@ -0,0 +1,248 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report is sent by the commands to the daemon serving their workspace, which
+// aggregates them into its metrics
+type Report struct {
+	// Task and Runs report a run of knit test, knit fmt or a knit.yaml task
+	Task string `json:"task,omitempty"`
+	Runs []Run  `json:"runs,omitempty"`
+	// CacheEnabled is set when the task cache was read, counting every run
+	// as a cache hit or miss
+	CacheEnabled bool `json:"cacheEnabled,omitempty"`
+	// Affected, when set, is the size of an affected set
+	Affected *int `json:"affected,omitempty"`
+}
+
+// Run is the result of a task in a module
+type Run struct {
+	Module   string        `json:"module"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exitCode"`
+	// Cached is set when the result was restored from the cache
+	Cached bool `json:"cached,omitempty"`
+}
+
+// Buckets of the histograms, in seconds for the durations
+var (
+	durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+	affectedBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []int
+	count   int
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]int, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type taskModule struct{ task, module string }
+
+// metrics aggregates the reports received since the daemon started
+type metrics struct {
+	runs         map[[2]string]int // task and result
+	failures     map[taskModule]int
+	lastDuration map[taskModule]time.Duration
+	durations    map[string]*histogram
+	cacheHits    map[string]int
+	cacheMisses  map[string]int
+	affected     *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		runs:         make(map[[2]string]int),
+		failures:     make(map[taskModule]int),
+		lastDuration: make(map[taskModule]time.Duration),
+		durations:    make(map[string]*histogram),
+		cacheHits:    make(map[string]int),
+		cacheMisses:  make(map[string]int),
+		affected:     newHistogram(affectedBuckets),
+	}
+}
+
+func (m *metrics) record(r Report) {
+	if r.Affected != nil {
+		m.affected.observe(float64(*r.Affected))
+	}
+	for _, run := range r.Runs {
+		key := taskModule{r.Task, run.Module}
+		switch {
+		case run.Cached:
+			m.runs[[2]string{r.Task, "cached"}]++
+			m.cacheHits[r.Task]++
+			continue
+		case run.ExitCode != 0:
+			m.runs[[2]string{r.Task, "failure"}]++
+			m.failures[key]++
+		default:
+			m.runs[[2]string{r.Task, "success"}]++
+		}
+		if r.CacheEnabled {
+			m.cacheMisses[r.Task]++
+		}
+		if m.durations[r.Task] == nil {
+			m.durations[r.Task] = newHistogram(durationBuckets)
+		}
+		m.durations[r.Task].observe(run.Duration.Seconds())
+		m.lastDuration[key] = run.Duration
+	}
+}
+
+// MetricsHandler serves the metrics of the daemon on /metrics, in the
+// Prometheus text format
+func (s *Server) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		s.writeMetrics(w)
+	})
+	return mux
+}
+
+func (s *Server) writeMetrics(w io.Writer) {
+	m := s.metrics
+	p := &promWriter{w: w}
+
+	p.header("knit_task_runs_total", "counter", "Task runs in a module by result: success, failure or cached.")
+	for _, k := range sortedKeys(m.runs, func(k [2]string) string { return k[0] + "\x00" + k[1] }) {
+		p.sample("knit_task_runs_total", labels("task", k[0], "result", k[1]), float64(m.runs[k]))
+	}
+	p.header("knit_task_failures_total", "counter", "Failed task runs by module.")
+	for _, k := range sortedKeys(m.failures, taskModuleKey) {
+		p.sample("knit_task_failures_total", labels("task", k.task, "module", k.module), float64(m.failures[k]))
+	}
+	p.header("knit_task_duration_seconds", "histogram", "Duration of the task runs not restored from the cache.")
+	for _, task := range sortedKeys(m.durations, func(k string) string { return k }) {
+		p.histogram("knit_task_duration_seconds", labels("task", task), m.durations[task])
+	}
+	p.header("knit_task_last_duration_seconds", "gauge", "Duration of the last run of a task in a module.")
+	for _, k := range sortedKeys(m.lastDuration, taskModuleKey) {
+		p.sample("knit_task_last_duration_seconds", labels("task", k.task, "module", k.module), m.lastDuration[k].Seconds())
+	}
+
+	tasks := make(map[string]bool)
+	for task := range m.cacheHits {
+		tasks[task] = true
+	}
+	for task := range m.cacheMisses {
+		tasks[task] = true
+	}
+	sorted := sortedKeys(tasks, func(k string) string { return k })
+	p.header("knit_cache_hits_total", "counter", "Task runs restored from the cache.")
+	for _, task := range sorted {
+		p.sample("knit_cache_hits_total", labels("task", task), float64(m.cacheHits[task]))
+	}
+	p.header("knit_cache_misses_total", "counter", "Task runs with cache enabled that were not cached.")
+	for _, task := range sorted {
+		p.sample("knit_cache_misses_total", labels("task", task), float64(m.cacheMisses[task]))
+	}
+	p.header("knit_cache_hit_ratio", "gauge", "Ratio of the task runs with cache enabled restored from the cache since the daemon started.")
+	for _, task := range sorted {
+		p.sample("knit_cache_hit_ratio", labels("task", task), float64(m.cacheHits[task])/float64(m.cacheHits[task]+m.cacheMisses[task]))
+	}
+
+	p.header("knit_affected_modules", "histogram", "Size of the affected sets computed in the workspace.")
+	p.histogram("knit_affected_modules", "", m.affected)
+
+	modules := 0
+	if s.state != nil {
+		modules = len(s.state.Modules)
+	}
+	p.header("knit_workspace_modules", "gauge", "Modules in the workspace.")
+	p.sample("knit_workspace_modules", "", float64(modules))
+	p.header("knit_daemon_files", "gauge", "Watched go.mod, go.work, .gitignore and .go files.")
+	p.sample("knit_daemon_files", "", float64(s.status.Files))
+	p.header("knit_daemon_refreshes_total", "counter", "Reloads of the workspace triggered by file changes.")
+	p.sample("knit_daemon_refreshes_total", "", float64(s.status.Refreshes))
+	p.header("knit_daemon_queries_total", "counter", "State requests served.")
+	p.sample("knit_daemon_queries_total", "", float64(s.status.Queries))
+	p.header("knit_daemon_start_time_seconds", "gauge", "Start time of the daemon since the epoch.")
+	p.sample("knit_daemon_start_time_seconds", "", float64(s.status.Started.Unix()))
+}
+
+func taskModuleKey(k taskModule) string {
+	return k.task + "\x00" + k.module
+}
+
+// sortedKeys returns the keys of m ordered by their sort key
+func sortedKeys[K comparable, V any](m map[K]V, key func(K) string) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return key(keys[i]) < key(keys[j]) })
+	return keys
+}
+
+// labels formats name and value pairs as a Prometheus label set, without
+// the braces
+func labels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// promWriter writes the Prometheus text exposition format
+type promWriter struct {
+	w io.Writer
+}
+
+func (p *promWriter) header(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p *promWriter) sample(name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(p.w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (p *promWriter) histogram(name, labels string, h *histogram) {
+	with := func(le string) string {
+		if labels == "" {
+			return `le="` + le + `"`
+		}
+		return labels + `,le="` + le + `"`
+	}
+	for i, b := range h.buckets {
+		p.sample(name+"_bucket", with(strconv.FormatFloat(b, 'g', -1, 64)), float64(h.counts[i]))
+	}
+	p.sample(name+"_bucket", with("+Inf"), float64(h.count))
+	p.sample(name+"_sum", labels, h.sum)
+	p.sample(name+"_count", labels, float64(h.count))
+}
@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	s := &Server{metrics: newMetrics(), status: Status{Files: 3, Refreshes: 2, Started: time.Unix(100, 0)}}
+	one, three := 1, 3
+	s.metrics.record(Report{Affected: &one})
+	s.metrics.record(Report{Affected: &three})
+	s.metrics.record(Report{Task: "build", CacheEnabled: true, Runs: []Run{
+		{Module: "example.com/a", Duration: 2 * time.Second},
+		{Module: "example.com/b", Duration: 40 * time.Second, ExitCode: 1},
+		{Module: "example.com/c", Duration: time.Second, Cached: true},
+		{Module: "example.com/d", Duration: time.Second, Cached: true},
+	}})
+	s.metrics.record(Report{Task: "test", Runs: []Run{{Module: "example.com/a", Duration: 300 * time.Millisecond}}})
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE knit_task_runs_total counter\n",
+		`knit_task_runs_total{task="build",result="cached"} 2`,
+		`knit_task_runs_total{task="build",result="failure"} 1`,
+		`knit_task_runs_total{task="test",result="success"} 1`,
+		`knit_task_failures_total{task="build",module="example.com/b"} 1`,
+		`knit_task_duration_seconds_bucket{task="build",le="2.5"} 1`,
+		`knit_task_duration_seconds_bucket{task="build",le="+Inf"} 2`,
+		`knit_task_duration_seconds_sum{task="build"} 42`,
+		`knit_task_last_duration_seconds{task="test",module="example.com/a"} 0.3`,
+		`knit_cache_hits_total{task="build"} 2`,
+		`knit_cache_misses_total{task="build"} 2`,
+		`knit_cache_hit_ratio{task="build"} 0.5`,
+		`knit_affected_modules_bucket{le="2"} 1`,
+		`knit_affected_modules_bucket{le="5"} 2`,
+		"knit_affected_modules_sum 4\n",
+		"knit_daemon_refreshes_total 2\n",
+		"knit_daemon_start_time_seconds 100\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the metrics:\n%s", want, body)
+		}
+	}
+	// Tasks without cache enabled have no cache metrics
+	if strings.Contains(body, `knit_cache_misses_total{task="test"}`) {
+		t.Errorf("unexpected cache metrics for test:\n%s", body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	if got := labels("task", `a"b\c`, "module", "m"); got != `task="a\"b\\c",module="m"` {
+		t.Errorf("unexpected labels %s", got)
+	}
+}
@@ -0,0 +1,93 @@
+package git
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ExportTree writes the files of the repository at ref into dest, without
+// touching the working tree. When useMergeBase is true, the merge-base of ref
+// and HEAD is exported instead.
+func ExportTree(ref string, useMergeBase bool, dir, dest string) error {
+	if useMergeBase {
+		mergeBase, err := getMergeBase(ref, dir)
+		if err != nil {
+			return fmt.Errorf("failed to get merge-base: %w", err)
+		}
+		ref = mergeBase
+	}
+
+	cmd := exec.Command("git", "archive", "--format=tar", ref)
+	cmd.Dir = dir
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("git archive failed: %w", err)
+	}
+
+	extractErr := extractTar(stdout, dest)
+	// Drain the pipe so git can exit even if extraction stopped early
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git archive %s failed: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+	return extractErr
+}
+
+// extractTar extracts regular files, directories and symlinks of a tar
+// stream under dest, rejecting entries escaping it
+func extractTar(r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		target := filepath.Join(dest, filepath.FromSlash(hdr.Name))
+		if target != dest && !strings.HasPrefix(target, dest+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %q escapes the destination", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Backend names accepted by NewBackend
+const (
+	BackendAuto  = "auto"
+	BackendExec  = "exec"
+	BackendGoGit = "go-git"
+)
+
+// Backend computes the files changed in a git repository
+type Backend interface {
+	// GetChangedFiles returns the files changed compared to compareRef, relative to the repository root
+	GetChangedFiles(compareRef string, useMergeBase bool, dir string) ([]string, error)
+	// GetRepoRoot returns the top-level directory of the repository containing dir
+	GetRepoRoot(dir string) (string, error)
+}
+
+// NewBackend returns the backend with the given name.
+// "auto" (or an empty name) uses the git binary when it is on the PATH,
+// and falls back to the pure-Go implementation otherwise.
+func NewBackend(name string) (Backend, error) {
+	switch name {
+	case "", BackendAuto:
+		if _, err := exec.LookPath("git"); err != nil {
+			return goGitBackend{}, nil
+		}
+		return execBackend{}, nil
+	case BackendExec:
+		return execBackend{}, nil
+	case BackendGoGit:
+		return goGitBackend{}, nil
+	default:
+		return nil, fmt.Errorf("unknown git backend: %s (use auto, exec, or go-git)", name)
+	}
+}
+
+// execBackend shells out to the git binary
+type execBackend struct{}
+
+func (execBackend) GetChangedFiles(compareRef string, useMergeBase bool, dir string) ([]string, error) {
+	return GetChangedFiles(compareRef, useMergeBase, dir)
+}
+
+func (execBackend) GetRepoRoot(dir string) (string, error) {
+	return GetRepoRoot(dir)
+}
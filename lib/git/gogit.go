@@ -0,0 +1,173 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// goGitBackend reads the repository with go-git, for environments without the git binary
+type goGitBackend struct{}
+
+func openRepository(dir string) (*gogit.Repository, error) {
+	repo, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{
+		DetectDotGit:          true,
+		EnableDotGitCommonDir: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open git repository: %w", err)
+	}
+	return repo, nil
+}
+
+func (goGitBackend) GetRepoRoot(dir string) (string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return "", err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+	return wt.Filesystem.Root(), nil
+}
+
+// GetChangedFiles mirrors `git diff --name-only <ref>`: it compares the tree of
+// the reference (or its merge-base with HEAD) against the working tree,
+// ignoring untracked files.
+func (goGitBackend) GetChangedFiles(compareRef string, useMergeBase bool, dir string) ([]string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := resolveCommit(repo, compareRef)
+	if err != nil {
+		return nil, err
+	}
+	head, err := resolveCommit(repo, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	if useMergeBase {
+		bases, err := head.MergeBase(base)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get merge-base: %w", err)
+		}
+		if len(bases) == 0 {
+			return nil, fmt.Errorf("failed to get merge-base: no common ancestor between %s and HEAD", compareRef)
+		}
+		base = bases[0]
+	}
+
+	baseTree, err := base.Tree()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	// Candidates are files changed between base and HEAD, plus files with
+	// local modifications. Each is then checked against the working tree.
+	candidates := make(map[string]bool)
+	changes, err := object.DiffTree(baseTree, headTree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff trees: %w", err)
+	}
+	for _, change := range changes {
+		if change.From.Name != "" {
+			candidates[change.From.Name] = true
+		}
+		if change.To.Name != "" {
+			candidates[change.To.Name] = true
+		}
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree status: %w", err)
+	}
+	for file, s := range status {
+		if s.Worktree == gogit.Untracked && s.Staging == gogit.Untracked {
+			continue
+		}
+		candidates[file] = true
+	}
+
+	result := make([]string, 0, len(candidates))
+	for file := range candidates {
+		changed, err := differsFromTree(wt, baseTree, file)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			result = append(result, file)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func resolveCommit(repo *gogit.Repository, ref string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", ref, err)
+	}
+	return commit, nil
+}
+
+// differsFromTree reports whether the working tree copy of file differs from its version in tree
+func differsFromTree(wt *gogit.Worktree, tree *object.Tree, file string) (bool, error) {
+	entry, err := tree.File(file)
+	inTree := err == nil
+	if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+		return false, err
+	}
+
+	info, err := wt.Filesystem.Lstat(file)
+	inWorktree := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if !inTree || !inWorktree {
+		return inTree != inWorktree, nil
+	}
+
+	var content []byte
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := wt.Filesystem.Readlink(file)
+		if err != nil {
+			return false, err
+		}
+		content = []byte(target)
+	} else {
+		f, err := wt.Filesystem.Open(file)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		if content, err = io.ReadAll(f); err != nil {
+			return false, err
+		}
+	}
+
+	return plumbing.ComputeHash(plumbing.BlobObject, content) != entry.Hash, nil
+}
@@ -1,6 +1,8 @@
 package git
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os/exec"
 	"path/filepath"
@@ -52,6 +54,58 @@ func getMergeBase(ref string, dir string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
+// GetRepoRoot returns the top-level directory of the git repository containing dir
+func GetRepoRoot(dir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse --show-toplevel failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Version returns the version of the git binary, as printed by git --version
+func Version() (string, error) {
+	output, err := exec.Command("git", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("git --version failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// RefExists reports whether ref resolves to a commit in the repository containing dir
+func RefExists(ref, dir string) bool {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
+
+// Revision identifies the code checked out in the repository containing dir:
+// the HEAD commit, followed by a hash of the changes to the tracked files
+// when there are some
+func Revision(dir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse HEAD failed: %w", err)
+	}
+	head := strings.TrimSpace(string(output))
+
+	cmd = exec.Command("git", "diff", "HEAD")
+	cmd.Dir = dir
+	diff, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff HEAD failed: %w", err)
+	}
+	if len(diff) == 0 {
+		return head, nil
+	}
+	sum := sha256.Sum256(diff)
+	return head + "+" + hex.EncodeToString(sum[:6]), nil
+}
+
 // GetAffectedRootDirectories returns root directories that have changed files.
 // Deprecated: Use GetChangedFiles + FindAffectedModules instead.
 func GetAffectedRootDirectories(compareBranch string, dir string) ([]string, error) {
@@ -81,8 +135,8 @@ func GetAffectedRootDirectories(compareBranch string, dir string) ([]string, err
 }
 
 // FindAffectedModuleDirs determines which module directories contain changed files.
-// It takes the list of changed files and the list of module directories (absolute paths),
-// and returns the directories of modules that have changes.
+// It takes the list of changed files (relative to workspaceRoot, or absolute) and the list
+// of module directories (absolute paths), and returns the directories of modules that have changes.
 func FindAffectedModuleDirs(changedFiles []string, moduleDirs []string, workspaceRoot string) []string {
 	// Sort module directories by length (longest first) so more specific paths match first
 	// This prevents the root module from matching files in submodules
@@ -94,7 +148,10 @@ func FindAffectedModuleDirs(changedFiles []string, moduleDirs []string, workspac
 
 	for _, file := range changedFiles {
 		// Convert to absolute path
-		absFile := filepath.Join(workspaceRoot, file)
+		absFile := filepath.Clean(file)
+		if !filepath.IsAbs(file) {
+			absFile = filepath.Join(workspaceRoot, file)
+		}
 
 		// Check which module this file belongs to (most specific first)
 		for _, modDir := range sortedDirs {
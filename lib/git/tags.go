@@ -0,0 +1,57 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ListTags returns the tags of the repository containing dir
+func ListTags(dir string) ([]string, error) {
+	cmd := exec.Command("git", "tag", "--list")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git tag --list failed: %w", err)
+	}
+	trimmed := strings.TrimSpace(string(output))
+	if trimmed == "" {
+		return []string{}, nil
+	}
+	return strings.Split(trimmed, "\n"), nil
+}
+
+// CommitMessages returns the full messages of the commits reachable from HEAD
+// but not from since (every commit when since is empty) that touch the given
+// pathspecs, relative to dir, newest first.
+func CommitMessages(since string, pathspecs []string, dir string) ([]string, error) {
+	rev := "HEAD"
+	if since != "" {
+		rev = since + "..HEAD"
+	}
+	args := append([]string{"log", "--format=%B%x00", rev, "--"}, pathspecs...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %w", err)
+	}
+
+	var messages []string
+	for _, msg := range strings.Split(string(output), "\x00") {
+		if msg = strings.TrimSpace(msg); msg != "" {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// CreateTag creates a lightweight tag pointing to HEAD
+func CreateTag(name, dir string) error {
+	cmd := exec.Command("git", "tag", name)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git tag %s failed: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Package gotest parses the events printed by go test -json into the results
+// of the tests, so that runs can be counted and reported test by test rather
+// than by matching the text output, and lists the tests of a module.
+package gotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is an event of go test -json, as documented by go doc test2json
+type Event struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+	// ImportPath is the package of a build-output event
+	ImportPath string
+}
+
+// Status is the outcome of a test
+type Status string
+
+// Outcomes of a test
+const (
+	Passed  Status = "pass"
+	Failed  Status = "fail"
+	Skipped Status = "skip"
+)
+
+// Result is the outcome of a test, subtests included
+type Result struct {
+	Package string
+	Test    string
+	Status  Status
+	Elapsed time.Duration
+}
+
+// TopLevel reports whether the result is the one of a test function rather
+// than of a subtest
+func (r Result) TopLevel() bool {
+	return !strings.Contains(r.Test, "/")
+}
+
+// Counts counts the results of the tests by outcome
+type Counts struct {
+	Passed, Failed, Skipped int
+}
+
+// Total returns the number of tests counted
+func (c Counts) Total() int {
+	return c.Passed + c.Failed + c.Skipped
+}
+
+func (c Counts) String() string {
+	s := fmt.Sprintf("%d passed, %d failed", c.Passed, c.Failed)
+	if c.Skipped > 0 {
+		s += fmt.Sprintf(", %d skipped", c.Skipped)
+	}
+	return s
+}
+
+// Results collects the results of the tests from the lines of a go test
+// -json run. The zero value is ready to use and safe for concurrent use.
+type Results struct {
+	mu             sync.Mutex
+	tests          []Result
+	failedPackages []string
+	cached         map[string]bool
+	running        map[string][]string
+}
+
+// Record records a line of the output of go test -json and returns the lines
+// to print in its place, the way go test without -json would: the output of
+// the packages and of the failed tests. The output of a test is held until
+// its result is known. Lines that are not events are returned as is.
+func (r *Results) Record(line []byte) []string {
+	var e Event
+	if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &e) != nil || e.Action == "" {
+		return []string{string(line)}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := e.Package + " " + e.Test
+	switch e.Action {
+	case "build-output":
+		return outputLines(e.Output)
+	case "output":
+		if e.Test == "" {
+			// The verbose PASS of a package is left out like without -json
+			if strings.TrimSpace(e.Output) == "PASS" {
+				return nil
+			}
+			if strings.HasPrefix(e.Output, "ok ") && strings.Contains(e.Output, "(cached)") {
+				if r.cached == nil {
+					r.cached = make(map[string]bool)
+				}
+				r.cached[e.Package] = true
+			}
+			return outputLines(e.Output)
+		}
+		if r.running == nil {
+			r.running = make(map[string][]string)
+		}
+		r.running[key] = append(r.running[key], outputLines(e.Output)...)
+	case "pass", "fail", "skip":
+		if e.Test == "" {
+			if e.Action == "fail" {
+				r.failedPackages = append(r.failedPackages, e.Package)
+			}
+			return nil
+		}
+		output := r.running[key]
+		delete(r.running, key)
+		r.tests = append(r.tests, Result{
+			Package: e.Package,
+			Test:    e.Test,
+			Status:  Status(e.Action),
+			Elapsed: time.Duration(e.Elapsed * float64(time.Second)),
+		})
+		if e.Action == "fail" {
+			return output
+		}
+	}
+	return nil
+}
+
+func outputLines(output string) []string {
+	return strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+}
+
+// Tests returns the results recorded, in the order the tests ended
+func (r *Results) Tests() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Result(nil), r.tests...)
+}
+
+// FailedPackages returns the packages that failed, because of a test or not
+func (r *Results) FailedPackages() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.failedPackages...)
+}
+
+// Cached reports whether the results of a package were replayed from the
+// test cache of the go command rather than run
+func (r *Results) Cached(pkg string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cached[pkg]
+}
+
+// Count counts results by outcome
+func Count(results []Result) Counts {
+	var c Counts
+	for _, t := range results {
+		switch t.Status {
+		case Passed:
+			c.Passed++
+		case Failed:
+			c.Failed++
+		case Skipped:
+			c.Skipped++
+		}
+	}
+	return c
+}
+
+// Slowest returns the n longest test functions of results, longest first.
+// Subtests are left out, their time being part of the one of their test.
+func Slowest(results []Result, n int) []Result {
+	var top []Result
+	for _, t := range results {
+		if t.TopLevel() && t.Status != Skipped {
+			top = append(top, t)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Elapsed > top[j].Elapsed })
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
@@ -0,0 +1,81 @@
+package gotest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const run = `{"Action":"start","Package":"example.com/core"}
+{"Action":"run","Package":"example.com/core","Test":"TestAdd"}
+{"Action":"output","Package":"example.com/core","Test":"TestAdd","Output":"=== RUN   TestAdd\n"}
+{"Action":"output","Package":"example.com/core","Test":"TestAdd","Output":"--- PASS: TestAdd (0.30s)\n"}
+{"Action":"pass","Package":"example.com/core","Test":"TestAdd","Elapsed":0.3}
+{"Action":"run","Package":"example.com/core","Test":"TestSub"}
+{"Action":"run","Package":"example.com/core","Test":"TestSub/negative"}
+{"Action":"output","Package":"example.com/core","Test":"TestSub/negative","Output":"    core_test.go:12: got 1, want -1\n"}
+{"Action":"fail","Package":"example.com/core","Test":"TestSub/negative","Elapsed":0.5}
+{"Action":"output","Package":"example.com/core","Test":"TestSub","Output":"--- FAIL: TestSub (0.50s)\n"}
+{"Action":"fail","Package":"example.com/core","Test":"TestSub","Elapsed":0.5}
+{"Action":"run","Package":"example.com/core","Test":"TestSkip"}
+{"Action":"output","Package":"example.com/core","Test":"TestSkip","Output":"--- SKIP: TestSkip (0.00s)\n"}
+{"Action":"skip","Package":"example.com/core","Test":"TestSkip"}
+{"Action":"output","Package":"example.com/core","Output":"FAIL\n"}
+{"Action":"output","Package":"example.com/core","Output":"FAIL\texample.com/core\t0.8s\n"}
+{"Action":"fail","Package":"example.com/core","Elapsed":0.8}
+not an event
+`
+
+func TestRecord(t *testing.T) {
+	var r Results
+	var printed []string
+	for _, line := range strings.Split(strings.TrimSuffix(run, "\n"), "\n") {
+		printed = append(printed, r.Record([]byte(line))...)
+	}
+
+	want := []string{
+		"    core_test.go:12: got 1, want -1",
+		"--- FAIL: TestSub (0.50s)",
+		"FAIL",
+		"FAIL\texample.com/core\t0.8s",
+		"not an event",
+	}
+	if strings.Join(printed, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected the printed lines:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(printed, "\n"))
+	}
+
+	tests := r.Tests()
+	if len(tests) != 4 {
+		t.Fatalf("expected 4 results, got %+v", tests)
+	}
+	if c := Count(tests); c != (Counts{Passed: 1, Failed: 2, Skipped: 1}) || c.String() != "1 passed, 2 failed, 1 skipped" {
+		t.Errorf("unexpected counts %+v", c)
+	}
+	if failed := r.FailedPackages(); len(failed) != 1 || failed[0] != "example.com/core" {
+		t.Errorf("expected example.com/core to fail, got %v", failed)
+	}
+	if r.Cached("example.com/core") {
+		t.Error("expected example.com/core to have run")
+	}
+	r.Record([]byte(`{"Action":"output","Package":"example.com/utils","Output":"ok  \texample.com/utils\t(cached)\n"}`))
+	if !r.Cached("example.com/utils") {
+		t.Error("expected the results of example.com/utils to be cached")
+	}
+	if tests[0].Elapsed != 300*time.Millisecond {
+		t.Errorf("expected TestAdd to last 300ms, got %s", tests[0].Elapsed)
+	}
+}
+
+func TestSlowest(t *testing.T) {
+	results := []Result{
+		{Test: "TestA", Status: Passed, Elapsed: time.Second},
+		{Test: "TestB", Status: Failed, Elapsed: 3 * time.Second},
+		{Test: "TestB/sub", Status: Failed, Elapsed: 3 * time.Second},
+		{Test: "TestC", Status: Skipped, Elapsed: 5 * time.Second},
+		{Test: "TestD", Status: Passed, Elapsed: 2 * time.Second},
+	}
+	slowest := Slowest(results, 2)
+	if len(slowest) != 2 || slowest[0].Test != "TestB" || slowest[1].Test != "TestD" {
+		t.Errorf("expected TestB then TestD, got %+v", slowest)
+	}
+}
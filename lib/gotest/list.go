@@ -0,0 +1,93 @@
+package gotest
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Test is a function go test runs: a test, an example or a fuzz test, whose
+// seed corpus runs as a test
+type Test struct {
+	// Dir is the directory of its package, relative to the module root
+	Dir  string
+	Name string
+}
+
+// testPrefixes are the prefixes of the functions -run selects
+var testPrefixes = []string{"Test", "Example", "Fuzz"}
+
+// ListTests returns the functions go test runs in the packages of the module
+// at moduleDir, sorted by package and name, without building them: they are
+// read from the _test.go files whatever their build constraints. Nested
+// modules, vendor and testdata directories are left out, like by ./...
+func ListTests(moduleDir string) ([]Test, error) {
+	var tests []Test
+	err := filepath.WalkDir(moduleDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == moduleDir {
+				return nil
+			}
+			name := d.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), "_test.go") || strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_") {
+			return nil
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(moduleDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && isTestFunc(fn.Name.Name) {
+				tests = append(tests, Test{Dir: filepath.ToSlash(rel), Name: fn.Name.Name})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tests, func(i, j int) bool {
+		if tests[i].Dir != tests[j].Dir {
+			return tests[i].Dir < tests[j].Dir
+		}
+		return tests[i].Name < tests[j].Name
+	})
+	return tests, nil
+}
+
+// isTestFunc reports whether name is the one of a test, example or fuzz test:
+// a prefix not followed by a lower case letter, as go test requires
+func isTestFunc(name string) bool {
+	for _, prefix := range testPrefixes {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			if rest == "" {
+				return true
+			}
+			r, _ := utf8.DecodeRuneInString(rest)
+			return !unicode.IsLower(r)
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package gotest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListTests(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/m\n")
+	write("m_test.go", `package m
+
+import "testing"
+
+func TestB(t *testing.T)        {}
+func TestA(t *testing.T)        {}
+func Testing(t *testing.T)      {}
+func ExampleA()                 {}
+func FuzzParse(f *testing.F)    {}
+func BenchmarkA(b *testing.B)   {}
+func helper(t *testing.T)       {}
+`)
+	write("sub/sub_test.go", "package sub_test\n\nimport \"testing\"\n\nfunc Test_sub(t *testing.T) {}\n")
+	write("sub/testdata/data_test.go", "package data\n\nfunc TestData() {}\n")
+	write("nested/go.mod", "module example.com/m/nested\n")
+	write("nested/nested_test.go", "package nested\n\nfunc TestNested() {}\n")
+
+	tests, err := ListTests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Test{
+		{Dir: ".", Name: "ExampleA"},
+		{Dir: ".", Name: "FuzzParse"},
+		{Dir: ".", Name: "TestA"},
+		{Dir: ".", Name: "TestB"},
+		{Dir: "sub", Name: "Test_sub"},
+	}
+	if !reflect.DeepEqual(tests, want) {
+		t.Errorf("expected %+v, got %+v", want, tests)
+	}
+}
@@ -0,0 +1,225 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Dir is the directory, relative to the workspace root, where knit keeps its state
+const Dir = ".knit"
+
+const fileName = "history.json"
+
+// History records facts about previous runs, persisted in .knit/history.json
+type History struct {
+	// Durations maps a task name to the last duration of each module, in seconds
+	Durations map[string]map[string]float64 `json:"durations"`
+	// Failed maps a task name to the modules whose last run of the task failed
+	Failed map[string][]string `json:"failed,omitempty"`
+	// LastTask is the name of the most recently run task
+	LastTask string `json:"lastTask,omitempty"`
+	// Tests maps a test, as package.Test, to its outcomes on the last
+	// revisions of the code it ran on, oldest first
+	Tests map[string][]TestOutcomes `json:"tests,omitempty"`
+	// TestDurations maps a test, as package.Test, to its last duration in
+	// seconds
+	TestDurations map[string]float64 `json:"testDurations,omitempty"`
+	// CoverRun is the start of the last run of 'knit test --cover', the
+	// coverage profiles written before it being left by older runs
+	CoverRun *time.Time `json:"coverRun,omitempty"`
+}
+
+// TestOutcomes counts the runs of a test on a revision of the code
+type TestOutcomes struct {
+	Revision string `json:"revision"`
+	Passed   int    `json:"passed,omitempty"`
+	Failed   int    `json:"failed,omitempty"`
+}
+
+// Flaky reports whether the test both passed and failed on the revision
+func (o TestOutcomes) Flaky() bool {
+	return o.Passed > 0 && o.Failed > 0
+}
+
+// maxTestRevisions is the number of revisions the outcomes of a test are kept
+// for
+const maxTestRevisions = 10
+
+// FlakyTest is a test that both passed and failed on a same revision
+type FlakyTest struct {
+	Test string `json:"test"`
+	// FlakyRevisions counts the revisions it was flaky on, out of Revisions
+	FlakyRevisions int `json:"flakyRevisions"`
+	Revisions      int `json:"revisions"`
+	// Passed and Failed count its runs on the revisions it was flaky on
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+}
+
+// Load reads the history of the workspace. A missing file yields an empty history.
+func Load(workspaceRoot string) (*History, error) {
+	h := &History{
+		Durations: make(map[string]map[string]float64),
+		Failed:    make(map[string][]string),
+	}
+
+	data, err := os.ReadFile(filepath.Join(workspaceRoot, Dir, fileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %w", err)
+	}
+	if h.Durations == nil {
+		h.Durations = make(map[string]map[string]float64)
+	}
+	if h.Failed == nil {
+		h.Failed = make(map[string][]string)
+	}
+	return h, nil
+}
+
+// Save writes the history of the workspace
+func (h *History) Save(workspaceRoot string) error {
+	dir := filepath.Join(workspaceRoot, Dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+
+	// Write then rename, so a concurrent reader never sees a partial file
+	tmp := filepath.Join(dir, fileName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, fileName))
+}
+
+// RecordDuration stores the duration of a task for a module
+func (h *History) RecordDuration(task, module string, d time.Duration) {
+	if h.Durations[task] == nil {
+		h.Durations[task] = make(map[string]float64)
+	}
+	h.Durations[task][module] = d.Seconds()
+}
+
+// RecordResult stores whether the last run of a task failed for a module.
+// Modules that were not run keep their previous state.
+func (h *History) RecordResult(task, module string, failed bool) {
+	h.LastTask = task
+
+	kept := make([]string, 0, len(h.Failed[task]))
+	for _, m := range h.Failed[task] {
+		if m != module {
+			kept = append(kept, m)
+		}
+	}
+	if failed {
+		kept = append(kept, module)
+		sort.Strings(kept)
+	}
+
+	if len(kept) == 0 {
+		delete(h.Failed, task)
+	} else {
+		h.Failed[task] = kept
+	}
+}
+
+// RecordTest stores an outcome of a test on a revision of the code. Only the
+// last revisions are kept.
+func (h *History) RecordTest(test, revision string, failed bool) {
+	if h.Tests == nil {
+		h.Tests = make(map[string][]TestOutcomes)
+	}
+	outcomes := h.Tests[test]
+	if n := len(outcomes); n == 0 || outcomes[n-1].Revision != revision {
+		outcomes = append(outcomes, TestOutcomes{Revision: revision})
+	}
+	last := &outcomes[len(outcomes)-1]
+	if failed {
+		last.Failed++
+	} else {
+		last.Passed++
+	}
+	if len(outcomes) > maxTestRevisions {
+		outcomes = outcomes[len(outcomes)-maxTestRevisions:]
+	}
+	h.Tests[test] = outcomes
+}
+
+// RecordTestDuration stores the duration of a test
+func (h *History) RecordTestDuration(test string, d time.Duration) {
+	if h.TestDurations == nil {
+		h.TestDurations = make(map[string]float64)
+	}
+	h.TestDurations[test] = d.Seconds()
+}
+
+// IsFlaky reports whether a test both passed and failed on one of the
+// revisions recorded
+func (h *History) IsFlaky(test string) bool {
+	for _, o := range h.Tests[test] {
+		if o.Flaky() {
+			return true
+		}
+	}
+	return false
+}
+
+// FlakyTests returns the tests that both passed and failed on one of the
+// revisions recorded, the most often flaky first
+func (h *History) FlakyTests() []FlakyTest {
+	var flaky []FlakyTest
+	for test, outcomes := range h.Tests {
+		f := FlakyTest{Test: test, Revisions: len(outcomes)}
+		for _, o := range outcomes {
+			if o.Flaky() {
+				f.FlakyRevisions++
+				f.Passed += o.Passed
+				f.Failed += o.Failed
+			}
+		}
+		if f.FlakyRevisions > 0 {
+			flaky = append(flaky, f)
+		}
+	}
+	sort.Slice(flaky, func(i, j int) bool {
+		if flaky[i].FlakyRevisions != flaky[j].FlakyRevisions {
+			return flaky[i].FlakyRevisions > flaky[j].FlakyRevisions
+		}
+		return flaky[i].Test < flaky[j].Test
+	})
+	return flaky
+}
+
+// Duration returns the last recorded duration of a task for a module
+func (h *History) Duration(task, module string) (time.Duration, bool) {
+	seconds, ok := h.Durations[task][module]
+	return time.Duration(seconds * float64(time.Second)), ok
+}
+
+// SortByDuration orders modules longest-running first for the given task.
+// Modules without history come first, since they may be the slowest.
+// Ties keep their original order.
+func (h *History) SortByDuration(task string, modules []string) {
+	sort.SliceStable(modules, func(i, j int) bool {
+		di, oki := h.Durations[task][modules[i]]
+		dj, okj := h.Durations[task][modules[j]]
+		if oki != okj {
+			return !oki
+		}
+		return di > dj
+	})
+}
@@ -0,0 +1,97 @@
+package history
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	root := t.TempDir()
+
+	h, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.RecordDuration("test", "example.com/api", 1500*time.Millisecond)
+	if err := h.Save(root); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := loaded.Duration("test", "example.com/api")
+	if !ok || d != 1500*time.Millisecond {
+		t.Errorf("expected 1.5s for example.com/api, got %v (found: %v)", d, ok)
+	}
+	if _, ok := loaded.Duration("fmt", "example.com/api"); ok {
+		t.Error("expected no duration for another task")
+	}
+}
+
+func TestSortByDuration(t *testing.T) {
+	h := &History{Durations: map[string]map[string]float64{
+		"test": {"fast": 1, "slow": 10, "medium": 5},
+	}}
+
+	modules := []string{"fast", "new", "slow", "medium"}
+	h.SortByDuration("test", modules)
+
+	expected := []string{"new", "slow", "medium", "fast"}
+	for i := range expected {
+		if modules[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, modules)
+		}
+	}
+}
+
+func TestRecordResult(t *testing.T) {
+	h, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.RecordResult("test", "example.com/api", true)
+	h.RecordResult("test", "example.com/core", true)
+	h.RecordResult("test", "example.com/utils", false)
+	if len(h.Failed["test"]) != 2 {
+		t.Fatalf("expected 2 failed modules, got %v", h.Failed["test"])
+	}
+
+	// A later successful run clears the failure, other modules are untouched
+	h.RecordResult("test", "example.com/api", false)
+	if len(h.Failed["test"]) != 1 || h.Failed["test"][0] != "example.com/core" {
+		t.Errorf("expected only example.com/core to remain failed, got %v", h.Failed["test"])
+	}
+	if h.LastTask != "test" {
+		t.Errorf("expected last task to be test, got %s", h.LastTask)
+	}
+}
+
+func TestFlakyTests(t *testing.T) {
+	h := &History{}
+	h.RecordTest("example.com/core.TestFlaky", "abc", false)
+	h.RecordTest("example.com/core.TestFlaky", "abc", true)
+	h.RecordTest("example.com/core.TestFlaky", "def", false)
+	// Failing on a new revision only is a regression, not a flake
+	h.RecordTest("example.com/core.TestBroken", "abc", false)
+	h.RecordTest("example.com/core.TestBroken", "def", true)
+
+	if !h.IsFlaky("example.com/core.TestFlaky") || h.IsFlaky("example.com/core.TestBroken") {
+		t.Errorf("expected only TestFlaky to be flaky, got %+v", h.Tests)
+	}
+	flaky := h.FlakyTests()
+	want := FlakyTest{Test: "example.com/core.TestFlaky", FlakyRevisions: 1, Revisions: 2, Passed: 1, Failed: 1}
+	if len(flaky) != 1 || flaky[0] != want {
+		t.Errorf("expected %+v, got %+v", want, flaky)
+	}
+
+	for i := 0; i < 2*maxTestRevisions; i++ {
+		h.RecordTest("example.com/core.TestVersion", fmt.Sprint(i), false)
+	}
+	if n := len(h.Tests["example.com/core.TestVersion"]); n != maxTestRevisions {
+		t.Errorf("expected the last %d revisions to be kept, got %d", maxTestRevisions, n)
+	}
+}
@@ -0,0 +1,221 @@
+// Package ignore tells the tree walks of knit which directories to skip:
+// the directories no Go module lives in, such as .git and node_modules, and
+// the paths ignored by .gitignore files.
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// heavyDirs are never walked, whatever the .gitignore files say
+var heavyDirs = map[string]bool{
+	".git":         true,
+	".hg":          true,
+	".jj":          true,
+	".svn":         true,
+	"node_modules": true,
+}
+
+// Heavy reports whether a directory name is one of the directories never
+// walked, such as .git or node_modules
+func Heavy(name string) bool {
+	return heavyDirs[name]
+}
+
+type rule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher matches paths below a root against the .gitignore files of the
+// repository: those of the root and of its subdirectories, those of the
+// directories between the repository root and the root, and
+// .git/info/exclude. It is safe for concurrent use.
+type Matcher struct {
+	root string
+	// top is the repository root, root itself outside of a repository
+	top string
+
+	mu    sync.Mutex
+	rules map[string][]rule
+}
+
+// New returns the matcher of the tree rooted at root
+func New(root string) *Matcher {
+	root = filepath.Clean(root)
+	m := &Matcher{root: root, top: root, rules: make(map[string][]rule)}
+	for dir := root; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			m.top = dir
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return m
+}
+
+// SkipDir reports whether a walk below the root skips the directory at path:
+// a heavy directory or one ignored by git. The root itself is never skipped.
+func (m *Matcher) SkipDir(path string) bool {
+	if filepath.Clean(path) == m.root {
+		return false
+	}
+	return Heavy(filepath.Base(path)) || m.Ignored(path, true)
+}
+
+// Ignored reports whether path, at or below the root, is ignored by git.
+// Walks skipping ignored directories never ask about their content, so the
+// parent directories of path are not checked.
+func (m *Matcher) Ignored(path string, isDir bool) bool {
+	path = filepath.Clean(path)
+	if path == m.top {
+		return false
+	}
+	rel, err := filepath.Rel(m.top, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	ignored := false
+	check := func(rules []rule, relToDir string) {
+		for _, r := range rules {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if r.re.MatchString(relToDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	check(m.load(filepath.Join(m.top, ".git", "info", "exclude")), rel)
+
+	// The .gitignore files of every directory from the top down to the
+	// parent of path, deeper ones winning
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		dir := filepath.Join(m.top, filepath.FromSlash(strings.Join(parts[:i], "/")))
+		check(m.load(filepath.Join(dir, ".gitignore")), strings.Join(parts[i:], "/"))
+	}
+	return ignored
+}
+
+// load returns the rules of an ignore file, read once
+func (m *Matcher) load(file string) []rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rules, ok := m.rules[file]; ok {
+		return rules
+	}
+	rules := readFile(file)
+	m.rules[file] = rules
+	return rules
+}
+
+// readFile parses an ignore file, yielding no rule when it cannot be read
+func readFile(file string) []rule {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []rule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, ok := parseRule(scanner.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// parseRule parses a line of a .gitignore file, see gitignore(5)
+func parseRule(line string) (rule, bool) {
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+	var r rule
+	if strings.HasPrefix(line, "!") {
+		r.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly, line = true, strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+	// A pattern with a slash other than a trailing one is relative to the
+	// directory of the file, otherwise it matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	prefix := "^(?:.*/)?"
+	if anchored {
+		prefix = "^"
+	}
+	re, err := regexp.Compile(prefix + globToRegexp(line) + "$")
+	if err != nil {
+		return rule{}, false
+	}
+	r.re = re
+	return r, true
+}
+
+// globToRegexp translates a gitignore glob, with * and ? not matching
+// slashes and ** matching any number of directories
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "a/b/debug.log", false, true},
+		{"*.log", "a/b/debug.txt", false, false},
+		{"build/", "a/build", true, true},
+		{"build/", "a/build", false, false},
+		{"/dist", "dist", true, true},
+		{"/dist", "a/dist", true, false},
+		{"docs/*.md", "docs/a.md", false, true},
+		{"docs/*.md", "docs/x/a.md", false, false},
+		{"**/gen", "a/b/gen", true, true},
+		{"a/**/b", "a/x/y/b", true, true},
+		{"a/**/b", "a/b", true, true},
+		{"out/**", "out/x/y", false, true},
+		{"file?.go", "file1.go", false, true},
+		{"[!a]*.tmp", "b.tmp", false, true},
+		{"[!a]*.tmp", "a.tmp", false, false},
+		{`\#notes`, "#notes", false, true},
+		{"trailing  ", "trailing", false, true},
+	} {
+		r, ok := parseRule(tc.pattern)
+		if !ok {
+			t.Errorf("%q: not parsed", tc.pattern)
+			continue
+		}
+		got := r.re.MatchString(tc.path) && (!r.dirOnly || tc.isDir)
+		if got != tc.want {
+			t.Errorf("%q matching %s (dir %v) = %v, want %v", tc.pattern, tc.path, tc.isDir, got, tc.want)
+		}
+	}
+	for _, line := range []string{"", "# comment", "   ", "/"} {
+		if _, ok := parseRule(line); ok {
+			t.Errorf("expected %q to hold no rule", line)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(repo, ".git", "info", "exclude"), "local/\n")
+	writeFile(t, filepath.Join(repo, ".gitignore"), "dist/\n*.gen.go\n!keep.gen.go\n")
+	writeFile(t, filepath.Join(repo, "ws", "api", ".gitignore"), "/tmp\n!dist/\n")
+	root := filepath.Join(repo, "ws")
+	m := New(root)
+
+	for path, want := range map[string]bool{
+		"ws/dist":            true,
+		"ws/api/dist":        false, // re-included by the deeper file
+		"ws/api/tmp":         true,
+		"ws/tmp":             false, // anchored to api
+		"ws/local":           true,
+		"ws/node_modules":    true,
+		"ws/api/.git":        true,
+		"ws/api":             false,
+		"ws":                 false,
+		"ws/api/x.gen.go":    true,
+		"ws/api/keep.gen.go": false,
+	} {
+		full := filepath.Join(repo, filepath.FromSlash(path))
+		got := m.SkipDir(full)
+		if filepath.Ext(path) == ".go" {
+			got = m.Ignored(full, false)
+		}
+		if got != want {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+
+	// Outside of a repository, the .gitignore of the root applies
+	plain := t.TempDir()
+	writeFile(t, filepath.Join(plain, ".gitignore"), "out\n")
+	if m := New(plain); !m.SkipDir(filepath.Join(plain, "x", "out")) || m.SkipDir(filepath.Join(plain, "x")) {
+		t.Error("expected out to be ignored at any depth")
+	}
+}
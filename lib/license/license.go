@@ -0,0 +1,129 @@
+package license
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Unknown is the identifier of licenses that could not be recognized
+const Unknown = "Unknown"
+
+// License is the license found in a module directory
+type License struct {
+	// ID is the SPDX identifier of the license, or Unknown
+	ID string
+	// File is the license file the license was read from, empty if none
+	File string
+}
+
+// signature recognizes a license by phrases its text contains
+type signature struct {
+	id      string
+	phrases []string
+	// without lists phrases that must not appear, to tell close variants apart
+	without []string
+}
+
+// signatures are checked in order, so stricter variants come first
+var signatures = []signature{
+	{id: "AGPL-3.0", phrases: []string{"gnu affero general public license", "version 3"}},
+	{id: "LGPL-3.0", phrases: []string{"gnu lesser general public license", "version 3"}},
+	{id: "LGPL-2.1", phrases: []string{"gnu lesser general public license", "version 2.1"}},
+	{id: "GPL-3.0", phrases: []string{"gnu general public license", "version 3"}},
+	{id: "GPL-2.0", phrases: []string{"gnu general public license", "version 2"}},
+	{id: "MPL-2.0", phrases: []string{"mozilla public license", "2.0"}},
+	{id: "Apache-2.0", phrases: []string{"apache license", "version 2.0"}},
+	{id: "MIT", phrases: []string{"permission is hereby granted, free of charge"}},
+	{id: "ISC", phrases: []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{id: "BSD-3-Clause", phrases: []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{id: "BSD-3-Clause", phrases: []string{"redistribution and use in source and binary forms", "the names of its contributors may not be used"}},
+	{id: "BSD-2-Clause", phrases: []string{"redistribution and use in source and binary forms"}, without: []string{"neither the name", "may not be used to endorse"}},
+	{id: "Unlicense", phrases: []string{"this is free and unencumbered software released into the public domain"}},
+	{id: "CC0-1.0", phrases: []string{"cc0 1.0 universal"}},
+	{id: "Zlib", phrases: []string{"this software is provided 'as-is', without any express or implied warranty"}},
+}
+
+// spaces matches runs of whitespace, collapsed before matching phrases
+var spaces = regexp.MustCompile(`\s+`)
+
+// Identify returns the SPDX identifier of a license text, or Unknown
+func Identify(text string) string {
+	normalized := strings.ToLower(spaces.ReplaceAllString(text, " "))
+	for _, s := range signatures {
+		if containsAll(normalized, s.phrases) && !containsAny(normalized, s.without) {
+			return s.id
+		}
+	}
+	return Unknown
+}
+
+func containsAll(text string, phrases []string) bool {
+	for _, p := range phrases {
+		if !strings.Contains(text, p) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(text string, phrases []string) bool {
+	for _, p := range phrases {
+		if strings.Contains(text, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Files returns the license files at the root of dir, such as LICENSE,
+// LICENSE.md or COPYING, sorted by name
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := strings.ToLower(e.Name())
+		for _, prefix := range []string{"license", "licence", "copying", "unlicense"} {
+			if strings.HasPrefix(name, prefix) {
+				files = append(files, e.Name())
+				break
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Detect identifies the license of the module in dir, from the first
+// recognized license file
+func Detect(dir string) (License, error) {
+	files, err := Files(dir)
+	if err != nil {
+		return License{}, err
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			return License{}, fmt.Errorf("failed to read %s: %w", f, err)
+		}
+		if id := Identify(string(data)); id != Unknown {
+			return License{ID: id, File: f}, nil
+		}
+	}
+
+	l := License{ID: Unknown}
+	if len(files) > 0 {
+		l.File = files[0]
+	}
+	return l, nil
+}
@@ -0,0 +1,48 @@
+package license
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentify(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"MIT License\n\nPermission is hereby granted, free of charge, to any person\nobtaining a copy", "MIT"},
+		{"                                 Apache License\n                           Version 2.0, January 2004", "Apache-2.0"},
+		{"Redistribution and use in source and binary forms, with or without\nmodification, are permitted...\n   * Neither the name of Google Inc. nor the names", "BSD-3-Clause"},
+		{"Redistribution and use in source and binary forms, with or without\nmodification, are permitted provided that the following conditions are met", "BSD-2-Clause"},
+		{"Mozilla Public License Version 2.0\n==================================", "MPL-2.0"},
+		{"GNU LESSER GENERAL PUBLIC LICENSE\nVersion 3, 29 June 2007", "LGPL-3.0"},
+		{"GNU GENERAL PUBLIC LICENSE\nVersion 2, June 1991", "GPL-2.0"},
+		{"Permission to use, copy, modify, and/or distribute this software for any\npurpose with or without fee is hereby granted", "ISC"},
+		{"All rights reserved. Do not copy.", Unknown},
+	}
+	for _, c := range cases {
+		if got := Identify(c.text); got != c.want {
+			t.Errorf("Identify(%q) = %s, want %s", c.text, got, c.want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	dir := t.TempDir()
+	if l, err := Detect(dir); err != nil || l.ID != Unknown || l.File != "" {
+		t.Errorf("expected an unknown license without file, got %+v, %v", l, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/x\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "COPYING.txt"), []byte("see the website"), 0644)
+	os.WriteFile(filepath.Join(dir, "LICENSE.md"), []byte("Permission is hereby granted, free of charge, to any person"), 0644)
+
+	l, err := Detect(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ID != "MIT" || l.File != "LICENSE.md" {
+		t.Errorf("expected MIT from LICENSE.md, got %+v", l)
+	}
+}
@@ -0,0 +1,297 @@
+// Package prcomment posts the impact report of a change on its pull request,
+// or merge request on GitLab. The comment carries a hidden marker, so that
+// later runs update it instead of adding one per push.
+package prcomment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marker identifies the comment posted by knit
+const Marker = "<!-- knit:pr-comment -->"
+
+// Providers of the pull request
+const (
+	GitHub = "github"
+	GitLab = "gitlab"
+)
+
+// Target is the pull request to comment on
+type Target struct {
+	Provider string
+	// APIURL is the API root, e.g. https://api.github.com or
+	// https://gitlab.com/api/v4
+	APIURL string
+	// Repo is owner/name on GitHub and the project ID or path on GitLab
+	Repo   string
+	Number int
+	Token  string
+}
+
+// FromEnv returns the pull request of the CI job, from the variables set by
+// GitHub Actions or GitLab CI. number, when positive, overrides the number of
+// the pull request, e.g. for workflows not triggered by a pull_request event.
+// The token is read from GITHUB_TOKEN, or GITLAB_TOKEN on GitLab.
+func FromEnv(number int) (*Target, error) {
+	switch {
+	case os.Getenv("GITHUB_REPOSITORY") != "":
+		t := &Target{Provider: GitHub, APIURL: os.Getenv("GITHUB_API_URL"), Repo: os.Getenv("GITHUB_REPOSITORY"), Number: number, Token: os.Getenv("GITHUB_TOKEN")}
+		if t.APIURL == "" {
+			t.APIURL = "https://api.github.com"
+		}
+		if t.Number <= 0 {
+			n, err := githubEventNumber(os.Getenv("GITHUB_EVENT_PATH"))
+			if err != nil {
+				return nil, err
+			}
+			t.Number = n
+		}
+		return t.check("GITHUB_TOKEN")
+	case os.Getenv("CI_PROJECT_ID") != "":
+		t := &Target{Provider: GitLab, APIURL: os.Getenv("CI_API_V4_URL"), Repo: os.Getenv("CI_PROJECT_ID"), Number: number, Token: os.Getenv("GITLAB_TOKEN")}
+		if t.APIURL == "" {
+			t.APIURL = "https://gitlab.com/api/v4"
+		}
+		if t.Number <= 0 {
+			t.Number, _ = strconv.Atoi(os.Getenv("CI_MERGE_REQUEST_IID"))
+		}
+		return t.check("GITLAB_TOKEN")
+	}
+	return nil, fmt.Errorf("no pull request found: set GITHUB_REPOSITORY on GitHub Actions or CI_PROJECT_ID on GitLab CI")
+}
+
+func (t *Target) check(tokenVar string) (*Target, error) {
+	if t.Number <= 0 {
+		return nil, fmt.Errorf("no pull request number found in the %s job, pass --pr", t.Provider)
+	}
+	if t.Token == "" {
+		return nil, fmt.Errorf("%s is not set, the comment cannot be posted", tokenVar)
+	}
+	t.APIURL = strings.TrimSuffix(t.APIURL, "/")
+	return t, nil
+}
+
+// githubEventNumber reads the pull request number of the event payload of a
+// GitHub Actions job, 0 for events not about a pull request
+func githubEventNumber(path string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the GitHub event: %w", err)
+	}
+	var event struct {
+		PullRequest struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+		Issue struct {
+			Number int `json:"number"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return 0, fmt.Errorf("failed to parse the GitHub event: %w", err)
+	}
+	if event.PullRequest.Number != 0 {
+		return event.PullRequest.Number, nil
+	}
+	return event.Issue.Number, nil
+}
+
+// Client posts comments on a Target
+type Client struct {
+	Target *Target
+	HTTP   *http.Client
+}
+
+type comment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// Upsert updates the comment of knit on the pull request with body, marked
+// with Marker, or posts it when there is none. created reports whether a
+// new comment was posted.
+func (c *Client) Upsert(ctx context.Context, body string) (created bool, err error) {
+	if !strings.Contains(body, Marker) {
+		body = Marker + "\n" + body
+	}
+	existing, err := c.find(ctx)
+	if err != nil {
+		return false, err
+	}
+	payload := map[string]string{"body": body}
+	if existing == 0 {
+		return true, c.do(ctx, http.MethodPost, c.commentsURL(), payload, nil)
+	}
+	method := http.MethodPatch
+	if c.Target.Provider == GitLab {
+		method = http.MethodPut
+	}
+	return false, c.do(ctx, method, c.commentURL(existing), payload, nil)
+}
+
+// find returns the ID of the comment holding Marker, 0 when there is none
+func (c *Client) find(ctx context.Context) (int64, error) {
+	for page := 1; ; page++ {
+		var comments []comment
+		if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s?per_page=100&page=%d", c.commentsURL(), page), nil, &comments); err != nil {
+			return 0, err
+		}
+		for _, cm := range comments {
+			if strings.Contains(cm.Body, Marker) {
+				return cm.ID, nil
+			}
+		}
+		if len(comments) < 100 {
+			return 0, nil
+		}
+	}
+}
+
+func (c *Client) commentsURL() string {
+	t := c.Target
+	if t.Provider == GitLab {
+		return fmt.Sprintf("%s/projects/%s/merge_requests/%d/notes", t.APIURL, url.PathEscape(t.Repo), t.Number)
+	}
+	return fmt.Sprintf("%s/repos/%s/issues/%d/comments", t.APIURL, t.Repo, t.Number)
+}
+
+func (c *Client) commentURL(id int64) string {
+	t := c.Target
+	if t.Provider == GitLab {
+		return fmt.Sprintf("%s/%d", c.commentsURL(), id)
+	}
+	return fmt.Sprintf("%s/repos/%s/issues/comments/%d", t.APIURL, t.Repo, id)
+}
+
+func (c *Client) do(ctx context.Context, method, url string, payload, result any) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Target.Provider == GitLab {
+		req.Header.Set("PRIVATE-TOKEN", c.Target.Token)
+	} else {
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("Authorization", "Bearer "+c.Target.Token)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", method, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to %s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(msg))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse the response of %s: %w", url, err)
+	}
+	return nil
+}
+
+// Module is a module of the report
+type Module struct {
+	Path string
+	// Dependent is set for the modules depending on a changed one, without
+	// a change of their own
+	Dependent bool
+	// Result is the outcome of the last run of the task: passed, failed, or
+	// empty when the module was not run
+	Result   string
+	Duration time.Duration
+}
+
+// Results of a module
+const (
+	Passed = "passed"
+	Failed = "failed"
+)
+
+// Report is the impact of a change
+type Report struct {
+	// Base is the reference the change is compared with
+	Base    string
+	Task    string
+	Modules []Module
+}
+
+// Render formats the report as the Markdown body of the comment
+func Render(r Report) string {
+	var b strings.Builder
+	b.WriteString(Marker + "\n")
+
+	changed, dependents, failed := 0, 0, 0
+	for _, m := range r.Modules {
+		if m.Dependent {
+			dependents++
+		} else {
+			changed++
+		}
+		if m.Result == Failed {
+			failed++
+		}
+	}
+	if len(r.Modules) == 0 {
+		fmt.Fprintf(&b, "### knit: no module affected\n\nNo module changed compared with `%s`.\n", r.Base)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "### knit: %s affected, %s depending on them\n\n", plural(changed, "module"), plural(dependents, "module"))
+	if failed > 0 {
+		fmt.Fprintf(&b, "❌ %s failed `%s`.\n\n", plural(failed, "module"), r.Task)
+	}
+	fmt.Fprintf(&b, "| Module | Impact | %s |\n|---|---|---|\n", r.Task)
+	for _, m := range r.Modules {
+		impact := "changed"
+		if m.Dependent {
+			impact = "dependent"
+		}
+		result := "not run"
+		switch m.Result {
+		case Passed:
+			result = fmt.Sprintf("✅ passed in %s", m.Duration.Round(time.Millisecond))
+		case Failed:
+			result = "❌ failed"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s |\n", m.Path, impact, result)
+	}
+	fmt.Fprintf(&b, "\n<sub>Compared with `%s`.</sub>\n", r.Base)
+	return b.String()
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
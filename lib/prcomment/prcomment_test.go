@@ -0,0 +1,132 @@
+package prcomment
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{"GITHUB_REPOSITORY", "GITHUB_API_URL", "GITHUB_TOKEN", "GITHUB_EVENT_PATH", "CI_PROJECT_ID", "CI_API_V4_URL", "CI_MERGE_REQUEST_IID", "GITLAB_TOKEN"} {
+		t.Setenv(env, "")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	clearEnv(t)
+	if _, err := FromEnv(0); err == nil {
+		t.Error("expected an error outside of CI")
+	}
+
+	event := filepath.Join(t.TempDir(), "event.json")
+	if err := os.WriteFile(event, []byte(`{"pull_request":{"number":42}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITHUB_REPOSITORY", "org/repo")
+	t.Setenv("GITHUB_EVENT_PATH", event)
+	if _, err := FromEnv(0); err == nil || !strings.Contains(err.Error(), "GITHUB_TOKEN") {
+		t.Errorf("expected a missing token error, got %v", err)
+	}
+	t.Setenv("GITHUB_TOKEN", "secret")
+	target, err := FromEnv(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Provider != GitHub || target.Number != 42 || target.APIURL != "https://api.github.com" {
+		t.Errorf("unexpected target %+v", target)
+	}
+	if target, err := FromEnv(7); err != nil || target.Number != 7 {
+		t.Errorf("expected --pr to win, got %+v, %v", target, err)
+	}
+
+	clearEnv(t)
+	t.Setenv("CI_PROJECT_ID", "12")
+	t.Setenv("CI_API_V4_URL", "https://gitlab.example.com/api/v4/")
+	t.Setenv("GITLAB_TOKEN", "secret")
+	if _, err := FromEnv(0); err == nil || !strings.Contains(err.Error(), "--pr") {
+		t.Errorf("expected a missing number error, got %v", err)
+	}
+	t.Setenv("CI_MERGE_REQUEST_IID", "3")
+	target, err = FromEnv(0)
+	if err != nil || target.Provider != GitLab || target.Number != 3 || target.APIURL != "https://gitlab.example.com/api/v4" {
+		t.Errorf("unexpected target %+v, %v", target, err)
+	}
+}
+
+// fakeAPI records the requests to a comment API holding comments
+type fakeAPI struct {
+	comments []comment
+	requests []string
+	bodies   []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(f.comments)
+		return
+	}
+	var payload map[string]string
+	data, _ := io.ReadAll(r.Body)
+	json.Unmarshal(data, &payload)
+	f.bodies = append(f.bodies, payload["body"])
+	w.Write([]byte(`{}`))
+}
+
+func TestUpsert(t *testing.T) {
+	github := &fakeAPI{comments: []comment{{ID: 1, Body: "LGTM"}, {ID: 5, Body: Marker + "\nold"}}}
+	server := httptest.NewServer(github)
+	defer server.Close()
+
+	client := &Client{Target: &Target{Provider: GitHub, APIURL: server.URL, Repo: "org/repo", Number: 42, Token: "secret"}}
+	created, err := client.Upsert(context.Background(), "new")
+	if err != nil || created {
+		t.Fatalf("expected an update, got %v, %v", created, err)
+	}
+	want := []string{"GET /repos/org/repo/issues/42/comments", "PATCH /repos/org/repo/issues/comments/5"}
+	if strings.Join(github.requests, ",") != strings.Join(want, ",") || github.bodies[0] != Marker+"\nnew" {
+		t.Errorf("unexpected requests %v with %q", github.requests, github.bodies)
+	}
+
+	gitlab := &fakeAPI{}
+	server = httptest.NewServer(gitlab)
+	defer server.Close()
+	client = &Client{Target: &Target{Provider: GitLab, APIURL: server.URL, Repo: "group/project", Number: 3, Token: "secret"}}
+	if created, err := client.Upsert(context.Background(), Render(Report{Base: "main"})); err != nil || !created {
+		t.Fatalf("expected a new comment, got %v, %v", created, err)
+	}
+	if gitlab.requests[1] != "POST /projects/group/project/merge_requests/3/notes" || strings.Count(gitlab.bodies[0], Marker) != 1 {
+		t.Errorf("unexpected requests %v with %q", gitlab.requests, gitlab.bodies)
+	}
+}
+
+func TestRender(t *testing.T) {
+	body := Render(Report{Base: "origin/main", Task: "test", Modules: []Module{
+		{Path: "example.com/core", Result: Passed, Duration: 1500 * time.Millisecond},
+		{Path: "example.com/api", Dependent: true, Result: Failed},
+		{Path: "example.com/app", Dependent: true},
+	}})
+	for _, want := range []string{
+		Marker + "\n### knit: 1 module affected, 2 modules depending on them\n",
+		"❌ 1 module failed `test`.",
+		"| `example.com/core` | changed | ✅ passed in 1.5s |",
+		"| `example.com/api` | dependent | ❌ failed |",
+		"| `example.com/app` | dependent | not run |",
+		"Compared with `origin/main`.",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+	if body := Render(Report{Base: "main"}); !strings.Contains(body, "no module affected") {
+		t.Errorf("unexpected empty report:\n%s", body)
+	}
+}
@@ -0,0 +1,267 @@
+package query
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Universe is the workspace a query is evaluated against
+type Universe struct {
+	// Modules lists every module path, in workspace order
+	Modules []string
+	// Deps maps a module to the modules it depends on directly
+	Deps map[string][]string
+	// Tags maps a module to its tags
+	Tags map[string][]string
+	// Changed returns the modules with changes compared to a reference
+	Changed func(ref string) ([]string, error)
+}
+
+type set map[string]bool
+
+// Eval parses and evaluates a query, returning the selected modules in
+// workspace order
+func Eval(q string, u *Universe) ([]string, error) {
+	expr, err := Parse(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	return EvalExpr(expr, u)
+}
+
+// EvalExpr evaluates a parsed query, returning the selected modules in
+// workspace order
+func EvalExpr(expr Expr, u *Universe) ([]string, error) {
+	s, err := expr.eval(u)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(s))
+	for _, m := range u.Modules {
+		if s[m] {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+func (w word) eval(u *Universe) (set, error) {
+	if isGlob(w.value) {
+		result := make(set)
+		for _, m := range u.Modules {
+			if MatchPattern(w.value, m) {
+				result[m] = true
+			}
+		}
+		return result, nil
+	}
+
+	for _, m := range u.Modules {
+		if m == w.value {
+			return set{m: true}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown module: %s", w.value)
+}
+
+// isGlob reports whether a word is a pattern rather than a module path
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?") || strings.HasSuffix(s, "/...")
+}
+
+// MatchPattern reports whether a slash-separated name matches a glob
+// pattern, as path.Match does. A pattern ending in "/..." also matches every
+// name below that prefix, as go package patterns do.
+func MatchPattern(pattern, name string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/..."); ok {
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+func (b binary) eval(u *Universe) (set, error) {
+	left, err := b.left.eval(u)
+	if err != nil {
+		return nil, err
+	}
+	right, err := b.right.eval(u)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(set)
+	switch b.op {
+	case opUnion:
+		for m := range left {
+			result[m] = true
+		}
+		for m := range right {
+			result[m] = true
+		}
+	case opIntersect:
+		for m := range left {
+			if right[m] {
+				result[m] = true
+			}
+		}
+	case opExcept:
+		for m := range left {
+			if !right[m] {
+				result[m] = true
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c call) eval(u *Universe) (set, error) {
+	switch c.name {
+	case "all":
+		if err := c.arity(0, 0); err != nil {
+			return nil, err
+		}
+		result := make(set, len(u.Modules))
+		for _, m := range u.Modules {
+			result[m] = true
+		}
+		return result, nil
+
+	case "deps", "rdeps":
+		if err := c.arity(1, 2); err != nil {
+			return nil, err
+		}
+		from, err := c.args[0].eval(u)
+		if err != nil {
+			return nil, err
+		}
+		depth := 0
+		if len(c.args) == 2 {
+			if depth, err = c.intArg(1); err != nil {
+				return nil, err
+			}
+		}
+		edges := u.Deps
+		if c.name == "rdeps" {
+			edges = reverse(u.Deps)
+		}
+		return reach(edges, from, depth), nil
+
+	case "tag":
+		if err := c.arity(1, 1); err != nil {
+			return nil, err
+		}
+		name, err := c.wordArg(0)
+		if err != nil {
+			return nil, err
+		}
+		result := make(set)
+		for m, tags := range u.Tags {
+			for _, t := range tags {
+				if t == name {
+					result[m] = true
+				}
+			}
+		}
+		return result, nil
+
+	case "changed":
+		if err := c.arity(1, 1); err != nil {
+			return nil, err
+		}
+		ref, err := c.wordArg(0)
+		if err != nil {
+			return nil, err
+		}
+		if u.Changed == nil {
+			return nil, fmt.Errorf("changed() is not available")
+		}
+		modules, err := u.Changed(ref)
+		if err != nil {
+			return nil, err
+		}
+		result := make(set, len(modules))
+		for _, m := range modules {
+			result[m] = true
+		}
+		return result, nil
+
+	default:
+		return nil, fmt.Errorf("unknown function: %s (use all, deps, rdeps, tag or changed)", c.name)
+	}
+}
+
+func (c call) arity(min, max int) error {
+	if len(c.args) < min || len(c.args) > max {
+		if min == max {
+			return fmt.Errorf("%s() expects %d argument(s), got %d", c.name, min, len(c.args))
+		}
+		return fmt.Errorf("%s() expects %d to %d arguments, got %d", c.name, min, max, len(c.args))
+	}
+	return nil
+}
+
+// wordArg returns a literal argument, such as a tag name or a reference
+func (c call) wordArg(i int) (string, error) {
+	w, ok := c.args[i].(word)
+	if !ok {
+		return "", fmt.Errorf("argument %d of %s() must be a literal, got %s", i+1, c.name, c.args[i])
+	}
+	return w.value, nil
+}
+
+func (c call) intArg(i int) (int, error) {
+	s, err := c.wordArg(i)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("argument %d of %s() must be a positive depth, got %s", i+1, c.name, s)
+	}
+	return n, nil
+}
+
+// reach returns the modules reachable from start, start included, following
+// at most depth edges when depth is greater than zero
+func reach(edges map[string][]string, start set, depth int) set {
+	dist := make(map[string]int, len(start))
+	queue := make([]string, 0, len(start))
+	for m := range start {
+		dist[m] = 0
+		queue = append(queue, m)
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		if depth > 0 && dist[v] >= depth {
+			continue
+		}
+		for _, next := range edges[v] {
+			if _, seen := dist[next]; !seen {
+				dist[next] = dist[v] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	result := make(set, len(dist))
+	for m := range dist {
+		result[m] = true
+	}
+	return result
+}
+
+func reverse(edges map[string][]string) map[string][]string {
+	reversed := make(map[string][]string)
+	for src, deps := range edges {
+		for _, dst := range deps {
+			reversed[dst] = append(reversed[dst], src)
+		}
+	}
+	return reversed
+}
@@ -0,0 +1,236 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed query expression
+type Expr interface {
+	eval(u *Universe) (set, error)
+	String() string
+}
+
+// word is a module path, or a literal argument of a function
+type word struct {
+	value string
+}
+
+// call is a function application such as deps(x) or changed(main)
+type call struct {
+	name string
+	args []Expr
+}
+
+// binary combines two sets with union, intersection or difference
+type binary struct {
+	op          string
+	left, right Expr
+}
+
+func (w word) String() string { return w.value }
+
+func (c call) String() string {
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		args[i] = a.String()
+	}
+	return c.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (b binary) String() string {
+	return "(" + b.left.String() + " " + b.op + " " + b.right.String() + ")"
+}
+
+// Operators, written either as a symbol or as a keyword
+const (
+	opUnion     = "|"
+	opIntersect = "&"
+	opExcept    = "-"
+)
+
+var operators = map[string]string{
+	"|": opUnion, "+": opUnion, "union": opUnion,
+	"&": opIntersect, "intersect": opIntersect,
+	"-": opExcept, "except": opExcept,
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+	tokEOF
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// isWordChar reports whether r can appear in an unquoted word. Module paths
+// may contain '-', so a minus is only an operator when it stands alone, and
+// git revisions '~' and '^', as in changed(HEAD^).
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._/~^@*-", r)
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case r == '|' || r == '&' || r == '+':
+			tokens = append(tokens, token{tokOp, string(r), i})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{tokWord, string(runes[i+1 : end]), i})
+			i = end + 1
+		case isWordChar(r):
+			start := i
+			for i < len(runes) && isWordChar(runes[i]) {
+				i++
+			}
+			value := string(runes[start:i])
+			kind := tokWord
+			if _, ok := operators[value]; ok {
+				kind = tokOp
+			}
+			tokens = append(tokens, token{kind, value, start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", r, i)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) error {
+	if t := p.next(); t.kind != kind {
+		return unexpected(t, what)
+	}
+	return nil
+}
+
+func unexpected(t token, what string) error {
+	if t.kind == tokEOF {
+		return fmt.Errorf("unexpected end of query, expected %s", what)
+	}
+	return fmt.Errorf("unexpected %q at offset %d, expected %s", t.value, t.pos, what)
+}
+
+// Parse parses a query. Every binary operator has the same precedence and
+// associates to the left, use parentheses to group.
+func Parse(s string) (Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, unexpected(t, "an operator")
+	}
+	return expr, nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp {
+		op := operators[p.next().value]
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+
+	case tokWord:
+		if p.peek().kind != tokLParen {
+			return word{value: t.value}, nil
+		}
+		p.next()
+		c := call{name: t.value}
+		if p.peek().kind == tokRParen {
+			p.next()
+			return c, nil
+		}
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+			if p.peek().kind == tokComma {
+				p.next()
+				continue
+			}
+			if err := p.expect(tokRParen, "',' or ')'"); err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+
+	default:
+		return nil, unexpected(t, "a module or a function")
+	}
+}
@@ -0,0 +1,99 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func testUniverse() *Universe {
+	return &Universe{
+		Modules: []string{"example.com/core", "example.com/utils", "example.com/api", "example.com/app", "example.com/my-tool"},
+		Deps: map[string][]string{
+			"example.com/utils": {"example.com/core"},
+			"example.com/api":   {"example.com/utils", "example.com/core"},
+			"example.com/app":   {"example.com/api", "example.com/core"},
+		},
+		Tags: map[string][]string{
+			"example.com/core":  {"shared"},
+			"example.com/utils": {"shared"},
+			"example.com/app":   {"service"},
+		},
+		Changed: func(ref string) ([]string, error) {
+			switch ref {
+			case "origin/main":
+				return []string{"example.com/utils", "example.com/my-tool"}, nil
+			case "HEAD^":
+				return []string{"example.com/core"}, nil
+			}
+			return nil, fmt.Errorf("unexpected ref %s", ref)
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"example.com/api", []string{"example.com/api"}},
+		{"deps(example.com/api)", []string{"example.com/core", "example.com/utils", "example.com/api"}},
+		{"deps(example.com/app, 1)", []string{"example.com/core", "example.com/api", "example.com/app"}},
+		{"rdeps(example.com/utils)", []string{"example.com/utils", "example.com/api", "example.com/app"}},
+		{"rdeps(example.com/core) & changed(origin/main)", []string{"example.com/utils"}},
+		{"changed(origin/main) | tag(service)", []string{"example.com/utils", "example.com/app", "example.com/my-tool"}},
+		{"all() - tag(shared)", []string{"example.com/api", "example.com/app", "example.com/my-tool"}},
+		{"all() except tag(shared) intersect rdeps(example.com/api)", []string{"example.com/api", "example.com/app"}},
+		{"example.com/my-tool + example.com/core", []string{"example.com/core", "example.com/my-tool"}},
+		{"rdeps(tag(shared), 1) & (tag(service) | example.com/api)", []string{"example.com/api", "example.com/app"}},
+		{"changed(HEAD^) | example.com/app", []string{"example.com/core", "example.com/app"}},
+		{"tag('service')", []string{"example.com/app"}},
+		{"tag(missing)", []string{}},
+		{"example.com/a*", []string{"example.com/api", "example.com/app"}},
+		{"example.com/... - example.com/my-*", []string{"example.com/core", "example.com/utils", "example.com/api", "example.com/app"}},
+		{"example.org/*", []string{}},
+	}
+
+	for _, tt := range tests {
+		got, err := Eval(tt.query, testUniverse())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	queries := []string{
+		"",
+		"deps(",
+		"deps(example.com/api))",
+		"example.com/unknown",
+		"unknown(example.com/api)",
+		"deps(example.com/api, -1)",
+		"tag(deps(example.com/api))",
+		"changed()",
+		"example.com/api |",
+		"'unterminated",
+		"example.com/api ; example.com/app",
+	}
+
+	for _, q := range queries {
+		if got, err := Eval(q, testUniverse()); err == nil {
+			t.Errorf("%q: expected an error, got %v", q, got)
+		}
+	}
+}
+
+func TestParseString(t *testing.T) {
+	expr, err := Parse("rdeps(a) & changed(main) | b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := expr.String(); got != "((rdeps(a) & changed(main)) | b)" {
+		t.Errorf("unexpected parse tree: %s", got)
+	}
+}
@@ -0,0 +1,162 @@
+package release
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Bump is the part of a semantic version to increment
+type Bump int
+
+const (
+	None Bump = iota
+	Patch
+	Minor
+	Major
+)
+
+func (b Bump) String() string {
+	switch b {
+	case Patch:
+		return "patch"
+	case Minor:
+		return "minor"
+	case Major:
+		return "major"
+	default:
+		return "none"
+	}
+}
+
+// ParseBump parses a bump name as printed by Bump.String
+func ParseBump(s string) (Bump, error) {
+	switch s {
+	case "patch":
+		return Patch, nil
+	case "minor":
+		return Minor, nil
+	case "major":
+		return Major, nil
+	}
+	return None, fmt.Errorf("unknown bump %q, expected patch, minor or major", s)
+}
+
+// headerRegexp matches the header of a conventional commit, "type(scope)!: description"
+var headerRegexp = regexp.MustCompile(`^([a-zA-Z]+)(\([^)]*\))?(!)?: `)
+
+// CommitBump derives the bump required by a conventional commit message:
+// a breaking change ("!" after the type or a BREAKING CHANGE footer) is a
+// major bump, feat a minor one, fix and perf a patch. Other commits need no
+// release.
+func CommitBump(message string) Bump {
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(line, "BREAKING CHANGE:") || strings.HasPrefix(line, "BREAKING-CHANGE:") {
+			return Major
+		}
+	}
+
+	header, _, _ := strings.Cut(message, "\n")
+	m := headerRegexp.FindStringSubmatch(strings.TrimSpace(header))
+	if m == nil {
+		return None
+	}
+	if m[3] == "!" {
+		return Major
+	}
+	switch strings.ToLower(m[1]) {
+	case "feat":
+		return Minor
+	case "fix", "perf":
+		return Patch
+	}
+	return None
+}
+
+// Highest returns the largest bump required by a list of commit messages
+func Highest(messages []string) Bump {
+	bump := None
+	for _, msg := range messages {
+		if b := CommitBump(msg); b > bump {
+			bump = b
+		}
+	}
+	return bump
+}
+
+// Next returns the version following version for the given bump. An empty
+// version stands for a module that was never released, starting at v0.0.0.
+// Before v1 a breaking change only bumps the minor version, as v0 makes no
+// compatibility promise and a new major version requires a new module path.
+func Next(version string, bump Bump) string {
+	if version == "" {
+		version = "v0.0.0"
+	}
+	var major, minor, patch int
+	fmt.Sscanf(semver.Canonical(version), "v%d.%d.%d", &major, &minor, &patch)
+
+	if bump == Major && major == 0 {
+		bump = Minor
+	}
+	switch bump {
+	case Major:
+		return fmt.Sprintf("v%d.0.0", major+1)
+	case Minor:
+		return fmt.Sprintf("v%d.%d.0", major, minor+1)
+	case Patch:
+		return fmt.Sprintf("v%d.%d.%d", major, minor, patch+1)
+	}
+	return version
+}
+
+// RequiresNewPath reports whether version cannot be published under
+// modulePath because its major version does not match the path suffix
+func RequiresNewPath(modulePath, version string) bool {
+	return module.CheckPathMajor(version, pathMajor(modulePath)) != nil
+}
+
+// TagPrefix returns the prefix of the version tags of a module whose
+// directory is relDir relative to the repository root, following the go
+// command conventions: no prefix at the root, the directory otherwise,
+// without the major version suffix directory such as "/v2".
+func TagPrefix(modulePath, relDir string) string {
+	relDir = path.Clean(strings.ReplaceAll(relDir, "\\", "/"))
+	if major := pathMajor(modulePath); major != "" {
+		if relDir == strings.TrimPrefix(major, "/") {
+			relDir = "."
+		} else {
+			relDir = strings.TrimSuffix(relDir, major)
+		}
+	}
+	if relDir == "." {
+		return ""
+	}
+	return relDir + "/"
+}
+
+// pathMajor returns the major version suffix of a module path, such as "/v2"
+func pathMajor(modulePath string) string {
+	_, major, _ := module.SplitPathVersion(modulePath)
+	return major
+}
+
+// LatestVersion returns the highest release version among tags carrying
+// prefix, without the prefix, or an empty string when there is none.
+// Prerelease versions are ignored.
+func LatestVersion(tags []string, prefix string) string {
+	latest := ""
+	for _, tag := range tags {
+		v, ok := strings.CutPrefix(tag, prefix)
+		if !ok || !semver.IsValid(v) || semver.Prerelease(v) != "" || semver.Build(v) != "" {
+			continue
+		}
+		if latest == "" || semver.Compare(v, latest) > 0 {
+			latest = v
+		}
+	}
+	return latest
+}
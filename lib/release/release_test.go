@@ -0,0 +1,85 @@
+package release
+
+import "testing"
+
+func TestCommitBump(t *testing.T) {
+	cases := []struct {
+		message string
+		want    Bump
+	}{
+		{"feat: add a parser", Minor},
+		{"feat(query): add tags", Minor},
+		{"fix: handle empty input", Patch},
+		{"perf(graph): cache levels", Patch},
+		{"feat!: drop the old API", Major},
+		{"refactor(core)!: rename Config", Major},
+		{"fix: rename flag\n\nBREAKING CHANGE: --dir is now --path", Major},
+		{"chore: update dependencies", None},
+		{"Update readme", None},
+		{"feature: not conventional", None},
+	}
+	for _, c := range cases {
+		if got := CommitBump(c.message); got != c.want {
+			t.Errorf("CommitBump(%q) = %s, want %s", c.message, got, c.want)
+		}
+	}
+
+	if got := Highest([]string{"fix: a", "feat: b", "docs: c"}); got != Minor {
+		t.Errorf("expected a minor bump, got %s", got)
+	}
+}
+
+func TestNext(t *testing.T) {
+	cases := []struct {
+		version string
+		bump    Bump
+		want    string
+	}{
+		{"", Patch, "v0.0.1"},
+		{"", Minor, "v0.1.0"},
+		{"v0.3.2", Major, "v0.4.0"},
+		{"v1.2.3", Patch, "v1.2.4"},
+		{"v1.2.3", Minor, "v1.3.0"},
+		{"v1.2.3", Major, "v2.0.0"},
+		{"v1.2.3", None, "v1.2.3"},
+	}
+	for _, c := range cases {
+		if got := Next(c.version, c.bump); got != c.want {
+			t.Errorf("Next(%q, %s) = %s, want %s", c.version, c.bump, got, c.want)
+		}
+	}
+
+	if !RequiresNewPath("example.com/core", "v2.0.0") {
+		t.Error("expected v2 to require a /v2 module path")
+	}
+	if RequiresNewPath("example.com/core/v2", "v2.1.0") {
+		t.Error("expected v2.1.0 to fit example.com/core/v2")
+	}
+}
+
+func TestTagPrefix(t *testing.T) {
+	cases := []struct {
+		modulePath, relDir, want string
+	}{
+		{"example.com/repo", ".", ""},
+		{"example.com/repo/core", "core", "core/"},
+		{"example.com/repo/core/v2", "core/v2", "core/"},
+		{"example.com/repo/core/v2", "core", "core/"},
+		{"example.com/repo/v3", "v3", ""},
+	}
+	for _, c := range cases {
+		if got := TagPrefix(c.modulePath, c.relDir); got != c.want {
+			t.Errorf("TagPrefix(%q, %q) = %q, want %q", c.modulePath, c.relDir, got, c.want)
+		}
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	tags := []string{"v9.0.0", "core/v1.2.0", "core/v1.10.0", "core/v2.0.0-rc.1", "core/latest", "utils/v3.0.0"}
+	if got := LatestVersion(tags, "core/"); got != "v1.10.0" {
+		t.Errorf("expected v1.10.0, got %q", got)
+	}
+	if got := LatestVersion(tags, "api/"); got != "" {
+		t.Errorf("expected no version, got %q", got)
+	}
+}
@@ -1 +1,220 @@
 package resolver
+
+import "sort"
+
+// ShortestPaths returns every shortest path from one vertex to another in a
+// directed graph given as an adjacency map. Each path starts with from and
+// ends with to. It returns nil when to is not reachable from from.
+func ShortestPaths[T any](adjMap map[string]map[string]T, from, to string) [][]string {
+	if from == to {
+		return [][]string{{from}}
+	}
+
+	// Breadth-first search, remembering every predecessor at minimal distance
+	dist := map[string]int{from: 0}
+	preds := make(map[string][]string)
+	queue := []string{from}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		if _, found := dist[to]; found && dist[v] >= dist[to] {
+			break
+		}
+		for _, next := range sortedKeys(adjMap[v]) {
+			d, seen := dist[next]
+			if !seen {
+				dist[next] = dist[v] + 1
+				queue = append(queue, next)
+			}
+			if !seen || d == dist[v]+1 {
+				preds[next] = append(preds[next], v)
+			}
+		}
+	}
+
+	if _, found := dist[to]; !found {
+		return nil
+	}
+
+	// Walk predecessors back from the destination
+	var paths [][]string
+	var walk func(v string, suffix []string)
+	walk = func(v string, suffix []string) {
+		path := append([]string{v}, suffix...)
+		if v == from {
+			paths = append(paths, path)
+			return
+		}
+		for _, p := range preds[v] {
+			walk(p, path)
+		}
+	}
+	walk(to, nil)
+	return paths
+}
+
+// sortedKeys returns the keys of m in lexical order, for deterministic results
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Dependents returns every vertex that transitively reaches vertex in a
+// directed graph given as an adjacency map, mapped to its distance from it.
+// A depth greater than zero stops the search after that many edges.
+func Dependents[T any](adjMap map[string]map[string]T, vertex string, depth int) map[string]int {
+	// Reverse the edges once, then walk breadth-first from the vertex
+	reverse := make(map[string][]string)
+	for src, deps := range adjMap {
+		for dst := range deps {
+			reverse[dst] = append(reverse[dst], src)
+		}
+	}
+
+	dist := map[string]int{vertex: 0}
+	queue := []string{vertex}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		if depth > 0 && dist[v] >= depth {
+			continue
+		}
+		for _, prev := range reverse[v] {
+			if _, seen := dist[prev]; !seen {
+				dist[prev] = dist[v] + 1
+				queue = append(queue, prev)
+			}
+		}
+	}
+
+	delete(dist, vertex)
+	return dist
+}
+
+// Dependencies returns the sorted vertices vertex transitively reaches in a
+// directed graph given as an adjacency map
+func Dependencies[T any](adjMap map[string]map[string]T, vertex string) []string {
+	seen := map[string]bool{vertex: true}
+	queue := []string{vertex}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for next := range adjMap[v] {
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	delete(seen, vertex)
+	return sortedKeys(seen)
+}
+
+// Levels groups the vertices of an acyclic graph given as an adjacency map
+// into levels: a vertex only depends on vertices of earlier levels, so every
+// vertex of a level can be processed in parallel once the previous levels are
+// done. Vertices keep the order of vertices within a level, and edges to
+// vertices not listed are ignored. Vertices on a cycle are not placed in any
+// level.
+func Levels[T any](adjMap map[string]map[string]T, vertices []string) [][]string {
+	listed := make(map[string]bool, len(vertices))
+	for _, v := range vertices {
+		listed[v] = true
+	}
+
+	level := make(map[string]int, len(vertices))
+	placed := 0
+	var levels [][]string
+	for placed < len(vertices) {
+		var current []string
+		for _, v := range vertices {
+			if _, done := level[v]; done {
+				continue
+			}
+			ready := true
+			for dep := range adjMap[v] {
+				if !listed[dep] {
+					continue
+				}
+				if l, done := level[dep]; !done || l == len(levels) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				current = append(current, v)
+			}
+		}
+		if len(current) == 0 {
+			break
+		}
+		for _, v := range current {
+			level[v] = len(levels)
+		}
+		placed += len(current)
+		levels = append(levels, current)
+	}
+	return levels
+}
+
+// Cycles returns the cycles of a directed graph given as an adjacency map:
+// each strongly connected component with more than one vertex, or a single
+// vertex with an edge to itself. Vertices of a cycle and cycles are sorted
+// lexically.
+func Cycles[T any](adjMap map[string]map[string]T) [][]string {
+	// Tarjan's algorithm
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var cycles [][]string
+
+	var visit func(v string)
+	visit = func(v string) {
+		index[v] = len(index)
+		lowlink[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range sortedKeys(adjMap[v]) {
+			if _, seen := index[w]; !seen {
+				visit(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onStack[w] {
+				lowlink[v] = min(lowlink[v], index[w])
+			}
+		}
+
+		if lowlink[v] != index[v] {
+			return
+		}
+		var component []string
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		_, selfLoop := adjMap[v][v]
+		if len(component) > 1 || selfLoop {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, v := range sortedKeys(adjMap) {
+		if _, seen := index[v]; !seen {
+			visit(v)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
@@ -2,6 +2,7 @@ package resolver
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/dominikbraun/graph"
@@ -22,3 +23,120 @@ func TestG(t *testing.T) {
 		return false
 	})
 }
+
+func TestShortestPaths(t *testing.T) {
+	adjMap := map[string]map[string]bool{
+		"app":   {"api": true, "core": true},
+		"api":   {"utils": true, "core": true},
+		"utils": {"core": true},
+		"core":  {},
+		"tools": {},
+	}
+
+	paths := ShortestPaths(adjMap, "app", "core")
+	if len(paths) != 1 || len(paths[0]) != 2 {
+		t.Fatalf("expected the direct edge app -> core, got %v", paths)
+	}
+
+	paths = ShortestPaths(adjMap, "app", "utils")
+	if len(paths) != 1 || fmt.Sprint(paths[0]) != "[app api utils]" {
+		t.Errorf("expected app -> api -> utils, got %v", paths)
+	}
+
+	// Two shortest paths of the same length
+	adjMap["app"] = map[string]bool{"api": true, "utils": true}
+	adjMap["api"] = map[string]bool{"core": true}
+	paths = ShortestPaths(adjMap, "app", "core")
+	if len(paths) != 2 {
+		t.Errorf("expected 2 shortest paths, got %v", paths)
+	}
+
+	if paths := ShortestPaths(adjMap, "core", "app"); paths != nil {
+		t.Errorf("expected no path from core to app, got %v", paths)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	adjMap := map[string]map[string]bool{
+		"app":   {"api": true, "core": true},
+		"api":   {"utils": true, "core": true},
+		"utils": {"core": true},
+		"core":  {},
+		"tools": {},
+	}
+
+	dependents := Dependents(adjMap, "utils", 0)
+	if len(dependents) != 2 || dependents["api"] != 1 || dependents["app"] != 2 {
+		t.Errorf("expected api at 1 and app at 2, got %v", dependents)
+	}
+
+	dependents = Dependents(adjMap, "core", 1)
+	if len(dependents) != 3 {
+		t.Errorf("expected the 3 direct dependents of core, got %v", dependents)
+	}
+
+	dependents = Dependents(adjMap, "utils", 1)
+	if len(dependents) != 1 || dependents["api"] != 1 {
+		t.Errorf("expected only api within depth 1, got %v", dependents)
+	}
+
+	if dependents := Dependents(adjMap, "app", 0); len(dependents) != 0 {
+		t.Errorf("expected no dependents of app, got %v", dependents)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	adjMap := map[string]map[string]bool{
+		"app":   {"api": true},
+		"api":   {"utils": true, "core": true},
+		"utils": {"core": true},
+		"core":  {},
+	}
+
+	if deps := Dependencies(adjMap, "app"); strings.Join(deps, ",") != "api,core,utils" {
+		t.Errorf("expected api, core and utils, got %v", deps)
+	}
+	if deps := Dependencies(adjMap, "core"); len(deps) != 0 {
+		t.Errorf("expected no dependencies of core, got %v", deps)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	adjMap := map[string]map[string]bool{
+		"app":   {"api": true, "core": true},
+		"api":   {"utils": true, "core": true},
+		"utils": {"core": true},
+		"core":  {},
+		"tools": {},
+	}
+
+	levels := Levels(adjMap, []string{"app", "api", "utils", "core", "tools"})
+	got := fmt.Sprint(levels)
+	if got != "[[core tools] [utils] [api] [app]]" {
+		t.Errorf("unexpected levels: %s", got)
+	}
+
+	// Vertices on a cycle are left out
+	adjMap["core"] = map[string]bool{"app": true}
+	if levels := Levels(adjMap, []string{"app", "api", "utils", "core", "tools"}); fmt.Sprint(levels) != "[[tools]]" {
+		t.Errorf("unexpected levels with a cycle: %v", levels)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	adjMap := map[string]map[string]bool{
+		"app":   {"api": true, "core": true},
+		"api":   {"utils": true, "core": true},
+		"utils": {"core": true},
+		"core":  {},
+	}
+	if cycles := Cycles(adjMap); len(cycles) != 0 {
+		t.Errorf("expected no cycles, got %v", cycles)
+	}
+
+	adjMap["core"] = map[string]bool{"api": true}
+	adjMap["tools"] = map[string]bool{"tools": true}
+	if got := fmt.Sprint(Cycles(adjMap)); got != "[[api core utils] [tools]]" {
+		t.Errorf("unexpected cycles: %s", got)
+	}
+}
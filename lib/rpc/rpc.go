@@ -0,0 +1,222 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Error codes of the JSON-RPC 2.0 specification
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// CodeServerError is returned when a method fails
+	CodeServerError = -32000
+	// CodeUnauthorized is returned when a connection does not authenticate
+	CodeUnauthorized = -32001
+)
+
+// MethodAuthenticate is the method a connection authenticates with, with
+// params {"token": ...}, when the server requires a token
+const MethodAuthenticate = "rpc.authenticate"
+
+// Error is a JSON-RPC error object. Handlers return one to choose the code,
+// other errors are reported with CodeServerError.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// InvalidParams returns the error reporting invalid method parameters
+func InvalidParams(format string, args ...any) *Error {
+	return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// Notify sends a notification to the client while a method runs, e.g. to
+// stream progress
+type Notify func(method string, params any) error
+
+// Handler implements a method. params is nil when the request has none.
+type Handler func(ctx context.Context, params json.RawMessage, notify Notify) (any, error)
+
+// Server dispatches JSON-RPC 2.0 requests to handlers
+type Server struct {
+	methods map[string]Handler
+	token   string
+}
+
+// NewServer returns a server without methods
+func NewServer() *Server {
+	return &Server{methods: make(map[string]Handler)}
+}
+
+// Handle registers the handler of a method
+func (s *Server) Handle(method string, h Handler) {
+	s.methods[method] = h
+}
+
+// RequireToken makes every connection authenticate before calling a method:
+// its first request must be MethodAuthenticate with the token, otherwise
+// ServeConn answers with CodeUnauthorized and returns
+func (s *Server) RequireToken(token string) {
+	s.token = token
+}
+
+// ServeConn reads newline-delimited requests from r and writes responses
+// and notifications to w, one JSON object per line, until r is exhausted or
+// ctx is done. Requests are handled concurrently, so a long method does not
+// block the others; ServeConn waits for them before returning. A line that
+// is not a JSON-RPC 2.0 request is answered with an error and ends the
+// connection, so that another protocol, such as an HTTP request sent to a
+// TCP listener, is never read as requests.
+func (s *Server) ServeConn(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	send := func(v any) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return encoder.Encode(v)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	authenticated := s.token == ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			send(response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{Code: CodeParseError, Message: err.Error()}})
+			return nil
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			send(response{JSONRPC: "2.0", ID: idOrNull(req.ID), Error: &Error{Code: CodeInvalidRequest, Message: "expected a JSON-RPC 2.0 request with a method"}})
+			return nil
+		}
+		if !authenticated {
+			if !s.authenticate(req) {
+				send(response{JSONRPC: "2.0", ID: idOrNull(req.ID), Error: &Error{Code: CodeUnauthorized, Message: "expected " + MethodAuthenticate + " with the token of the server first"}})
+				return nil
+			}
+			authenticated = true
+			if req.ID != nil {
+				send(response{JSONRPC: "2.0", ID: req.ID, Result: true})
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(req request) {
+			defer wg.Done()
+			result, err := s.call(ctx, req, func(method string, params any) error {
+				return send(notification{JSONRPC: "2.0", Method: method, Params: params})
+			})
+			// Requests without id are notifications, which get no response
+			if req.ID == nil {
+				return
+			}
+			resp := response{JSONRPC: "2.0", ID: req.ID, Result: result}
+			if err != nil {
+				var rpcErr *Error
+				if !errors.As(err, &rpcErr) {
+					rpcErr = &Error{Code: CodeServerError, Message: err.Error()}
+				}
+				resp.Result, resp.Error = nil, rpcErr
+			} else if result == nil {
+				resp.Result = json.RawMessage("null")
+			}
+			send(resp)
+		}(req)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read requests: %w", err)
+	}
+	return nil
+}
+
+// authenticate reports whether req is MethodAuthenticate with the token of
+// the server
+func (s *Server) authenticate(req request) bool {
+	if req.Method != MethodAuthenticate {
+		return false
+	}
+	var p struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(req.Params, &p); err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(p.Token), []byte(s.token)) == 1
+}
+
+func (s *Server) call(ctx context.Context, req request, notify Notify) (result any, err error) {
+	h, ok := s.methods[req.Method]
+	if !ok {
+		return nil, &Error{Code: CodeMethodNotFound, Message: "method not found: " + req.Method}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = &Error{Code: CodeInternalError, Message: fmt.Sprint(r)}
+		}
+	}()
+	return h(ctx, req.Params, notify)
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if id == nil {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+// DecodeParams decodes the parameters of a request into v, leaving v
+// untouched when there are none
+func DecodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return InvalidParams("invalid params: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// serve runs the server on the given request lines and returns the decoded
+// messages it wrote
+func serve(t *testing.T, s *Server, lines ...string) []map[string]any {
+	t.Helper()
+	var out bytes.Buffer
+	if err := s.ServeConn(context.Background(), strings.NewReader(strings.Join(lines, "\n")+"\n"), &out); err != nil {
+		t.Fatalf("ServeConn failed: %v", err)
+	}
+	var messages []map[string]any
+	d := json.NewDecoder(&out)
+	for d.More() {
+		var m map[string]any
+		if err := d.Decode(&m); err != nil {
+			t.Fatalf("invalid output: %v\n%s", err, out.String())
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// byID indexes responses by id
+func byID(messages []map[string]any) map[float64]map[string]any {
+	indexed := make(map[float64]map[string]any)
+	for _, m := range messages {
+		if id, ok := m["id"].(float64); ok {
+			indexed[id] = m
+		}
+	}
+	return indexed
+}
+
+func TestServeConn(t *testing.T) {
+	s := NewServer()
+	s.Handle("add", func(ctx context.Context, params json.RawMessage, notify Notify) (any, error) {
+		var p struct{ A, B int }
+		if err := DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := notify("progress", map[string]int{"a": p.A}); err != nil {
+			return nil, err
+		}
+		return p.A + p.B, nil
+	})
+	s.Handle("fail", func(context.Context, json.RawMessage, Notify) (any, error) {
+		return nil, errors.New("boom")
+	})
+	s.Handle("panic", func(context.Context, json.RawMessage, Notify) (any, error) {
+		panic("oops")
+	})
+	s.Handle("nothing", func(context.Context, json.RawMessage, Notify) (any, error) {
+		return nil, nil
+	})
+
+	messages := serve(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"add","params":{"A":2,"B":3}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"fail"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"missing"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"add","params":"bad"}`,
+		`{"jsonrpc":"2.0","id":5,"method":"panic"}`,
+		`{"jsonrpc":"2.0","id":6,"method":"nothing"}`,
+		`{"jsonrpc":"2.0","method":"add","params":{"A":1}}`,
+		`{"id":7,"method":"add"}`,
+		`{"jsonrpc":"2.0","id":8,"method":"nothing"}`,
+	)
+	responses := byID(messages)
+
+	if got := responses[1]["result"]; got != float64(5) {
+		t.Errorf("expected add to return 5, got %v", responses[1])
+	}
+	for id, code := range map[float64]float64{2: CodeServerError, 3: CodeMethodNotFound, 4: CodeInvalidParams, 5: CodeInternalError, 7: CodeInvalidRequest} {
+		errObj, _ := responses[id]["error"].(map[string]any)
+		if errObj["code"] != code {
+			t.Errorf("request %v: expected error code %v, got %v", id, code, responses[id])
+		}
+	}
+	if r, ok := responses[6]; !ok || r["result"] != nil || r["error"] != nil {
+		t.Errorf("expected a null result, got %v", r)
+	}
+
+	// The invalid request ends the connection
+	if r, ok := responses[8]; ok {
+		t.Errorf("expected no request to be served after an invalid one, got %v", r)
+	}
+
+	notifications := 0
+	for _, m := range messages {
+		if m["method"] == "progress" {
+			notifications++
+		}
+	}
+	// The notification request runs but gets no response
+	if notifications != 2 {
+		t.Errorf("expected 2 notifications, got %d", notifications)
+	}
+	if len(messages) != 9 {
+		t.Errorf("expected 9 messages, got %d: %v", len(messages), messages)
+	}
+}
+
+func TestServeConnParseError(t *testing.T) {
+	s := NewServer()
+	s.Handle("nothing", func(context.Context, json.RawMessage, Notify) (any, error) {
+		return nil, nil
+	})
+
+	// An HTTP request carrying a JSON-RPC body ends at its request line
+	messages := serve(t, s,
+		"POST / HTTP/1.1",
+		"Content-Type: text/plain",
+		"",
+		`{"jsonrpc":"2.0","id":1,"method":"nothing"}`,
+	)
+	if len(messages) != 1 {
+		t.Fatalf("expected a single parse error, got %v", messages)
+	}
+	if errObj, _ := messages[0]["error"].(map[string]any); errObj["code"] != float64(CodeParseError) {
+		t.Errorf("expected a parse error, got %v", messages[0])
+	}
+}
+
+func TestServeConnToken(t *testing.T) {
+	s := NewServer()
+	s.Handle("nothing", func(context.Context, json.RawMessage, Notify) (any, error) {
+		return nil, nil
+	})
+	s.RequireToken("secret")
+
+	for name, auth := range map[string]string{
+		"no authentication": `{"jsonrpc":"2.0","id":1,"method":"nothing"}`,
+		"wrong token":       `{"jsonrpc":"2.0","id":1,"method":"rpc.authenticate","params":{"token":"guess"}}`,
+	} {
+		messages := serve(t, s, auth, `{"jsonrpc":"2.0","id":2,"method":"nothing"}`)
+		if len(messages) != 1 {
+			t.Errorf("%s: expected a single error, got %v", name, messages)
+			continue
+		}
+		if errObj, _ := messages[0]["error"].(map[string]any); errObj["code"] != float64(CodeUnauthorized) {
+			t.Errorf("%s: expected CodeUnauthorized, got %v", name, messages[0])
+		}
+	}
+
+	responses := byID(serve(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"rpc.authenticate","params":{"token":"secret"}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"nothing"}`,
+	))
+	if responses[1]["result"] != true {
+		t.Errorf("expected the authentication to succeed, got %v", responses[1])
+	}
+	if r, ok := responses[2]; !ok || r["error"] != nil {
+		t.Errorf("expected the authenticated request to be served, got %v", r)
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"sync"
+	"time"
 
 	"github.com/nicolasgere/knit/lib/utils"
 )
@@ -13,73 +16,186 @@ import (
 func NewRunner(ctx context.Context, concurency int) Runner {
 	return Runner{
 		semaphore: make(chan struct{}, concurency),
+		acquiring: &sync.Mutex{},
 		ctx:       ctx,
 	}
 }
 
 type Runner struct {
 	semaphore chan struct{}
+	// acquiring is held by the task taking several slots, so that two of them
+	// never wait for each other with part of the slots
+	acquiring *sync.Mutex
 	ctx       context.Context
+	// quiet disables the log line printed when a task starts
+	quiet bool
 }
 
+// Quiet returns a runner sharing the concurrency of r that does not log task
+// starts, for callers printing task output themselves
+func (r Runner) Quiet() Runner {
+	r.quiet = true
+	return r
+}
+
+// Context returns the context the commands of r are killed with
+func (r Runner) Context() context.Context {
+	return r.ctx
+}
+
+// WithContext returns a runner sharing the concurrency of r whose commands
+// are killed with ctx instead
+func (r Runner) WithContext(ctx context.Context) Runner {
+	r.ctx = ctx
+	return r
+}
+
+// Concurrency returns the number of slots of the runner, the weight of a task
+// running alone
+func (r Runner) Concurrency() int {
+	return cap(r.semaphore)
+}
+
+// weight returns the number of slots task takes
+func (r *Runner) weight(task *Task) int {
+	return min(max(task.Weight, 1), cap(r.semaphore))
+}
+
+// acquire waits for the slots of task to be free and takes them
+func (r *Runner) acquire(task *Task) {
+	n := r.weight(task)
+	if n > 1 {
+		r.acquiring.Lock()
+		defer r.acquiring.Unlock()
+	}
+	for range n {
+		r.semaphore <- struct{}{}
+	}
+}
+
+// ExecCommand runs cmd once the slots of task are free and reports its result
+// on tf.Done
 func (r *Runner) ExecCommand(cmd *exec.Cmd, tf *TaskFuture, task *Task) {
-	r.semaphore <- struct{}{}
-	defer func() { <-r.semaphore }()
-	utils.LogTaskStart(task.Id, task.Cmd)
+	r.acquire(task)
+	r.execCommand(cmd, tf, task)
+}
+
+// execCommand is ExecCommand for a caller already holding the slots of task,
+// which are released once the command exits
+func (r *Runner) execCommand(cmd *exec.Cmd, tf *TaskFuture, task *Task) {
+	defer func() {
+		for range r.weight(task) {
+			<-r.semaphore
+		}
+	}()
+	if !r.quiet {
+		label := task.Cmd
+		if task.Label != "" {
+			label = task.Label
+		}
+		utils.LogTaskStart(task.Id, label)
+	}
+	start := time.Now()
+	result := r.exec(cmd, tf)
+	result.Started, result.Duration = start, time.Since(start)
+	tf.Done <- result
+}
+
+func (r *Runner) exec(cmd *exec.Cmd, tf *TaskFuture) TaskResult {
 	pipeout, err := cmd.StdoutPipe()
 	if err != nil {
-		tf.Done <- TaskResult{Err: err, Status: 1}
+		close(tf.Stdout)
+		close(tf.Stderr)
+		return TaskResult{Err: err, Status: 1}
 	}
-	ReaderToChan(&pipeout, tf.Stdout)
 	pipeerr, err := cmd.StderrPipe()
 	if err != nil {
-		tf.Done <- TaskResult{Err: err, Status: 1}
+		close(tf.Stdout)
+		close(tf.Stderr)
+		return TaskResult{Err: err, Status: 1}
 	}
-	ReaderToChan(&pipeerr, tf.Stderr)
 	if err := cmd.Start(); err != nil {
-		tf.Done <- TaskResult{Err: err, Status: 1}
+		close(tf.Stdout)
+		close(tf.Stderr)
+		return TaskResult{Err: err, Status: 1}
 	}
+
+	// Wait closes the pipes, so all output must be read before calling it
+	stdoutDone := ReaderToChan(&pipeout, tf.Stdout)
+	stderrDone := ReaderToChan(&pipeerr, tf.Stderr)
+	<-stdoutDone
+	<-stderrDone
+
 	if err := cmd.Wait(); err != nil {
-		fmt.Println("ERROR")
 		if exiterr, ok := err.(*exec.ExitError); ok {
-			tf.Done <- TaskResult{Err: err, Status: exiterr.ExitCode()}
-		} else {
-			tf.Done <- TaskResult{Err: err, Status: exiterr.ExitCode()}
+			return TaskResult{Err: err, Status: exiterr.ExitCode()}
 		}
+		return TaskResult{Err: err, Status: 1}
 	}
-	tf.Done <- TaskResult{Err: err, Status: 0}
+	return TaskResult{Status: 0}
 }
 
 func (r *Runner) RunTask(task Task) (tf *TaskFuture) {
+	tf = newTaskFuture(task)
+	go func() {
+		r.ExecCommand(r.command(task), tf, &task)
+	}()
+	return
+}
+
+func (r *Runner) command(task Task) *exec.Cmd {
 	cmd := exec.CommandContext(r.ctx, "sh", "-c", task.Cmd)
 	cmd.Dir = task.Root
-	tf = &TaskFuture{
+	if task.Environ != nil {
+		cmd.Env = append(append([]string{}, task.Environ...), task.Env...)
+	} else if len(task.Env) > 0 {
+		cmd.Env = append(os.Environ(), task.Env...)
+	}
+	return cmd
+}
+
+func newTaskFuture(task Task) *TaskFuture {
+	return &TaskFuture{
 		Id:     task.Id,
 		Stdout: make(chan []byte),
 		Stderr: make(chan []byte),
 		Done:   make(chan TaskResult, 1),
 	}
-
-	go r.ExecCommand(cmd, tf, &task)
-	return
 }
 
+// RunTasks runs tasks concurrently, up to the runner concurrency, a task
+// taking as many slots as its Weight. Tasks are started in the given order,
+// so callers can schedule the longest first.
 func (r *Runner) RunTasks(tasks []Task) (tf []*TaskFuture) {
-	tf = make([]*TaskFuture, 0)
+	tf = make([]*TaskFuture, 0, len(tasks))
+	pending := make([]*TaskFuture, 0, len(tasks))
 	for _, task := range tasks {
-		tf = append(tf, r.RunTask(task))
+		f := newTaskFuture(task)
+		tf = append(tf, f)
+		pending = append(pending, f)
 	}
+
+	go func() {
+		for i := range tasks {
+			r.acquire(&tasks[i])
+			go r.execCommand(r.command(tasks[i]), pending[i], &tasks[i])
+		}
+	}()
 	return
 }
 
-func ReaderToChan(r *io.ReadCloser, out chan []byte) {
+// ReaderToChan sends each line read from r to out, then closes out. The
+// returned channel is closed once r is exhausted.
+func ReaderToChan(r *io.ReadCloser, out chan []byte) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
 		rc := *r
+		defer close(done)
 		defer close(out)
-		defer rc.Close()
 		scanner := bufio.NewScanner(rc)
 		for scanner.Scan() {
-			t := scanner.Bytes()
+			// The scanner reuses its buffer, copy the line before handing it off
+			t := append([]byte(nil), scanner.Bytes()...)
 			out <- t
 		}
 		if err := scanner.Err(); err != nil {
@@ -87,4 +203,5 @@ func ReaderToChan(r *io.ReadCloser, out chan []byte) {
 			fmt.Println("Error reading:", err)
 		}
 	}()
+	return done
 }
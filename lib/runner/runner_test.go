@@ -1,7 +1,12 @@
 package runner
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -40,7 +45,7 @@ import (
 // }
 
 func TestRunnerMultiple(t *testing.T) {
-	r := Runner{}
+	r := NewRunner(context.Background(), 2)
 	tasks := []Task{
 		{
 			Id:   "a",
@@ -89,3 +94,106 @@ func TestRunnerMultiple(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestRunTasksStartsInOrder(t *testing.T) {
+	r := NewRunner(context.Background(), 1)
+	log := filepath.Join(t.TempDir(), "order.log")
+	tasks := []Task{
+		{Id: "c", Cmd: "echo c >> " + log, Root: "."},
+		{Id: "a", Cmd: "echo a >> " + log, Root: "."},
+		{Id: "b", Cmd: "echo b >> " + log, Root: "."},
+	}
+
+	for _, tf := range r.RunTasks(tasks) {
+		result := <-tf.Done
+		if result.Status != 0 {
+			t.Fatalf("task %s failed: %v", tf.Id, result.Err)
+		}
+		if result.Duration <= 0 {
+			t.Errorf("expected a duration for task %s", tf.Id)
+		}
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "c\na\nb\n" {
+		t.Errorf("expected tasks to start in the given order, got %q", data)
+	}
+}
+
+func TestRunTasksWeights(t *testing.T) {
+	r := NewRunner(context.Background(), 3)
+	log := filepath.Join(t.TempDir(), "weights.log")
+	run := func(id string) string {
+		return fmt.Sprintf("echo start %s >> %s && sleep 0.1 && echo end %s >> %s", id, log, id, log)
+	}
+	tasks := []Task{
+		{Id: "a", Cmd: run("a"), Root: "."},
+		{Id: "heavy", Cmd: run("heavy"), Root: ".", Weight: 2},
+		{Id: "alone", Cmd: run("alone"), Root: ".", Weight: 10},
+		{Id: "b", Cmd: run("b"), Root: "."},
+	}
+	for _, tf := range r.RunTasks(tasks) {
+		if result := <-tf.Done; result.Status != 0 {
+			t.Fatalf("task %s failed: %v", tf.Id, result.Err)
+		}
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a and heavy fill the 3 slots, alone waits for both and runs before b
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{"end alone", "start b", "end b"}
+	if len(lines) != 8 || !slices.Equal(lines[5:], want) || lines[4] != "start alone" {
+		t.Errorf("expected alone to run alone, then b, got %q", lines)
+	}
+	if !slices.Contains(lines[:2], "start a") || !slices.Contains(lines[:2], "start heavy") {
+		t.Errorf("expected a and heavy to run together, got %q", lines)
+	}
+}
+
+func TestExecCommand(t *testing.T) {
+	r := NewRunner(context.Background(), 2)
+	task := Task{Id: "heavy", Cmd: "true", Root: ".", Weight: 2}
+	tf := newTaskFuture(task)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.ExecCommand(r.command(task), tf, &task)
+	}()
+	if result := <-tf.Done; result.Status != 0 {
+		t.Fatalf("task failed: %v", result.Err)
+	}
+	<-done
+	if n := len(r.semaphore); n != 0 {
+		t.Errorf("expected ExecCommand to release the slots it took, %d still taken", n)
+	}
+}
+
+func TestTaskEnviron(t *testing.T) {
+	t.Setenv("KNIT_RUNNER_PARENT", "inherited")
+	r := NewRunner(context.Background(), 1)
+	out := filepath.Join(t.TempDir(), "env.log")
+	cmd := `echo "parent=$KNIT_RUNNER_PARENT added=$KNIT_RUNNER_ADDED" >> ` + out
+	tasks := []Task{
+		{Id: "inherit", Cmd: cmd, Root: ".", Env: []string{"KNIT_RUNNER_ADDED=1"}},
+		{Id: "replace", Cmd: cmd, Root: ".", Env: []string{"KNIT_RUNNER_ADDED=2"}, Environ: []string{"PATH=" + os.Getenv("PATH")}},
+	}
+	for _, tf := range r.RunTasks(tasks) {
+		if result := <-tf.Done; result.Status != 0 {
+			t.Fatalf("task %s failed: %v", tf.Id, result.Err)
+		}
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "parent=inherited added=1\nparent= added=2\n" {
+		t.Errorf("expected Environ to replace the inherited environment, got %q", data)
+	}
+}
@@ -1,11 +1,23 @@
 package runner
 
+import "time"
+
 type Task struct {
 	Id   string
 	Name string
 	Root string
 	Cmd  string
 	Args []string
+	// Label, when set, is logged instead of Cmd when the task starts
+	Label string
+	// Env holds KEY=value pairs added to the environment of the command
+	Env []string
+	// Environ, when not nil, replaces the environment knit runs in as the
+	// one Env is added to
+	Environ []string
+	// Weight is the number of concurrency slots the task takes, one when 0,
+	// at most all of them, running it alone
+	Weight int
 }
 
 type TaskFuture struct {
@@ -18,4 +30,7 @@ type TaskFuture struct {
 type TaskResult struct {
 	Err    error
 	Status int
+	// Started is when the command started, once a concurrency slot was free
+	Started  time.Time
+	Duration time.Duration
 }
@@ -0,0 +1,211 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ChecksumsFile is the name of the release asset listing the SHA-256 of
+// every other asset, as written by sha256sum
+const ChecksumsFile = "checksums.txt"
+
+// Release is a GitHub release
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Asset returns the asset of the release with the given name
+func (r *Release) Asset(name string) (Asset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Client fetches releases from the GitHub releases API of a repository
+type Client struct {
+	// BaseURL is the releases endpoint, e.g. https://api.github.com/repos/owner/repo/releases
+	BaseURL string
+	// Token authenticates requests when set, raising the API rate limit
+	Token string
+	HTTP  *http.Client
+}
+
+// Release fetches the release of a tag, or the latest release when tag is empty
+func (c *Client) Release(ctx context.Context, tag string) (*Release, error) {
+	url := c.BaseURL + "/latest"
+	if tag != "" {
+		url = c.BaseURL + "/tags/" + tag
+	}
+	data, err := c.get(ctx, url, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	var r Release
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse release: %w", err)
+	}
+	return &r, nil
+}
+
+// Download fetches the content of an asset
+func (c *Client) Download(ctx context.Context, a Asset) ([]byte, error) {
+	return c.get(ctx, a.URL, "application/octet-stream")
+}
+
+func (c *Client) get(ctx context.Context, url, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// ArchiveName returns the name of the release archive of a binary for a
+// platform, following the goreleaser defaults: name_1.2.3_linux_amd64.tar.gz,
+// or .zip on Windows
+func ArchiveName(binary, version, goos, goarch string) string {
+	ext := ".tar.gz"
+	if goos == "windows" {
+		ext = ".zip"
+	}
+	return fmt.Sprintf("%s_%s_%s_%s%s", binary, strings.TrimPrefix(version, "v"), goos, goarch, ext)
+}
+
+// Verify checks data against its SHA-256 in a checksums file
+func Verify(data, checksums []byte, name string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, fields[0]) {
+			return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, fields[0])
+		}
+		return nil
+	}
+	return fmt.Errorf("no checksum found for %s", name)
+}
+
+// ExtractBinary returns the content of the file named binary, at any depth,
+// in a .tar.gz or .zip archive
+func ExtractBinary(archiveName string, data []byte, binary string) ([]byte, error) {
+	if strings.HasSuffix(archiveName, ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", archiveName, err)
+		}
+		for _, f := range zr.File {
+			if path.Base(f.Name) != binary || f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open %s in %s: %w", f.Name, archiveName, err)
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+		return nil, fmt.Errorf("%s not found in %s", binary, archiveName)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", archiveName, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s not found in %s", binary, archiveName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", archiveName, err)
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Base(hdr.Name) == binary {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// Replace atomically replaces the executable at exe with data, through a
+// temporary file in the same directory. On Windows, where a running
+// executable cannot be overwritten, the old one is moved aside first.
+func Replace(exe string, data []byte) error {
+	dir := filepath.Dir(exe)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(exe)+".new-")
+	if err != nil {
+		return fmt.Errorf("failed to create a file next to %s: %w", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return fmt.Errorf("failed to make %s executable: %w", tmp.Name(), err)
+	}
+
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return fmt.Errorf("failed to move %s aside: %w", exe, err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tarGz(t *testing.T, name string, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "README.md", Mode: 0644, Size: 2, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("hi"))
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(content)
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestArchiveName(t *testing.T) {
+	if got := ArchiveName("knit", "v1.2.3", "linux", "amd64"); got != "knit_1.2.3_linux_amd64.tar.gz" {
+		t.Errorf("unexpected linux archive %s", got)
+	}
+	if got := ArchiveName("knit", "v1.2.3", "windows", "arm64"); got != "knit_1.2.3_windows_arm64.zip" {
+		t.Errorf("unexpected windows archive %s", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte("binary")
+	sums := []byte(fmt.Sprintf("%s  other.tar.gz\n%s *knit.tar.gz\n", checksum([]byte("x")), checksum(data)))
+
+	if err := Verify(data, sums, "knit.tar.gz"); err != nil {
+		t.Errorf("expected a valid checksum, got %v", err)
+	}
+	if err := Verify([]byte("tampered"), sums, "knit.tar.gz"); err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+	if err := Verify(data, sums, "missing.tar.gz"); err == nil {
+		t.Error("expected a missing checksum error")
+	}
+}
+
+func TestExtractBinary(t *testing.T) {
+	got, err := ExtractBinary("knit.tar.gz", tarGz(t, "knit_1.0.0/knit", []byte("tar")), "knit")
+	if err != nil || string(got) != "tar" {
+		t.Errorf("tar.gz: got %q, %v", got, err)
+	}
+	if _, err := ExtractBinary("knit.tar.gz", tarGz(t, "other", []byte("tar")), "knit"); err == nil {
+		t.Error("expected an error when the binary is missing")
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("knit.exe")
+	w.Write([]byte("zip"))
+	zw.Close()
+	got, err = ExtractBinary("knit.zip", buf.Bytes(), "knit.exe")
+	if err != nil || string(got) != "zip" {
+		t.Errorf("zip: got %q, %v", got, err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "knit")
+	if err := os.WriteFile(exe, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Replace(exe, []byte("new")); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	got, _ := os.ReadFile(exe)
+	if string(got) != "new" {
+		t.Errorf("expected the new content, got %q", got)
+	}
+	info, _ := os.Stat(exe)
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("expected an executable file, got mode %v", info.Mode())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary file left, got %d entries", len(entries))
+	}
+}
+
+func TestClientRelease(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/releases/latest":
+			fmt.Fprintf(w, `{"tag_name":"v1.1.0","assets":[{"name":"checksums.txt","browser_download_url":"%s/dl/checksums.txt"}]}`, "http://"+r.Host)
+		case "/releases/tags/v1.0.0":
+			fmt.Fprint(w, `{"tag_name":"v1.0.0","assets":[]}`)
+		case "/dl/checksums.txt":
+			fmt.Fprint(w, "sums")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL + "/releases", Token: "secret"}
+	ctx := context.Background()
+
+	latest, err := client.Release(ctx, "")
+	if err != nil {
+		t.Fatalf("latest release failed: %v", err)
+	}
+	if latest.TagName != "v1.1.0" || auth != "Bearer secret" {
+		t.Errorf("unexpected latest release %+v (auth %q)", latest, auth)
+	}
+	asset, ok := latest.Asset(ChecksumsFile)
+	if !ok {
+		t.Fatalf("expected a %s asset", ChecksumsFile)
+	}
+	data, err := client.Download(ctx, asset)
+	if err != nil || string(data) != "sums" {
+		t.Errorf("download: got %q, %v", data, err)
+	}
+
+	tagged, err := client.Release(ctx, "v1.0.0")
+	if err != nil || tagged.TagName != "v1.0.0" {
+		t.Errorf("tagged release: got %+v, %v", tagged, err)
+	}
+	if _, err := client.Release(ctx, "v9.9.9"); err == nil {
+		t.Error("expected an error for a missing release")
+	}
+}
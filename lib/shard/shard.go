@@ -0,0 +1,46 @@
+package shard
+
+import "sort"
+
+// Item is a unit of work to distribute, such as a module or a test
+type Item struct {
+	Id     string
+	Weight float64
+}
+
+// Partition splits items into n groups of balanced total weight, using the
+// longest-processing-time-first heuristic. The result is deterministic:
+// items are placed by decreasing weight (ties broken by id) into the
+// lightest group (ties broken by index), and each group is sorted by id.
+func Partition(items []Item, n int) [][]Item {
+	if n < 1 {
+		return nil
+	}
+
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Weight != sorted[j].Weight {
+			return sorted[i].Weight > sorted[j].Weight
+		}
+		return sorted[i].Id < sorted[j].Id
+	})
+
+	groups := make([][]Item, n)
+	loads := make([]float64, n)
+	for _, item := range sorted {
+		lightest := 0
+		for g := 1; g < n; g++ {
+			if loads[g] < loads[lightest] {
+				lightest = g
+			}
+		}
+		groups[lightest] = append(groups[lightest], item)
+		loads[lightest] += item.Weight
+	}
+
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].Id < group[j].Id })
+	}
+	return groups
+}
@@ -0,0 +1,56 @@
+package shard
+
+import "testing"
+
+func TestPartitionBalancesWeights(t *testing.T) {
+	items := []Item{
+		{Id: "a", Weight: 10},
+		{Id: "b", Weight: 6},
+		{Id: "c", Weight: 5},
+		{Id: "d", Weight: 4},
+		{Id: "e", Weight: 1},
+	}
+
+	groups := Partition(items, 2)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+
+	var loads [2]float64
+	total := 0
+	for i, g := range groups {
+		for _, item := range g {
+			loads[i] += item.Weight
+		}
+		total += len(g)
+	}
+	if total != len(items) {
+		t.Errorf("expected %d items across groups, got %d", len(items), total)
+	}
+	// a(10) -> g0, b(6) -> g1, c(5) -> g1, d(4) -> g0, e(1) -> g1
+	if loads[0] != 14 || loads[1] != 12 {
+		t.Errorf("expected loads 14/12, got %v", loads)
+	}
+}
+
+func TestPartitionIsDeterministic(t *testing.T) {
+	items := []Item{{Id: "c", Weight: 1}, {Id: "a", Weight: 1}, {Id: "b", Weight: 1}}
+	reversed := []Item{{Id: "b", Weight: 1}, {Id: "a", Weight: 1}, {Id: "c", Weight: 1}}
+
+	g1 := Partition(items, 2)
+	g2 := Partition(reversed, 2)
+	for i := range g1 {
+		if len(g1[i]) != len(g2[i]) {
+			t.Fatalf("group %d differs: %v vs %v", i, g1[i], g2[i])
+		}
+		for j := range g1[i] {
+			if g1[i][j].Id != g2[i][j].Id {
+				t.Errorf("group %d differs: %v vs %v", i, g1[i], g2[i])
+			}
+		}
+	}
+
+	if groups := Partition(items, 5); len(groups[4]) != 0 {
+		t.Errorf("expected empty trailing groups when n exceeds items, got %v", groups[4])
+	}
+}
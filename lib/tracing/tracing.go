@@ -0,0 +1,343 @@
+// Package tracing exports the runs of knit as OpenTelemetry traces, sent
+// when the run ends over OTLP/HTTP with the JSON encoding. It is configured
+// with the standard environment variables:
+//
+//	OTEL_EXPORTER_OTLP_ENDPOINT         base URL, /v1/traces is appended
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT  full URL, overriding the base one
+//	OTEL_EXPORTER_OTLP[_TRACES]_HEADERS key=value pairs separated by commas
+//	OTEL_EXPORTER_OTLP[_TRACES]_TIMEOUT export timeout in milliseconds
+//	OTEL_EXPORTER_OTLP[_TRACES]_PROTOCOL only http/json is supported
+//	OTEL_SERVICE_NAME                   knit by default
+//	OTEL_RESOURCE_ATTRIBUTES            key=value pairs separated by commas
+//	OTEL_TRACES_EXPORTER, OTEL_SDK_DISABLED  none and true disable tracing
+//	TRACEPARENT                         W3C parent of the run, e.g. a CI span
+//
+// A nil *Tracer and a nil *Span are valid and record nothing, so callers do
+// not check whether tracing is enabled.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProtocolJSON is the only OTLP protocol supported
+const ProtocolJSON = "http/json"
+
+// Tracer records the spans of a run, exported as one trace by Flush
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	timeout  time.Duration
+	resource map[string]string
+	version  string
+	http     *http.Client
+
+	traceID, parentID string
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Span is a timed operation of a run
+type Span struct {
+	t          *Tracer
+	id, parent string
+	name       string
+	start, end time.Time
+	attributes []attribute
+	failed     bool
+	message    string
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+// FromEnv returns a tracer configured by the environment, or nil when no
+// OTLP endpoint is set or tracing is disabled. version is the version of
+// knit, reported as the instrumentation scope version.
+func FromEnv(version string) (*Tracer, error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil, nil
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if protocol := signalEnv("PROTOCOL"); protocol != "" && protocol != ProtocolJSON {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, only %s is supported", protocol, ProtocolJSON)
+	}
+
+	t := &Tracer{
+		endpoint: endpoint,
+		headers:  parsePairs(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		timeout:  10 * time.Second,
+		resource: parsePairs(os.Getenv("OTEL_RESOURCE_ATTRIBUTES")),
+		version:  version,
+		http:     http.DefaultClient,
+		traceID:  randomID(16),
+	}
+	for k, v := range parsePairs(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")) {
+		t.headers[k] = v
+	}
+	if ms, err := strconv.Atoi(signalEnv("TIMEOUT")); err == nil && ms > 0 {
+		t.timeout = time.Duration(ms) * time.Millisecond
+	}
+	if service := os.Getenv("OTEL_SERVICE_NAME"); service != "" {
+		t.resource["service.name"] = service
+	} else if _, ok := t.resource["service.name"]; !ok {
+		t.resource["service.name"] = "knit"
+	}
+	if traceID, parentID, ok := parseTraceparent(os.Getenv("TRACEPARENT")); ok {
+		t.traceID, t.parentID = traceID, parentID
+	}
+	return t, nil
+}
+
+// signalEnv returns the traces variable of an OTLP setting, falling back to
+// the one shared by every signal
+func signalEnv(setting string) string {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + setting); v != "" {
+		return v
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + setting)
+}
+
+// parsePairs parses the key=value,key=value lists of the OTEL variables,
+// whose values are URL-encoded
+func parsePairs(s string) map[string]string {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = unescaped
+		}
+		pairs[strings.TrimSpace(key)] = value
+	}
+	return pairs
+}
+
+// parseTraceparent parses a W3C traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(s string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	for _, id := range parts[1:3] {
+		if b, err := hex.DecodeString(id); err != nil || bytes.Count(b, []byte{0}) == len(b) {
+			return "", "", false
+		}
+	}
+	return parts[1], parts[2], true
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start starts a span child of parent, or the root span of the run when
+// parent is nil
+func (t *Tracer) Start(parent *Span, name string, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{t: t, id: randomID(8), parent: t.parentID, name: name, start: start}
+	if parent != nil {
+		s.parent = parent.id
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return s
+}
+
+// Set records an attribute of the span. Values are strings, bools, ints or
+// float64s.
+func (s *Span) Set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.attributes = append(s.attributes, attribute{key, value})
+}
+
+// Fail marks the span as failed
+func (s *Span) Fail(message string) {
+	if s == nil {
+		return
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.failed, s.message = true, message
+}
+
+// End ends the span
+func (s *Span) End(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.end = end
+}
+
+// Flush exports the ended spans as one trace
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	body, err := json.Marshal(t.export())
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to export trace: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export trace: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to export trace to %s: %s: %s", t.endpoint, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// The OTLP JSON encoding of a trace, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []jsonSpan `json:"spans"`
+	}
+	scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	jsonSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+	status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	keyValue struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+// OTLP span kinds and status codes
+const (
+	kindInternal = 1
+	statusOK     = 1
+	statusError  = 2
+)
+
+func (t *Tracer) export() exportRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := resource{Attributes: []keyValue{}}
+	for k, v := range t.resource {
+		res.Attributes = append(res.Attributes, keyValue{k, encodeValue(v)})
+	}
+	spans := make([]jsonSpan, 0, len(t.spans))
+	for _, s := range t.spans {
+		if s.end.IsZero() {
+			continue
+		}
+		js := jsonSpan{
+			TraceID:           t.traceID,
+			SpanID:            s.id,
+			ParentSpanID:      s.parent,
+			Name:              s.name,
+			Kind:              kindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            status{Code: statusOK},
+		}
+		for _, a := range s.attributes {
+			js.Attributes = append(js.Attributes, keyValue{a.key, encodeValue(a.value)})
+		}
+		if s.failed {
+			js.Status = status{Code: statusError, Message: s.message}
+		}
+		spans = append(spans, js)
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   res,
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "knit", Version: t.version}, Spans: spans}},
+	}}}
+}
+
+// encodeValue encodes an attribute value as an OTLP AnyValue, where 64-bit
+// integers are strings
+func encodeValue(v any) map[string]any {
+	switch v := v.(type) {
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	case string:
+		return map[string]any{"stringValue": v}
+	}
+	return map[string]any{"stringValue": fmt.Sprint(v)}
+}
@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromEnvDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if tracer, err := FromEnv("v1"); tracer != nil || err != nil {
+		t.Errorf("expected no tracer without an endpoint, got %v, %v", tracer, err)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	if tracer, err := FromEnv("v1"); tracer != nil || err != nil {
+		t.Errorf("expected OTEL_TRACES_EXPORTER=none to disable tracing, got %v, %v", tracer, err)
+	}
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+	if _, err := FromEnv("v1"); err == nil || !strings.Contains(err.Error(), "unsupported OTLP protocol") {
+		t.Errorf("expected grpc to be rejected, got %v", err)
+	}
+
+	// A nil tracer records nothing
+	var tracer *Tracer
+	span := tracer.Start(nil, "run", time.Now())
+	span.Set("key", "value")
+	span.Fail("failed")
+	span.End(time.Now())
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer server.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL+"/")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=secret%20key")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "ci.pipeline=42")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	t.Setenv("TRACEPARENT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tracer, err := FromEnv("v1.2.3")
+	if err != nil || tracer == nil {
+		t.Fatalf("expected a tracer, got %v, %v", tracer, err)
+	}
+
+	start := time.Unix(100, 0)
+	root := tracer.Start(nil, "knit test", start)
+	child := tracer.Start(root, "test example.com/core", start.Add(time.Second))
+	child.Set("knit.exit_code", 1)
+	child.Set("knit.cache_hit", false)
+	child.Fail("exit 1")
+	child.End(start.Add(2 * time.Second))
+	tracer.Start(root, "never ended", start)
+	root.End(start.Add(3 * time.Second))
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("x-api-key") != "secret key" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers: %v", header)
+	}
+	var req exportRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("invalid export request: %v\n%s", err, body)
+	}
+	rs := req.ResourceSpans[0]
+	resource := map[string]any{}
+	for _, kv := range rs.Resource.Attributes {
+		resource[kv.Key] = kv.Value["stringValue"]
+	}
+	if resource["service.name"] != "knit" || resource["ci.pipeline"] != "42" {
+		t.Errorf("unexpected resource: %v", resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 || rs.ScopeSpans[0].Scope.Version != "v1.2.3" {
+		t.Fatalf("expected the 2 ended spans, got %+v", rs.ScopeSpans[0])
+	}
+	r, c := spans[0], spans[1]
+	if r.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || r.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected the root to continue TRACEPARENT, got %+v", r)
+	}
+	if c.ParentSpanID != r.SpanID || c.TraceID != r.TraceID || c.StartTimeUnixNano != "101000000000" {
+		t.Errorf("unexpected child span: %+v", c)
+	}
+	if c.Status.Code != statusError || c.Status.Message != "exit 1" || r.Status.Code != statusOK {
+		t.Errorf("unexpected statuses: %+v, %+v", r.Status, c.Status)
+	}
+	if len(c.Attributes) != 2 || c.Attributes[0].Value["intValue"] != "1" || c.Attributes[1].Value["boolValue"] != false {
+		t.Errorf("unexpected attributes: %+v", c.Attributes)
+	}
+}
+
+func TestFlushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", server.URL+"/custom")
+	tracer, err := FromEnv("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Start(nil, "run", time.Now()).End(time.Now())
+	if err := tracer.Flush(context.Background()); err == nil || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("expected the export to fail, got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+// Package unused finds the exported functions and types of workspace
+// modules that no other package of the workspace uses.
+package unused
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"golang.org/x/tools/go/packages"
+)
+
+// Identifier is an exported function or type nothing else uses
+type Identifier struct {
+	Module  string `json:"module"`
+	Package string `json:"package"`
+	Name    string `json:"name"`
+	// Kind is "func" or "type"
+	Kind string `json:"kind"`
+	// File and Line are the position of the declaration
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// use is a package using an identifier
+type use struct {
+	pkg, module string
+}
+
+// Find returns the exported package-level functions and types of the
+// library packages of modules that no other package uses, sorted by package
+// and name. The uses are the ones of every package of modules, tests
+// included, but the ones of the package itself and of its tests. With
+// crossModule, only the uses from other modules count, and the internal
+// packages, which other modules cannot import, are left out.
+func Find(modules []analyzer.Module, crossModule bool) ([]Identifier, error) {
+	var defs []Identifier
+	uses := make(map[string][]use)
+	// signatures maps a function to the types of its signature, used along
+	// with it
+	signatures := make(map[string][]string)
+	for _, m := range modules {
+		pkgs, err := load(m.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pkgs {
+			recordUses(p, m.Path, uses)
+			// The test variants of a package type-check it again
+			if p.ID != p.PkgPath || p.Name == "main" || (crossModule && isInternal(p.PkgPath)) {
+				continue
+			}
+			scope := p.Types.Scope()
+			for _, name := range scope.Names() {
+				obj := scope.Lookup(name)
+				if !obj.Exported() {
+					continue
+				}
+				kind := ""
+				switch obj := obj.(type) {
+				case *types.Func:
+					kind = "func"
+					namedTypes(obj.Type(), func(t *types.TypeName) {
+						signatures[key(obj)] = append(signatures[key(obj)], key(t))
+					})
+				case *types.TypeName:
+					kind = "type"
+				default:
+					continue
+				}
+				pos := p.Fset.Position(obj.Pos())
+				defs = append(defs, Identifier{Module: m.Path, Package: p.PkgPath, Name: name, Kind: kind, File: pos.Filename, Line: pos.Line})
+			}
+		}
+	}
+
+	used := func(id Identifier, k string) bool {
+		for _, u := range uses[k] {
+			if crossModule && u.module != id.Module || !crossModule && u.pkg != id.Package {
+				return true
+			}
+		}
+		return false
+	}
+	// The types of the signature of a used function are used with it
+	usedTypes := make(map[string]bool)
+	for _, id := range defs {
+		k := id.Package + "." + id.Name
+		if id.Kind == "func" && used(id, k) {
+			for _, t := range signatures[k] {
+				usedTypes[t] = true
+			}
+		}
+	}
+
+	var unused []Identifier
+	for _, id := range defs {
+		k := id.Package + "." + id.Name
+		if !used(id, k) && !usedTypes[k] {
+			unused = append(unused, id)
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool {
+		if unused[i].Package != unused[j].Package {
+			return unused[i].Package < unused[j].Package
+		}
+		return unused[i].Name < unused[j].Name
+	})
+	return unused, nil
+}
+
+// load loads the packages of the module in dir with their tests
+func load(dir string) ([]*packages.Package, error) {
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedSyntax | packages.NeedImports | packages.NeedDeps,
+		Dir:   dir,
+		Tests: true,
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages in %s: %w", dir, err)
+	}
+	for _, p := range pkgs {
+		if len(p.Errors) > 0 {
+			return nil, fmt.Errorf("failed to load %s: %v", p.PkgPath, p.Errors[0])
+		}
+	}
+	return pkgs, nil
+}
+
+// recordUses records the package-level identifiers p uses, and the types
+// whose fields or methods it selects, keyed by package path and name. The
+// external test package of a package is the package itself.
+func recordUses(p *packages.Package, module string, uses map[string][]use) {
+	if p.TypesInfo == nil {
+		return
+	}
+	u := use{pkg: strings.TrimSuffix(p.PkgPath, "_test"), module: module}
+	for _, obj := range p.TypesInfo.Uses {
+		if obj.Pkg() != nil && obj.Parent() == obj.Pkg().Scope() {
+			uses[key(obj)] = append(uses[key(obj)], u)
+		}
+	}
+	for _, sel := range p.TypesInfo.Selections {
+		namedTypes(sel.Recv(), func(t *types.TypeName) {
+			uses[key(t)] = append(uses[key(t)], u)
+		})
+	}
+}
+
+// namedTypes calls fn with the named types t is made of, without looking
+// into their underlying types
+func namedTypes(t types.Type, fn func(*types.TypeName)) {
+	switch t := t.(type) {
+	case *types.Named:
+		if t.Obj().Pkg() != nil {
+			fn(t.Origin().Obj())
+		}
+	case *types.Pointer:
+		namedTypes(t.Elem(), fn)
+	case *types.Slice:
+		namedTypes(t.Elem(), fn)
+	case *types.Array:
+		namedTypes(t.Elem(), fn)
+	case *types.Chan:
+		namedTypes(t.Elem(), fn)
+	case *types.Map:
+		namedTypes(t.Key(), fn)
+		namedTypes(t.Elem(), fn)
+	case *types.Signature:
+		for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				namedTypes(tuple.At(i).Type(), fn)
+			}
+		}
+	}
+}
+
+// key identifies a package-level object across the loads of the modules,
+// each type-checking the packages again
+func key(obj types.Object) string {
+	return obj.Pkg().Path() + "." + obj.Name()
+}
+
+// isInternal reports whether a package path has an internal element, which
+// makes it unimportable from other modules
+func isInternal(pkgPath string) bool {
+	for _, elem := range strings.Split(pkgPath, "/") {
+		if elem == "internal" {
+			return true
+		}
+	}
+	return false
+}
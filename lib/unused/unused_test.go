@@ -0,0 +1,68 @@
+package unused
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+)
+
+var files = map[string]string{
+	"go.work":           "go 1.22\n\nuse (\n\t./lib\n\t./app\n)\n",
+	"lib/go.mod":        "module example.com/lib\n\ngo 1.22\n",
+	"lib/lib.go":        "package lib\n\nfunc Used() Result { return Result{} }\n\nfunc Unused() {}\n\nfunc Local() {}\n\nfunc OnlyTested() {}\n\ntype Result struct{ N int }\n\ntype Selected struct{}\n\nfunc (Selected) Method() {}\n\nfunc NewSelected() *Selected { return nil }\n\ntype Options struct{}\n\nfunc helper() { Local() }\n",
+	"lib/lib_test.go":   "package lib\n\nimport \"testing\"\n\nfunc TestOnlyTested(t *testing.T) { OnlyTested() }\n",
+	"lib/sub/sub.go":    "package sub\n\nimport \"example.com/lib\"\n\nfunc Sub() { lib.Local() }\n",
+	"lib/sub/x_test.go": "package sub_test\n\nimport (\n\t\"testing\"\n\n\t\"example.com/lib/sub\"\n)\n\nfunc TestSub(t *testing.T) { sub.Sub() }\n",
+	"app/go.mod":        "module example.com/app\n\ngo 1.22\n\nrequire example.com/lib v0.0.0\n",
+	"app/main.go":       "package main\n\nimport \"example.com/lib\"\n\nfunc main() { _ = lib.Used().N; lib.NewSelected().Method() }\n\nfunc Exported() {}\n",
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modules := []analyzer.Module{
+		{Path: "example.com/lib", Dir: filepath.Join(dir, "lib")},
+		{Path: "example.com/app", Dir: filepath.Join(dir, "app")},
+	}
+
+	unused, err := Find(modules, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sub is only used by its own tests, Result is returned by Used and
+	// Selected is only selected
+	want := []string{"example.com/lib.OnlyTested", "example.com/lib.Options", "example.com/lib.Unused", "example.com/lib/sub.Sub"}
+	assertNames(t, unused, want)
+	if unused[0].Kind != "func" || unused[1].Kind != "type" || unused[0].File != filepath.Join(dir, "lib", "lib.go") || unused[0].Line != 9 {
+		t.Errorf("unexpected identifiers %+v", unused)
+	}
+
+	unused, err = Find(modules, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Local is only used from its own module
+	assertNames(t, unused, []string{"example.com/lib.Local", "example.com/lib.OnlyTested", "example.com/lib.Options", "example.com/lib.Unused", "example.com/lib/sub.Sub"})
+}
+
+func assertNames(t *testing.T, unused []Identifier, want []string) {
+	t.Helper()
+	if len(unused) != len(want) {
+		t.Fatalf("expected %v, got %+v", want, unused)
+	}
+	for i, id := range unused {
+		if got := id.Package + "." + id.Name; got != want[i] {
+			t.Errorf("expected %s, got %s", want[i], got)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package utils
 import (
 	"fmt"
 	"hash/fnv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 type LogLevel int
@@ -28,6 +30,7 @@ const (
 	Red     = "\033[31m"
 	Green   = "\033[32m"
 	Yellow  = "\033[33m"
+	Orange  = "\033[38;5;208m"
 	Blue    = "\033[34m"
 	Magenta = "\033[35m"
 	Cyan    = "\033[36m"
@@ -88,47 +91,151 @@ func getColorForTask(id string) string {
 	return color
 }
 
+// Timestamp formats of the log prefixes
+const (
+	// TimestampRelative is the seconds since knit started, the default
+	TimestampRelative = "relative"
+	// TimestampClock is the wall-clock time of the day
+	TimestampClock = "clock"
+	// TimestampISO is the RFC 3339 date and time, with milliseconds
+	TimestampISO = "iso"
+	// TimestampNone leaves the timestamp out
+	TimestampNone = "none"
+)
+
+// MaxPrefixWidth is the widest the task IDs of the log prefixes are padded to
+// by AlignPrefixes, unless a width is set with SetPrefixWidth
+const MaxPrefixWidth = 32
+
+var (
+	timestampFormat = TimestampRelative
+	// prefixWidth is the width the task IDs are padded or truncated to, 0
+	// leaving them as they are, and fixedWidth the one of SetPrefixWidth
+	prefixWidth, fixedWidth int
+	formatMu                sync.RWMutex
+)
+
+// SetTimestamps sets the timestamp format of the log prefixes
+func SetTimestamps(format string) error {
+	switch format {
+	case TimestampRelative, TimestampClock, TimestampISO, TimestampNone:
+	default:
+		return fmt.Errorf("unknown timestamp format %q: expected %s, %s, %s or %s", format, TimestampRelative, TimestampClock, TimestampISO, TimestampNone)
+	}
+	formatMu.Lock()
+	defer formatMu.Unlock()
+	timestampFormat = format
+	return nil
+}
+
+// SetPrefixWidth pads the task IDs of the log prefixes to width columns,
+// truncating the longer ones from the left, instead of the width
+// AlignPrefixes computes when 0
+func SetPrefixWidth(width int) error {
+	if width < 0 {
+		return fmt.Errorf("invalid prefix width %d, expected a positive number of columns", width)
+	}
+	formatMu.Lock()
+	defer formatMu.Unlock()
+	prefixWidth, fixedWidth = width, width
+	return nil
+}
+
+// AlignPrefixes pads the task IDs of the log prefixes to the longest of ids,
+// at most MaxPrefixWidth, so that the output of the tasks lines up, unless a
+// width was set with SetPrefixWidth
+func AlignPrefixes(ids []string) {
+	formatMu.Lock()
+	defer formatMu.Unlock()
+	if fixedWidth > 0 {
+		return
+	}
+	prefixWidth = 0
+	for _, id := range ids {
+		prefixWidth = max(prefixWidth, utf8.RuneCountInString(id))
+	}
+	prefixWidth = min(prefixWidth, MaxPrefixWidth)
+}
+
+// fitID pads or truncates id to the prefix width, returning it and the
+// padding going after it
+func fitID(id string) (string, string) {
+	formatMu.RLock()
+	width := prefixWidth
+	formatMu.RUnlock()
+
+	n := utf8.RuneCountInString(id)
+	if width == 0 || n == width {
+		return id, ""
+	}
+	if n < width {
+		return id, strings.Repeat(" ", width-n)
+	}
+	// The end of a module path tells modules apart better than its start
+	runes := []rune(id)
+	return "…" + string(runes[n-width+1:]), ""
+}
+
+// timestamp returns the timestamp of a log line, empty with TimestampNone
+func timestamp() string {
+	formatMu.RLock()
+	format := timestampFormat
+	formatMu.RUnlock()
+
+	switch format {
+	case TimestampClock:
+		return time.Now().Format("15:04:05")
+	case TimestampISO:
+		return time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+	case TimestampNone:
+		return ""
+	}
+	return fmt.Sprintf("%.1f", time.Since(STARTED).Seconds())
+}
+
+// prefix returns the timestamp and the task ID starting the log lines of a
+// task, fitted to the prefix width
+func prefix(id string) string {
+	ts := timestamp()
+	fitted, padding := fitID(id)
+	if IsColorEnabled() {
+		p := fmt.Sprintf("%s[%s]%s%s", getColorForTask(id), fitted, Reset, padding)
+		if ts != "" {
+			p = Dim + ts + Reset + " " + p
+		}
+		return p
+	}
+	p := "[" + fitted + "]" + padding
+	if ts != "" {
+		p = ts + " " + p
+	}
+	return p
+}
+
 func LogWithTaskId(id string, msg string, level LogLevel) {
-	Since := time.Since(STARTED)
 	if level >= LOG_LEVEL {
-		if IsColorEnabled() {
-			color := getColorForTask(id)
-			timeColor := Dim
-			fmt.Printf("%s%.1f%s %s[%s]%s %s\n", timeColor, Since.Seconds(), Reset, color, id, Reset, msg)
-		} else {
-			fmt.Printf("%.1f [%s] %s\n", Since.Seconds(), id, msg)
-		}
+		fmt.Printf("%s %s\n", prefix(id), msg)
 	}
 }
 
 // LogStatus logs a status message with appropriate color
 func LogStatus(id string, status string, isSuccess bool) {
-	Since := time.Since(STARTED)
 	if IsColorEnabled() {
-		color := getColorForTask(id)
 		statusColor := Green
 		if !isSuccess {
 			statusColor = Red
 		}
-		timeColor := Dim
-		fmt.Printf("%s%.1f%s %s[%s]%s %s%s%s\n", timeColor, Since.Seconds(), Reset, color, id, Reset, statusColor, status, Reset)
+		fmt.Printf("%s %s%s%s\n", prefix(id), statusColor, status, Reset)
 	} else {
-		fmt.Printf("%.1f [%s] %s\n", Since.Seconds(), id, status)
+		fmt.Printf("%s %s\n", prefix(id), status)
 	}
 }
 
 // LogTaskStart logs when a task starts with highlighted command
 func LogTaskStart(id string, cmd string) {
-	Since := time.Since(STARTED)
 	if IsColorEnabled() {
-		color := getColorForTask(id)
-		timeColor := Dim
-		fmt.Printf("%s%.1f%s %s[%s]%s %s▶ Run%s %s%s%s\n",
-			timeColor, Since.Seconds(), Reset,
-			color, id, Reset,
-			Bold, Reset,
-			Cyan, cmd, Reset)
+		fmt.Printf("%s %s▶ Run%s %s%s%s\n", prefix(id), Bold, Reset, Cyan, cmd, Reset)
 	} else {
-		fmt.Printf("%.1f [%s] Run task -> %s\n", Since.Seconds(), id, cmd)
+		fmt.Printf("%s Run task -> %s\n", prefix(id), cmd)
 	}
 }
@@ -0,0 +1,81 @@
+package vcs
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// jujutsu shells out to the jj binary
+type jujutsu struct{}
+
+func (jujutsu) Name() string { return Jujutsu }
+
+func (jujutsu) ChangedFiles(compareRef string, useMergeBase bool, dir string) ([]string, error) {
+	from := compareRef
+	if useMergeBase {
+		// Common ancestors of the reference and the working-copy commit
+		from = fmt.Sprintf("heads(::(%s) & ::@)", compareRef)
+	}
+	output, err := run(dir, "jj", "diff", "--name-only", "--from", from, "--to", "@")
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(output), nil
+}
+
+func (jujutsu) Root(dir string) (string, error) {
+	output, err := run(dir, "jj", "root")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// mercurial shells out to the hg binary
+type mercurial struct{}
+
+func (mercurial) Name() string { return Mercurial }
+
+func (mercurial) ChangedFiles(compareRef string, useMergeBase bool, dir string) ([]string, error) {
+	rev := compareRef
+	if useMergeBase {
+		rev = fmt.Sprintf("ancestor(%s, .)", compareRef)
+	}
+	// Modified, added, removed and deleted files, without status prefix,
+	// relative to the repository root like git
+	output, err := run(dir, "hg", "status", "--config", "ui.relative-paths=false", "-mard", "-n", "--rev", rev)
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(output), nil
+}
+
+func (mercurial) Root(dir string) (string, error) {
+	output, err := run(dir, "hg", "root")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func run(dir string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("%s %s failed: %w\n%s", name, args[0], err, exitErr.Stderr)
+		}
+		return "", fmt.Errorf("%s %s failed: %w", name, args[0], err)
+	}
+	return string(output), nil
+}
+
+func splitLines(output string) []string {
+	trimmed := strings.TrimSpace(output)
+	if trimmed == "" {
+		return []string{}
+	}
+	return strings.Split(trimmed, "\n")
+}
@@ -0,0 +1,128 @@
+package vcs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nicolasgere/knit/lib/git"
+)
+
+// Names of the supported version control systems
+const (
+	Auto      = "auto"
+	Git       = "git"
+	Jujutsu   = "jj"
+	Mercurial = "hg"
+)
+
+// VCS detects the files changed in a repository
+type VCS interface {
+	// Name returns the short name of the version control system
+	Name() string
+	// ChangedFiles returns the files changed compared to compareRef, relative to the repository root.
+	// If useMergeBase is true, it compares against the common ancestor of compareRef and the working copy.
+	ChangedFiles(compareRef string, useMergeBase bool, dir string) ([]string, error)
+	// Root returns the top-level directory of the repository containing dir
+	Root(dir string) (string, error)
+}
+
+// New returns the version control system with the given name. "auto" (or an
+// empty name) detects it from the repository containing dir. gitBackend
+// selects the git implementation, see git.NewBackend.
+func New(name, gitBackend, dir string) (VCS, error) {
+	if name == "" || name == Auto {
+		name = Detect(dir)
+	}
+
+	switch name {
+	case Git:
+		backend, err := git.NewBackend(gitBackend)
+		if err != nil {
+			return nil, err
+		}
+		return gitVCS{backend}, nil
+	case Jujutsu:
+		return jujutsu{}, nil
+	case Mercurial:
+		return mercurial{}, nil
+	default:
+		return nil, fmt.Errorf("unknown vcs: %s (use auto, git, jj, or hg)", name)
+	}
+}
+
+// Detect returns the version control system of the nearest repository
+// containing dir, defaulting to git. Colocated jj repositories also contain
+// a .git directory, so .jj takes precedence.
+func Detect(dir string) string {
+	markers := []struct {
+		dir  string
+		name string
+	}{
+		{".jj", Jujutsu},
+		{".hg", Mercurial},
+		{".git", Git},
+	}
+
+	for {
+		for _, m := range markers {
+			if _, err := os.Stat(filepath.Join(dir, m.dir)); err == nil {
+				return m.name
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return Git
+		}
+		dir = parent
+	}
+}
+
+// RelativeToDir converts paths relative to the repository root (as returned
+// by ChangedFiles) into paths relative to dir, which may be a subdirectory of
+// the repository. Paths outside dir are returned as absolute paths.
+func RelativeToDir(files []string, repoRoot string, dir string) ([]string, error) {
+	realRoot, err := filepath.EvalSymlinks(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := filepath.Rel(realRoot, realDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(files))
+	for i, file := range files {
+		file = filepath.FromSlash(file)
+		switch {
+		case prefix == ".":
+			result[i] = file
+		case strings.HasPrefix(file, prefix+string(filepath.Separator)):
+			result[i] = strings.TrimPrefix(file, prefix+string(filepath.Separator))
+		default:
+			result[i] = filepath.Join(repoRoot, file)
+		}
+	}
+	return result, nil
+}
+
+// gitVCS adapts a git backend to the VCS interface
+type gitVCS struct {
+	backend git.Backend
+}
+
+func (gitVCS) Name() string { return Git }
+
+func (g gitVCS) ChangedFiles(compareRef string, useMergeBase bool, dir string) ([]string, error) {
+	return g.backend.GetChangedFiles(compareRef, useMergeBase, dir)
+}
+
+func (g gitVCS) Root(dir string) (string, error) {
+	return g.backend.GetRepoRoot(dir)
+}
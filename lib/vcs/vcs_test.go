@@ -0,0 +1,58 @@
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "backend", "api")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := Detect(sub); got != Git {
+		t.Errorf("expected git fallback without any repository, got %s", got)
+	}
+
+	os.Mkdir(filepath.Join(root, ".hg"), 0755)
+	if got := Detect(sub); got != Mercurial {
+		t.Errorf("expected hg, got %s", got)
+	}
+
+	// Colocated jj repositories contain both .jj and .git
+	os.Mkdir(filepath.Join(root, "backend", ".git"), 0755)
+	os.Mkdir(filepath.Join(root, "backend", ".jj"), 0755)
+	if got := Detect(sub); got != Jujutsu {
+		t.Errorf("expected jj, got %s", got)
+	}
+}
+
+func TestRelativeToDir(t *testing.T) {
+	root := t.TempDir()
+	workspace := filepath.Join(root, "backend")
+	if err := os.Mkdir(workspace, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := RelativeToDir([]string{"backend/api/api.go", "README.md"}, root, workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0] != filepath.Join("api", "api.go") {
+		t.Errorf("expected api/api.go, got %s", files[0])
+	}
+	if files[1] != filepath.Join(root, "README.md") {
+		t.Errorf("expected absolute path for file outside workspace, got %s", files[1])
+	}
+
+	files, err = RelativeToDir([]string{"api/api.go"}, workspace, workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0] != filepath.Join("api", "api.go") {
+		t.Errorf("expected unchanged path at repository root, got %s", files[0])
+	}
+}
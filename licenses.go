@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/license"
+	"github.com/urfave/cli/v2"
+)
+
+// licensedModule is a third-party module as printed by 'knit licenses'
+type licensedModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	File    string `json:"file,omitempty"`
+}
+
+// licenseGroup lists the modules distributed under one license
+type licenseGroup struct {
+	License string           `json:"license"`
+	Unknown bool             `json:"unknown,omitempty"`
+	Modules []licensedModule `json:"modules"`
+}
+
+// createLicensesCommand creates the 'licenses' command reporting the licenses
+// of third-party dependencies
+func createLicensesCommand() *cli.Command {
+	var (
+		path          string
+		asJSON        bool
+		failOnUnknown bool
+	)
+
+	return &cli.Command{
+		Name:  "licenses",
+		Usage: "Report the licenses of the third-party modules used by the workspace",
+		Description: `Resolve the license of every module outside the workspace providing a
+package imported by a workspace module, from its LICENSE or COPYING file, and
+print the modules grouped by license. Test-only dependencies are left out.
+
+Examples:
+  knit licenses                    # Grouped table
+  knit licenses --json             # JSON array of groups
+  knit licenses --fail-on-unknown  # Fail when a license cannot be recognized`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "json",
+				Usage:       "Output a JSON array of license groups",
+				Destination: &asJSON,
+			},
+			&cli.BoolFlag{
+				Name:        "fail-on-unknown",
+				Usage:       "Exit with code 1 when a license is not recognized",
+				Destination: &failOnUnknown,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			deps, err := analyzer.ListDependencies(absPath, modules)
+			if err != nil {
+				return fmt.Errorf("failed to list dependencies: %w", err)
+			}
+
+			groups, err := groupByLicense(deps)
+			if err != nil {
+				return err
+			}
+			if err := printLicenses(groups, asJSON); err != nil {
+				return err
+			}
+
+			for _, g := range groups {
+				if g.Unknown && failOnUnknown {
+					return cli.Exit(fmt.Sprintf("%d module(s) with an unknown license", len(g.Modules)), 1)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// groupByLicense detects the license of each module and groups modules by
+// license, most used first, the unknown group last
+func groupByLicense(deps []analyzer.Module) ([]licenseGroup, error) {
+	byLicense := make(map[string][]licensedModule)
+	for _, d := range deps {
+		l := license.License{ID: license.Unknown}
+		if d.Dir != "" {
+			var err error
+			if l, err = license.Detect(d.Dir); err != nil {
+				return nil, fmt.Errorf("%s: %w", d.Path, err)
+			}
+		}
+		byLicense[l.ID] = append(byLicense[l.ID], licensedModule{Path: d.Path, Version: d.Version, File: l.File})
+	}
+
+	groups := make([]licenseGroup, 0, len(byLicense))
+	for id, modules := range byLicense {
+		groups = append(groups, licenseGroup{License: id, Unknown: id == license.Unknown, Modules: modules})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Unknown != groups[j].Unknown {
+			return !groups[i].Unknown
+		}
+		if len(groups[i].Modules) != len(groups[j].Modules) {
+			return len(groups[i].Modules) > len(groups[j].Modules)
+		}
+		return groups[i].License < groups[j].License
+	})
+	return groups, nil
+}
+
+func printLicenses(groups []licenseGroup, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(groups)
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No third-party modules found")
+		return nil
+	}
+	for _, g := range groups {
+		marker := ""
+		if g.Unknown {
+			marker = "✗ "
+		}
+		fmt.Printf("%s%s (%d)\n", marker, g.License, len(g.Modules))
+		for _, m := range g.Modules {
+			fmt.Printf("  %s %s\n", m.Path, m.Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/urfave/cli/v2"
+)
+
+// listedModule is a module as printed by 'knit list'
+type listedModule struct {
+	Path      string `json:"path"`
+	Dir       string `json:"dir"`
+	RelDir    string `json:"relDir"`
+	GoVersion string `json:"goVersion"`
+	HasMain   bool   `json:"hasMain"`
+	HasTests  bool   `json:"hasTests"`
+	// Kind is kindBinary for modules with a main package, kindLibrary otherwise
+	Kind string `json:"kind"`
+	// Mains lists the import paths of the main packages, sorted
+	Mains []string `json:"mains,omitempty"`
+}
+
+// Module kinds reported by 'knit list'
+const (
+	kindBinary  = "binary"
+	kindLibrary = "library"
+)
+
+// createListCommand creates the 'list' command enumerating workspace modules
+func createListCommand() *cli.Command {
+	var (
+		path     string
+		asJSON   bool
+		filters  cli.StringSlice
+		hasTests bool
+		mains    bool
+	)
+
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List workspace modules with their directory, Go version and packages",
+		Description: `Enumerate the modules of the workspace, in workspace order.
+
+Examples:
+  knit list                                # Table of every module
+  knit list --json                         # JSON array, for scripts
+  knit list --filter 'example.com/svc/...' # Only modules matching a glob
+  knit list --has-tests                    # Only modules with tests
+  knit list --mains --json                 # Deployable modules and their main packages`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "json",
+				Usage:       "Output a JSON array",
+				Destination: &asJSON,
+			},
+			&cli.StringSliceFlag{
+				Name:        "filter",
+				Usage:       "Only list modules whose path or directory matches a glob, '/...' matches a whole prefix (repeatable)",
+				Destination: &filters,
+			},
+			&cli.BoolFlag{
+				Name:        "has-tests",
+				Usage:       "Only list modules containing _test.go files",
+				Destination: &hasTests,
+			},
+			&cli.BoolFlag{
+				Name:        "mains",
+				Usage:       "Only list modules containing a main package, i.e. producing binaries",
+				Destination: &mains,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			if patterns := filters.Value(); len(patterns) > 0 {
+				modules = filterModules(modules, patterns, absPath)
+			}
+
+			listed, err := describeModules(absPath, modules)
+			if err != nil {
+				return err
+			}
+			if hasTests || mains {
+				kept := make([]listedModule, 0, len(listed))
+				for _, m := range listed {
+					if (!hasTests || m.HasTests) && (!mains || m.HasMain) {
+						kept = append(kept, m)
+					}
+				}
+				listed = kept
+			}
+
+			if asJSON {
+				data, err := json.MarshalIndent(listed, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to marshal JSON: %w", err)
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "PATH\tDIR\tGO\tMAIN\tTESTS")
+			for _, m := range listed {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Path, m.RelDir, m.GoVersion, yesNo(m.HasMain), yesNo(m.HasTests))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// describeModules inspects the packages of each module to tell whether it
+// has main packages and tests
+func describeModules(absPath string, modules []analyzer.Module) ([]listedModule, error) {
+	listed := make([]listedModule, 0, len(modules))
+	if len(modules) == 0 {
+		return listed, nil
+	}
+
+	packages, err := analyzer.ListPackages(absPath, modules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packages: %w", err)
+	}
+	mains := make(map[string][]string)
+	hasTests := make(map[string]bool)
+	for _, p := range packages {
+		if p.Module == nil {
+			continue
+		}
+		if p.Name == "main" {
+			mains[p.Module.Path] = append(mains[p.Module.Path], p.ImportPath)
+		}
+		if len(p.TestGoFiles) > 0 || len(p.XTestGoFiles) > 0 {
+			hasTests[p.Module.Path] = true
+		}
+	}
+
+	for _, m := range modules {
+		relDir, err := filepath.Rel(absPath, m.Dir)
+		if err != nil {
+			relDir = m.Dir
+		}
+		kind := kindLibrary
+		if len(mains[m.Path]) > 0 {
+			kind = kindBinary
+		}
+		sort.Strings(mains[m.Path])
+		listed = append(listed, listedModule{
+			Path:      m.Path,
+			Dir:       m.Dir,
+			RelDir:    relDir,
+			GoVersion: m.GoVersion,
+			HasMain:   kind == kindBinary,
+			HasTests:  hasTests[m.Path],
+			Kind:      kind,
+			Mains:     mains[m.Path],
+		})
+	}
+	return listed, nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
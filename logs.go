@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/urfave/cli/v2"
+)
+
+// logsDir is the directory of .knit holding the output of the last run of
+// every task, a log file per module in a directory per task
+const logsDir = "logs"
+
+// runLogs writes the output of the modules of a run of a task, replacing the
+// logs of its previous run
+type runLogs struct {
+	dir   string
+	files []*os.File
+}
+
+// taskLogsDir returns the directory of the logs of task
+func taskLogsDir(workspaceRoot, task string) string {
+	return filepath.Join(workspaceRoot, history.Dir, logsDir, url.PathEscape(task))
+}
+
+// newRunLogs removes the logs of the previous run of task and returns the
+// logs of the new one
+func newRunLogs(workspaceRoot, task string) (*runLogs, error) {
+	dir := taskLogsDir(workspaceRoot, task)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to remove the logs of the last %s run: %w", task, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return &runLogs{dir: dir}, nil
+}
+
+// create returns the log of module, nil when l is nil or the file cannot
+// be created, in which case the module is not logged
+func (l *runLogs) create(module string) io.Writer {
+	if l == nil {
+		return nil
+	}
+	f, err := os.Create(filepath.Join(l.dir, url.PathEscape(module)+".log"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v, the output of %s is not logged\n", err, module)
+		return nil
+	}
+	l.files = append(l.files, f)
+	return f
+}
+
+// close closes the logs of the run, once written
+func (l *runLogs) close() {
+	if l == nil {
+		return
+	}
+	for _, f := range l.files {
+		f.Close()
+	}
+	l.files = nil
+}
+
+// createLogsCommand creates the 'logs' command printing again the output of
+// the modules of the last run of a task
+func createLogsCommand() *cli.Command {
+	var (
+		path   string
+		task   string
+		failed bool
+	)
+
+	return &cli.Command{
+		Name:      "logs",
+		Usage:     "Print the output of the modules of the last run again",
+		ArgsUsage: "[module]",
+		Description: `Replay the output of the last run of a task, kept in .knit/logs per module,
+as it was printed, followed by the status of the module. The task is the
+last one run unless --task is set: test, fmt, a task of knit.yaml... A module
+argument, a path or directory as -t takes, only prints its output, and
+--failed only prints the modules that failed.
+
+Examples:
+  knit logs                        # Output of every module of the last run
+  knit logs --failed               # Only the modules that failed
+  knit logs example.com/api        # Only one module
+  knit logs --task lint --failed   # The last run of another task`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "task",
+				Usage:       "Task whose last run is printed (default: the last task run)",
+				Destination: &task,
+			},
+			&cli.BoolFlag{
+				Name:        "failed",
+				Usage:       "Only print the modules that failed",
+				Destination: &failed,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() > 1 {
+				return fmt.Errorf("expected at most one module, got %d", c.NArg())
+			}
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			h, err := history.Load(absPath)
+			if err != nil {
+				return err
+			}
+			if task == "" {
+				if task = h.LastTask; task == "" {
+					return fmt.Errorf("no previous run found in %s", absPath)
+				}
+			}
+			dir := taskLogsDir(absPath, task)
+			if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("no logs of a previous %s run", task)
+			}
+
+			if target := c.Args().First(); target != "" {
+				if modules, err = resolveTargets(modules, []string{target}, absPath); err != nil {
+					return err
+				}
+			}
+			if failed {
+				failedPaths := make(map[string]bool)
+				for _, p := range h.Failed[task] {
+					failedPaths[p] = true
+				}
+				var failedModules []analyzer.Module
+				for _, m := range modules {
+					if failedPaths[m.Path] {
+						failedModules = append(failedModules, m)
+					}
+				}
+				modules = failedModules
+			}
+
+			printed := 0
+			for _, m := range modules {
+				ok, err := printModuleLog(filepath.Join(dir, url.PathEscape(m.Path)+".log"), m.Path)
+				if err != nil {
+					return err
+				}
+				if ok {
+					printed++
+				}
+			}
+			if printed == 0 {
+				if failed {
+					fmt.Printf("No failed modules in the last %s run\n", task)
+				} else {
+					fmt.Printf("No logs of these modules in the last %s run\n", task)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// printModuleLog prints the log file of module with its prefix, reporting
+// whether the module has one
+func printModuleLog(file, module string) (bool, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read the logs of %s: %w", module, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		fmt.Printf("[%s] %s\n", module, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read the logs of %s: %w", module, err)
+	}
+	return true, nil
+}
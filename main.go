@@ -2,20 +2,27 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
-	"strings"
+	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	analyzer "github.com/nicolasgere/knit/lib/analyser"
 
-	"github.com/nicolasgere/knit/lib/git"
+	"github.com/nicolasgere/knit/lib/artifacts"
+	"github.com/nicolasgere/knit/lib/cache"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/daemon"
+	"github.com/nicolasgere/knit/lib/history"
 	"github.com/nicolasgere/knit/lib/runner"
+	"github.com/nicolasgere/knit/lib/tracing"
 	"github.com/nicolasgere/knit/lib/utils"
 	"github.com/urfave/cli/v2"
 )
@@ -47,398 +54,300 @@ func setupSignalHandling(cancel context.CancelFunc) {
 
 func createCliApp(r *runner.Runner) *cli.App {
 	return &cli.App{
+		// Unknown commands run the knit-<name> plugin found in PATH
+		Action: runPlugin,
 		Commands: []*cli.Command{
-			createCommand("fmt", "Format every modules", "go fmt ./...", r),
-			createCommand("test", "Test every modules", "go test ./...", r),
+			createFmtCommand(r),
+			createTestCommand(r),
+			createRunCommand(r),
+			createBuildCommand(r),
+			createCoverageCommand(),
+			createFlakyCommand(),
+			createBenchCommand(),
 			createAffectedCommand(),
+			createCICommand(r),
 			createGraphCommand(),
+			createShardCommand(),
+			createRerunFailedCommand(),
+			createLogsCommand(),
+			createWhyCommand(),
+			createImpactedCommand(),
+			createQueryCommand(),
+			createListCommand(),
+			createOwnersCommand(),
+			createCheckArchCommand(),
+			createCheckAPICommand(),
+			createCheckReplaceCommand(),
+			createCheckMajorsCommand(),
+			createUnusedCommand(),
+			createReleaseCommand(),
+			createReportCommand(),
+			createLicensesCommand(),
+			createAlignCommand(),
+			createUpgradeCommand(r),
+			createSyncGoCommand(),
+			createWorkCommand(),
+			createVendorCommand(),
+			createDownloadCommand(r),
+			createCleanCommand(),
+			createInitCommand(),
+			createNewCommand(),
+			createDoctorCommand(),
+			createVersionCommand(),
+			createSelfUpdateCommand(),
+			createDaemonCommand(),
+			createServeCommand(),
 		},
 	}
 }
 
-// OutputFormat defines the format for the affected command output
-type OutputFormat string
+// fmtCheckCmd lists the files go fmt would reformat in a module, failing
+// when there is one: it runs the gofmt commands printed by go fmt -n without
+// their -w flag
+const fmtCheckCmd = `cmds=$(go fmt -n ./...) && files=$(echo "$cmds" | sed 's/ -l -w / -l /' | sh -e) && { test -z "$files" || { echo "$files"; exit 1; }; }`
 
-const (
-	FormatList         OutputFormat = "list"
-	FormatGoArgs       OutputFormat = "go-args"
-	FormatGitHubMatrix OutputFormat = "github-matrix"
-)
-
-// createAffectedCommand creates the 'affected' command
-func createAffectedCommand() *cli.Command {
-	var (
-		path         string
-		base         string
-		useMergeBase bool
-		format       string
-		includeDeps  bool
-	)
-
-	return &cli.Command{
-		Name:  "affected",
-		Usage: "List modules affected by changes since a git reference",
-		Description: `Detect which modules have changed compared to a git reference.
-
-Examples:
-  knit affected                        # Compare against 'main' branch
-  knit affected --base origin/main     # Compare against origin/main
-  knit affected --merge-base           # Use merge-base (recommended for CI)
-  knit affected -f go-args             # Output: -p module1 -p module2
-  knit affected -f github-matrix       # Output: JSON matrix for GitHub Actions
-  knit affected --include-deps         # Include dependencies of affected modules`,
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:        "path",
-				Usage:       "Path to the workspace root",
-				Aliases:     []string{"p"},
-				Value:       ".",
-				Destination: &path,
-			},
-			&cli.StringFlag{
-				Name:        "base",
-				Usage:       "Git reference to compare against (branch, tag, or commit)",
-				Aliases:     []string{"b"},
-				Value:       "main",
-				Destination: &base,
-			},
-			&cli.BoolFlag{
-				Name:        "merge-base",
-				Usage:       "Compare against merge-base (common ancestor) - recommended for CI/PRs",
-				Aliases:     []string{"m"},
-				Destination: &useMergeBase,
-			},
-			&cli.StringFlag{
-				Name:        "format",
-				Usage:       "Output format: list (default), go-args, github-matrix",
-				Aliases:     []string{"f"},
-				Value:       "list",
-				Destination: &format,
-			},
-			&cli.BoolFlag{
-				Name:        "include-deps",
-				Usage:       "Include dependencies of affected modules",
-				Aliases:     []string{"d"},
-				Destination: &includeDeps,
-			},
-		},
-		Action: func(c *cli.Context) error {
-			return runAffected(path, base, useMergeBase, OutputFormat(format), includeDeps)
-		},
+// fmtCheckCommand returns the command of 'knit fmt --check', the check of
+// the formatter of knit.yaml failing when it prints a file, or fmtCheckCmd
+func fmtCheckCommand(format config.FormatConfig) string {
+	if format.Check == "" {
+		return fmtCheckCmd
 	}
+	return fmt.Sprintf(`files=$(%s) && { test -z "$files" || { echo "$files"; exit 1; }; }`, format.Check)
 }
 
-func runAffected(path, base string, useMergeBase bool, format OutputFormat, includeDeps bool) error {
-	// Get absolute path to workspace
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
-	}
-
-	// List all modules in the workspace
-	modules, err := analyzer.ListModule(absPath)
-	if err != nil {
-		return fmt.Errorf("failed to list modules: %w", err)
-	}
-
-	if len(modules) == 0 {
-		return fmt.Errorf("no modules found in workspace")
-	}
-
-	// Get changed files
-	changedFiles, err := git.GetChangedFiles(base, useMergeBase, absPath)
-	if err != nil {
-		return fmt.Errorf("failed to get changed files: %w", err)
-	}
-
-	// Get module directories
-	moduleDirs := make([]string, len(modules))
-	moduleDirToPath := make(map[string]string)
-	for i, m := range modules {
-		moduleDirs[i] = m.Dir
-		moduleDirToPath[m.Dir] = m.Path
-	}
-
-	// Find affected module directories
-	affectedDirs := git.FindAffectedModuleDirs(changedFiles, moduleDirs, absPath)
-
-	// Convert to module paths
-	affectedPaths := make([]string, 0, len(affectedDirs))
-	for _, dir := range affectedDirs {
-		if path, ok := moduleDirToPath[dir]; ok {
-			affectedPaths = append(affectedPaths, path)
-		}
-	}
-
-	// Include dependencies if requested
-	if includeDeps && len(affectedPaths) > 0 {
-		graph, err := analyzer.BuildDependencyGraph(modules)
+// createFmtCommand creates the 'fmt' command running go fmt, or the
+// formatter of knit.yaml, in every module, or only listing the unformatted
+// files with --check
+func createFmtCommand(r *runner.Runner) *cli.Command {
+	command := newModulesCommand("fmt", "Format every modules", r, func(c *cli.Context, workspaceRoot string) (taskSpec, error) {
+		cfg, err := config.Load(workspaceRoot)
 		if err != nil {
-			return fmt.Errorf("failed to build dependency graph: %w", err)
+			return taskSpec{}, err
 		}
-
-		allAffected := make(map[string]bool)
-		for _, p := range affectedPaths {
-			allAffected[p] = true
+		switch {
+		case c.Bool("check"):
+			return taskSpec{name: "fmt", cmd: fmtCheckCommand(cfg.Format)}, nil
+		case cfg.Format.Run != "":
+			return taskSpec{name: "fmt", cmd: cfg.Format.Run}, nil
 		}
+		return taskSpec{name: "fmt", cmd: "go fmt ./..."}, nil
+	})
+	command.Flags = append(command.Flags,
+		&cli.BoolFlag{
+			Name:  "check",
+			Usage: "Only list the files the formatter would change, without modifying them, failing the modules having one",
+		},
+	)
+	return command
+}
 
-		// For each affected module, find its dependencies
-		for _, p := range affectedPaths {
-			deps, err := analyzer.GetDependencyPaths(graph, p)
-			if err != nil {
-				// Module might not have dependencies, continue
-				continue
-			}
-			for _, dep := range deps {
-				allAffected[dep] = true
-			}
+// createTestCommand creates the 'test' command running go test in every
+// module, with the race detector when --race or the race setting of
+// knit.yaml enables it, writing a coverage profile with --cover and
+// reporting the results of every test with --json, retrying the flaky ones
+// with --retry-flaky, running one split of the tests of the modules with
+// --split and collecting the profiles of the tests with --cpuprofile-dir and
+// the like
+func createTestCommand(r *runner.Runner) *cli.Command {
+	command := newModulesCommand("test", "Test every modules", r, func(c *cli.Context, workspaceRoot string) (taskSpec, error) {
+		flags := ""
+		events := c.Bool("json") || c.Int("retry-flaky") > 0
+		if events {
+			flags += " -json"
 		}
-
-		// Convert back to slice
-		affectedPaths = make([]string, 0, len(allAffected))
-		for p := range allAffected {
-			affectedPaths = append(affectedPaths, p)
+		if c.Bool("cover") {
+			flags += " -coverprofile=" + coverProfile
 		}
-	}
-
-	// Output in the requested format
-	return outputAffected(affectedPaths, format)
-}
-
-func outputAffected(modules []string, format OutputFormat) error {
-	switch format {
-	case FormatList:
-		for _, m := range modules {
-			fmt.Println(m)
+		spec := taskSpec{name: "test", cmd: "go test" + flags + " ./...", testEvents: events, retryFlaky: c.Int("retry-flaky"), cover: c.Bool("cover")}
+		cfg, err := config.Load(workspaceRoot)
+		if err != nil {
+			return spec, err
 		}
-
-	case FormatGoArgs:
-		// Output: -p module1 -p module2 ...
-		var args []string
-		for _, m := range modules {
-			args = append(args, "-p", m)
+		race := cfg.Race.Default
+		if c.IsSet("race") {
+			race = c.Bool("race")
 		}
-		fmt.Println(strings.Join(args, " "))
-
-	case FormatGitHubMatrix:
-		// Output: JSON for GitHub Actions matrix
-		type MatrixOutput struct {
-			Module []string `json:"module"`
+		raceFlag := func(m analyzer.Module) string {
+			if !race {
+				return ""
+			}
+			for _, pattern := range cfg.Race.Exclude {
+				if matchModule(pattern, m, workspaceRoot) {
+					return ""
+				}
+			}
+			return " -race"
 		}
-		matrix := MatrixOutput{Module: modules}
-		if len(modules) == 0 {
-			matrix.Module = []string{} // Ensure empty array, not null
+		moduleFlags := func(m analyzer.Module) string {
+			return raceFlag(m) + flags
 		}
-		data, err := json.Marshal(matrix)
+		// A test flaky under the race detector is retried with it, but
+		// without rewriting the coverage profile of the module
+		spec.retryFlags = raceFlag
+		profiles, err := newTestProfiles(workspaceRoot, map[string]string{
+			"cpu":   c.String("cpuprofile-dir"),
+			"mem":   c.String("memprofile-dir"),
+			"block": c.String("blockprofile-dir"),
+		})
 		if err != nil {
-			return fmt.Errorf("failed to marshal JSON: %w", err)
+			return spec, err
 		}
-		fmt.Println(string(data))
-
-	default:
-		return fmt.Errorf("unknown format: %s (use list, go-args, or github-matrix)", format)
-	}
-
-	return nil
-}
-
-// createGraphCommand creates the 'graph' command to visualize module dependencies
-func createGraphCommand() *cli.Command {
-	var (
-		path   string
-		format string
-	)
-
-	return &cli.Command{
-		Name:  "graph",
-		Usage: "Display the dependency graph of all modules in the workspace",
-		Description: `Show all modules and their dependencies within the monorepo.
-
-Examples:
-  knit graph                    # Show dependency graph
-  knit graph -f dot             # Output in DOT format (for Graphviz)
-  knit graph -f json            # Output in JSON format`,
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:        "path",
-				Usage:       "Path to the workspace root",
-				Aliases:     []string{"p"},
-				Value:       ".",
-				Destination: &path,
-			},
-			&cli.StringFlag{
-				Name:        "format",
-				Usage:       "Output format: tree (default), dot, json",
-				Aliases:     []string{"f"},
-				Value:       "tree",
-				Destination: &format,
-			},
+		if profiles != nil {
+			if c.Bool("cover") || c.IsSet("split") || c.IsSet("split-index") {
+				return spec, fmt.Errorf("the profile directories cannot be combined with --cover or --split")
+			}
+			spec.profiles = profiles
+			spec.moduleCmd = func(m analyzer.Module) (string, error) {
+				return profiles.command(m, moduleFlags(m))
+			}
+		} else if c.IsSet("split") || c.IsSet("split-index") {
+			if c.Bool("cover") {
+				return spec, fmt.Errorf("--cover cannot be combined with --split, the go test runs of a split would overwrite the profile of each other")
+			}
+			split, err := newTestSplit(workspaceRoot, c.Int("split"), c.Int("split-index"))
+			if err != nil {
+				return spec, err
+			}
+			spec.moduleCmd = func(m analyzer.Module) (string, error) {
+				return split.command(m, moduleFlags(m))
+			}
+		} else if race {
+			spec.moduleCmd = func(m analyzer.Module) (string, error) {
+				return "go test" + moduleFlags(m) + " ./...", nil
+			}
+		}
+		return spec, nil
+	})
+	command.Flags = append(command.Flags,
+		&cli.BoolFlag{
+			Name:  "race",
+			Usage: "Run the tests with the race detector, but in the modules excluded by race.exclude of knit.yaml (default: race.default of knit.yaml)",
 		},
-		Action: func(c *cli.Context) error {
-			return runGraph(path, format)
+		&cli.BoolFlag{
+			Name:  "cover",
+			Usage: "Write the coverage profile of every module to " + coverProfile + " in its directory, for 'knit coverage report'",
 		},
-	}
-}
-
-func runGraph(path, format string) error {
-	// Get absolute path to workspace
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
-	}
-
-	// List all modules in the workspace
-	modules, err := analyzer.ListModule(absPath)
-	if err != nil {
-		return fmt.Errorf("failed to list modules: %w", err)
-	}
-
-	if len(modules) == 0 {
-		return fmt.Errorf("no modules found in workspace")
-	}
-
-	// Build dependency graph
-	g, err := analyzer.BuildDependencyGraph(modules)
-	if err != nil {
-		return fmt.Errorf("failed to build dependency graph: %w", err)
-	}
-
-	// Get adjacency map
-	adjMap, err := (*g).AdjacencyMap()
-	if err != nil {
-		return fmt.Errorf("failed to get adjacency map: %w", err)
-	}
-
-	// Output in requested format
-	switch format {
-	case "tree":
-		return outputGraphTree(modules, adjMap)
-	case "dot":
-		return outputGraphDot(modules, adjMap)
-	case "json":
-		return outputGraphJSON(modules, adjMap)
-	default:
-		return fmt.Errorf("unknown format: %s (use tree, dot, or json)", format)
-	}
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Run go test -json and report the results of every test: counts, failed and slowest tests, in the step summary too",
+		},
+		&cli.IntFlag{
+			Name:  "retry-flaky",
+			Usage: "Retry the failed tests of a module up to `N` times when they are all known to be flaky by 'knit flaky', implies --json",
+		},
+		&cli.IntFlag{
+			Name:  "split",
+			Usage: "Split the tests of every module into `N` groups balanced by their recorded durations, running the one of --split-index",
+		},
+		&cli.IntFlag{
+			Name:  "split-index",
+			Usage: "Index of the group of tests to run with --split (0-based)",
+		},
+		&cli.StringFlag{
+			Name:  "cpuprofile-dir",
+			Usage: "Write the CPU profile of the tests of every package to `DIR`/<module>/<package>.cpu.pprof, _root being the root package, and print the top consumers",
+		},
+		&cli.StringFlag{
+			Name:  "memprofile-dir",
+			Usage: "Write the memory profile of the tests of every package to `DIR`/<module>/<package>.mem.pprof and print the top consumers",
+		},
+		&cli.StringFlag{
+			Name:  "blockprofile-dir",
+			Usage: "Write the blocking profile of the tests of every package to `DIR`/<module>/<package>.block.pprof and print the top consumers",
+		},
+	)
+	return command
 }
 
-func outputGraphTree[T any](modules []analyzer.Module, adjMap map[string]map[string]T) error {
-	fmt.Println("Module Dependency Graph")
-	fmt.Println("=======================")
-	fmt.Println()
-
-	for _, m := range modules {
-		deps := adjMap[m.Path]
-		if len(deps) == 0 {
-			fmt.Printf("📦 %s\n", m.Path)
-			fmt.Println("   (no workspace dependencies)")
-		} else {
-			fmt.Printf("📦 %s\n", m.Path)
-			depList := make([]string, 0, len(deps))
-			for dep := range deps {
-				depList = append(depList, dep)
-			}
-			for i, dep := range depList {
-				if i == len(depList)-1 {
-					fmt.Printf("   └── %s\n", dep)
-				} else {
-					fmt.Printf("   ├── %s\n", dep)
-				}
-			}
+// createRunCommand creates the 'run' command running a task of knit.yaml in
+// every module
+func createRunCommand(r *runner.Runner) *cli.Command {
+	command := newModulesCommand("run", "Run a task of knit.yaml in every module", r, func(c *cli.Context, workspaceRoot string) (taskSpec, error) {
+		if c.NArg() != 1 {
+			return taskSpec{}, fmt.Errorf("expected a task name: knit run [flags] <task>")
 		}
-		fmt.Println()
-	}
-
-	return nil
-}
-
-func outputGraphDot[T any](modules []analyzer.Module, adjMap map[string]map[string]T) error {
-	fmt.Println("digraph dependencies {")
-	fmt.Println("  rankdir=TB;")
-	fmt.Println("  node [shape=box, style=rounded];")
-	fmt.Println()
-
-	// Add all nodes
-	for _, m := range modules {
-		// Use short name for display
-		shortName := m.Path
-		if idx := strings.LastIndex(m.Path, "/"); idx != -1 {
-			shortName = m.Path[idx+1:]
+		name := c.Args().First()
+		cfg, err := config.Load(workspaceRoot)
+		if err != nil {
+			return taskSpec{}, err
 		}
-		fmt.Printf("  \"%s\" [label=\"%s\"];\n", m.Path, shortName)
-	}
-	fmt.Println()
-
-	// Add edges
-	for _, m := range modules {
-		deps := adjMap[m.Path]
-		for dep := range deps {
-			fmt.Printf("  \"%s\" -> \"%s\";\n", m.Path, dep)
+		if cfg.Tasks[name].Run == "" {
+			return taskSpec{}, fmt.Errorf("no task %q with a run command in %s", name, config.FileName)
 		}
-	}
+		return taskSpec{name: name, cmd: cfg.Tasks[name].Run}, nil
+	})
+	command.ArgsUsage = "<task>"
+	command.Description = `Run the command of a task defined in knit.yaml in every selected module, with
+the same selection flags, hooks and history as 'knit test'. The command is a
+Go template with the variables:
+
+  {{.Module.Path}}     the module path
+  {{.Module.Dir}}      the absolute module directory
+  {{.Module.RelDir}}   the module directory relative to the workspace root
+  {{.ShortName}}       the last element of the module path
+  {{.WorkspaceRoot}}   the absolute workspace root
 
-	fmt.Println("}")
-	return nil
+Examples:
+  knit run build
+  knit run --affected docker   # docker build -t registry/{{.ShortName}} {{.Module.Dir}}`
+	return command
 }
 
-func outputGraphJSON[T any](modules []analyzer.Module, adjMap map[string]map[string]T) error {
-	type ModuleNode struct {
-		Path         string   `json:"path"`
-		Dir          string   `json:"dir"`
-		Dependencies []string `json:"dependencies"`
-	}
-
-	type GraphOutput struct {
-		Modules []ModuleNode `json:"modules"`
-	}
-
-	output := GraphOutput{
-		Modules: make([]ModuleNode, 0, len(modules)),
-	}
-
-	for _, m := range modules {
-		deps := adjMap[m.Path]
-		depList := make([]string, 0, len(deps))
-		for dep := range deps {
-			depList = append(depList, dep)
-		}
-
-		output.Modules = append(output.Modules, ModuleNode{
-			Path:         m.Path,
-			Dir:          m.Dir,
-			Dependencies: depList,
-		})
-	}
-
-	data, err := json.MarshalIndent(output, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
-	}
-	fmt.Println(string(data))
-	return nil
+// taskSpec is the task run by a modules command
+type taskSpec struct {
+	name, cmd string
+	// moduleCmd, when set, returns the command run in a module instead of cmd
+	moduleCmd func(m analyzer.Module) (string, error)
+	// testEvents is set when the command prints the events of go test -json
+	testEvents bool
+	// retryFlaky is the number of times known-flaky failed tests are retried
+	retryFlaky int
+	// retryFlags, when set, returns the go test flags the failed tests of a
+	// module are retried with, such as -race when the module ran with it
+	retryFlags func(m analyzer.Module) string
+	// profiles, when set, collects the profiles written by the tests
+	profiles *testProfiles
+	// cover is set when the command writes the coverage profile of the modules
+	cover bool
 }
 
-func createCommand(name, usage, cmd string, r *runner.Runner) *cli.Command {
-	var target string
+// newModulesCommand creates a command running a task in the modules selected
+// by its flags. task resolves the name and command of the task once the
+// workspace root is known.
+func newModulesCommand(usageName, usage string, r *runner.Runner, task func(c *cli.Context, workspaceRoot string) (taskSpec, error)) *cli.Command {
+	var targets cli.StringSlice
 	var useColor bool
 	var affected bool
+	var failed bool
 	var base string
+	var queryText string
+	var exclude cli.StringSlice
+	var owners cli.StringSlice
+	var envFiles cli.StringSlice
+	var env, forwardEnv, scrubEnv cli.StringSlice
+	var artifactsDir string
+	var force, noCache, cacheReadOnly bool
+	var reportFile, keepOutput string
+	var schema bool
+	var timestamps string
+	var prefixWidth int
+	var changes changeFlags
 
 	return &cli.Command{
-		Name:  name,
+		Name:  usageName,
 		Usage: usage,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:        "Path",
 				Usage:       "Path to the root directory of the project",
 				Aliases:     []string{"p"},
 				Destination: &defaultDir,
 			},
-			&cli.StringFlag{
+			&cli.StringSliceFlag{
 				Name:        "target",
-				Usage:       "Targeted module",
+				Usage:       "Targeted module path or directory, globs allowed, '/...' matches a whole prefix (repeatable)",
 				Aliases:     []string{"t"},
-				Destination: &target,
+				Destination: &targets,
 			},
 			&cli.BoolFlag{
 				Name:        "affected",
@@ -447,6 +356,14 @@ func createCommand(name, usage, cmd string, r *runner.Runner) *cli.Command {
 				Destination: &affected,
 				Value:       false,
 			},
+			&cli.BoolFlag{
+				Name:        "failed",
+				Usage:       "Run only on modules that failed during the last run of this command",
+				Destination: &failed,
+			},
+			queryFlag(&queryText),
+			excludeFlag(&exclude),
+			ownerFlag(&owners),
 			&cli.StringFlag{
 				Name:        "base",
 				Usage:       "Git reference to compare against when using --affected (default: main)",
@@ -461,101 +378,602 @@ func createCommand(name, usage, cmd string, r *runner.Runner) *cli.Command {
 				Destination: &useColor,
 				Value:       false,
 			},
-		},
-		Action: func(*cli.Context) error {
+			timestampsFlag(&timestamps),
+			prefixWidthFlag(&prefixWidth),
+			&cli.StringSliceFlag{
+				Name:        "env-file",
+				Usage:       "Load the variables of a .env file into the environment of the tasks (repeatable, later files win)",
+				Destination: &envFiles,
+			},
+			&cli.StringSliceFlag{
+				Name:        "env",
+				Usage:       "Set a variable in the environment of the tasks and hooks, as KEY=value (repeatable)",
+				Aliases:     []string{"e"},
+				Destination: &env,
+			},
+			&cli.StringSliceFlag{
+				Name:        "forward-env",
+				Usage:       "Only pass the variables of the environment whose name matches a glob to the tasks, besides PATH, HOME and the like (repeatable, adds to forwardEnv of knit.yaml)",
+				Destination: &forwardEnv,
+			},
+			&cli.StringSliceFlag{
+				Name:        "scrub-env",
+				Usage:       "Never pass the variables of the environment whose name matches a glob to the tasks (repeatable, adds to scrubEnv of knit.yaml)",
+				Destination: &scrubEnv,
+			},
+			&cli.StringFlag{
+				Name:        "artifacts-dir",
+				Usage:       "Copy the outputs declared for the task in knit.yaml into `DIR`/<task>/<module dir> after the run",
+				Destination: &artifactsDir,
+			},
+			&cli.BoolFlag{
+				Name:        "force",
+				Usage:       "Run the modules with a cached result of the task too, refreshing it",
+				Destination: &force,
+			},
+			&cli.BoolFlag{
+				Name:        "no-cache",
+				Usage:       "Neither read nor write the task cache",
+				Destination: &noCache,
+			},
+			&cli.BoolFlag{
+				Name:        "cache-readonly",
+				Usage:       "Use cached results but never write the cache, e.g. for untrusted pull requests",
+				Destination: &cacheReadOnly,
+			},
+			&cli.StringFlag{
+				Name:        "report",
+				Usage:       "Write a JSON report of the run, the exit code and duration of every module, to `FILE`",
+				Destination: &reportFile,
+			},
+			&cli.StringFlag{
+				Name:        "keep-output",
+				Usage:       "Keep the report, the logs, the coverage profiles and the durations of the run in a new directory of `DIR`, for CI artifacts",
+				Destination: &keepOutput,
+			},
+			schemaFlag("--report", &schema),
+		}, changes.flags()...),
+		Action: func(c *cli.Context) error {
+			if schema {
+				return printSchema(schemaRunReport)
+			}
 			// Enable color output if requested
 			utils.SetColorEnabled(useColor)
+			if err := utils.SetTimestamps(timestamps); err != nil {
+				return err
+			}
+			if err := utils.SetPrefixWidth(prefixWidth); err != nil {
+				return err
+			}
 
-			// Get absolute path to workspace
-			absPath, err := filepath.Abs(defaultDir)
+			absPath, modules, err := loadModules(defaultDir)
 			if err != nil {
-				return fmt.Errorf("failed to get absolute path: %w", err)
+				return err
 			}
-
-			modules, err := analyzer.ListModule(absPath)
+			spec, err := task(c, absPath)
 			if err != nil {
 				return err
 			}
+			name, cmd := spec.name, spec.cmd
 			modulesToRun := modules
+			var generate map[string][]analyzer.Module
 
 			// Filter by affected modules if requested
-			if affected {
-				changedFiles, err := git.GetChangedFiles(base, true, absPath)
+			if affected || changes.filesFrom != "" {
+				src, err := changes.source(base, true)
+				if err != nil {
+					return err
+				}
+				detected, err := detectChanges(modules, absPath, src)
 				if err != nil {
-					return fmt.Errorf("failed to get changed files: %w", err)
+					return err
 				}
+				modulesToRun, generate = detected.affected, detected.generate
+				affectedCount := len(modulesToRun)
+				reportToDaemon(absPath, daemon.Report{Affected: &affectedCount})
 
-				// Get module directories
-				moduleDirs := make([]string, len(modules))
-				moduleDirToPath := make(map[string]string)
-				for i, m := range modules {
-					moduleDirs[i] = m.Dir
-					moduleDirToPath[m.Dir] = m.Path
+				if len(modulesToRun) == 0 {
+					fmt.Println("No affected modules found")
+					writeStepSummary(name, nil, nil, nil, nil, nil, true)
+					return nil
 				}
+			}
 
-				// Find affected module directories
-				affectedDirs := git.FindAffectedModuleDirs(changedFiles, moduleDirs, absPath)
+			// Filter by query if requested
+			if queryText != "" {
+				modulesToRun, err = filterByQuery(absPath, modules, modulesToRun, queryText, &changes)
+				if err != nil {
+					return err
+				}
+			}
 
-				// Convert to module list
-				affectedModules := make([]analyzer.Module, 0)
-				affectedPaths := make(map[string]bool)
-				for _, dir := range affectedDirs {
-					if path, ok := moduleDirToPath[dir]; ok {
-						affectedPaths[path] = true
-					}
+			// Filter by the failures of the last run if requested
+			if failed {
+				h, err := history.Load(absPath)
+				if err != nil {
+					return err
+				}
+				failedPaths := make(map[string]bool)
+				for _, p := range h.Failed[name] {
+					failedPaths[p] = true
 				}
 
-				for _, m := range modules {
-					if affectedPaths[m.Path] {
-						affectedModules = append(affectedModules, m)
+				failedModules := make([]analyzer.Module, 0)
+				for _, m := range modulesToRun {
+					if failedPaths[m.Path] {
+						failedModules = append(failedModules, m)
 					}
 				}
-				modulesToRun = affectedModules
+				modulesToRun = failedModules
 
 				if len(modulesToRun) == 0 {
-					fmt.Println("No affected modules found")
+					fmt.Printf("No failed modules in the last %s run\n", name)
 					return nil
 				}
 			}
 
-			// Filter by target if specified
-			if target != "" {
-				filteredModule := make([]analyzer.Module, 0)
-				for _, m := range modulesToRun {
-					if m.Path == target {
-						filteredModule = append(filteredModule, m)
-					}
+			// Filter by targets if specified, resolved against the whole workspace
+			if patterns := targets.Value(); len(patterns) > 0 {
+				targeted, err := resolveTargets(modules, patterns, absPath)
+				if err != nil {
+					return err
 				}
-				modulesToRun = filteredModule
+				modulesToRun = intersectModules(modulesToRun, targeted)
 			}
 
-			runOnModules(defaultDir, cmd, r, modulesToRun)
-			return nil
+			// Filter by CODEOWNERS owner if requested
+			if len(owners.Value()) > 0 {
+				byModule, err := moduleOwners(absPath, modules)
+				if err != nil {
+					return err
+				}
+				modulesToRun = filterByOwner(modulesToRun, byModule, owners.Value())
+			}
+
+			modulesToRun = excludeModules(modulesToRun, exclude.Value(), absPath)
+
+			opts := runOptions{
+				envFiles:      envFiles.Value(),
+				env:           env.Value(),
+				forwardEnv:    forwardEnv.Value(),
+				scrubEnv:      scrubEnv.Value(),
+				artifactsDir:  artifactsDir,
+				reportFile:    reportFile,
+				keepOutput:    keepOutput,
+				force:         force,
+				noCache:       noCache,
+				cacheReadOnly: cacheReadOnly,
+				affected:      affected || changes.filesFrom != "",
+				moduleCmd:     spec.moduleCmd,
+				testEvents:    spec.testEvents,
+				retryFlaky:    spec.retryFlaky,
+				retryFlags:    spec.retryFlags,
+				profiles:      spec.profiles,
+				cover:         spec.cover,
+			}
+			// The generate tasks of the generator inputs changed run first
+			generateOpts := opts
+			generateOpts.moduleCmd, generateOpts.testEvents, generateOpts.retryFlaky, generateOpts.retryFlags, generateOpts.profiles, generateOpts.reportFile, generateOpts.keepOutput = nil, false, 0, nil, nil, "", ""
+			for _, task := range sortedKeys(generate) {
+				targets := intersectModules(generate[task], modulesToRun)
+				if task == name || len(targets) == 0 {
+					continue
+				}
+				cfg, err := config.Load(absPath)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Running %s first on the modules consuming the changed generator inputs\n", task)
+				if err := runOnModules(absPath, task, cfg.Tasks[task].Run, r, targets, generateOpts); err != nil {
+					return err
+				}
+			}
+			return runOnModules(absPath, name, cmd, r, modulesToRun, opts)
+		},
+	}
+}
+
+// createRerunFailedCommand creates the 'rerun-failed' command, a shortcut for
+// running the last command again with --failed
+func createRerunFailedCommand() *cli.Command {
+	var path string
+	var useColor bool
+	var timestamps string
+	var prefixWidth int
+
+	return &cli.Command{
+		Name:  "rerun-failed",
+		Usage: "Re-run the last command on the modules that failed",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "color",
+				Usage:       "Enable colored output for better readability",
+				Aliases:     []string{"c"},
+				Destination: &useColor,
+			},
+			timestampsFlag(&timestamps),
+			prefixWidthFlag(&prefixWidth),
+		},
+		Action: func(c *cli.Context) error {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+			h, err := history.Load(absPath)
+			if err != nil {
+				return err
+			}
+			if h.LastTask == "" {
+				return fmt.Errorf("no previous run found in %s", absPath)
+			}
+
+			command, taskArgs := h.LastTask, []string(nil)
+			if c.App.Command(h.LastTask) == nil {
+				// Tasks of knit.yaml run with 'knit run <task>'
+				cfg, err := config.Load(absPath)
+				if err != nil {
+					return err
+				}
+				if cfg.Tasks[h.LastTask].Run == "" {
+					return fmt.Errorf("no previous run found in %s", absPath)
+				}
+				command, taskArgs = "run", []string{h.LastTask}
+			}
+
+			args := []string{c.App.Name, command, "--failed", "-p", absPath, "--timestamps", timestamps}
+			if prefixWidth != 0 {
+				args = append(args, "--prefix-width", strconv.Itoa(prefixWidth))
+			}
+			if useColor {
+				args = append(args, "--color")
+			}
+			return c.App.RunContext(c.Context, append(args, taskArgs...))
 		},
 	}
 }
 
-func runOnModules(dir, cmd string, r *runner.Runner, modules []analyzer.Module) {
+// runOptions holds the settings of runOnModules given on the command line
+type runOptions struct {
+	// envFiles are .env files added to the environment of the tasks
+	envFiles []string
+	// env holds KEY=value pairs set for the tasks, overriding any other
+	env []string
+	// forwardEnv and scrubEnv add to the forwardEnv and scrubEnv of knit.yaml
+	forwardEnv, scrubEnv []string
+	// artifactsDir, when set, receives the declared outputs of the task
+	artifactsDir string
+	// reportFile, when set, receives the JSON report of the run
+	reportFile string
+	// keepOutput, when set, receives a directory per run keeping its report,
+	// logs, coverage profiles and durations
+	keepOutput string
+	// force runs the modules with a cached result, refreshing it; noCache
+	// neither reads nor writes the cache and cacheReadOnly never writes it
+	force, noCache, cacheReadOnly bool
+	// affected is set when modules are the ones affected by a change,
+	// as told by the step summary
+	affected bool
+	// moduleCmd, when set, returns the command run in a module instead of cmd
+	moduleCmd func(m analyzer.Module) (string, error)
+	// testEvents parses the output of the tasks as go test -json events,
+	// printing the output of the failed tests only and reporting every test
+	testEvents bool
+	// retryFlaky, with testEvents, is the number of times the failed tests
+	// of a module are retried when they are all known to be flaky
+	retryFlaky int
+	// retryFlags, when set, returns the go test flags of the retries of the
+	// failed tests of a module
+	retryFlags func(m analyzer.Module) string
+	// profiles, when set, reports the top consumers of the profiles written
+	// by the tasks
+	profiles *testProfiles
+	// ordered starts the modules in the given order instead of
+	// longest-running first
+	ordered bool
+	// cover is set when the tasks write the coverage profile of the modules
+	cover bool
+}
+
+// runOnModules runs cmd in every module, longest-running first according to
+// the durations recorded for this task name unless opts.ordered is set, and
+// records the new durations.
+// The run is exported as a trace when OTEL_EXPORTER_OTLP_ENDPOINT is set.
+func runOnModules(workspaceRoot, name, cmd string, r *runner.Runner, modules []analyzer.Module, opts runOptions) (err error) {
+	tracer, err := tracing.FromEnv(currentBuildInfo().Version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v, the run is not traced\n", err)
+	}
+	root := tracer.Start(nil, "knit "+name, time.Now())
+	root.Set("knit.task", name)
+	defer func() {
+		if err != nil {
+			root.Fail(err.Error())
+		}
+		root.End(time.Now())
+		if err := tracer.Flush(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	}()
+
+	h, err := history.Load(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	if opts.cover {
+		start := time.Now()
+		h.CoverRun = &start
+	}
+	if !opts.ordered {
+		modules = sortByDuration(h, name, modules)
+	}
+
+	cfg, err := config.Load(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	if onlyIf := cfg.Tasks[name].OnlyIf; onlyIf != "" {
+		if modules, err = filterByCondition(workspaceRoot, cfg, modules, onlyIf); err != nil {
+			return fmt.Errorf("invalid onlyIf of task %s: %w", name, err)
+		}
+		if len(modules) == 0 {
+			fmt.Printf("No modules match the onlyIf condition of %s\n", name)
+			return nil
+		}
+	}
+	for _, pattern := range cfg.Tasks[name].Outputs {
+		if err := artifacts.Validate(pattern); err != nil {
+			return fmt.Errorf("invalid outputs of task %s: %w", name, err)
+		}
+	}
+	hooks := cfg.Tasks[name]
+	runBefore, runAfter := hooks.Hooks(config.ScopeRun)
+	moduleBefore, moduleAfter := hooks.Hooks(config.ScopeModule)
+
+	// The environments are computed first so that a broken .env file fails
+	// the run before any hook
+	if err := checkEnvAssignments(opts.env); err != nil {
+		return err
+	}
+	for _, patterns := range [][]string{opts.forwardEnv, opts.scrubEnv} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid environment glob %q: %w", p, err)
+			}
+		}
+	}
+	environ := forwardedEnvironment(os.Environ(), append(cfg.ForwardEnv, opts.forwardEnv...), append(cfg.ScrubEnv, opts.scrubEnv...))
+	dotenv, err := loadEnvFiles(opts.envFiles)
+	if err != nil {
+		return err
+	}
+	rootEnv, err := taskEnvironment(cfg, dotenv, environ, nil)
+	if err != nil {
+		return err
+	}
+	rootEnv = append(rootEnv, opts.env...)
+	toolchains, err := moduleToolchains(workspaceRoot, modules)
+	if err != nil {
+		return err
+	}
+	tasks := createTasks(modules, name, cmd)
+	for i := range tasks {
+		cmd := cmd
+		if opts.moduleCmd != nil {
+			if cmd, err = opts.moduleCmd(modules[i]); err != nil {
+				return err
+			}
+		}
+		hooked := hookedCommand(cmd, moduleBefore, moduleAfter)
+		if tasks[i].Env, err = taskEnvironment(cfg, dotenv, environ, &modules[i]); err != nil {
+			return err
+		}
+		tasks[i].Env = append(tasks[i].Env, opts.env...)
+		tasks[i].Environ = environ
+		// Heavy modules take several slots of the runner, or all of them
+		if m := cfg.Modules[modules[i].Path]; m.Exclusive {
+			tasks[i].Weight = r.Concurrency()
+		} else {
+			tasks[i].Weight = m.Weight
+		}
+		if setting := toolchains[modules[i].Path]; setting != "" {
+			// A GOTOOLCHAIN of the knit.yaml env, coming after, wins
+			tasks[i].Env = append([]string{setting}, tasks[i].Env...)
+		}
+		label, err := expandCommand(cmd, modules[i], workspaceRoot)
+		if err != nil {
+			return err
+		}
+		if tasks[i].Cmd, err = expandCommand(hooked, modules[i], workspaceRoot); err != nil {
+			return err
+		}
+		if hooked != cmd {
+			tasks[i].Label = label + " (with module hooks)"
+		}
+	}
+
+	// The output of the modules lines up, their statuses from the cache too
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.Id
+	}
+	utils.AlignPrefixes(ids)
+
+	var tc *taskCache
+	if cfg.Tasks[name].Cache && !opts.noCache {
+		if tc, err = newTaskCache(workspaceRoot, name, cfg.Tasks[name], tasks, modules); err != nil {
+			return err
+		}
+	}
+
+	if !runHooks(workspaceRoot, name, "before", r, runBefore, environ, rootEnv) {
+		runHooks(workspaceRoot, name, "after", r, runAfter, environ, rootEnv)
+		return cli.Exit(fmt.Sprintf("a before hook of %s failed, no module was run", name), 1)
+	}
 
-	tasks := createTasks(modules, cmd)
-	tfs := r.RunTasks(tasks)
+	// Modules with a cached result are reported as done without running
+	results := make([]runner.TaskResult, len(tasks))
+	ran := make([]bool, len(tasks))
+	var toRun []runner.Task
+	var runIndex []int
+	var entries []*cache.Entry
+	if tc != nil && !opts.force {
+		if entries, err = tc.restore(); err != nil {
+			return err
+		}
+	}
+	logs, err := newRunLogs(workspaceRoot, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v, the run is not logged\n", err)
+	}
+	defer logs.close()
+	root.Set("knit.modules", len(tasks))
+	for i := range tasks {
+		if entries != nil && entries[i] != nil {
+			span := tracer.Start(root, name+" "+tasks[i].Id, time.Now())
+			span.Set("knit.module", tasks[i].Id)
+			span.Set("knit.cache_hit", true)
+			span.End(time.Now())
+			status := fmt.Sprintf("✓ Cached (ran in %s on %s), --force to run again", entries[i].Elapsed.Round(time.Millisecond), entries[i].Created.Format(time.DateTime))
+			utils.LogStatus(tasks[i].Id, status, true)
+			if log := logs.create(tasks[i].Id); log != nil {
+				fmt.Fprintln(log, status)
+			}
+			continue
+		}
+		ran[i] = true
+		toRun = append(toRun, tasks[i])
+		runIndex = append(runIndex, i)
+	}
 
+	tfs := r.RunTasks(toRun)
 	var wg sync.WaitGroup
 	wg.Add(len(tfs))
 
-	for _, tf := range tfs {
-		go handleTaskFuture(tf, &wg)
+	coverage := make([]packageCoverage, len(tasks))
+	var tests *testRun
+	if opts.testEvents {
+		tests = newTestRun(len(tasks), h)
+	}
+	for j, tf := range tfs {
+		i := runIndex[j]
+		onStdout := func(line []byte) []string {
+			lines := []string{string(line)}
+			if tests != nil {
+				lines = tests.tests[i].Record(line)
+			}
+			for _, l := range lines {
+				coverage[i].record([]byte(l))
+			}
+			return lines
+		}
+		go handleLoggedTaskFuture(tf, &results[i], onStdout, logs.create(tasks[i].Id), &wg)
 	}
 
 	wg.Wait()
-	return
+	if tests != nil {
+		revision := recordTestOutcomes(workspaceRoot, h, tests.tests)
+		if opts.retryFlaky > 0 {
+			flags := make([]string, len(tasks))
+			if opts.retryFlags != nil {
+				for i := range modules {
+					flags[i] = opts.retryFlags(modules[i])
+				}
+			}
+			retryFlakyTests(r, tasks, flags, results, tests, revision, opts.retryFlaky)
+		}
+		tests.print()
+	}
+	if opts.profiles != nil {
+		opts.profiles.report(tasks, results)
+	}
+
+	failures := 0
+	report := daemon.Report{Task: name, CacheEnabled: tc != nil && !opts.force}
+	for i, result := range results {
+		report.Runs = append(report.Runs, daemon.Run{Module: tasks[i].Id, Duration: result.Duration, ExitCode: result.Status, Cached: !ran[i]})
+		if ran[i] {
+			h.RecordDuration(name, tasks[i].Id, result.Duration)
+			span := tracer.Start(root, name+" "+tasks[i].Id, result.Started)
+			span.Set("knit.module", tasks[i].Id)
+			span.Set("knit.cache_hit", false)
+			span.Set("knit.exit_code", result.Status)
+			if result.Status != 0 {
+				span.Fail(fmt.Sprintf("exit %d", result.Status))
+			}
+			span.End(result.Started.Add(result.Duration))
+		}
+		h.RecordResult(name, tasks[i].Id, result.Status != 0)
+		if result.Status != 0 {
+			failures++
+		}
+	}
+	if err := h.Save(workspaceRoot); err != nil {
+		return fmt.Errorf("failed to save history: %w", err)
+	}
+	reportToDaemon(workspaceRoot, report)
+	if opts.reportFile != "" {
+		if err := writeRunReport(opts.reportFile, report); err != nil {
+			return err
+		}
+	}
+	if tc != nil && !opts.cacheReadOnly {
+		tc.store(results, ran)
+	}
+	if opts.artifactsDir != "" {
+		err = collectArtifacts(workspaceRoot, name, cfg.Tasks[name].Outputs, modules, opts.artifactsDir)
+	}
+	if opts.keepOutput != "" && err == nil {
+		logs.close()
+		var kept string
+		if kept, err = keepRunOutput(opts.keepOutput, workspaceRoot, report, modules, opts.cover); err == nil {
+			fmt.Printf("Kept the output of the run in %s\n", kept)
+		}
+	}
+	afterOK := runHooks(workspaceRoot, name, "after", r, runAfter, environ, rootEnv)
+	writeStepSummary(name, tasks, results, entries, coverage, tests, opts.affected)
+
+	if err != nil {
+		return err
+	}
+	if failures > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d modules failed, rerun them with 'knit %s --failed'", failures, len(tasks), name), 1)
+	}
+	if !afterOK {
+		return cli.Exit(fmt.Sprintf("an after hook of %s failed", name), 1)
+	}
+	return nil
+}
+
+// sortByDuration orders modules longest-running first for the given task
+func sortByDuration(h *history.History, task string, modules []analyzer.Module) []analyzer.Module {
+	paths := make([]string, len(modules))
+	byPath := make(map[string]analyzer.Module, len(modules))
+	for i, m := range modules {
+		paths[i] = m.Path
+		byPath[m.Path] = m
+	}
+	h.SortByDuration(task, paths)
+
+	sorted := make([]analyzer.Module, len(paths))
+	for i, p := range paths {
+		sorted[i] = byPath[p]
+	}
+	return sorted
 }
 
-func createTasks(modules []analyzer.Module, cmd string) []runner.Task {
+func createTasks(modules []analyzer.Module, name, cmd string) []runner.Task {
 	tasks := make([]runner.Task, len(modules))
 	for i, module := range modules {
 		tasks[i] = runner.Task{
 			Id:   module.Path,
+			Name: name,
 			Cmd:  cmd,
 			Root: module.Dir,
 		}
@@ -563,15 +981,40 @@ func createTasks(modules []analyzer.Module, cmd string) []runner.Task {
 	return tasks
 }
 
-func handleTaskFuture(tf *runner.TaskFuture, wg *sync.WaitGroup) {
-	defer wg.Done()
+// handleTaskFuture logs the output and the result of a task, storing the
+// result in res. onStdout, when set, is called with every stdout line and
+// returns the lines to log in its place. wg, when not nil, is marked done
+// once the task ends.
+func handleTaskFuture(tf *runner.TaskFuture, res *runner.TaskResult, onStdout func([]byte) []string, wg *sync.WaitGroup) {
+	handleLoggedTaskFuture(tf, res, onStdout, nil, wg)
+}
+
+// handleLoggedTaskFuture is handleTaskFuture also writing the output lines
+// printed for the task and its status to log, when not nil
+func handleLoggedTaskFuture(tf *runner.TaskFuture, res *runner.TaskResult, onStdout func([]byte) []string, log io.Writer, wg *sync.WaitGroup) {
+	if wg != nil {
+		defer wg.Done()
+	}
+	output := func(line []byte, ok bool, channel *chan []byte) {
+		handleOutput(tf.Id, line, ok, channel)
+		if ok && log != nil {
+			fmt.Fprintf(log, "%s\n", line)
+		}
+	}
 	for {
 		select {
 		case stdout, ok := <-tf.Stdout:
-			handleOutput(tf.Id, stdout, ok, &tf.Stdout)
+			if ok && onStdout != nil {
+				for _, line := range onStdout(stdout) {
+					output([]byte(line), true, &tf.Stdout)
+				}
+				continue
+			}
+			output(stdout, ok, &tf.Stdout)
 		case stderr, ok := <-tf.Stderr:
-			handleOutput(tf.Id, stderr, ok, &tf.Stderr)
+			output(stderr, ok, &tf.Stderr)
 		case result := <-tf.Done:
+			*res = result
 			isSuccess := result.Status == 0
 			statusMsg := fmt.Sprintf("Done with status %d", result.Status)
 			if isSuccess {
@@ -580,6 +1023,9 @@ func handleTaskFuture(tf *runner.TaskFuture, wg *sync.WaitGroup) {
 				statusMsg = fmt.Sprintf("✗ Failed (exit %d)", result.Status)
 			}
 			utils.LogStatus(tf.Id, statusMsg, isSuccess)
+			if log != nil {
+				fmt.Fprintln(log, statusMsg)
+			}
 			return
 		}
 	}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/urfave/cli/v2"
+)
+
+// moduleTemplates holds the starter files of each 'knit new' template, keyed
+// by file name. Files are Go templates receiving a newModuleData.
+var moduleTemplates = map[string]map[string]string{
+	"service": {
+		"main.go": `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("{{.Name}}")
+}
+`,
+	},
+	"library": {
+		"{{.Package}}.go": `// Package {{.Package}} is the {{.Name}} library.
+package {{.Package}}
+`,
+		"{{.Package}}_test.go": `package {{.Package}}
+
+import "testing"
+
+func TestPackage(t *testing.T) {}
+`,
+	},
+}
+
+// newModuleData is the data given to module templates
+type newModuleData struct {
+	Path    string
+	Name    string
+	Package string
+}
+
+// createNewCommand creates the 'new' command scaffolding a module
+func createNewCommand() *cli.Command {
+	var (
+		path         string
+		templateName string
+		modulePath   string
+	)
+
+	return &cli.Command{
+		Name:      "new",
+		Usage:     "Create a workspace module from a template",
+		ArgsUsage: "<dir>",
+		Description: `Create a module in dir, relative to the workspace root, with a go.mod, the
+starter files of the template, a use directive in go.work and its template
+name as tag in knit.yaml. The module path is inferred from the paths of the
+existing modules, e.g. example.com/core in core/ makes services/billing
+example.com/services/billing; set it with --module otherwise.
+
+Templates:
+  service   A main package
+  library   A package with a test
+
+Examples:
+  knit new --template service services/billing
+  knit new --template library --module example.com/lib/money lib/money`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "template",
+				Usage:       "Module template: service or library",
+				Value:       "library",
+				Destination: &templateName,
+			},
+			&cli.StringFlag{
+				Name:        "module",
+				Usage:       "Module path, instead of inferring it from the workspace",
+				Destination: &modulePath,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected a module directory")
+			}
+			files, ok := moduleTemplates[templateName]
+			if !ok {
+				return fmt.Errorf("unknown template %q, expected service or library", templateName)
+			}
+
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			relDir := filepath.ToSlash(filepath.Clean(c.Args().First()))
+			if filepath.IsAbs(relDir) || relDir == "." || strings.HasPrefix(relDir, "../") {
+				return fmt.Errorf("module directory %s must be below the workspace root", c.Args().First())
+			}
+			dir := filepath.Join(absPath, relDir)
+			if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+				return fmt.Errorf("%s already holds a module", relDir)
+			}
+
+			if modulePath == "" {
+				prefix, ok := modulePathPrefix(absPath, modules)
+				if !ok {
+					return fmt.Errorf("cannot infer the module path from the workspace, set it with --module")
+				}
+				modulePath = prefix + "/" + relDir
+			}
+
+			goVersion, err := highestGoVersion(absPath, moduleRelDirs(absPath, modules))
+			if err != nil {
+				return err
+			}
+			if err := scaffoldModule(dir, modulePath, goVersion, files); err != nil {
+				return err
+			}
+
+			workFile, err := analyzer.WorkFile(absPath)
+			if err != nil {
+				return err
+			}
+			if workFile == "" {
+				workFile = filepath.Join(absPath, "go.work")
+			}
+			rel, err := filepath.Rel(filepath.Dir(workFile), dir)
+			if err != nil {
+				return fmt.Errorf("failed to locate %s from go.work: %w", relDir, err)
+			}
+			if err := analyzer.SyncWorkUses(workFile, goVersion, []string{"./" + filepath.ToSlash(rel)}, nil); err != nil {
+				return err
+			}
+			if err := config.AddModule(absPath, modulePath, config.ModuleConfig{Tags: []string{templateName}}); err != nil {
+				return err
+			}
+
+			fmt.Printf("Created %s in %s\n", modulePath, relDir)
+			return nil
+		},
+	}
+}
+
+// modulePathPrefix infers the module path of the workspace root from the
+// modules whose path ends with their directory, the most common prefix
+// winning
+func modulePathPrefix(absPath string, modules []analyzer.Module) (string, bool) {
+	counts := make(map[string]int)
+	for _, m := range modules {
+		rel, err := filepath.Rel(absPath, m.Dir)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			counts[m.Path]++
+		} else if prefix, ok := strings.CutSuffix(m.Path, "/"+rel); ok {
+			counts[prefix]++
+		}
+	}
+
+	best := ""
+	for _, prefix := range sortedKeys(counts) {
+		if best == "" || counts[prefix] > counts[best] {
+			best = prefix
+		}
+	}
+	return best, best != ""
+}
+
+// moduleRelDirs returns the directories of modules relative to the workspace root
+func moduleRelDirs(absPath string, modules []analyzer.Module) []string {
+	dirs := make([]string, 0, len(modules))
+	for _, m := range modules {
+		rel, err := filepath.Rel(absPath, m.Dir)
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, rel)
+	}
+	return dirs
+}
+
+// scaffoldModule writes the go.mod and the template files of a new module
+func scaffoldModule(dir, modulePath, goVersion string, files map[string]string) error {
+	name := path.Base(modulePath)
+	data := newModuleData{Path: modulePath, Name: name, Package: packageName(name)}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	goMod := fmt.Sprintf("module %s\n\ngo %s\n", modulePath, goVersion)
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644); err != nil {
+		return fmt.Errorf("failed to write go.mod: %w", err)
+	}
+	for nameTmpl, contentTmpl := range files {
+		fileName, err := executeTemplate(nameTmpl, data)
+		if err != nil {
+			return err
+		}
+		content, err := executeTemplate(contentTmpl, data)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, fileName), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", fileName, err)
+		}
+	}
+	return nil
+}
+
+// packageName turns the last element of a module path into a package name
+func packageName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9' && b.Len() > 0) {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "lib"
+	}
+	return b.String()
+}
+
+// executeTemplate renders one of the module template strings
+func executeTemplate(text string, data newModuleData) (string, error) {
+	tmpl, err := template.New("new").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse module template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to execute module template: %w", err)
+	}
+	return b.String(), nil
+}
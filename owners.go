@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/codeowners"
+	"github.com/nicolasgere/knit/lib/git"
+	"github.com/urfave/cli/v2"
+)
+
+// createOwnersCommand creates the 'owners' command mapping modules to their CODEOWNERS
+func createOwnersCommand() *cli.Command {
+	var (
+		path   string
+		asJSON bool
+		owners cli.StringSlice
+	)
+
+	return &cli.Command{
+		Name:  "owners",
+		Usage: "Show the owners of each module according to CODEOWNERS",
+		Description: `Map every module to the owners of its go.mod in the repository's
+CODEOWNERS file (.github/CODEOWNERS, CODEOWNERS or docs/CODEOWNERS).
+
+Examples:
+  knit owners                          # Table of modules and owners
+  knit owners --json                   # {"example.com/api": ["@org/backend"]}
+  knit owners --owner @org/payments    # Only the modules of a team`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "json",
+				Usage:       "Output a JSON object mapping module paths to owners",
+				Destination: &asJSON,
+			},
+			ownerFlag(&owners),
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			byModule, err := moduleOwners(absPath, modules)
+			if err != nil {
+				return err
+			}
+			if len(owners.Value()) > 0 {
+				modules = filterByOwner(modules, byModule, owners.Value())
+			}
+
+			if asJSON {
+				out := make(map[string][]string, len(modules))
+				for _, m := range modules {
+					out[m.Path] = append([]string{}, byModule[m.Path]...)
+				}
+				data, err := json.MarshalIndent(out, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to marshal JSON: %w", err)
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "MODULE\tOWNERS")
+			for _, m := range modules {
+				o := strings.Join(byModule[m.Path], " ")
+				if o == "" {
+					o = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\n", m.Path, o)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// ownerFlag returns the --owner flag shared by the commands selecting modules
+func ownerFlag(destination *cli.StringSlice) cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:        "owner",
+		Usage:       "Only keep modules owned by this CODEOWNERS owner, e.g. @org/team (repeatable)",
+		Destination: destination,
+	}
+}
+
+// moduleOwners maps each module to the owners of its go.mod. The CODEOWNERS
+// file is looked for at the repository root, then at the workspace root.
+func moduleOwners(absPath string, modules []analyzer.Module) (map[string][]string, error) {
+	root := absPath
+	if repoRoot, err := git.GetRepoRoot(absPath); err == nil {
+		root = repoRoot
+	}
+	rules, err := codeowners.Load(root)
+	if errors.Is(err, os.ErrNotExist) && root != absPath {
+		root = absPath
+		rules, err = codeowners.Load(root)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve symlinks so paths from git and from go list compare equal
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	owners := make(map[string][]string, len(modules))
+	for _, m := range modules {
+		goMod := m.GoMod
+		if resolved, err := filepath.EvalSymlinks(goMod); err == nil {
+			goMod = resolved
+		}
+		rel, err := filepath.Rel(root, goMod)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		owners[m.Path] = rules.Owners(filepath.ToSlash(rel))
+	}
+	return owners, nil
+}
+
+// filterByOwner keeps the modules owned by at least one of owners. Owners
+// compare case-insensitively, as GitHub handles and emails do.
+func filterByOwner(modules []analyzer.Module, byModule map[string][]string, owners []string) []analyzer.Module {
+	kept := make([]analyzer.Module, 0, len(modules))
+	for _, m := range modules {
+		if ownedByAny(byModule[m.Path], owners) {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+func ownedByAny(moduleOwners, owners []string) bool {
+	for _, mo := range moduleOwners {
+		for _, o := range owners {
+			if strings.EqualFold(mo, o) {
+				return true
+			}
+		}
+	}
+	return false
+}
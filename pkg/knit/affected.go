@@ -0,0 +1,69 @@
+package knit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nicolasgere/knit/lib/git"
+	"github.com/nicolasgere/knit/lib/vcs"
+)
+
+// Changes describes the change to compute the affected modules of
+type Changes struct {
+	// Base is the reference to compare the working copy against, e.g. main
+	Base string
+	// MergeBase compares against the common ancestor of Base and the working
+	// copy instead, as CI does for pull requests
+	MergeBase bool
+	// Files, when not nil, lists the changed files, relative to the workspace
+	// root or absolute, instead of asking the VCS
+	Files []string
+	// VCS is auto (or empty), git, jj or hg
+	VCS string
+	// GitBackend is auto (or empty), exec or go-git
+	GitBackend string
+}
+
+// ChangedFiles returns the files of the change, relative to the workspace
+// root. ctx is only checked before running the version control system, which
+// is not interrupted once started.
+func (w *Workspace) ChangedFiles(ctx context.Context, c Changes) ([]string, error) {
+	if c.Files != nil {
+		return c.Files, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo, err := vcs.New(c.VCS, c.GitBackend, w.Root)
+	if err != nil {
+		return nil, err
+	}
+	files, err := repo.ChangedFiles(c.Base, c.MergeBase, w.Root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files: %w", err)
+	}
+	repoRoot, err := repo.Root(w.Root)
+	if err != nil {
+		return nil, err
+	}
+	return vcs.RelativeToDir(files, repoRoot, w.Root)
+}
+
+// Affected returns the modules containing a file of the change, in
+// workspace order
+func (w *Workspace) Affected(ctx context.Context, c Changes) ([]Module, error) {
+	files, err := w.ChangedFiles(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, len(w.Modules))
+	for i, m := range w.Modules {
+		dirs[i] = m.Dir
+	}
+	affected := make(map[string]bool)
+	for _, dir := range git.FindAffectedModuleDirs(files, dirs, w.Root) {
+		affected[dir] = true
+	}
+	return w.filter(func(m Module) bool { return affected[m.Dir] }), nil
+}
@@ -0,0 +1,123 @@
+package knit
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// workspaceDir is the workspace of the e2e tests: core, utils -> core,
+// api -> utils and core, app -> api and core
+var workspaceDir = filepath.Join("..", "..", "e2e", "testdata", "workspace")
+
+func paths(modules []Module) []string {
+	p := make([]string, len(modules))
+	for i, m := range modules {
+		p[i] = m.Path
+	}
+	return p
+}
+
+func TestLoad(t *testing.T) {
+	w, err := Load(context.Background(), workspaceDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := []string{"example.com/core", "example.com/utils", "example.com/api", "example.com/app"}
+	if got := paths(w.Modules); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	m, ok := w.Module("example.com/utils")
+	if !ok || m.RelDir != "utils" || !filepath.IsAbs(m.Dir) {
+		t.Errorf("unexpected utils module %+v", m)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Load(ctx, workspaceDir); err == nil {
+		t.Error("expected Load to fail with a cancelled context")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	ctx := context.Background()
+	w, err := Load(ctx, workspaceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deps, err := w.Dependencies(ctx)
+	if err != nil {
+		t.Fatalf("Dependencies failed: %v", err)
+	}
+	if want := []string{"example.com/api", "example.com/core"}; !reflect.DeepEqual(deps["example.com/app"], want) {
+		t.Errorf("expected app to depend on %v, got %v", want, deps["example.com/app"])
+	}
+
+	dependents, err := w.Dependents(ctx, "example.com/utils", 0)
+	if err != nil {
+		t.Fatalf("Dependents failed: %v", err)
+	}
+	if want := []string{"example.com/api", "example.com/app"}; !reflect.DeepEqual(paths(dependents), want) {
+		t.Errorf("expected dependents %v, got %v", want, paths(dependents))
+	}
+	if _, err := w.Dependents(ctx, "example.com/missing", 0); err == nil {
+		t.Error("expected an error for an unknown module")
+	}
+}
+
+func TestAffected(t *testing.T) {
+	ctx := context.Background()
+	w, err := Load(ctx, workspaceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	affected, err := w.Affected(ctx, Changes{Files: []string{"utils/utils.go", "api/api.go", "README.md"}})
+	if err != nil {
+		t.Fatalf("Affected failed: %v", err)
+	}
+	if want := []string{"example.com/utils", "example.com/api"}; !reflect.DeepEqual(paths(affected), want) {
+		t.Errorf("expected %v, got %v", want, paths(affected))
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	w, err := Load(ctx, workspaceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	lines := make(map[string][]string)
+	results := Run(ctx, w.Modules, `basename "$PWD"; test "$(basename "$PWD")" != api || exit 3`, RunOptions{
+		Concurrency: 2,
+		Output: func(m Module, line []byte, stderr bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			lines[m.Path] = append(lines[m.Path], string(line))
+		},
+	})
+
+	if len(results) != len(w.Modules) {
+		t.Fatalf("expected %d results, got %d", len(w.Modules), len(results))
+	}
+	for i, r := range results {
+		if r.Module.Path != w.Modules[i].Path {
+			t.Errorf("expected results in module order, got %s at %d", r.Module.Path, i)
+		}
+		wantCode := 0
+		if r.Module.RelDir == "api" {
+			wantCode = 3
+		}
+		if r.ExitCode != wantCode {
+			t.Errorf("%s: expected exit code %d, got %d (%v)", r.Module.Path, wantCode, r.ExitCode, r.Err)
+		}
+		if got := lines[r.Module.Path]; len(got) != 1 || got[0] != r.Module.RelDir {
+			t.Errorf("%s: unexpected output %v", r.Module.Path, got)
+		}
+	}
+}
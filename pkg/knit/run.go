@@ -0,0 +1,90 @@
+package knit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nicolasgere/knit/lib/runner"
+)
+
+// DefaultConcurrency is the number of commands Run runs at once by default,
+// as the knit command does
+const DefaultConcurrency = 3
+
+// RunOptions controls how Run schedules commands
+type RunOptions struct {
+	// Name identifies the task, e.g. test; it is informational only
+	Name string
+	// Concurrency is the number of commands run at once, DefaultConcurrency when zero
+	Concurrency int
+	// Output, when set, receives every line the command prints in a module.
+	// It may be called concurrently for different modules.
+	Output func(module Module, line []byte, stderr bool)
+	// Done, when set, receives the result of each module as soon as its
+	// command exits. It may be called concurrently.
+	Done func(Result)
+}
+
+// Result is the outcome of a command in a module
+type Result struct {
+	Module   Module
+	ExitCode int
+	Duration time.Duration
+	// Err is set when the command failed or could not be started
+	Err error
+}
+
+// Run runs command with sh -c in the directory of every module, started in
+// the given order, and returns the results in the same order. Commands still
+// running when ctx is done are killed.
+func Run(ctx context.Context, modules []Module, command string, opts RunOptions) []Result {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	r := runner.NewRunner(ctx, concurrency).Quiet()
+
+	tasks := make([]runner.Task, len(modules))
+	for i, m := range modules {
+		tasks[i] = runner.Task{Id: m.Path, Name: opts.Name, Cmd: command, Root: m.Dir}
+	}
+
+	results := make([]Result, len(modules))
+	var wg sync.WaitGroup
+	for i, tf := range r.RunTasks(tasks) {
+		wg.Add(1)
+		go func(i int, tf *runner.TaskFuture) {
+			defer wg.Done()
+			results[i] = collect(modules[i], tf, opts.Output)
+			if opts.Done != nil {
+				opts.Done(results[i])
+			}
+		}(i, tf)
+	}
+	wg.Wait()
+	return results
+}
+
+// collect drains the output of a task until it is done
+func collect(m Module, tf *runner.TaskFuture, output func(Module, []byte, bool)) Result {
+	stdout, stderr := tf.Stdout, tf.Stderr
+	for {
+		select {
+		case line, ok := <-stdout:
+			if !ok {
+				stdout = nil
+			} else if output != nil {
+				output(m, line, false)
+			}
+		case line, ok := <-stderr:
+			if !ok {
+				stderr = nil
+			} else if output != nil {
+				output(m, line, true)
+			}
+		case res := <-tf.Done:
+			return Result{Module: m, ExitCode: res.Status, Duration: res.Duration, Err: res.Err}
+		}
+	}
+}
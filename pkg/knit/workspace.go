@@ -0,0 +1,131 @@
+// Package knit is the Go API of knit. It loads a Go workspace, computes the
+// modules affected by a change and runs commands on modules with the same
+// scheduler as the knit command, for tools that would otherwise shell out to
+// the CLI.
+//
+// Load, Dependencies, Dependents and Run take a context, killing the go and
+// task processes they start once it is done. ChangedFiles and Affected only
+// check it before asking the version control system, whose commands run to
+// completion, and methods answering from the loaded workspace, such as
+// Module, take none. The package keeps no state: several workspaces can be
+// used concurrently.
+package knit
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/resolver"
+)
+
+// Module is a module of a Go workspace
+type Module struct {
+	// Path is the module path declared in go.mod
+	Path string `json:"path"`
+	// Dir is the absolute directory of the module
+	Dir string `json:"dir"`
+	// RelDir is Dir relative to the workspace root, in slash form
+	RelDir string `json:"relDir"`
+	// GoVersion is the go directive of go.mod
+	GoVersion string `json:"goVersion"`
+}
+
+// Workspace is a loaded Go workspace
+type Workspace struct {
+	// Root is the absolute directory of the workspace
+	Root string
+	// Modules lists the modules of the workspace, in go.work order
+	Modules []Module
+
+	modules []analyzer.Module
+}
+
+// Load lists the modules of the workspace rooted at dir, with go list -m
+func Load(ctx context.Context, dir string) (*Workspace, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	modules, err := analyzer.ListModuleContext(ctx, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list modules: %w", err)
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no modules found in workspace")
+	}
+
+	w := &Workspace{Root: root, Modules: make([]Module, len(modules)), modules: modules}
+	for i, m := range modules {
+		w.Modules[i] = w.module(m)
+	}
+	return w, nil
+}
+
+func (w *Workspace) module(m analyzer.Module) Module {
+	rel, err := filepath.Rel(w.Root, m.Dir)
+	if err != nil {
+		rel = m.Dir
+	}
+	return Module{Path: m.Path, Dir: m.Dir, RelDir: filepath.ToSlash(rel), GoVersion: m.GoVersion}
+}
+
+// Module returns the workspace module with the given path
+func (w *Workspace) Module(path string) (Module, bool) {
+	for _, m := range w.Modules {
+		if m.Path == path {
+			return m, true
+		}
+	}
+	return Module{}, false
+}
+
+// Dependencies maps the path of every module to the sorted paths of the
+// workspace modules its packages import
+func (w *Workspace) Dependencies(ctx context.Context) (map[string][]string, error) {
+	adjMap, err := analyzer.ListModuleImportsContext(ctx, w.modules)
+	if err != nil {
+		return nil, err
+	}
+	deps := make(map[string][]string, len(adjMap))
+	for path, imported := range adjMap {
+		deps[path] = make([]string, 0, len(imported))
+		for dep := range imported {
+			deps[path] = append(deps[path], dep)
+		}
+		sort.Strings(deps[path])
+	}
+	return deps, nil
+}
+
+// Dependents returns the modules depending on the module with the given
+// path, directly or transitively, in workspace order. A depth greater than
+// zero stops after that many dependency edges.
+func (w *Workspace) Dependents(ctx context.Context, path string, depth int) ([]Module, error) {
+	if _, ok := w.Module(path); !ok {
+		return nil, fmt.Errorf("unknown module: %s", path)
+	}
+	adjMap, err := analyzer.ListModuleImportsContext(ctx, w.modules)
+	if err != nil {
+		return nil, err
+	}
+	dependents := resolver.Dependents(adjMap, path, depth)
+	return w.filter(func(m Module) bool {
+		_, ok := dependents[m.Path]
+		return ok
+	}), nil
+}
+
+// filter returns the modules of the workspace keep returns true for, in
+// workspace order
+func (w *Workspace) filter(keep func(Module) bool) []Module {
+	kept := make([]Module, 0)
+	for _, m := range w.Modules {
+		if keep(m) {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
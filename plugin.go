@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/pkg/knit"
+	"github.com/urfave/cli/v2"
+)
+
+// pluginPrefix is the prefix of the executables 'knit <name>' dispatches to
+// when name is not a knit command, as git and kubectl do
+const pluginPrefix = "knit-"
+
+// pluginContext is the workspace context handed to plugins as JSON
+type pluginContext struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	KnitVersion   string         `json:"knitVersion"`
+	WorkspaceRoot string         `json:"workspaceRoot"`
+	Modules       []knit.Module  `json:"modules"`
+	Config        *config.Config `json:"config,omitempty"`
+	// Base is the reference the affected modules are computed against
+	Base     string        `json:"base"`
+	Affected []knit.Module `json:"affected"`
+	// Errors lists what could not be computed, the rest of the context being usable
+	Errors []string `json:"errors,omitempty"`
+}
+
+// runPlugin is the action of the root command: anything that is not a knit
+// command runs the knit-<name> executable found in PATH with the remaining
+// arguments, stdin, stdout and stderr. The plugin receives the workspace
+// context in its environment:
+//
+//	KNIT_BIN           the knit executable, to call knit back
+//	KNIT_VERSION       the version of knit
+//	KNIT_WORKSPACE     the workspace root
+//	KNIT_BASE          the reference the affected modules are computed against
+//	KNIT_CONTEXT_FILE  a JSON pluginContext, removed once the plugin exits
+func runPlugin(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return cli.ShowAppHelp(c)
+	}
+	name := c.Args().First()
+	exe, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return fmt.Errorf("unknown command %q: not a knit command and no %s%s in PATH", name, pluginPrefix, name)
+	}
+
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	base := os.Getenv("KNIT_BASE")
+	if base == "" {
+		base = "main"
+	}
+	pc := loadPluginContext(ctx, base)
+
+	file, err := os.CreateTemp("", "knit-context-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write the plugin context: %w", err)
+	}
+	defer os.Remove(file.Name())
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(pc); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write the plugin context: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write the plugin context: %w", err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	cmd := exec.CommandContext(ctx, exe, c.Args().Tail()...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"KNIT_BIN="+self,
+		"KNIT_VERSION="+pc.KnitVersion,
+		"KNIT_WORKSPACE="+pc.WorkspaceRoot,
+		"KNIT_BASE="+base,
+		"KNIT_CONTEXT_FILE="+file.Name(),
+	)
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return cli.Exit("", exitErr.ExitCode())
+		}
+		return fmt.Errorf("failed to run %s: %w", exe, err)
+	}
+	return nil
+}
+
+// loadPluginContext describes the workspace containing the working
+// directory. Plugins may run outside a workspace, so failures are recorded
+// in the context rather than returned.
+func loadPluginContext(ctx context.Context, base string) *pluginContext {
+	pc := &pluginContext{
+		SchemaVersion: outputSchemaVersion,
+		KnitVersion:   currentBuildInfo().Version,
+		Base:          base,
+		Modules:       []knit.Module{},
+		Affected:      []knit.Module{},
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		pc.Errors = append(pc.Errors, err.Error())
+		return pc
+	}
+	if workFile, err := analyzer.WorkFile(root); err == nil && workFile != "" {
+		root = filepath.Dir(workFile)
+	}
+	pc.WorkspaceRoot = root
+
+	w, err := knit.Load(ctx, root)
+	if err != nil {
+		pc.Errors = append(pc.Errors, err.Error())
+		return pc
+	}
+	pc.Modules = w.Modules
+	if pc.Config, err = config.Load(root); err != nil {
+		pc.Errors = append(pc.Errors, err.Error())
+	}
+	if affected, err := w.Affected(ctx, knit.Changes{Base: base, MergeBase: true}); err != nil {
+		pc.Errors = append(pc.Errors, fmt.Sprintf("affected modules: %v", err))
+	} else {
+		pc.Affected = affected
+	}
+	return pc
+}
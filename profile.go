@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/gotest"
+	"github.com/nicolasgere/knit/lib/runner"
+)
+
+// profileKind is a profile go test writes
+type profileKind struct {
+	name, flag string
+	// sampleIndex is the sample of the profile go tool pprof reports
+	sampleIndex string
+}
+
+// profileKinds are the profiles 'knit test' collects, the allocations being
+// what the tests spend their time on rather than the memory left in use
+var profileKinds = []profileKind{
+	{name: "cpu", flag: "-cpuprofile"},
+	{name: "mem", flag: "-memprofile", sampleIndex: "alloc_space"},
+	{name: "block", flag: "-blockprofile"},
+}
+
+// topConsumers is the number of functions and of modules printed in the top
+// consumers of a profile
+const topConsumers = 5
+
+// testProfiles collects the profiles of the tests of every module, for
+// 'knit test --cpuprofile-dir' and the like
+type testProfiles struct {
+	workspaceRoot string
+	// dirs maps the name of a profile kind to its directory
+	dirs map[string]string
+	// files maps a module path to the files of each kind its tests write
+	files map[string]map[string][]string
+}
+
+// newTestProfiles returns the collection of the profiles whose directory is
+// set in dirs, keyed by kind, nil when there is none
+func newTestProfiles(workspaceRoot string, dirs map[string]string) (*testProfiles, error) {
+	p := &testProfiles{workspaceRoot: workspaceRoot, dirs: make(map[string]string), files: make(map[string]map[string][]string)}
+	for _, kind := range profileKinds {
+		if dirs[kind.name] == "" {
+			continue
+		}
+		dir, err := filepath.Abs(dirs[kind.name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		p.dirs[kind.name] = dir
+	}
+	if len(p.dirs) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// command returns the go test command of module m writing the profiles of
+// each of its packages with tests, go test profiling a single package at a
+// time. The profiles of a package are <dir>/<module>/<package>.<kind>.pprof,
+// <package> as given by profileFileName, next to the test binary reading them
+// needs.
+func (p *testProfiles) command(m analyzer.Module, flags string) (string, error) {
+	tests, err := gotest.ListTests(m.Dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to list the tests of %s: %w", m.Path, err)
+	}
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, t := range tests {
+		if !seen[t.Dir] {
+			seen[t.Dir] = true
+			dirs = append(dirs, t.Dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return "echo " + shellQuote("no tests to profile"), nil
+	}
+
+	module := moduleFileName(p.workspaceRoot, m)
+	p.files[m.Path] = make(map[string][]string)
+	var commands []string
+	for _, dir := range dirs {
+		name := profileFileName(dir)
+		cmd := "go test" + flags
+		binary := ""
+		for _, kind := range profileKinds {
+			root, ok := p.dirs[kind.name]
+			if !ok {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Join(root, module), 0755); err != nil {
+				return "", fmt.Errorf("failed to create the profile directory: %w", err)
+			}
+			file := filepath.Join(root, module, name+"."+kind.name+".pprof")
+			p.files[m.Path][kind.name] = append(p.files[m.Path][kind.name], file)
+			cmd += " " + kind.flag + " " + shellQuote(file)
+			if binary == "" {
+				binary = filepath.Join(root, module, name+".test")
+			}
+		}
+		commands = append(commands, cmd+" -o "+shellQuote(binary)+" "+packagePattern(dir))
+	}
+	return allCommands(commands), nil
+}
+
+// rootProfileName names the profiles of the root package of a module, the go
+// command ignoring the directories starting with '_'
+const rootProfileName = "_root"
+
+// profileFileName returns the name of the profiles of the package in dir,
+// relative to the module root: rootProfileName for the root, else dir with
+// its separators escaped, which no other directory escapes to
+func profileFileName(dir string) string {
+	if dir == "." {
+		return rootProfileName
+	}
+	return url.PathEscape(dir)
+}
+
+// report prints the top consumers of every profile in the modules whose
+// tests took the longest
+func (p *testProfiles) report(tasks []runner.Task, results []runner.TaskResult) {
+	order := make([]int, len(tasks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return results[order[a]].Duration > results[order[b]].Duration })
+	if len(order) > topConsumers {
+		order = order[:topConsumers]
+	}
+
+	for _, kind := range profileKinds {
+		dir, ok := p.dirs[kind.name]
+		if !ok {
+			continue
+		}
+		fmt.Printf("\nProfiles %s written to %s\n", kind.name, dir)
+		for _, i := range order {
+			var files []string
+			for _, f := range p.files[tasks[i].Id][kind.name] {
+				if _, err := os.Stat(f); err == nil {
+					files = append(files, f)
+				}
+			}
+			if len(files) == 0 {
+				continue
+			}
+			fmt.Printf("Top %s consumers of %s, whose tests took %s:\n", kind.name, tasks[i].Id, results[i].Duration.Round(slowestPrecision))
+			top, err := pprofTop(kind, files)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+				continue
+			}
+			fmt.Println(top)
+		}
+	}
+}
+
+// pprofTop returns the functions of the profiles with the most samples, the
+// profiles being merged
+func pprofTop(kind profileKind, files []string) (string, error) {
+	args := []string{"tool", "pprof", "-top", fmt.Sprintf("-nodecount=%d", topConsumers)}
+	if kind.sampleIndex != "" {
+		args = append(args, "-sample_index="+kind.sampleIndex)
+	}
+	output, err := exec.Command("go", append(args, files...)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("go tool pprof failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	// The rows of the functions follow the header of the table
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	for i, line := range lines {
+		if strings.Contains(line, "flat%") {
+			if i == len(lines)-1 {
+				return "  no samples", nil
+			}
+			return "  " + strings.Join(lines[i:], "\n  "), nil
+		}
+	}
+	return "  " + strings.Join(lines, "\n  "), nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/query"
+	"github.com/urfave/cli/v2"
+)
+
+const queryHelp = `Queries combine module sets with functions and operators:
+
+  example.com/core       the module itself
+  example.com/svc/*      modules matching a glob, '/...' matches a whole prefix
+  all()                  every module of the workspace
+  deps(x[, depth])       x and the modules it depends on
+  rdeps(x[, depth])      x and the modules depending on it
+  tag(name)              modules tagged name in knit.yaml
+  changed(ref)           modules changed since the merge-base with ref
+  a | b, a + b           union (also 'union')
+  a & b                  intersection (also 'intersect')
+  a - b                  difference (also 'except')
+
+Operators have the same precedence and associate to the left, use
+parentheses to group.`
+
+// createQueryCommand creates the 'query' command selecting modules with a query expression
+func createQueryCommand() *cli.Command {
+	var (
+		path       string
+		format     string
+		tmplText   string
+		jobCommand string
+		changes    changeFlags
+	)
+
+	return &cli.Command{
+		Name:      "query",
+		Usage:     "Select modules with a query over the dependency graph",
+		ArgsUsage: "<query>",
+		Description: queryHelp + `
+
+Other commands (test, fmt, shard) accept the same expression with --query.
+
+Examples:
+  knit query 'rdeps(example.com/core) & changed(origin/main)'
+  knit query -f rel-dirs 'tag(service) - changed(main)'
+  knit test --query 'deps(example.com/app, 1)'`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "Output format, same as 'affected': list (default), go-args, github-matrix, dirs, rel-dirs, list0, dirs0, rel-dirs0, template, gitlab-ci, circleci, azure-matrix",
+				Aliases:     []string{"f"},
+				Value:       "list",
+				Destination: &format,
+			},
+			&cli.StringFlag{
+				Name:        "template",
+				Usage:       "Go template used with -f template",
+				Destination: &tmplText,
+			},
+			&cli.StringFlag{
+				Name:        "job-command",
+				Usage:       "Go template of the command run by each generated CI job",
+				Value:       defaultJobCommand,
+				Destination: &jobCommand,
+			},
+		}, changes.vcsFlags()...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected 1 argument: <query>")
+			}
+
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			selected, err := queryModules(absPath, modules, c.Args().First(), &changes)
+			if err != nil {
+				return err
+			}
+			return outputAffected(selected, affectedOptions{
+				Format:     OutputFormat(format),
+				Template:   tmplText,
+				JobCommand: jobCommand,
+			}, absPath)
+		},
+	}
+}
+
+// queryFlag returns the --query flag shared by the commands selecting modules
+func queryFlag(destination *string) cli.Flag {
+	return &cli.StringFlag{
+		Name:        "query",
+		Usage:       "Only keep modules selected by a query, see 'knit query --help'",
+		Aliases:     []string{"q"},
+		Destination: destination,
+	}
+}
+
+// queryModules evaluates a query against the workspace and returns the
+// selected modules in workspace order
+func queryModules(absPath string, modules []analyzer.Module, q string, changes *changeFlags) ([]analyzer.Module, error) {
+	expr, err := query.Parse(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+
+	cfg, err := config.Load(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	imports, err := listModuleImports(absPath, modules, analyzer.BuildContext{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build dependency graph: %w", err)
+	}
+	adjMap, err := (*analyzer.GraphFromImports(modules, imports)).AdjacencyMap()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get adjacency map: %w", err)
+	}
+
+	universe := newUniverse(absPath, modules, cfg, adjMap, changes)
+	byPath := make(map[string]analyzer.Module, len(modules))
+	for _, m := range modules {
+		byPath[m.Path] = m
+	}
+
+	paths, err := query.EvalExpr(expr, universe)
+	if err != nil {
+		return nil, err
+	}
+	selected := make([]analyzer.Module, len(paths))
+	for i, p := range paths {
+		selected[i] = byPath[p]
+	}
+	return selected, nil
+}
+
+// newUniverse builds the universe queries are evaluated against. changed() is
+// only available when changes is not nil.
+func newUniverse[T any](absPath string, modules []analyzer.Module, cfg *config.Config, adjMap map[string]map[string]T, changes *changeFlags) *query.Universe {
+	universe := &query.Universe{
+		Modules: make([]string, len(modules)),
+		Deps:    make(map[string][]string, len(adjMap)),
+		Tags:    cfg.Tags(),
+	}
+	if changes != nil {
+		universe.Changed = func(ref string) ([]string, error) {
+			changed, err := affectedModules(modules, absPath, changes.vcsSource(ref, true))
+			if err != nil {
+				return nil, err
+			}
+			paths := make([]string, len(changed))
+			for i, m := range changed {
+				paths[i] = m.Path
+			}
+			return paths, nil
+		}
+	}
+	for i, m := range modules {
+		universe.Modules[i] = m.Path
+	}
+	for src, deps := range adjMap {
+		for dst := range deps {
+			universe.Deps[src] = append(universe.Deps[src], dst)
+		}
+	}
+	return universe
+}
+
+// filterByQuery keeps the modules of subset also selected by the query,
+// evaluated against the whole workspace
+func filterByQuery(absPath string, modules, subset []analyzer.Module, q string, changes *changeFlags) ([]analyzer.Module, error) {
+	selected, err := queryModules(absPath, modules, q, changes)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool, len(selected))
+	for _, m := range selected {
+		keep[m.Path] = true
+	}
+	filtered := make([]analyzer.Module, 0, len(subset))
+	for _, m := range subset {
+		if keep[m.Path] {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered, nil
+}
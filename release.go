@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/git"
+	"github.com/nicolasgere/knit/lib/release"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/mod/semver"
+)
+
+// plannedRelease is the next release of a module as printed by 'knit release'
+type plannedRelease struct {
+	Module   string `json:"module"`
+	Previous string `json:"previous,omitempty"`
+	Version  string `json:"version"`
+	Tag      string `json:"tag"`
+	Bump     string `json:"bump"`
+	Commits  int    `json:"commits"`
+	// Blocked explains why the tag cannot be created, if it cannot
+	Blocked string `json:"blocked,omitempty"`
+}
+
+// createReleaseCommand creates the 'release' command computing the next
+// version tag of every module changed since its last release
+func createReleaseCommand() *cli.Command {
+	var (
+		path    string
+		auto    bool
+		bump    string
+		targets cli.StringSlice
+		create  bool
+		asJSON  bool
+	)
+
+	return &cli.Command{
+		Name:  "release",
+		Usage: "Propose or create version tags for modules changed since their last tag",
+		Description: `Find the commits touching each module since its last version tag, following
+the go command tag conventions (v1.2.3 at the repository root, core/v1.2.3 for
+a module in core/), and compute the next version.
+
+With --auto the bump is derived from conventional commits: a breaking change
+(feat!: or a BREAKING CHANGE footer) is major, feat is minor, fix and perf are
+patch. Before v1 breaking changes bump the minor version. Modules without
+releasable commits are left alone.
+
+Examples:
+  knit release --auto                   # Propose the next tags
+  knit release --auto --create          # Create them on HEAD
+  knit release --bump patch -t example.com/core`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "auto",
+				Usage:       "Derive the bump of each module from its conventional commits",
+				Destination: &auto,
+			},
+			&cli.StringFlag{
+				Name:        "bump",
+				Usage:       "Apply the same bump to every changed module: patch, minor or major",
+				Destination: &bump,
+			},
+			&cli.StringSliceFlag{
+				Name:        "target",
+				Usage:       "Only release these modules, globs allowed (repeatable)",
+				Aliases:     []string{"t"},
+				Destination: &targets,
+			},
+			&cli.BoolFlag{
+				Name:        "create",
+				Usage:       "Create the tags on HEAD instead of only printing them",
+				Destination: &create,
+			},
+			&cli.BoolFlag{
+				Name:        "json",
+				Usage:       "Output a JSON array",
+				Destination: &asJSON,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if auto == (bump != "") {
+				return fmt.Errorf("specify either --auto or --bump")
+			}
+			fixed := release.None
+			if bump != "" {
+				var err error
+				if fixed, err = release.ParseBump(bump); err != nil {
+					return err
+				}
+			}
+
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			if patterns := targets.Value(); len(patterns) > 0 {
+				if modules, err = resolveTargets(modules, patterns, absPath); err != nil {
+					return err
+				}
+			}
+
+			releases, err := planReleases(absPath, modules, fixed)
+			if err != nil {
+				return err
+			}
+			if create {
+				if err := createTags(absPath, releases); err != nil {
+					return err
+				}
+			}
+			return printReleases(releases, asJSON, create)
+		},
+	}
+}
+
+// planReleases computes the next release of every module with releasable
+// commits since its last tag. A fixed bump other than release.None applies to
+// every module with commits, instead of deriving it from the messages.
+func planReleases(absPath string, modules []analyzer.Module, fixed release.Bump) ([]plannedRelease, error) {
+	repoRoot, err := git.GetRepoRoot(absPath)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := git.ListTags(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	relDirs := make(map[string]string, len(modules))
+	all, err := analyzer.ListModule(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list modules: %w", err)
+	}
+	for _, m := range all {
+		if relDirs[m.Path], err = repoRelDir(repoRoot, m.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	releases := make([]plannedRelease, 0)
+	for _, m := range modules {
+		relDir := relDirs[m.Path]
+		prefix := release.TagPrefix(m.Path, relDir)
+		previous := release.LatestVersion(tags, prefix)
+
+		since := ""
+		if previous != "" {
+			since = prefix + previous
+		}
+		messages, err := git.CommitMessages(since, modulePathspecs(relDir, relDirs), repoRoot)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.Path, err)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		bump := fixed
+		if bump == release.None {
+			bump = release.Highest(messages)
+		}
+		if bump == release.None {
+			continue
+		}
+
+		r := plannedRelease{
+			Module:   m.Path,
+			Previous: previous,
+			Version:  release.Next(previous, bump),
+			Bump:     bump.String(),
+			Commits:  len(messages),
+		}
+		r.Tag = prefix + r.Version
+		if release.RequiresNewPath(m.Path, r.Version) {
+			r.Blocked = fmt.Sprintf("%s requires a module path ending in /%s", r.Version, semver.Major(r.Version))
+		}
+		releases = append(releases, r)
+	}
+	return releases, nil
+}
+
+// repoRelDir returns dir relative to the repository root, slash-separated
+func repoRelDir(repoRoot, dir string) (string, error) {
+	// Resolve symlinks so paths from git and from go list compare equal
+	if resolved, err := filepath.EvalSymlinks(repoRoot); err == nil {
+		repoRoot = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	rel, err := filepath.Rel(repoRoot, dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate %s in the repository: %w", dir, err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// modulePathspecs returns the git pathspecs selecting the files of the module
+// in relDir, excluding the modules nested below it
+func modulePathspecs(relDir string, relDirs map[string]string) []string {
+	pathspecs := []string{relDir}
+	for _, other := range relDirs {
+		if other != relDir && (relDir == "." || strings.HasPrefix(other, relDir+"/")) {
+			pathspecs = append(pathspecs, ":(exclude)"+other)
+		}
+	}
+	return pathspecs
+}
+
+// createTags creates the tag of every release that is not blocked
+func createTags(absPath string, releases []plannedRelease) error {
+	for _, r := range releases {
+		if r.Blocked != "" {
+			continue
+		}
+		if err := git.CreateTag(r.Tag, absPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printReleases(releases []plannedRelease, asJSON, created bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(releases)
+	}
+
+	if len(releases) == 0 {
+		fmt.Println("No module needs a release")
+		return nil
+	}
+	for _, r := range releases {
+		previous := "(none)"
+		if r.Previous != "" {
+			previous = strings.TrimSuffix(r.Tag, r.Version) + r.Previous
+		}
+		fmt.Printf("%s: %s -> %s (%s, %d commit(s))\n", r.Module, previous, r.Tag, r.Bump, r.Commits)
+		if r.Blocked != "" {
+			fmt.Printf("    skipped: %s\n", r.Blocked)
+		}
+	}
+	if created {
+		fmt.Println("Tags created, push them with 'git push --tags'")
+	} else {
+		fmt.Println("Run again with --create to create the tags")
+	}
+	return nil
+}
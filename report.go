@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/nicolasgere/knit/lib/prcomment"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/urfave/cli/v2"
+)
+
+// createReportCommand creates the 'report' command grouping the reports
+// published by CI jobs
+func createReportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "report",
+		Usage: "Publish reports about a change from CI",
+		Subcommands: []*cli.Command{
+			createReportPRCommentCommand(),
+		},
+	}
+}
+
+// createReportPRCommentCommand creates the 'report pr-comment' command
+// commenting the impact of a pull request on it
+func createReportPRCommentCommand() *cli.Command {
+	var (
+		path    string
+		base    string
+		task    string
+		number  int
+		dryRun  bool
+		changes changeFlags
+		build   buildFlags
+	)
+
+	return &cli.Command{
+		Name:  "pr-comment",
+		Usage: "Comment the affected modules, their dependents and test results on the pull request",
+		Description: `Post a comment on the pull request of the CI job listing the modules changed
+since the merge-base with the target branch, the modules depending on them,
+and the result of the last run of a task in each, 'test' by default, as
+recorded by 'knit test'. The comment of a previous run is updated rather
+than a new one added on every push.
+
+On GitHub Actions the pull request is read from GITHUB_REPOSITORY and the
+event payload, and GITHUB_TOKEN needs the pull-requests: write permission.
+On GitLab CI it is read from CI_PROJECT_ID and CI_MERGE_REQUEST_IID, and
+GITLAB_TOKEN must be a token with the api scope. The target branch of the
+pull request is the default --base.
+
+Examples:
+  knit test --affected --merge-base; knit report pr-comment
+  knit report pr-comment --dry-run    # Print the comment instead
+  knit report pr-comment --pr 42      # Outside of a pull_request workflow`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Git reference to compare against (default: the target branch of the pull request, or main)",
+				Aliases:     []string{"b"},
+				Destination: &base,
+			},
+			&cli.StringFlag{
+				Name:        "task",
+				Usage:       "Task whose last results are reported",
+				Value:       "test",
+				Destination: &task,
+			},
+			&cli.IntFlag{
+				Name:        "pr",
+				Usage:       "Number of the pull request (default: the one of the CI job)",
+				Destination: &number,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "Print the comment instead of posting it",
+				Destination: &dryRun,
+			},
+		}, append(changes.flags(), build.flags()...)...),
+		Action: func(c *cli.Context) error {
+			if base == "" {
+				base = pullRequestBase()
+			}
+			src, err := changes.source(base, true)
+			if err != nil {
+				return err
+			}
+			body, err := prImpactReport(path, src, task, build.context())
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				fmt.Print(body)
+				return nil
+			}
+
+			target, err := prcomment.FromEnv(number)
+			if err != nil {
+				return err
+			}
+			client := &prcomment.Client{Target: target}
+			created, err := client.Upsert(context.Background(), body)
+			if err != nil {
+				return err
+			}
+			verb := "Updated the"
+			if created {
+				verb = "Posted a"
+			}
+			fmt.Printf("%s knit comment on %s #%d\n", verb, target.Repo, target.Number)
+			return nil
+		},
+	}
+}
+
+// pullRequestBase returns the target branch of the pull request of the CI
+// job, main outside of one
+func pullRequestBase() string {
+	for _, env := range []string{"GITHUB_BASE_REF", "CI_MERGE_REQUEST_TARGET_BRANCH_NAME"} {
+		if ref := os.Getenv(env); ref != "" {
+			return "origin/" + ref
+		}
+	}
+	return "main"
+}
+
+// prImpactReport renders the affected modules, followed by their dependents,
+// with the last results of task
+func prImpactReport(path string, src changeSource, task string, build analyzer.BuildContext) (string, error) {
+	absPath, modules, imports, err := loadModuleImports(path, true, build)
+	if err != nil {
+		return "", err
+	}
+	affected, err := affectedModules(modules, absPath, src)
+	if err != nil {
+		return "", err
+	}
+	h, err := history.Load(absPath)
+	if err != nil {
+		return "", err
+	}
+
+	changed := make(map[string]bool, len(affected))
+	for _, m := range affected {
+		changed[m.Path] = true
+	}
+	dependents := make(map[string]bool)
+	for _, m := range affected {
+		for dep := range resolver.Dependents(imports, m.Path, 0) {
+			if !changed[dep] {
+				dependents[dep] = true
+			}
+		}
+	}
+
+	report := prcomment.Report{Base: src.Base, Task: task}
+	add := func(m analyzer.Module, dependent bool) {
+		rm := prcomment.Module{Path: m.Path, Dependent: dependent}
+		if slices.Contains(h.Failed[task], m.Path) {
+			rm.Result = prcomment.Failed
+		} else if d, ok := h.Duration(task, m.Path); ok {
+			rm.Result, rm.Duration = prcomment.Passed, d
+		}
+		report.Modules = append(report.Modules, rm)
+	}
+	for _, m := range affected {
+		add(m, false)
+	}
+	for _, m := range modules {
+		if dependents[m.Path] {
+			add(m, true)
+		}
+	}
+	return prcomment.Render(report), nil
+}
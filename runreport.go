@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/nicolasgere/knit/lib/daemon"
+)
+
+// runReport is the JSON report --report writes, versioned by schemaVersion
+type runReport struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Task          string          `json:"task"`
+	Failed        int             `json:"failed"`
+	Modules       []runReportItem `json:"modules"`
+}
+
+// runReportItem is the result of the task in a module
+type runReportItem struct {
+	Path       string `json:"path"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	Cached     bool   `json:"cached,omitempty"`
+}
+
+// writeRunReport writes the runs of report to file as a runReport
+func writeRunReport(file string, report daemon.Report) error {
+	out := runReport{SchemaVersion: outputSchemaVersion, Task: report.Task, Modules: make([]runReportItem, 0, len(report.Runs))}
+	for _, run := range report.Runs {
+		if run.ExitCode != 0 {
+			out.Failed++
+		}
+		out.Modules = append(out.Modules, runReportItem{
+			Path:       run.Module,
+			ExitCode:   run.ExitCode,
+			DurationMs: run.Duration.Milliseconds(),
+			Cached:     run.Cached,
+		})
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	if err := os.WriteFile(file, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write the report: %w", err)
+	}
+	return nil
+}
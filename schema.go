@@ -0,0 +1,41 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+// schemas are the JSON schemas of the machine-readable outputs, by name, each
+// describing the outputs of schema version outputSchemaVersion
+//
+//go:embed assets/schemas/*.json
+var schemas embed.FS
+
+// Names of the JSON schemas
+const (
+	schemaAffected     = "affected"
+	schemaGitHubMatrix = "github-matrix"
+	schemaGraph        = "graph"
+	schemaRunReport    = "run-report"
+)
+
+// schemaFlag returns the --schema flag printing the JSON schema of what
+func schemaFlag(what string, destination *bool) cli.Flag {
+	return &cli.BoolFlag{
+		Name:        "schema",
+		Usage:       "Print the JSON schema of " + what + " and exit",
+		Destination: destination,
+	}
+}
+
+// printSchema prints the JSON schema of the given name
+func printSchema(name string) error {
+	data, err := schemas.ReadFile("assets/schemas/" + name + ".json")
+	if err != nil {
+		return fmt.Errorf("no JSON schema for %s", name)
+	}
+	fmt.Print(string(data))
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/nicolasgere/knit/lib/selfupdate"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/mod/semver"
+)
+
+// releasesURL is the GitHub releases endpoint of knit, overridden with
+// KNIT_RELEASES_URL for mirrors and tests
+const releasesURL = "https://api.github.com/repos/nicolasgere/knit/releases"
+
+// createSelfUpdateCommand creates the 'self-update' command replacing the
+// running binary with the latest release
+func createSelfUpdateCommand() *cli.Command {
+	var (
+		check         bool
+		targetVersion string
+		force         bool
+	)
+
+	return &cli.Command{
+		Name:  "self-update",
+		Usage: "Update knit to the latest GitHub release",
+		Description: `Fetch the latest GitHub release of knit, or the one given with --version,
+download the archive for this platform, verify it against the checksums.txt
+of the release and replace the running binary. Nothing is downloaded when
+knit is already at that version, unless --force is set. With --check, only
+report whether an update is available, exiting with code 1 when it is.
+
+GITHUB_TOKEN, when set, authenticates the requests to the GitHub API.
+KNIT_RELEASES_URL replaces the releases endpoint, e.g. for a mirror, which
+GITHUB_TOKEN is not sent to.
+
+Examples:
+  knit self-update
+  knit self-update --check
+  knit self-update --version v0.4.0`,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:        "check",
+				Usage:       "Only report whether an update is available",
+				Destination: &check,
+			},
+			&cli.StringFlag{
+				Name:        "version",
+				Usage:       "Release to install instead of the latest, e.g. v0.4.0",
+				Destination: &targetVersion,
+			},
+			&cli.BoolFlag{
+				Name:        "force",
+				Usage:       "Install even when knit is already at that version",
+				Destination: &force,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if targetVersion != "" && !semver.IsValid(targetVersion) {
+				return fmt.Errorf("invalid version %q, expected e.g. v0.4.0", targetVersion)
+			}
+
+			// GITHUB_TOKEN is only sent to GitHub, never to a mirror
+			client := &selfupdate.Client{BaseURL: releasesURL, Token: os.Getenv("GITHUB_TOKEN")}
+			if url := os.Getenv("KNIT_RELEASES_URL"); url != "" {
+				client.BaseURL = url
+				client.Token = ""
+			}
+			ctx := c.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			release, err := client.Release(ctx, targetVersion)
+			if err != nil {
+				return err
+			}
+			current := currentBuildInfo().Version
+			if current == release.TagName && !force {
+				fmt.Printf("✓ knit %s is up to date\n", current)
+				return nil
+			}
+			if check {
+				if semver.IsValid(current) && semver.Compare(current, release.TagName) > 0 {
+					fmt.Printf("✓ knit %s is newer than %s\n", current, release.TagName)
+					return nil
+				}
+				return cli.Exit(fmt.Sprintf("knit %s is available (current %s), run 'knit self-update'", release.TagName, current), 1)
+			}
+
+			exe, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("failed to locate the running binary: %w", err)
+			}
+			if exe, err = filepath.EvalSymlinks(exe); err != nil {
+				return fmt.Errorf("failed to locate the running binary: %w", err)
+			}
+			binary, err := downloadRelease(ctx, client, release)
+			if err != nil {
+				return err
+			}
+			if err := selfupdate.Replace(exe, binary); err != nil {
+				return err
+			}
+			fmt.Printf("Updated knit %s -> %s (%s)\n", current, release.TagName, exe)
+			return nil
+		},
+	}
+}
+
+// downloadRelease returns the knit binary of a release for this platform,
+// after verifying its archive against the release checksums
+func downloadRelease(ctx context.Context, client *selfupdate.Client, release *selfupdate.Release) ([]byte, error) {
+	name := selfupdate.ArchiveName("knit", release.TagName, runtime.GOOS, runtime.GOARCH)
+	archive, ok := release.Asset(name)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s for %s/%s", release.TagName, name, runtime.GOOS, runtime.GOARCH)
+	}
+	checksums, ok := release.Asset(selfupdate.ChecksumsFile)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s, refusing to install an unverified binary", release.TagName, selfupdate.ChecksumsFile)
+	}
+
+	sums, err := client.Download(ctx, checksums)
+	if err != nil {
+		return nil, err
+	}
+	data, err := client.Download(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	if err := selfupdate.Verify(data, sums, name); err != nil {
+		return nil, err
+	}
+
+	binary := "knit"
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	return selfupdate.ExtractBinary(name, data, binary)
+}
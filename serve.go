@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/nicolasgere/knit/lib/rpc"
+	"github.com/nicolasgere/knit/pkg/knit"
+	"github.com/urfave/cli/v2"
+)
+
+// createServeCommand creates the 'serve' command exposing knit over JSON-RPC
+func createServeCommand() *cli.Command {
+	var (
+		path   string
+		listen string
+	)
+
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Serve modules, affected sets, dependencies and task runs over JSON-RPC",
+		Description: `Answer JSON-RPC 2.0 requests, one JSON object per line, on stdin and stdout,
+or on a loopback TCP address with --listen, for editor plugins and
+dashboards. Requests are handled concurrently, and a line that is not a
+JSON-RPC request closes the connection.
+
+Since knit.run runs commands, connections to --listen must authenticate
+first: knit serve writes a token of the session to .knit/serve.token,
+readable by its user only, and the first request of a connection must be
+rpc.authenticate {token}.
+
+Methods:
+  knit.modules                                  Modules of the workspace
+  knit.affected     {base, mergeBase, files}    Modules affected by a change
+  knit.dependencies                             Workspace modules imported by each module
+  knit.dependents   {module, depth}             Modules depending on a module
+  knit.run          {command, modules, concurrency, token}
+                    Run a command in modules (all when empty), streaming
+                    knit.output {token, module, stream, line} and
+                    knit.taskDone {token, module, exitCode, durationMs}
+                    notifications, then returning every result
+
+Examples:
+  echo '{"jsonrpc":"2.0","id":1,"method":"knit.modules"}' | knit serve
+  knit serve --listen 127.0.0.1:7420   # token in .knit/serve.token`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "listen",
+				Usage:       "Loopback TCP address to listen on instead of stdio, e.g. 127.0.0.1:7420, with a token in .knit/serve.token",
+				Destination: &listen,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			server := newRPCServer(absPath)
+			if listen == "" {
+				return server.ServeConn(ctx, os.Stdin, os.Stdout)
+			}
+			return serveTCP(ctx, server, listen, absPath)
+		},
+	}
+}
+
+// serveTokenFile is the file of .knit holding the token of a 'knit serve
+// --listen' session
+const serveTokenFile = "serve.token"
+
+// serveTCP serves every connection to addr, which must be a loopback
+// address since knit.run executes commands. Connections authenticate with a
+// token written to .knit/serve.token for the session, so that neither other
+// local users nor web pages the browser lets reach loopback can call methods.
+func serveTCP(ctx context.Context, server *rpc.Server, addr, workspaceRoot string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("refusing to listen on %s: knit serve only listens on loopback addresses", addr)
+	}
+	tokenFile, err := writeServeToken(server, workspaceRoot)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tokenFile)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "Listening on %s, token in %s\n", listener.Addr(), tokenFile)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept a connection: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			server.ServeConn(ctx, conn, conn)
+		}()
+	}
+}
+
+// writeServeToken makes server require a new random token, written to
+// .knit/serve.token with permissions of its user only, and returns the file
+func writeServeToken(server *rpc.Server, workspaceRoot string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate a token: %w", err)
+	}
+	token := hex.EncodeToString(secret)
+
+	dir := filepath.Join(workspaceRoot, history.Dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	file := filepath.Join(dir, serveTokenFile)
+	// A file left by a previous session may be readable by others
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to remove %s: %w", file, err)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to write the token: %w", err)
+	}
+	_, err = f.WriteString(token + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write the token: %w", err)
+	}
+	server.RequireToken(token)
+	return file, nil
+}
+
+// rpcRunResult is the outcome of knit.run in a module
+type rpcRunResult struct {
+	Module     string `json:"module"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// newRPCServer registers the knit methods, each loading the workspace
+// rooted at absPath so that answers reflect the files on disk
+func newRPCServer(absPath string) *rpc.Server {
+	s := rpc.NewServer()
+
+	s.Handle("knit.modules", func(ctx context.Context, _ json.RawMessage, _ rpc.Notify) (any, error) {
+		w, err := knit.Load(ctx, absPath)
+		if err != nil {
+			return nil, err
+		}
+		return w.Modules, nil
+	})
+
+	s.Handle("knit.affected", func(ctx context.Context, params json.RawMessage, _ rpc.Notify) (any, error) {
+		p := struct {
+			Base      string   `json:"base"`
+			MergeBase bool     `json:"mergeBase"`
+			Files     []string `json:"files"`
+		}{Base: "main"}
+		if err := rpc.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		w, err := knit.Load(ctx, absPath)
+		if err != nil {
+			return nil, err
+		}
+		return w.Affected(ctx, knit.Changes{Base: p.Base, MergeBase: p.MergeBase, Files: p.Files})
+	})
+
+	s.Handle("knit.dependencies", func(ctx context.Context, _ json.RawMessage, _ rpc.Notify) (any, error) {
+		w, err := knit.Load(ctx, absPath)
+		if err != nil {
+			return nil, err
+		}
+		return w.Dependencies(ctx)
+	})
+
+	s.Handle("knit.dependents", func(ctx context.Context, params json.RawMessage, _ rpc.Notify) (any, error) {
+		var p struct {
+			Module string `json:"module"`
+			Depth  int    `json:"depth"`
+		}
+		if err := rpc.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Module == "" {
+			return nil, rpc.InvalidParams("module is required")
+		}
+		w, err := knit.Load(ctx, absPath)
+		if err != nil {
+			return nil, err
+		}
+		return w.Dependents(ctx, p.Module, p.Depth)
+	})
+
+	s.Handle("knit.run", func(ctx context.Context, params json.RawMessage, notify rpc.Notify) (any, error) {
+		var p struct {
+			Command     string          `json:"command"`
+			Modules     []string        `json:"modules"`
+			Concurrency int             `json:"concurrency"`
+			Token       json.RawMessage `json:"token"`
+		}
+		if err := rpc.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Command == "" {
+			return nil, rpc.InvalidParams("command is required")
+		}
+		w, err := knit.Load(ctx, absPath)
+		if err != nil {
+			return nil, err
+		}
+		modules := w.Modules
+		if len(p.Modules) > 0 {
+			modules = make([]knit.Module, 0, len(p.Modules))
+			for _, path := range p.Modules {
+				m, ok := w.Module(path)
+				if !ok {
+					return nil, rpc.InvalidParams("unknown module: %s", path)
+				}
+				modules = append(modules, m)
+			}
+		}
+
+		results := knit.Run(ctx, modules, p.Command, knit.RunOptions{
+			Concurrency: p.Concurrency,
+			Output: func(m knit.Module, line []byte, stderr bool) {
+				stream := "stdout"
+				if stderr {
+					stream = "stderr"
+				}
+				notify("knit.output", map[string]any{"token": p.Token, "module": m.Path, "stream": stream, "line": string(line)})
+			},
+			Done: func(r knit.Result) {
+				notify("knit.taskDone", map[string]any{"token": p.Token, "module": r.Module.Path, "exitCode": r.ExitCode, "durationMs": r.Duration.Milliseconds()})
+			},
+		})
+
+		out := make([]rpcRunResult, len(results))
+		for i, r := range results {
+			out[i] = rpcRunResult{Module: r.Module.Path, ExitCode: r.ExitCode, DurationMs: r.Duration.Milliseconds()}
+			if r.Err != nil {
+				out[i].Error = r.Err.Error()
+			}
+		}
+		return out, nil
+	})
+
+	return s
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/nicolasgere/knit/lib/shard"
+	"github.com/urfave/cli/v2"
+)
+
+// createShardCommand creates the 'shard' command to split modules across CI jobs
+func createShardCommand() *cli.Command {
+	var (
+		path       string
+		total      int
+		index      int
+		affected   bool
+		base       string
+		changes    changeFlags
+		durations  string
+		format     string
+		tmplText   string
+		jobCommand string
+		queryText  string
+	)
+
+	return &cli.Command{
+		Name:  "shard",
+		Usage: "Print one of N balanced groups of modules, to fan work across parallel CI jobs",
+		Description: `Split the workspace (or affected) modules into N groups of balanced total
+weight and print group I (0-based). The split is deterministic, so every job
+computes the same groups. Modules are weighted by the test durations recorded
+in .knit/history.json, or by --durations, a JSON object mapping module paths
+to seconds. Without any duration every module weighs the same.
+
+Examples:
+  knit shard --total 4 --index 0                        # First of 4 groups
+  knit shard --total 4 --index $CIRCLE_NODE_INDEX --affected
+  knit shard --total 2 --index 1 --durations durations.json -f go-args`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.IntFlag{
+				Name:        "total",
+				Usage:       "Number of groups",
+				Aliases:     []string{"n"},
+				Required:    true,
+				Destination: &total,
+			},
+			&cli.IntFlag{
+				Name:        "index",
+				Usage:       "Index of the group to print (0-based)",
+				Aliases:     []string{"i"},
+				Required:    true,
+				Destination: &index,
+			},
+			&cli.BoolFlag{
+				Name:        "affected",
+				Usage:       "Split only affected modules (since merge-base)",
+				Aliases:     []string{"a"},
+				Destination: &affected,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Git reference to compare against when using --affected (default: main)",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.StringFlag{
+				Name:        "durations",
+				Usage:       "JSON file mapping module paths to durations in seconds, used as weights",
+				Destination: &durations,
+			},
+			queryFlag(&queryText),
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "Output format, same as 'knit affected'",
+				Aliases:     []string{"f"},
+				Value:       "list",
+				Destination: &format,
+			},
+			&cli.StringFlag{
+				Name:        "template",
+				Usage:       "Go template used with -f template, same as 'knit affected'",
+				Destination: &tmplText,
+			},
+			&cli.StringFlag{
+				Name:        "job-command",
+				Usage:       "Go template of the command run by each generated CI job",
+				Value:       defaultJobCommand,
+				Destination: &jobCommand,
+			},
+		}, changes.flags()...),
+		Action: func(c *cli.Context) error {
+			if total < 1 {
+				return fmt.Errorf("--total must be at least 1")
+			}
+			if index < 0 || index >= total {
+				return fmt.Errorf("--index must be between 0 and %d", total-1)
+			}
+
+			absPath, workspace, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			if len(workspace) == 0 {
+				return fmt.Errorf("no modules found in workspace")
+			}
+			modules := workspace
+
+			if affected || changes.filesFrom != "" {
+				src, err := changes.source(base, true)
+				if err != nil {
+					return err
+				}
+				if modules, err = affectedModules(modules, absPath, src); err != nil {
+					return err
+				}
+			}
+			if queryText != "" {
+				if modules, err = filterByQuery(absPath, workspace, modules, queryText, &changes); err != nil {
+					return err
+				}
+			}
+
+			var weights map[string]float64
+			if durations != "" {
+				if weights, err = readDurations(durations); err != nil {
+					return err
+				}
+			} else {
+				h, err := history.Load(absPath)
+				if err != nil {
+					return err
+				}
+				weights = h.Durations["test"]
+			}
+
+			group := shardModules(modules, weights, total)[index]
+			return outputAffected(group, affectedOptions{Format: OutputFormat(format), Template: tmplText, JobCommand: jobCommand}, absPath)
+		},
+	}
+}
+
+// shardModules partitions modules into n balanced groups. Modules without a
+// known weight count as the average known weight, or 1 if none is known.
+func shardModules(modules []analyzer.Module, weights map[string]float64, n int) [][]analyzer.Module {
+	fallback := 1.0
+	var sum float64
+	var known int
+	for _, m := range modules {
+		if w, ok := weights[m.Path]; ok {
+			sum += w
+			known++
+		}
+	}
+	if known > 0 && sum > 0 {
+		fallback = sum / float64(known)
+	}
+
+	byPath := make(map[string]analyzer.Module, len(modules))
+	items := make([]shard.Item, 0, len(modules))
+	for _, m := range modules {
+		byPath[m.Path] = m
+		w, ok := weights[m.Path]
+		if !ok {
+			w = fallback
+		}
+		items = append(items, shard.Item{Id: m.Path, Weight: w})
+	}
+
+	groups := make([][]analyzer.Module, n)
+	for i, g := range shard.Partition(items, n) {
+		groups[i] = make([]analyzer.Module, 0, len(g))
+		for _, item := range g {
+			groups[i] = append(groups[i], byPath[item.Id])
+		}
+	}
+	return groups
+}
+
+// readDurations reads a JSON object mapping module paths to seconds
+func readDurations(file string) (map[string]float64, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read durations: %w", err)
+	}
+	var durations map[string]float64
+	if err := json.Unmarshal(data, &durations); err != nil {
+		return nil, fmt.Errorf("failed to parse durations: %w", err)
+	}
+	return durations, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/gotest"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/nicolasgere/knit/lib/shard"
+)
+
+// testSplit selects one of the balanced groups the tests of every module are
+// split into, for 'knit test --split'
+type testSplit struct {
+	total, index int
+	// durations are the recorded durations of the tests, in seconds
+	durations map[string]float64
+}
+
+// newTestSplit validates the --split flags and loads the durations of the
+// tests
+func newTestSplit(workspaceRoot string, total, index int) (*testSplit, error) {
+	if total < 1 {
+		return nil, fmt.Errorf("--split must be at least 1")
+	}
+	if index < 0 || index >= total {
+		return nil, fmt.Errorf("--split-index must be between 0 and %d", total-1)
+	}
+	h, err := history.Load(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	return &testSplit{total: total, index: index, durations: h.TestDurations}, nil
+}
+
+// command returns the go test command running the tests of the split in
+// module m, with flags. The packages whose tests all are in the split run in
+// one go test, the others in a go test each selecting their tests with -run.
+func (s testSplit) command(m analyzer.Module, flags string) (string, error) {
+	tests, err := gotest.ListTests(m.Dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to list the tests of %s: %w", m.Path, err)
+	}
+	group := s.partition(m.Path, tests)[s.index]
+
+	perDir := make(map[string]int)
+	for _, t := range tests {
+		perDir[t.Dir]++
+	}
+	selected := make(map[string][]string)
+	for _, item := range group {
+		dir, name, _ := strings.Cut(item.Id, " ")
+		selected[dir] = append(selected[dir], regexp.QuoteMeta(name))
+	}
+
+	var whole, commands []string
+	for _, dir := range sortedKeys(selected) {
+		pattern := packagePattern(dir)
+		if len(selected[dir]) == perDir[dir] {
+			whole = append(whole, pattern)
+			continue
+		}
+		commands = append(commands, "go test"+flags+" -run "+shellQuote("^("+strings.Join(selected[dir], "|")+")$")+" "+pattern)
+	}
+	if len(whole) > 0 {
+		commands = append([]string{"go test" + flags + " " + strings.Join(whole, " ")}, commands...)
+	}
+
+	if len(commands) == 0 {
+		return "echo " + shellQuote(fmt.Sprintf("no tests in split %d of %d", s.index, s.total)), nil
+	}
+	return allCommands(commands), nil
+}
+
+// allCommands returns a shell command running every command, failing when
+// one of them fails
+func allCommands(commands []string) string {
+	if len(commands) == 1 {
+		return commands[0]
+	}
+	return "status=0; " + strings.Join(commands, " || status=1; ") + " || status=1; exit $status"
+}
+
+// minTestWeight is the weight of the tests recorded as instant, the precision
+// of the durations of go test
+const minTestWeight = 0.01
+
+// partition splits the tests of a module into balanced groups, weighted by
+// their recorded durations. Tests without one weigh the average of the known
+// durations, or 1 if none is known, like the modules of 'knit shard'.
+func (s testSplit) partition(module string, tests []gotest.Test) [][]shard.Item {
+	weights := make([]float64, len(tests))
+	known := make([]bool, len(tests))
+	var sum float64
+	var count int
+	for i, t := range tests {
+		if d, ok := s.durations[packagePath(module, t.Dir)+"."+t.Name]; ok {
+			// Tests quicker than go test tells still take some time
+			weights[i], known[i] = max(d, minTestWeight), true
+			sum += d
+			count++
+		}
+	}
+	fallback := 1.0
+	if count > 0 && sum > 0 {
+		fallback = sum / float64(count)
+	}
+
+	items := make([]shard.Item, len(tests))
+	for i, t := range tests {
+		if !known[i] {
+			weights[i] = fallback
+		}
+		items[i] = shard.Item{Id: t.Dir + " " + t.Name, Weight: weights[i]}
+	}
+	return shard.Partition(items, s.total)
+}
+
+// packagePattern returns the go command pattern of the package in dir,
+// relative to the module root
+func packagePattern(dir string) string {
+	if dir == "." {
+		return "."
+	}
+	return "./" + dir
+}
+
+// packagePath returns the import path of the package in dir, relative to the
+// root of the module at path
+func packagePath(module, dir string) string {
+	if dir == "." {
+		return module
+	}
+	return module + "/" + dir
+}
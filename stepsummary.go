@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicolasgere/knit/lib/cache"
+	"github.com/nicolasgere/knit/lib/runner"
+)
+
+// coverageLine matches the coverage go test -cover prints for a package
+var coverageLine = regexp.MustCompile(`coverage: (\d+(?:\.\d+)?)% of statements`)
+
+// packageCoverage collects the coverage of the packages of a module from the
+// output of its task
+type packageCoverage struct {
+	mu       sync.Mutex
+	percents []float64
+}
+
+func (c *packageCoverage) record(line []byte) {
+	m := coverageLine.FindSubmatch(line)
+	if m == nil {
+		return
+	}
+	percent, err := strconv.ParseFloat(string(m[1]), 64)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.percents = append(c.percents, percent)
+}
+
+// average returns the mean coverage of the packages, false when the output
+// reported none
+func (c *packageCoverage) average() (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.percents) == 0 {
+		return 0, false
+	}
+	sum := 0.0
+	for _, p := range c.percents {
+		sum += p
+	}
+	return sum / float64(len(c.percents)), true
+}
+
+// writeStepSummary appends a Markdown table of the run to the file named by
+// GITHUB_STEP_SUMMARY, shown on the summary page of GitHub Actions
+// workflows. Nothing is written outside of GitHub Actions or when
+// KNIT_STEP_SUMMARY is off. entries holds the cached results, coverage the
+// coverage printed by each module, both indexed like tasks, and tests the
+// results of their tests with --json.
+func writeStepSummary(name string, tasks []runner.Task, results []runner.TaskResult, entries []*cache.Entry, coverage []packageCoverage, tests *testRun, affected bool) {
+	file := os.Getenv("GITHUB_STEP_SUMMARY")
+	if file == "" || os.Getenv("KNIT_STEP_SUMMARY") == "off" {
+		return
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write the step summary: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(stepSummary(name, tasks, results, entries, coverage, tests, affected)); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write the step summary: %v\n", err)
+	}
+}
+
+func stepSummary(name string, tasks []runner.Task, results []runner.TaskResult, entries []*cache.Entry, coverage []packageCoverage, tests *testRun, affected bool) string {
+	var b strings.Builder
+	if len(tasks) == 0 {
+		fmt.Fprintf(&b, "### knit %s: no affected module\n\n", name)
+		return b.String()
+	}
+
+	failures := 0
+	withCoverage := false
+	for i, result := range results {
+		if result.Status != 0 {
+			failures++
+		}
+		if _, ok := coverage[i].average(); ok {
+			withCoverage = true
+		}
+	}
+	of := fmt.Sprintf("%d modules", len(tasks))
+	if len(tasks) == 1 {
+		of = "1 module"
+	}
+	if affected {
+		of += " affected"
+	}
+	if failures > 0 {
+		fmt.Fprintf(&b, "### ❌ knit %s: %d of %s failed\n\n", name, failures, of)
+	} else {
+		fmt.Fprintf(&b, "### ✅ knit %s: %s passed\n\n", name, of)
+	}
+
+	b.WriteString("| Module | Result | Duration |")
+	if tests != nil {
+		b.WriteString(" Tests |")
+	}
+	if withCoverage {
+		b.WriteString(" Coverage |")
+	}
+	b.WriteString("\n|---|---|---:|")
+	if tests != nil {
+		b.WriteString("---|")
+	}
+	if withCoverage {
+		b.WriteString("---:|")
+	}
+	b.WriteString("\n")
+	for i, task := range tasks {
+		result, duration := "✅ passed", results[i].Duration
+		switch {
+		case entries != nil && entries[i] != nil:
+			result, duration = "✅ cached", entries[i].Elapsed
+		case results[i].Status != 0:
+			result = fmt.Sprintf("❌ failed (exit %d)", results[i].Status)
+		case tests != nil && tests.retried[i]:
+			result = "✅ passed on retrying flaky tests"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s |", task.Id, result, duration.Round(time.Millisecond))
+		if tests != nil {
+			if ran := entries == nil || entries[i] == nil; ran {
+				fmt.Fprintf(&b, " %s |", tests.counts(i))
+			} else {
+				b.WriteString(" |")
+			}
+		}
+		if withCoverage {
+			if percent, ok := coverage[i].average(); ok {
+				fmt.Fprintf(&b, " %.1f%% |", percent)
+			} else {
+				b.WriteString(" |")
+			}
+		}
+		b.WriteString("\n")
+	}
+	if withCoverage {
+		b.WriteString("\nCoverage is the average of the packages of each module.\n")
+	}
+	if tests != nil && len(tests.failedTests()) > 0 {
+		b.WriteString("\n<details><summary>Failed tests</summary>\n\n")
+		for _, t := range tests.failedTests() {
+			fmt.Fprintf(&b, "- %s\n", t)
+		}
+		b.WriteString("\n</details>\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
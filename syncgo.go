@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	goversion "go/version"
+	"path/filepath"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/mod/modfile"
+)
+
+// goFileDirectives are the directives of one go.mod or go.work file
+type goFileDirectives struct {
+	File string
+	// Rel is File relative to the workspace root, for display
+	Rel        string
+	Directives analyzer.GoDirectives
+}
+
+// createSyncGoCommand creates the 'sync-go' command updating the go and
+// toolchain directives of every go.mod and of go.work
+func createSyncGoCommand() *cli.Command {
+	var (
+		path      string
+		version   string
+		toolchain string
+		check     bool
+	)
+
+	return &cli.Command{
+		Name:  "sync-go",
+		Usage: "Set the go and toolchain directives of every go.mod and go.work",
+		Description: `Update the go directive, and the toolchain directive with --toolchain, of the
+go.mod file of every workspace module and of go.work. A toolchain older than
+the new go version is dropped. With --check nothing is written and the command
+fails when the files do not all use the same versions, or the given ones.
+
+Examples:
+  knit sync-go --version 1.23
+  knit sync-go --version 1.23.2 --toolchain go1.23.4
+  knit sync-go --check                  # Fail when versions have drifted
+  knit sync-go --check --version 1.23   # Fail unless every file uses go 1.23`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "version",
+				Usage:       "Go version of the go directives, e.g. 1.23 or 1.23.2",
+				Destination: &version,
+			},
+			&cli.StringFlag{
+				Name:        "toolchain",
+				Usage:       "Toolchain of the toolchain directives, e.g. go1.23.4",
+				Destination: &toolchain,
+			},
+			&cli.BoolFlag{
+				Name:        "check",
+				Usage:       "Only check the directives, exiting with code 1 when they differ",
+				Destination: &check,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if version == "" && !check {
+				return fmt.Errorf("--version is required unless --check is set")
+			}
+			if version != "" && !modfile.GoVersionRE.MatchString(version) {
+				return fmt.Errorf("invalid go version %q", version)
+			}
+			if toolchain != "" && !modfile.ToolchainRE.MatchString(toolchain) {
+				return fmt.Errorf("invalid toolchain %q, expected e.g. go1.23.4", toolchain)
+			}
+			if toolchain != "" && version != "" && goversion.Compare(toolchain, "go"+version) < 0 {
+				return fmt.Errorf("toolchain %s is older than go %s", toolchain, version)
+			}
+
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			files, err := readGoFiles(absPath, modules)
+			if err != nil {
+				return err
+			}
+
+			want := analyzer.GoDirectives{Go: version, Toolchain: toolchain}
+			if check {
+				return checkGoDirectives(files, want)
+			}
+			return syncGoDirectives(files, want)
+		},
+	}
+}
+
+// readGoFiles reads the directives of the go.mod of every module, followed
+// by go.work when the workspace has one
+func readGoFiles(absPath string, modules []analyzer.Module) ([]goFileDirectives, error) {
+	paths := make([]string, 0, len(modules)+1)
+	for _, m := range modules {
+		paths = append(paths, m.GoMod)
+	}
+	work, err := analyzer.WorkFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if work != "" {
+		paths = append(paths, work)
+	}
+
+	files := make([]goFileDirectives, 0, len(paths))
+	for _, p := range paths {
+		d, err := analyzer.ReadGoDirectives(p)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(absPath, p)
+		if err != nil {
+			rel = p
+		}
+		files = append(files, goFileDirectives{File: p, Rel: rel, Directives: d})
+	}
+	return files, nil
+}
+
+// checkGoDirectives fails when files use other versions than want, or when
+// no version is wanted, versions different from each other
+func checkGoDirectives(files []goFileDirectives, want analyzer.GoDirectives) error {
+	if want.Go == "" {
+		versions := make(map[string]bool)
+		for _, f := range files {
+			versions[f.Directives.Go] = true
+		}
+		if len(versions) == 1 {
+			fmt.Printf("✓ %d file(s) use go %s\n", len(files), files[0].Directives.Go)
+			return nil
+		}
+		for _, f := range files {
+			fmt.Printf("  %s: go %s\n", f.Rel, f.Directives.Go)
+		}
+		return cli.Exit(fmt.Sprintf("go versions have drifted across %d file(s), align them with 'knit sync-go --version'", len(files)), 1)
+	}
+
+	drifted := 0
+	for _, f := range files {
+		if f.Directives.Go != want.Go {
+			drifted++
+			fmt.Printf("✗ %s: go %s, want %s\n", f.Rel, f.Directives.Go, want.Go)
+		} else if want.Toolchain != "" && f.Directives.Toolchain != want.Toolchain {
+			drifted++
+			fmt.Printf("✗ %s: toolchain %s, want %s\n", f.Rel, displayToolchain(f.Directives.Toolchain), want.Toolchain)
+		}
+	}
+	if drifted > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d file(s) out of sync", drifted, len(files)), 1)
+	}
+	fmt.Printf("✓ %d file(s) use go %s\n", len(files), want.Go)
+	return nil
+}
+
+// syncGoDirectives rewrites the files whose directives differ from want
+func syncGoDirectives(files []goFileDirectives, want analyzer.GoDirectives) error {
+	updated := 0
+	for _, f := range files {
+		if f.Directives.Go == want.Go && (want.Toolchain == "" || f.Directives.Toolchain == want.Toolchain) {
+			continue
+		}
+		if err := analyzer.SetGoDirectives(f.File, want); err != nil {
+			return err
+		}
+		updated++
+		fmt.Printf("%s: go %s -> %s\n", f.Rel, f.Directives.Go, want.Go)
+	}
+	fmt.Printf("Updated %d of %d file(s)\n", updated, len(files))
+	return nil
+}
+
+func displayToolchain(toolchain string) string {
+	if toolchain == "" {
+		return "(none)"
+	}
+	return toolchain
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+)
+
+// resolveTargets returns the modules selected by --target patterns, in
+// workspace order. A pattern matching nothing falls back to an unambiguous
+// suffix match on the module path (-t api for example.com/api), otherwise it
+// is an error suggesting the closest module paths.
+func resolveTargets(modules []analyzer.Module, patterns []string, workspaceRoot string) ([]analyzer.Module, error) {
+	selected := make(map[string]bool)
+	for _, p := range patterns {
+		matches := filterModules(modules, []string{p}, workspaceRoot)
+		if len(matches) == 0 {
+			matches = suffixMatches(modules, p)
+			switch len(matches) {
+			case 0:
+				return nil, unknownTargetError(modules, p)
+			case 1:
+				fmt.Fprintf(os.Stderr, "target %q resolved to %s\n", p, matches[0].Path)
+			default:
+				paths := make([]string, len(matches))
+				for i, m := range matches {
+					paths[i] = m.Path
+				}
+				return nil, fmt.Errorf("target %q is ambiguous, it matches %s", p, strings.Join(paths, ", "))
+			}
+		}
+		for _, m := range matches {
+			selected[m.Path] = true
+		}
+	}
+
+	resolved := make([]analyzer.Module, 0, len(selected))
+	for _, m := range modules {
+		if selected[m.Path] {
+			resolved = append(resolved, m)
+		}
+	}
+	return resolved, nil
+}
+
+// suffixMatches returns the modules whose path ends with "/"+suffix
+func suffixMatches(modules []analyzer.Module, suffix string) []analyzer.Module {
+	suffix = "/" + strings.Trim(suffix, "/")
+	var matches []analyzer.Module
+	for _, m := range modules {
+		if strings.HasSuffix(m.Path, suffix) {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// unknownTargetError reports a target matching no module, with up to three of
+// the closest module paths as suggestions
+func unknownTargetError(modules []analyzer.Module, target string) error {
+	type candidate struct {
+		path     string
+		distance int
+	}
+	// Allow roughly one typo every three characters
+	threshold := max(2, len(target)/3)
+
+	var candidates []candidate
+	for _, m := range modules {
+		d := min(levenshtein(target, m.Path), levenshtein(target, shortName(m.Path)))
+		if d <= threshold {
+			candidates = append(candidates, candidate{m.Path, d})
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no module matches target %q", target)
+	}
+
+	// Only suggest the closest paths
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	suggestions := make([]string, 0, 3)
+	for i := 0; i < len(candidates) && i < 3 && candidates[i].distance == candidates[0].distance; i++ {
+		suggestions = append(suggestions, candidates[i].path)
+	}
+	return fmt.Errorf("no module matches target %q, did you mean %s?", target, strings.Join(suggestions, " or "))
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
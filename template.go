@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+)
+
+// templateModule is the data exposed for each module to --template
+type templateModule struct {
+	Path         string
+	Dir          string
+	RelDir       string
+	Name         string
+	GoVersion    string
+	Main         bool
+	Dependencies []string
+}
+
+// templateData is the root object passed to --template
+type templateData struct {
+	WorkspaceRoot string
+	Modules       []templateModule
+}
+
+// newTemplateData builds the template data for modules. adjMap may be nil when
+// dependencies are not known, leaving Dependencies empty.
+func newTemplateData[T any](modules []analyzer.Module, workspaceRoot string, adjMap map[string]map[string]T) templateData {
+	data := templateData{
+		WorkspaceRoot: workspaceRoot,
+		Modules:       make([]templateModule, 0, len(modules)),
+	}
+
+	for _, m := range modules {
+		relDir, err := filepath.Rel(workspaceRoot, m.Dir)
+		if err != nil {
+			relDir = m.Dir
+		}
+
+		deps := make([]string, 0, len(adjMap[m.Path]))
+		for dep := range adjMap[m.Path] {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+
+		data.Modules = append(data.Modules, templateModule{
+			Path:         m.Path,
+			Dir:          m.Dir,
+			RelDir:       relDir,
+			Name:         shortName(m.Path),
+			GoVersion:    m.GoVersion,
+			Main:         m.Main,
+			Dependencies: deps,
+		})
+	}
+	return data
+}
+
+// commandData is the data task commands and module hooks are expanded with
+type commandData struct {
+	Module        templateModule
+	WorkspaceRoot string
+	// ShortName is the last element of the module path
+	ShortName string
+}
+
+// expandCommand executes cmd as a Go template with the variables of module.
+// Commands without actions are returned as is.
+func expandCommand(cmd string, module analyzer.Module, workspaceRoot string) (string, error) {
+	if !strings.Contains(cmd, "{{") {
+		return cmd, nil
+	}
+	tmpl, err := template.New("command").Funcs(templateFuncs).Option("missingkey=error").Parse(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse command %q: %w", cmd, err)
+	}
+	m := newTemplateData[struct{}]([]analyzer.Module{module}, workspaceRoot, nil).Modules[0]
+	var b strings.Builder
+	if err := tmpl.Execute(&b, commandData{Module: m, WorkspaceRoot: workspaceRoot, ShortName: m.Name}); err != nil {
+		return "", fmt.Errorf("failed to expand command %q for %s: %w", cmd, module.Path, err)
+	}
+	return b.String(), nil
+}
+
+// renderTemplate executes the Go template text against data and writes it to stdout
+func renderTemplate(text string, data templateData) error {
+	if text == "" {
+		return fmt.Errorf("--template is required with -f template")
+	}
+
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(unescapeTemplateText(text))
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	if err := tmpl.Execute(os.Stdout, data); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+	return nil
+}
+
+// templateFuncs are the helper functions available to --template
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// templateEscapes interprets escape sequences that shells pass through
+// literally in single quotes, e.g. '{{range .Modules}}{{.Path}}\n{{end}}'
+var templateEscapes = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\0`, "\x00", `\\`, `\`)
+
+// unescapeTemplateText applies templateEscapes to the text outside of
+// {{ }} actions, so string literals inside actions keep Go semantics
+func unescapeTemplateText(text string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, "{{")
+		if start == -1 {
+			b.WriteString(templateEscapes.Replace(text))
+			return b.String()
+		}
+		b.WriteString(templateEscapes.Replace(text[:start]))
+
+		end := strings.Index(text[start:], "}}")
+		if end == -1 {
+			b.WriteString(text[start:])
+			return b.String()
+		}
+		b.WriteString(text[start : start+end+2])
+		text = text[start+end+2:]
+	}
+}
+
+// shortName returns the last element of a module path
+func shortName(modulePath string) string {
+	if idx := strings.LastIndex(modulePath, "/"); idx != -1 {
+		return modulePath[idx+1:]
+	}
+	return modulePath
+}
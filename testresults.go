@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nicolasgere/knit/lib/gotest"
+	"github.com/nicolasgere/knit/lib/history"
+)
+
+// slowestTests is the number of slowest tests printed after a run
+const slowestTests = 5
+
+// slowestPrecision is the precision of the durations of the slowest tests
+const slowestPrecision = 10 * time.Millisecond
+
+// testRun holds the results of the tests of a run of go test -json, indexed
+// like its tasks
+type testRun struct {
+	tests []gotest.Results
+	// retried tells the tasks that passed once their flaky tests were retried
+	retried []bool
+	// history tells the tests known to be flaky
+	history *history.History
+}
+
+func newTestRun(tasks int, h *history.History) *testRun {
+	return &testRun{tests: make([]gotest.Results, tasks), retried: make([]bool, tasks), history: h}
+}
+
+// testKey names a test in the history, as package.Test
+func testKey(t gotest.Result) string {
+	return t.Package + "." + t.Test
+}
+
+// flakyMark returns the mark of a test known to be flaky
+func (run *testRun) flakyMark(t gotest.Result) string {
+	if run.history.IsFlaky(testKey(t)) {
+		return " (flaky)"
+	}
+	return ""
+}
+
+// print prints the counts of the tests run, the failed ones and the slowest
+// ones
+func (run *testRun) print() {
+	var results []gotest.Result
+	for i := range run.tests {
+		results = append(results, run.tests[i].Tests()...)
+	}
+	counts := gotest.Count(results)
+	if counts.Total() == 0 {
+		return
+	}
+	fmt.Printf("\nTests: %s\n", counts)
+
+	if counts.Failed > 0 {
+		fmt.Println("Failed tests:")
+		for _, t := range results {
+			if t.Status == gotest.Failed {
+				fmt.Printf("  %s %s%s\n", t.Package, t.Test, run.flakyMark(t))
+			}
+		}
+	}
+
+	// Instant tests would be noise
+	var slowest []gotest.Result
+	for _, t := range gotest.Slowest(results, slowestTests) {
+		if t.Elapsed >= slowestPrecision {
+			slowest = append(slowest, t)
+		}
+	}
+	if len(slowest) == 0 {
+		return
+	}
+	fmt.Println("Slowest tests:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, t := range slowest {
+		fmt.Fprintf(w, "  %s\t%s %s\n", t.Elapsed.Round(slowestPrecision), t.Package, t.Test)
+	}
+	w.Flush()
+}
+
+// failedTests returns the failed tests of every task as package.Test, the
+// ones known to be flaky marked
+func (run *testRun) failedTests() []string {
+	var names []string
+	for i := range run.tests {
+		for _, t := range run.tests[i].Tests() {
+			if t.Status == gotest.Failed {
+				names = append(names, "`"+testKey(t)+"`"+run.flakyMark(t))
+			}
+		}
+	}
+	return names
+}
+
+// counts renders the counts of the tests of a task for the step summary
+func (run *testRun) counts(i int) string {
+	c := gotest.Count(run.tests[i].Tests())
+	if c.Total() == 0 {
+		return "no tests"
+	}
+	return c.String()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	goversion "go/version"
+	"os"
+	"path/filepath"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+)
+
+// moduleToolchains returns the GOTOOLCHAIN setting of the tasks of every
+// module declaring a toolchain directive newer than the toolchain selected for
+// the workspace, keyed by module path. The go command only follows the
+// directives of go.work in workspace mode, so without it such a module would
+// be built with an older toolchain than it asks for. A module asking for an
+// older toolchain keeps the workspace one, as it would on its own, and is
+// warned about. Nothing is set when the environment sets GOTOOLCHAIN to
+// another value than the default auto, such as local.
+func moduleToolchains(workspaceRoot string, modules []analyzer.Module) (map[string]string, error) {
+	if v := os.Getenv("GOTOOLCHAIN"); v != "" && v != "auto" {
+		return nil, nil
+	}
+	declared := make(map[string]string)
+	for _, m := range modules {
+		goMod := m.GoMod
+		if goMod == "" {
+			goMod = filepath.Join(m.Dir, "go.mod")
+		}
+		d, err := analyzer.ReadGoDirectives(goMod)
+		if err != nil {
+			return nil, err
+		}
+		if d.Toolchain != "" && d.Toolchain != "default" {
+			declared[m.Path] = d.Toolchain
+		}
+	}
+	if len(declared) == 0 {
+		return nil, nil
+	}
+
+	workspace, err := goToolchain(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string)
+	var older []string
+	for _, path := range sortedKeys(declared) {
+		toolchain := declared[path]
+		switch c := goversion.Compare(toolchain, workspace); {
+		case c > 0:
+			// The +auto suffix still switches to a newer toolchain when a
+			// dependency requires one
+			settings[path] = "GOTOOLCHAIN=" + toolchain + "+auto"
+			fmt.Fprintf(os.Stderr, "%s declares toolchain %s, newer than the workspace %s: its tasks run with GOTOOLCHAIN=%s+auto\n", path, toolchain, workspace, toolchain)
+		case c < 0:
+			older = append(older, path+" ("+toolchain+")")
+		}
+	}
+	if len(older) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: toolchain directives older than the workspace %s, which the tasks run with: %s\n", workspace, strings.Join(older, ", "))
+	}
+	return settings, nil
+}
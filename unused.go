@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nicolasgere/knit/lib/unused"
+	"github.com/urfave/cli/v2"
+)
+
+// createUnusedCommand creates the 'unused' command reporting the exported
+// functions and types of library modules nothing else in the workspace uses
+func createUnusedCommand() *cli.Command {
+	var (
+		path        string
+		crossModule bool
+		targets     cli.StringSlice
+		jsonOutput  bool
+	)
+
+	return &cli.Command{
+		Name:  "unused",
+		Usage: "Report the exported functions and types nothing else in the workspace uses",
+		Description: `Type-check every package of the workspace, tests included, and report the
+exported package-level functions and types of the library packages no other
+package uses: candidates to unexport or delete. The uses of a package
+itself and of its tests do not count. Types whose methods or fields are
+used, or that are part of the signature of a used function, are used.
+
+With --cross-module, only the uses from other modules count, reporting the
+API a module exports that only the module itself needs; internal packages
+are then left out. The exported API of modules imported from outside the
+workspace may be used by code knit does not see.
+
+Examples:
+  knit unused
+  knit unused --cross-module -t example.com/platform/...`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "cross-module",
+				Usage:       "Only count the uses from other modules",
+				Destination: &crossModule,
+			},
+			&cli.StringSliceFlag{
+				Name:        "target",
+				Usage:       "Only report the identifiers of these modules, globs allowed, '/...' matches a whole prefix (repeatable)",
+				Aliases:     []string{"t"},
+				Destination: &targets,
+			},
+			&cli.BoolFlag{
+				Name:        "json",
+				Usage:       "Output the identifiers as JSON",
+				Destination: &jsonOutput,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			identifiers, err := unused.Find(modules, crossModule)
+			if err != nil {
+				return err
+			}
+			if patterns := targets.Value(); len(patterns) > 0 {
+				targeted, err := resolveTargets(modules, patterns, absPath)
+				if err != nil {
+					return err
+				}
+				var kept []unused.Identifier
+				for _, id := range identifiers {
+					if hasModule(targeted, id.Module) {
+						kept = append(kept, id)
+					}
+				}
+				identifiers = kept
+			}
+			for i := range identifiers {
+				if rel, err := filepath.Rel(absPath, identifiers[i].File); err == nil {
+					identifiers[i].File = rel
+				}
+			}
+
+			if jsonOutput {
+				if identifiers == nil {
+					identifiers = []unused.Identifier{}
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(identifiers)
+			}
+			if len(identifiers) == 0 {
+				fmt.Println("✓ No unused exported function or type")
+				return nil
+			}
+			module := ""
+			for _, id := range identifiers {
+				if id.Module != module {
+					module = id.Module
+					fmt.Println(module)
+				}
+				fmt.Printf("  %s %s.%s  %s:%d\n", id.Kind, shortName(id.Package), id.Name, id.File, id.Line)
+			}
+			fmt.Printf("\n%d unused exported identifier(s)\n", len(identifiers))
+			return nil
+		},
+	}
+}
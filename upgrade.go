@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/history"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/nicolasgere/knit/lib/runner"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/mod/semver"
+)
+
+// upgradeResult is the outcome of upgrading dependencies in a module
+type upgradeResult struct {
+	Module   string
+	Upgrades []dependencyUpgrade
+	// Changed is set when go.mod or go.sum changed
+	Changed bool
+}
+
+// dependencyUpgrade is a dependency whose required version changed
+type dependencyUpgrade struct {
+	Dependency string
+	// From and To are the required versions before and after the upgrade,
+	// To being "removed" when go mod tidy dropped the requirement
+	From, To string
+}
+
+// verifyTasks are the tasks --verify runs on the modules impacted by the
+// upgrades, in order
+var verifyTasks = []struct{ name, cmd string }{
+	{"build", "go build ./..."},
+	{"test", "go test ./..."},
+}
+
+// createUpgradeCommand creates the 'upgrade' command upgrading dependencies
+// in every workspace module requiring them
+func createUpgradeCommand(r *runner.Runner) *cli.Command {
+	var (
+		path       string
+		affected   bool
+		base       string
+		changes    changeFlags
+		queryText  string
+		tags       cli.StringSlice
+		noTidy     bool
+		all        bool
+		verify     bool
+		reportFile string
+	)
+
+	return &cli.Command{
+		Name:      "upgrade",
+		Usage:     "Upgrade dependencies in every module requiring them, with go get and go mod tidy",
+		ArgsUsage: "<dependency>@<version> | --all [dependency...]",
+		Description: `Run 'go get <dependency>@<version>' then 'go mod tidy' in every workspace
+module directly requiring the dependency, one module at a time, and report
+the modules whose go.mod or go.sum changed. The version is any version query
+of go get, such as v1.9.0, latest or a commit. --affected, --tag and --query
+restrict the upgrade to some of the modules requiring the dependency.
+
+With --all, every direct dependency of the selected modules that has a newer
+version of the same major version, as told by 'go list -m -u', is upgraded
+to it, or only the dependencies given as arguments. --verify then builds and
+tests the modules whose go.mod changed and the modules depending on them,
+and --report writes a Markdown report of the upgrades and of their
+verification, to use as the description of a pull request.
+
+Examples:
+  knit upgrade github.com/some/dep@v1.9.0
+  knit upgrade --tag backend golang.org/x/net@latest
+  knit upgrade --affected --base origin/main github.com/some/dep@v1.9.0
+  knit upgrade --all --verify --report pr.md`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "affected",
+				Usage:       "Upgrade only affected modules (since merge-base)",
+				Aliases:     []string{"a"},
+				Destination: &affected,
+			},
+			&cli.StringFlag{
+				Name:        "base",
+				Usage:       "Git reference to compare against when using --affected (default: main)",
+				Aliases:     []string{"b"},
+				Value:       "main",
+				Destination: &base,
+			},
+			&cli.StringSliceFlag{
+				Name:        "tag",
+				Usage:       "Upgrade only modules with this tag in knit.yaml (repeatable, any tag matches)",
+				Destination: &tags,
+			},
+			queryFlag(&queryText),
+			&cli.BoolFlag{
+				Name:        "no-tidy",
+				Usage:       "Do not run 'go mod tidy' after 'go get'",
+				Destination: &noTidy,
+			},
+			&cli.BoolFlag{
+				Name:        "all",
+				Usage:       "Upgrade every outdated direct dependency, or the ones given as arguments, within its major version",
+				Destination: &all,
+			},
+			&cli.BoolFlag{
+				Name:        "verify",
+				Usage:       "Build and test the modules impacted by the upgrades",
+				Destination: &verify,
+			},
+			&cli.StringFlag{
+				Name:        "report",
+				Usage:       "Write a Markdown report of the upgrades and of their verification to `FILE`",
+				Destination: &reportFile,
+			},
+		}, changes.flags()...),
+		Action: func(c *cli.Context) error {
+			var dependency, version string
+			if all {
+				for _, arg := range c.Args().Slice() {
+					if strings.Contains(arg, "@") {
+						return fmt.Errorf("--all upgrades %s to its latest version, expected no @<version>", arg)
+					}
+				}
+			} else {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected a dependency: knit upgrade [flags] <dependency>@<version>, or --all")
+				}
+				var ok bool
+				dependency, version, ok = strings.Cut(c.Args().First(), "@")
+				if !ok || dependency == "" || version == "" {
+					return fmt.Errorf("invalid dependency %q, expected <dependency>@<version>", c.Args().First())
+				}
+			}
+
+			absPath, workspace, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			reqs, err := analyzer.ListRequirements(workspace)
+			if err != nil {
+				return err
+			}
+			for _, dep := range append(c.Args().Slice(), dependency) {
+				if dep != "" && !strings.Contains(dep, "@") && !requiresDependency(reqs, dep) {
+					return fmt.Errorf("no workspace module requires %s", dep)
+				}
+			}
+
+			modules := workspace
+			if affected || changes.filesFrom != "" {
+				src, err := changes.source(base, true)
+				if err != nil {
+					return err
+				}
+				if modules, err = affectedModules(modules, absPath, src); err != nil {
+					return err
+				}
+			}
+			if len(tags.Value()) > 0 {
+				if modules, err = filterByTags(absPath, modules, tags.Value()); err != nil {
+					return err
+				}
+			}
+			if queryText != "" {
+				if modules, err = filterByQuery(absPath, workspace, modules, queryText, &changes); err != nil {
+					return err
+				}
+			}
+
+			var results []upgradeResult
+			for _, m := range modules {
+				targets := make(map[string]string)
+				if all {
+					if targets, err = outdatedDependencies(m, reqs[m.Path], c.Args().Slice()); err != nil {
+						return err
+					}
+				} else if requiredVersion(reqs[m.Path], dependency) != "" {
+					targets[dependency] = version
+				}
+				if len(targets) == 0 {
+					continue
+				}
+				fmt.Printf("Upgrading %s in %s\n", strings.Join(sortedKeys(targets), ", "), m.Path)
+				result, err := upgradeModule(m, reqs[m.Path], targets, noTidy)
+				if err != nil {
+					return err
+				}
+				results = append(results, result)
+			}
+			if len(results) == 0 {
+				if all {
+					fmt.Println("Dependencies are up to date")
+				} else {
+					fmt.Printf("No selected module requires %s\n", dependency)
+				}
+				return nil
+			}
+
+			fmt.Println()
+			var changed []string
+			for _, r := range results {
+				if !r.Changed {
+					fmt.Printf("- %s: unchanged\n", r.Module)
+					continue
+				}
+				changed = append(changed, r.Module)
+				fmt.Printf("✓ %s: %s\n", r.Module, describeUpgrades(r.Upgrades))
+			}
+			fmt.Printf("%d of %d module(s) changed\n", len(changed), len(results))
+
+			var verification *upgradeVerification
+			var verifyErr error
+			if verify && len(changed) > 0 {
+				verification, verifyErr = verifyUpgrades(absPath, workspace, changed, r)
+				if verification == nil {
+					return verifyErr
+				}
+			}
+			if reportFile != "" {
+				var b strings.Builder
+				writeUpgradeReport(&b, results, verification)
+				if err := os.WriteFile(reportFile, []byte(b.String()), 0644); err != nil {
+					return fmt.Errorf("failed to write the report: %w", err)
+				}
+			}
+			return verifyErr
+		},
+	}
+}
+
+// filterByTags keeps the modules with one of the tags in knit.yaml
+func filterByTags(absPath string, modules []analyzer.Module, tags []string) ([]analyzer.Module, error) {
+	cfg, err := config.Load(absPath)
+	if err != nil {
+		return nil, err
+	}
+	moduleTags := cfg.Tags()
+	var kept []analyzer.Module
+	for _, m := range modules {
+		for _, t := range moduleTags[m.Path] {
+			if slices.Contains(tags, t) {
+				kept = append(kept, m)
+				break
+			}
+		}
+	}
+	return kept, nil
+}
+
+// requiredVersion returns the version at which reqs require dependency, or
+// an empty string
+func requiredVersion(reqs []analyzer.Requirement, dependency string) string {
+	for _, r := range reqs {
+		if r.Path == dependency {
+			return r.Version
+		}
+	}
+	return ""
+}
+
+// outdatedDependencies returns the direct dependencies of m with a newer
+// version of the same major version, mapped to that version. A non-empty
+// only restricts them to these dependencies.
+func outdatedDependencies(m analyzer.Module, reqs []analyzer.Requirement, only []string) (map[string]string, error) {
+	args := []string{"list", "-m", "-u", "-json"}
+	for _, r := range reqs {
+		if len(only) == 0 || slices.Contains(only, r.Path) {
+			args = append(args, r.Path)
+		}
+	}
+	outdated := make(map[string]string)
+	if len(args) == 4 {
+		return outdated, nil
+	}
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = m.Dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -m -u failed in %s: %w", m.Path, commandError(err))
+	}
+	dec := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var listed struct {
+			Path, Version string
+			Update        *struct{ Version string }
+		}
+		if err := dec.Decode(&listed); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse go list -m -u output: %w", err)
+		}
+		// The selected version may be higher than the required one when
+		// another module requires it
+		latest := listed.Version
+		if listed.Update != nil {
+			latest = listed.Update.Version
+		}
+		if semver.Compare(latest, requiredVersion(reqs, listed.Path)) > 0 {
+			outdated[listed.Path] = latest
+		}
+	}
+	return outdated, nil
+}
+
+// commandError adds the standard error of a failed command to err
+func commandError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// upgradeModule runs go get on the targets, dependencies mapped to a version,
+// and go mod tidy in m, then reports how the requirements of m changed
+func upgradeModule(m analyzer.Module, reqs []analyzer.Requirement, targets map[string]string, noTidy bool) (upgradeResult, error) {
+	result := upgradeResult{Module: m.Path}
+	files := []string{m.GoMod, filepath.Join(m.Dir, "go.sum")}
+	before := make([][]byte, len(files))
+	for i, f := range files {
+		// go.sum may not exist yet
+		before[i], _ = os.ReadFile(f)
+	}
+
+	get := []string{"get"}
+	for _, dep := range sortedKeys(targets) {
+		get = append(get, dep+"@"+targets[dep])
+	}
+	commands := [][]string{get}
+	if !noTidy {
+		commands = append(commands, []string{"mod", "tidy"})
+	}
+	for _, args := range commands {
+		cmd := exec.Command("go", args...)
+		cmd.Dir = m.Dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return result, fmt.Errorf("go %s failed in %s: %w\n%s", strings.Join(args, " "), m.Path, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	for i, f := range files {
+		after, _ := os.ReadFile(f)
+		if !bytes.Equal(before[i], after) {
+			result.Changed = true
+		}
+	}
+	after, err := analyzer.ListRequirements([]analyzer.Module{m})
+	if err != nil {
+		return result, err
+	}
+	for _, dep := range sortedKeys(targets) {
+		from, to := requiredVersion(reqs, dep), requiredVersion(after[m.Path], dep)
+		if to == "" {
+			// go mod tidy drops a requirement nothing imports
+			to = "removed"
+		}
+		if from != to {
+			result.Upgrades = append(result.Upgrades, dependencyUpgrade{Dependency: dep, From: from, To: to})
+		}
+	}
+	return result, nil
+}
+
+// describeUpgrades renders the upgrades of a module on one line
+func describeUpgrades(upgrades []dependencyUpgrade) string {
+	if len(upgrades) == 0 {
+		return "go.sum updated"
+	}
+	described := make([]string, len(upgrades))
+	for i, u := range upgrades {
+		described[i] = fmt.Sprintf("%s %s -> %s", u.Dependency, u.From, u.To)
+	}
+	return strings.Join(described, ", ")
+}
+
+// upgradeVerification is the outcome of building and testing the modules
+// impacted by upgrades
+type upgradeVerification struct {
+	// Modules are the paths of the modules built and tested
+	Modules []string
+	// Failed maps the name of each verify task to the modules it failed in
+	Failed map[string][]string
+}
+
+// verifyUpgrades runs the verify tasks with the runner on the changed modules
+// and the modules depending on them, tests included. The modules each task
+// failed in are the ones the run records in the history, as for --failed.
+// The returned error tells whether a task failed.
+func verifyUpgrades(absPath string, workspace []analyzer.Module, changed []string, r *runner.Runner) (*upgradeVerification, error) {
+	imports, err := listModuleImports(absPath, workspace, analyzer.BuildContext{})
+	if err != nil {
+		return nil, err
+	}
+	impacted := make(map[string]bool)
+	for _, m := range changed {
+		impacted[m] = true
+		for dep := range resolver.Dependents(imports, m, 0) {
+			impacted[dep] = true
+		}
+	}
+	var modules []analyzer.Module
+	v := &upgradeVerification{Failed: make(map[string][]string)}
+	for _, m := range workspace {
+		if impacted[m.Path] {
+			modules = append(modules, m)
+			v.Modules = append(v.Modules, m.Path)
+		}
+	}
+
+	var failed error
+	for _, task := range verifyTasks {
+		fmt.Printf("\nVerifying the upgrades: %s of %d module(s)\n", task.name, len(modules))
+		if err := runOnModules(absPath, task.name, task.cmd, r, modules, runOptions{}); err != nil {
+			if _, ok := err.(cli.ExitCoder); !ok {
+				return nil, err
+			}
+			failed = err
+		}
+		h, err := history.Load(absPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range h.Failed[task.name] {
+			if impacted[m] {
+				v.Failed[task.name] = append(v.Failed[task.name], m)
+			}
+		}
+	}
+	if failed != nil {
+		return v, cli.Exit("the verification of the upgrades failed", 1)
+	}
+	return v, nil
+}
+
+// writeUpgradeReport renders the upgrades and their verification as
+// Markdown, for the description of a pull request
+func writeUpgradeReport(w io.Writer, results []upgradeResult, v *upgradeVerification) {
+	fmt.Fprintln(w, "## Dependency upgrades")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Module | Dependency | From | To |")
+	fmt.Fprintln(w, "|---|---|---|---|")
+	for _, r := range results {
+		for _, u := range r.Upgrades {
+			fmt.Fprintf(w, "| `%s` | `%s` | %s | %s |\n", r.Module, u.Dependency, u.From, u.To)
+		}
+	}
+	if v == nil {
+		return
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "## Verification")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Built and tested the %d module(s) impacted by the upgrades.\n", len(v.Modules))
+	fmt.Fprintln(w)
+	fmt.Fprint(w, "| Module |")
+	for _, task := range verifyTasks {
+		fmt.Fprintf(w, " %s%s |", strings.ToUpper(task.name[:1]), task.name[1:])
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "|---"+strings.Repeat("|---", len(verifyTasks))+"|")
+	for _, m := range v.Modules {
+		fmt.Fprintf(w, "| `%s` |", m)
+		for _, task := range verifyTasks {
+			result := "✅"
+			if slices.Contains(v.Failed[task.name], m) {
+				result = "❌"
+			}
+			fmt.Fprintf(w, " %s |", result)
+		}
+		fmt.Fprintln(w)
+	}
+}
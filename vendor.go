@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// vendorDir is a vendor directory knit vendor writes, with the go command
+// writing it
+type vendorDir struct {
+	// Dir is the workspace or module directory the vendor directory is in
+	Dir string
+	// Args are the arguments of the go command, followed by -o <dir>
+	Args []string
+	// Env is added to the environment of the go command
+	Env []string
+}
+
+// createVendorCommand creates the 'vendor' command writing the vendor
+// directories of the workspace
+func createVendorCommand() *cli.Command {
+	var (
+		path      string
+		perModule bool
+		targets   cli.StringSlice
+		check     bool
+	)
+
+	return &cli.Command{
+		Name:  "vendor",
+		Usage: "Vendor the dependencies of the workspace, or check that vendor directories are up to date",
+		Description: `Run 'go work vendor' at the root of a workspace with a go.work file, writing
+a single vendor directory for every module. With --per-module, or without
+go.work, run 'go mod vendor' in every module instead, outside of workspace
+mode, for modules built on their own. With --check nothing is written: the
+dependencies are vendored to a temporary directory and compared with the
+vendor directories, and the command fails listing the files that differ.
+
+Examples:
+  knit vendor
+  knit vendor --per-module -t example.com/service/...
+  knit vendor --check   # In CI, fail when vendor/ is out of date`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "per-module",
+				Usage:       "Run 'go mod vendor' in every module instead of 'go work vendor'",
+				Destination: &perModule,
+			},
+			&cli.StringSliceFlag{
+				Name:        "target",
+				Usage:       "With --per-module, only vendor these modules, globs allowed, '/...' matches a whole prefix (repeatable)",
+				Aliases:     []string{"t"},
+				Destination: &targets,
+			},
+			&cli.BoolFlag{
+				Name:        "check",
+				Usage:       "Only check the vendor directories, exiting with code 1 when they are out of date",
+				Destination: &check,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, modules, err := loadModules(path)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(filepath.Join(absPath, "go.work")); err != nil {
+				perModule = true
+			}
+			if len(targets.Value()) > 0 {
+				if !perModule {
+					return fmt.Errorf("--target requires --per-module, go work vendor vendors the whole workspace")
+				}
+				if modules, err = resolveTargets(modules, targets.Value(), absPath); err != nil {
+					return err
+				}
+			}
+
+			dirs := []vendorDir{{Dir: absPath, Args: []string{"work", "vendor"}}}
+			if perModule {
+				dirs = dirs[:0]
+				for _, m := range modules {
+					dirs = append(dirs, vendorDir{Dir: m.Dir, Args: []string{"mod", "vendor"}, Env: []string{"GOWORK=off"}})
+				}
+			}
+			if check {
+				return checkVendor(absPath, dirs)
+			}
+			for _, d := range dirs {
+				if err := d.run(filepath.Join(d.Dir, "vendor")); err != nil {
+					return err
+				}
+				fmt.Printf("✓ Vendored %s\n", relativeVendorDir(absPath, d.Dir))
+			}
+			return nil
+		},
+	}
+}
+
+// run vendors the dependencies of d to out
+func (d vendorDir) run(out string) error {
+	args := append(append([]string(nil), d.Args...), "-o", out)
+	cmd := exec.Command("go", args...)
+	cmd.Dir = d.Dir
+	cmd.Env = append(os.Environ(), d.Env...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go %s failed in %s: %w\n%s", strings.Join(d.Args, " "), d.Dir, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// checkVendor vendors the dependencies of every directory to a temporary
+// directory and compares them with its vendor directory
+func checkVendor(absPath string, dirs []vendorDir) error {
+	tmp, err := os.MkdirTemp("", "knit-vendor-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	outdated := 0
+	for i, d := range dirs {
+		want := filepath.Join(tmp, fmt.Sprint(i))
+		if err := d.run(want); err != nil {
+			return err
+		}
+		rel := relativeVendorDir(absPath, d.Dir)
+		differences, err := diffTrees(want, filepath.Join(d.Dir, "vendor"))
+		if err != nil {
+			return err
+		}
+		if len(differences) == 0 {
+			fmt.Printf("✓ %s is up to date\n", rel)
+			continue
+		}
+		outdated++
+		fmt.Printf("✗ %s is out of date\n", rel)
+		for _, diff := range differences {
+			fmt.Printf("    %s%s/%s\n", diff[:2], rel, diff[2:])
+		}
+	}
+	if outdated > 0 {
+		return cli.Exit(fmt.Sprintf("%d vendor directory(ies) out of date, run 'knit vendor'", outdated), 1)
+	}
+	return nil
+}
+
+// relativeVendorDir returns the vendor directory of dir relative to the
+// workspace root, for display
+func relativeVendorDir(absPath, dir string) string {
+	rel, err := filepath.Rel(absPath, filepath.Join(dir, "vendor"))
+	if err != nil {
+		return filepath.Join(dir, "vendor")
+	}
+	return filepath.ToSlash(rel)
+}
+
+// diffTrees compares the files of the directory got with the ones of want,
+// either missing, and returns the differences, sorted: "+ file" for a file
+// missing from got, "- file" for a file only in got and "~ file" for a file
+// whose content differs
+func diffTrees(want, got string) ([]string, error) {
+	wantFiles, err := readTree(want)
+	if err != nil {
+		return nil, err
+	}
+	gotFiles, err := readTree(got)
+	if err != nil {
+		return nil, err
+	}
+	var differences []string
+	for name, content := range wantFiles {
+		if other, ok := gotFiles[name]; !ok {
+			differences = append(differences, "+ "+name)
+		} else if !bytes.Equal(content, other) {
+			differences = append(differences, "~ "+name)
+		}
+	}
+	for name := range gotFiles {
+		if _, ok := wantFiles[name]; !ok {
+			differences = append(differences, "- "+name)
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool { return differences[i][2:] < differences[j][2:] })
+	return differences, nil
+}
+
+// readTree reads the files under dir, keyed by slash path relative to dir,
+// an empty map when dir does not exist
+func readTree(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == dir {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	return files, nil
+}
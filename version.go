@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X main.version=v1.2.3 -X main.commit=abc123 -X main.date=2024-06-01T10:00:00Z".
+// When unset, they are read from the build information embedded by the go command.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// outputSchemaVersion is the version of the JSON outputs of knit, bumped on
+// incompatible changes so that scripts and CI caches can pin behavior
+const outputSchemaVersion = 1
+
+// buildInfo describes the running knit binary
+type buildInfo struct {
+	Version       string              `json:"version"`
+	Commit        string              `json:"commit"`
+	Date          string              `json:"date"`
+	Modified      bool                `json:"modified,omitempty"`
+	GoVersion     string              `json:"goVersion"`
+	Platform      string              `json:"platform"`
+	SchemaVersion int                 `json:"schemaVersion"`
+	Formats       map[string][]string `json:"formats"`
+}
+
+// currentBuildInfo returns the metadata of the running binary, preferring
+// values set with -ldflags
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:       version,
+		Commit:        commit,
+		Date:          date,
+		GoVersion:     runtime.Version(),
+		Platform:      runtime.GOOS + "/" + runtime.GOARCH,
+		SchemaVersion: outputSchemaVersion,
+		Formats: map[string][]string{
+			"affected": make([]string, len(affectedFormats)),
+			"graph":    graphFormats,
+		},
+	}
+	for i, f := range affectedFormats {
+		info.Formats["affected"][i] = string(f)
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// createVersionCommand creates the 'version' command printing build metadata
+func createVersionCommand() *cli.Command {
+	var asJSON bool
+
+	return &cli.Command{
+		Name:  "version",
+		Usage: "Print the version, commit and build date of knit",
+		Description: `Print the build metadata of knit, along with the version of its JSON outputs
+and the formats of the affected and graph commands, for bug reports and to
+pin behavior in CI.
+
+Examples:
+  knit version
+  knit version --json`,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:        "json",
+				Usage:       "Output a JSON object",
+				Destination: &asJSON,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			info := currentBuildInfo()
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(info)
+			}
+
+			commit := info.Commit
+			if info.Modified {
+				commit += " (modified)"
+			}
+			fmt.Printf("knit %s\n", info.Version)
+			fmt.Printf("commit:   %s\n", commit)
+			fmt.Printf("built:    %s\n", info.Date)
+			fmt.Printf("go:       %s %s\n", info.GoVersion, info.Platform)
+			fmt.Printf("schema:   %d\n", info.SchemaVersion)
+			fmt.Printf("affected: %s\n", strings.Join(info.Formats["affected"], ", "))
+			fmt.Printf("graph:    %s\n", strings.Join(info.Formats["graph"], ", "))
+			return nil
+		},
+	}
+}
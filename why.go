@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/resolver"
+	"github.com/urfave/cli/v2"
+)
+
+// createWhyCommand creates the 'why' command explaining a dependency between two modules
+func createWhyCommand() *cli.Command {
+	var (
+		path  string
+		build buildFlags
+	)
+
+	return &cli.Command{
+		Name:      "why",
+		Usage:     "Show the shortest import chains explaining why a module depends on another",
+		ArgsUsage: "<from> <to>",
+		Description: `Print every shortest chain of module dependencies from <from> to <to>,
+with the package import creating each link.
+
+Examples:
+  knit why example.com/app example.com/core
+  knit why --goos windows example.com/app example.com/core`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+		}, build.flags()...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return fmt.Errorf("expected 2 arguments: <from> <to>")
+			}
+			return runWhy(path, c.Args().Get(0), c.Args().Get(1), build.context())
+		},
+	}
+}
+
+func runWhy(path, from, to string, build analyzer.BuildContext) error {
+	absPath, modules, err := loadModules(path)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{from, to} {
+		if !hasModule(modules, name) {
+			return fmt.Errorf("unknown module: %s", name)
+		}
+	}
+
+	imports, err := listModuleImports(absPath, modules, build)
+	if err != nil {
+		return fmt.Errorf("failed to analyze imports: %w", err)
+	}
+
+	paths := resolver.ShortestPaths(imports, from, to)
+	if len(paths) == 0 {
+		fmt.Printf("%s does not depend on %s\n", from, to)
+		return nil
+	}
+
+	fmt.Printf("%s depends on %s through %d shortest path(s):\n", from, to, len(paths))
+	for i, p := range paths {
+		fmt.Printf("\n%d. %s\n", i+1, p[0])
+		for j := 1; j < len(p); j++ {
+			// Show the first import creating the edge, there may be many
+			edge := imports[p[j-1]][p[j]]
+			suffix := ""
+			if len(edge) > 1 {
+				suffix = fmt.Sprintf(" (+%d more)", len(edge)-1)
+			}
+			fmt.Printf("   └── %s\n", p[j])
+			fmt.Printf("       %s imports %s%s\n", edge[0].Package, edge[0].Imported, suffix)
+		}
+	}
+	return nil
+}
+
+// hasModule reports whether modules contains the module path
+func hasModule(modules []analyzer.Module, path string) bool {
+	for _, m := range modules {
+		if m.Path == path {
+			return true
+		}
+	}
+	return false
+}
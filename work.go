@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	goversion "go/version"
+	"os"
+	"path/filepath"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/urfave/cli/v2"
+)
+
+// createWorkCommand creates the 'work' command grouping go.work maintenance
+func createWorkCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "work",
+		Usage: "Maintain the go.work file of the workspace",
+		Subcommands: []*cli.Command{
+			createWorkSyncCommand(),
+		},
+	}
+}
+
+// createWorkSyncCommand creates the 'work sync' command listing every module
+// found on disk in go.work
+func createWorkSyncCommand() *cli.Command {
+	var (
+		path  string
+		check bool
+	)
+
+	return &cli.Command{
+		Name:  "sync",
+		Usage: "Add the modules found on disk to go.work and drop the missing ones",
+		Description: `Find every go.mod below the workspace root, skipping vendor, testdata and
+directories starting with "." or "_" like the go command, as well as
+node_modules and the directories ignored by .gitignore, and update the use
+directives of go.work: modules missing from it are added, and directives
+pointing at directories without a go.mod are dropped. go.work is created when
+missing. With --check nothing is written and the command fails when go.work
+is out of date.
+
+Examples:
+  knit work sync
+  knit work sync --check   # In CI, fail when a module is not in go.work`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "Path to the workspace root",
+				Aliases:     []string{"p"},
+				Value:       ".",
+				Destination: &path,
+			},
+			&cli.BoolFlag{
+				Name:        "check",
+				Usage:       "Only check go.work, exiting with code 1 when it is out of date",
+				Destination: &check,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+			return runWorkSync(absPath, check)
+		},
+	}
+}
+
+// workDrift compares go.work with the modules on disk
+type workDrift struct {
+	WorkFile string
+	// Exists is false when go.work is missing
+	Exists bool
+	// OnDisk lists every module directory found below the workspace root
+	OnDisk []string
+	// Missing lists the modules on disk go.work does not use
+	Missing []string
+	// Stale lists the use directives pointing at directories without go.mod
+	Stale []string
+}
+
+// findWorkDrift compares the use directives of go.work at the workspace root
+// with the modules found on disk
+func findWorkDrift(absPath string) (*workDrift, error) {
+	d := &workDrift{WorkFile: filepath.Join(absPath, "go.work")}
+	onDisk, err := analyzer.FindModuleDirs(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(onDisk) == 0 {
+		return nil, fmt.Errorf("no go.mod found in %s", absPath)
+	}
+	d.OnDisk = onDisk
+
+	var listed []string
+	if _, err := os.Stat(d.WorkFile); err == nil {
+		d.Exists = true
+		if listed, err = analyzer.ReadWorkUses(d.WorkFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// Compare directories in their cleaned form, as go.work may spell them differently
+	listedDirs := make(map[string]bool, len(listed))
+	for _, dir := range listed {
+		abs := dir
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(absPath, dir)
+		}
+		listedDirs[filepath.Clean(abs)] = true
+		if _, err := os.Stat(filepath.Join(abs, "go.mod")); err != nil {
+			d.Stale = append(d.Stale, dir)
+		}
+	}
+	for _, dir := range onDisk {
+		if !listedDirs[filepath.Join(absPath, dir)] {
+			d.Missing = append(d.Missing, dir)
+		}
+	}
+	return d, nil
+}
+
+// UpToDate reports whether go.work exists and uses exactly the modules on disk
+func (d *workDrift) UpToDate() bool {
+	return d.Exists && len(d.Missing) == 0 && len(d.Stale) == 0
+}
+
+func runWorkSync(absPath string, check bool) error {
+	d, err := findWorkDrift(absPath)
+	if err != nil {
+		return err
+	}
+	missing, stale := d.Missing, d.Stale
+
+	if d.UpToDate() {
+		fmt.Printf("✓ go.work uses all %d module(s)\n", len(d.OnDisk))
+		return nil
+	}
+
+	if !d.Exists {
+		fmt.Println("go.work does not exist")
+	}
+	for _, dir := range missing {
+		fmt.Printf("+ %s\n", dir)
+	}
+	for _, dir := range stale {
+		fmt.Printf("- %s (no go.mod)\n", dir)
+	}
+	if check {
+		return cli.Exit(fmt.Sprintf("go.work is out of date: %d module(s) missing, %d stale, run 'knit work sync'", len(missing), len(stale)), 1)
+	}
+
+	goVersion, err := highestGoVersion(absPath, d.OnDisk)
+	if err != nil {
+		return err
+	}
+	if err := analyzer.SyncWorkUses(d.WorkFile, goVersion, missing, stale); err != nil {
+		return err
+	}
+	fmt.Printf("Updated go.work: %d added, %d dropped\n", len(missing), len(stale))
+	return nil
+}
+
+// highestGoVersion returns the highest go directive of the modules in dirs,
+// the version a new go.work needs
+func highestGoVersion(absPath string, dirs []string) (string, error) {
+	highest := ""
+	for _, dir := range dirs {
+		d, err := analyzer.ReadGoDirectives(filepath.Join(absPath, dir, "go.mod"))
+		if err != nil {
+			return "", err
+		}
+		if d.Go != "" && (highest == "" || goversion.Compare("go"+d.Go, "go"+highest) > 0) {
+			highest = d.Go
+		}
+	}
+	if highest == "" {
+		return "", fmt.Errorf("no go directive found in the modules of %s", absPath)
+	}
+	return highest, nil
+}
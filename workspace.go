@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	analyzer "github.com/nicolasgere/knit/lib/analyser"
+	"github.com/nicolasgere/knit/lib/config"
+	"github.com/nicolasgere/knit/lib/query"
+	"github.com/nicolasgere/knit/lib/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// loadModules resolves the workspace path and lists its modules, but the
+// ones excluded by the excludeModules setting of knit.yaml
+func loadModules(path string) (string, []analyzer.Module, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	modules, err := analyzer.ListModule(absPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list modules: %w", err)
+	}
+	if modules, err = configuredModules(absPath, modules); err != nil {
+		return "", nil, err
+	}
+	return absPath, modules, nil
+}
+
+// matchModule reports whether a module matches a pattern, either on its
+// module path or on its directory relative to the workspace root
+func matchModule(pattern string, m analyzer.Module, workspaceRoot string) bool {
+	if query.MatchPattern(pattern, m.Path) {
+		return true
+	}
+	relDir, err := filepath.Rel(workspaceRoot, m.Dir)
+	if err != nil {
+		return false
+	}
+	dirPattern := strings.TrimSuffix(strings.TrimPrefix(filepath.ToSlash(pattern), "./"), "/")
+	return query.MatchPattern(dirPattern, filepath.ToSlash(relDir))
+}
+
+// filterModules keeps the modules matching at least one of the patterns
+func filterModules(modules []analyzer.Module, patterns []string, workspaceRoot string) []analyzer.Module {
+	filtered := make([]analyzer.Module, 0, len(modules))
+	for _, m := range modules {
+		for _, p := range patterns {
+			if matchModule(p, m, workspaceRoot) {
+				filtered = append(filtered, m)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// excludeFlag returns the --exclude flag shared by the commands selecting modules
+func excludeFlag(destination *cli.StringSlice) cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:        "exclude",
+		Usage:       "Skip modules whose path or directory matches a glob, '/...' matches a whole prefix (repeatable)",
+		Aliases:     []string{"x"},
+		Destination: destination,
+	}
+}
+
+// timestampsFlag returns the --timestamps flag of the commands printing the
+// output of tasks
+func timestampsFlag(destination *string) cli.Flag {
+	return &cli.StringFlag{
+		Name:        "timestamps",
+		Usage:       "Timestamp of the output lines: relative (seconds since start), clock, iso or none",
+		Value:       utils.TimestampRelative,
+		Destination: destination,
+	}
+}
+
+// prefixWidthFlag returns the --prefix-width flag of the commands printing
+// the output of tasks
+func prefixWidthFlag(destination *int) cli.Flag {
+	return &cli.IntFlag{
+		Name:        "prefix-width",
+		Usage:       fmt.Sprintf("Pad or truncate the module of the output lines to `N` columns (default: the longest module, at most %d)", utils.MaxPrefixWidth),
+		Destination: destination,
+	}
+}
+
+// excludeModules drops the modules matching any of the patterns
+func excludeModules(modules []analyzer.Module, patterns []string, workspaceRoot string) []analyzer.Module {
+	if len(patterns) == 0 {
+		return modules
+	}
+	kept := make([]analyzer.Module, 0, len(modules))
+	for _, m := range modules {
+		if len(filterModules([]analyzer.Module{m}, patterns, workspaceRoot)) == 0 {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// configuredModules drops the modules matching the excludeModules patterns
+// of the knit.yaml of the workspace
+func configuredModules(workspaceRoot string, modules []analyzer.Module) ([]analyzer.Module, error) {
+	cfg, err := config.Load(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	return excludeModules(modules, cfg.ExcludeModules, workspaceRoot), nil
+}
+
+// intersectModules keeps the modules of a that are also in b, in the order of a
+func intersectModules(a, b []analyzer.Module) []analyzer.Module {
+	inB := make(map[string]bool, len(b))
+	for _, m := range b {
+		inB[m.Path] = true
+	}
+	kept := make([]analyzer.Module, 0, len(a))
+	for _, m := range a {
+		if inB[m.Path] {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// sortedKeys returns the keys of m in lexical order, for deterministic output
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}